@@ -0,0 +1,48 @@
+package langfuse
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// Annotate attaches a reviewer's note and scores to an existing trace, e.g.
+// from a support tool after the trace has completed. The note is stored under
+// the trace's "comment" metadata key, replacing an earlier note. Scores without
+// an ID get one derived from the trace, observation and score name, so
+// annotating again updates them instead of adding duplicates.
+func (l *Langfuse) Annotate(traceID string, note string, scores []*model.Score) error {
+	if traceID == "" {
+		return fmt.Errorf("trace ID is required")
+	}
+	for _, s := range scores {
+		if s.TraceID != "" && s.TraceID != traceID {
+			return fmt.Errorf("score %q belongs to trace %s, not %s", s.Name, s.TraceID, traceID)
+		}
+	}
+
+	if note != "" {
+		if _, err := l.Trace(&model.Trace{ID: traceID, Metadata: map[string]interface{}{metadataKeyComment: note}}); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, s := range scores {
+		s.TraceID = traceID
+		if s.ID == "" {
+			s.ID = annotationScoreID(traceID, s.ObservationID, s.Name)
+		}
+		if _, err := l.Score(s); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// annotationScoreID derives a stable score ID, so repeated annotations upsert
+func annotationScoreID(traceID string, observationID string, name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("langfuse-go/annotation/"+traceID+"/"+observationID+"/"+name)).String()
+}
@@ -0,0 +1,277 @@
+package langfuse
+
+import (
+	"sync"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+const defaultMaxBufferedTraces = 1000
+
+// BufferedTrace holds the events of a trace recorded while trace buffering is enabled
+type BufferedTrace struct {
+	TraceID string
+	Events  []model.IngestionEvent
+	// Errored is true when an observation has level ERROR or an "error" metadata key
+	Errored bool
+	// Duration spans the earliest start to the latest end of the trace's observations
+	Duration time.Duration
+
+	start time.Time
+	end   time.Time
+}
+
+// observe updates the error and timing summary with one event body
+func (t *BufferedTrace) observe(body any) {
+	var start, end *time.Time
+	var level model.ObservationLevel
+	var metadata any
+
+	switch b := body.(type) {
+	case *model.Trace:
+		start = b.Timestamp
+	case *model.Span:
+		start, end, level, metadata = b.StartTime, b.EndTime, b.Level, b.Metadata
+	case *model.Generation:
+		start, end, level, metadata = b.StartTime, b.EndTime, b.Level, b.Metadata
+	case *model.Event:
+		start, level, metadata = b.StartTime, b.Level, b.Metadata
+	}
+
+	if level == model.ObservationLevelError || hasErrorMetadata(metadata) {
+		t.Errored = true
+	}
+	for _, ts := range []*time.Time{start, end} {
+		if ts == nil || ts.IsZero() {
+			continue
+		}
+		if t.start.IsZero() || ts.Before(t.start) {
+			t.start = *ts
+		}
+		if ts.After(t.end) {
+			t.end = *ts
+		}
+	}
+	if !t.start.IsZero() {
+		t.Duration = t.end.Sub(t.start)
+	}
+}
+
+// hasErrorMetadata reports whether metadata carries an "error" key
+func hasErrorMetadata(metadata any) bool {
+	switch m := metadata.(type) {
+	case map[string]interface{}:
+		_, hasError := m["error"]
+		return hasError
+	case model.M:
+		_, hasError := m["error"]
+		return hasError
+	default:
+		return false
+	}
+}
+
+// traceBuffer holds events per trace until the trace ends
+type traceBuffer struct {
+	mu        sync.Mutex
+	keep      func(*BufferedTrace) bool
+	maxTraces int
+	traces    map[string]*BufferedTrace
+	order     []string // trace IDs, oldest first
+	// ended records whether recently ended traces were kept, so events arriving
+	// after EndTrace are sent or dropped instead of buffered again
+	ended      map[string]bool
+	endedOrder []string // ended trace IDs, oldest first
+}
+
+func newTraceBuffer(keep func(*BufferedTrace) bool, maxTraces int) *traceBuffer {
+	if maxTraces <= 0 {
+		maxTraces = defaultMaxBufferedTraces
+	}
+	return &traceBuffer{
+		keep:      keep,
+		maxTraces: maxTraces,
+		traces:    make(map[string]*BufferedTrace),
+		ended:     make(map[string]bool),
+	}
+}
+
+// add buffers event under traceID. When the buffer is full, the events of the
+// oldest trace are returned so they can be sent without sampling. Events of a
+// trace that already ended are returned if the trace was kept and otherwise
+// dropped, with discarded called.
+func (b *traceBuffer) add(traceID string, event model.IngestionEvent, discarded func(int)) []model.IngestionEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if kept, ended := b.ended[traceID]; ended {
+		if kept {
+			return []model.IngestionEvent{event}
+		}
+		discarded(1)
+		return nil
+	}
+
+	trace, exists := b.traces[traceID]
+	if !exists {
+		trace = &BufferedTrace{TraceID: traceID}
+		b.traces[traceID] = trace
+		b.order = append(b.order, traceID)
+	}
+	trace.Events = append(trace.Events, event)
+	trace.observe(event.Body)
+
+	if len(b.order) <= b.maxTraces {
+		return nil
+	}
+
+	// The evicted trace is sent, so its later events are sent too instead of
+	// being buffered and sampled as a trace of their own
+	oldest := b.order[0]
+	b.order = b.order[1:]
+	evicted := b.traces[oldest]
+	delete(b.traces, oldest)
+	b.markEndedLocked(oldest, true)
+	return evicted.Events
+}
+
+// release removes a trace from the buffer and returns its events if the
+// predicate keeps it; otherwise discarded is called with the number of events
+// dropped. A trace that already ended or was evicted reports whether it was sent.
+func (b *traceBuffer) release(traceID string, discarded func(int)) ([]model.IngestionEvent, bool) {
+	b.mu.Lock()
+	trace, exists := b.traces[traceID]
+	if exists {
+		delete(b.traces, traceID)
+		for i, id := range b.order {
+			if id == traceID {
+				b.order = append(b.order[:i], b.order[i+1:]...)
+				break
+			}
+		}
+	}
+	sent := b.ended[traceID]
+	b.mu.Unlock()
+
+	if !exists {
+		return nil, sent
+	}
+	kept := b.keep == nil || b.keep(trace)
+	b.markEnded(traceID, kept)
+	if !kept {
+		discarded(len(trace.Events))
+		return nil, false
+	}
+	return trace.Events, true
+}
+
+// markEnded remembers the sampling decision of an ended trace, forgetting the
+// oldest decision beyond maxTraces
+func (b *traceBuffer) markEnded(traceID string, kept bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.markEndedLocked(traceID, kept)
+}
+
+// markEndedLocked is markEnded for callers holding b.mu
+func (b *traceBuffer) markEndedLocked(traceID string, kept bool) {
+	if _, seen := b.ended[traceID]; !seen {
+		b.endedOrder = append(b.endedOrder, traceID)
+	}
+	b.ended[traceID] = kept
+	if len(b.endedOrder) > b.maxTraces {
+		delete(b.ended, b.endedOrder[0])
+		b.endedOrder = b.endedOrder[1:]
+	}
+}
+
+// eventTraceID returns the trace an ingestion event belongs to
+func eventTraceID(event model.IngestionEvent) string {
+	switch b := event.Body.(type) {
+	case *model.Trace:
+		return b.ID
+	case *model.Span:
+		return b.TraceID
+	case *model.Generation:
+		return b.TraceID
+	case *model.Event:
+		return b.TraceID
+	case *model.Score:
+		return b.TraceID
+	default:
+		return ""
+	}
+}
+
+// WithTraceBuffering holds each trace's events in memory until EndTrace is called,
+// then sends them only if keep returns true. This enables tail-based sampling such
+// as keeping only traces that errored or were slow. At most maxTraces traces are
+// buffered (1000 if maxTraces <= 0); beyond that the oldest trace is sent unsampled,
+// along with its later events. Events of a trace that arrive after EndTrace, such
+// as late scores, are sent if the trace was kept and dropped otherwise.
+// A nil keep disables buffering. Changing the buffering is safe at any time, but
+// traces still buffered under the previous setting are not sent, so set it before
+// recording traces.
+func (l *Langfuse) WithTraceBuffering(keep func(*BufferedTrace) bool, maxTraces int) *Langfuse {
+	if keep == nil {
+		l.buffer.Store(nil)
+		return l
+	}
+	l.buffer.Store(newTraceBuffer(keep, maxTraces))
+	return l
+}
+
+// EndTrace releases the buffered events of a trace, sending them if the buffering
+// predicate keeps the trace and discarding them otherwise. It reports whether the
+// trace was sent, including a trace evicted from a full buffer, and is a no-op
+// when buffering is disabled.
+func (l *Langfuse) EndTrace(traceID string) bool {
+	buffer := l.buffer.Load()
+	if buffer == nil {
+		return false
+	}
+
+	events, kept := buffer.release(traceID, func(discarded int) {
+		l.metrics.eventsSampledOut.Add(int64(discarded))
+	})
+	l.enqueue(events...)
+	return kept
+}
+
+// dispatchAll queues events in order without letting a flush split them
+func (l *Langfuse) dispatchAll(events []model.IngestionEvent) {
+	if l.buffer.Load() != nil {
+		for _, event := range events {
+			l.dispatch(event)
+		}
+		return
+	}
+	l.enqueue(events...)
+}
+
+// enqueue hands events to the ingestion queue together
+func (l *Langfuse) enqueue(events ...model.IngestionEvent) {
+	if len(events) == 0 {
+		return
+	}
+	l.metrics.eventsEnqueued.Add(int64(len(events)))
+	if l.recorder != nil {
+		l.recorder.record(events)
+		return
+	}
+	l.observer.DispatchAll(events)
+}
+
+// dispatch queues an event, buffering it per trace when buffering is enabled
+func (l *Langfuse) dispatch(event model.IngestionEvent) {
+	if buffer := l.buffer.Load(); buffer != nil {
+		if traceID := eventTraceID(event); traceID != "" {
+			l.enqueue(buffer.add(traceID, event, func(discarded int) {
+				l.metrics.eventsSampledOut.Add(int64(discarded))
+			})...)
+			return
+		}
+	}
+	l.enqueue(event)
+}
@@ -0,0 +1,260 @@
+package langfuse
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// maxSamplingDecisions bounds the per-trace sampling decisions remembered for
+// later calls on the same trace; the oldest are forgotten first
+const maxSamplingDecisions = 10000
+
+// MaskFunc rewrites an input or output payload before it leaves the process,
+// e.g. to redact personal data
+type MaskFunc func(data any) any
+
+// CallOption overrides a client-level setting for a single Trace, Span or
+// Generation call. Overrides take precedence over the client defaults set with
+// WithEnvironment, WithSampleRate and WithMask.
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	environment string
+	sampleRate  float64
+	mask        MaskFunc
+	// sampled is set when the call overrides the sampling rate
+	sampled bool
+	// maxInlineSize is the call's media offloading threshold, set when inlineSized is
+	maxInlineSize int
+	inlineSized   bool
+}
+
+// WithCallEnvironment records the call in environment instead of the client's
+// default environment. Fields that already set an environment are kept.
+func WithCallEnvironment(environment string) CallOption {
+	return func(o *callOptions) {
+		o.environment = environment
+	}
+}
+
+// WithCallSampleRate samples the call's trace at rate instead of the client's
+// rate; WithCallSampleRate(1) always records it, e.g. for a debug request. The
+// decision is remembered for the trace, so later calls on it without an
+// override follow it.
+func WithCallSampleRate(rate float64) CallOption {
+	return func(o *callOptions) {
+		o.sampleRate = clampRate(rate)
+		o.sampled = true
+	}
+}
+
+// WithCallMask masks the call's input and output with mask instead of the
+// client's mask. A nil mask records them unmasked.
+func WithCallMask(mask MaskFunc) CallOption {
+	return func(o *callOptions) {
+		o.mask = mask
+	}
+}
+
+// WithCallMaxInlineSize uploads the call's string and byte slice payloads
+// larger than size bytes as media instead of using the client's
+// WithMaxInlineSize. A size of zero or less keeps them inline.
+func WithCallMaxInlineSize(size int) CallOption {
+	return func(o *callOptions) {
+		o.maxInlineSize = size
+		o.inlineSized = true
+	}
+}
+
+// WithEnvironment sets the environment of traces, observations and scores
+// that do not set one themselves, e.g. "staging". Langfuse reserves
+// environment names starting with "langfuse".
+func (l *Langfuse) WithEnvironment(environment string) *Langfuse {
+	l.environment = environment
+	return l
+}
+
+// WithVersion sets the version of traces and observations that do not set one
+// themselves, e.g. the release of the application, so regressions can be
+// tracked per version
+func (l *Langfuse) WithVersion(version string) *Langfuse {
+	l.version = version
+	return l
+}
+
+// WithSampleRate records only the given fraction of traces (all by default).
+// The decision is derived from the trace ID and remembered when the trace is
+// created, so every observation and score of a trace is kept or dropped
+// together, even if the rate changes meanwhile. Dropped events count as
+// sampled out in Stats.
+func (l *Langfuse) WithSampleRate(rate float64) *Langfuse {
+	l.sampleRate = clampRate(rate)
+	return l
+}
+
+// WithMask rewrites the input and output of traces and observations with mask
+// before they are queued. A nil mask disables masking.
+func (l *Langfuse) WithMask(mask MaskFunc) *Langfuse {
+	l.mask = mask
+	return l
+}
+
+// prepare applies the client defaults and per-call overrides to body, an
+// event of the given trace, canonicalizes its payloads when enabled, and
+// reports whether the trace is sampled
+func (l *Langfuse) prepare(traceID string, body any, opts []CallOption) bool {
+	o := callOptions{environment: l.baseEnvironment(), sampleRate: l.sampleRate, mask: l.mask}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if l.belowMinLevel(body) {
+		l.metrics.eventsFiltered.Add(1)
+		return false
+	}
+	_, createsTrace := body.(*model.Trace)
+	if !l.sampled(traceID, o, createsTrace) {
+		l.metrics.eventsSampledOut.Add(1)
+		return false
+	}
+
+	switch b := body.(type) {
+	case *model.Trace:
+		b.Environment = defaultEnvironment(b.Environment, o.environment)
+		l.tagTestTrace(b)
+		b.Version = defaultVersion(b.Version, l.version)
+		b.Input, b.Output = applyMask(o.mask, b.Input), applyMask(o.mask, b.Output)
+		if l.canonicalJSON {
+			b.Input, b.Output, b.Metadata = canonicalize(b.Input), canonicalize(b.Output), canonicalize(b.Metadata)
+		}
+	case *model.Span:
+		b.Environment = defaultEnvironment(b.Environment, o.environment)
+		b.Version = defaultVersion(b.Version, l.version)
+		b.Input, b.Output = applyMask(o.mask, b.Input), applyMask(o.mask, b.Output)
+		if l.canonicalJSON {
+			b.Input, b.Output, b.Metadata = canonicalize(b.Input), canonicalize(b.Output), canonicalize(b.Metadata)
+		}
+	case *model.Generation:
+		b.Environment = defaultEnvironment(b.Environment, o.environment)
+		b.Version = defaultVersion(b.Version, l.version)
+		b.Input, b.Output = applyMask(o.mask, b.Input), applyMask(o.mask, b.Output)
+		if l.canonicalJSON {
+			b.Input, b.Output, b.Metadata = canonicalize(b.Input), canonicalize(b.Output), canonicalize(b.Metadata)
+		}
+	case *model.Event:
+		b.Environment = defaultEnvironment(b.Environment, o.environment)
+		b.Version = defaultVersion(b.Version, l.version)
+		b.Input, b.Output = applyMask(o.mask, b.Input), applyMask(o.mask, b.Output)
+		if l.canonicalJSON {
+			b.Input, b.Output, b.Metadata = canonicalize(b.Input), canonicalize(b.Output), canonicalize(b.Metadata)
+		}
+	case *model.Score:
+		b.Environment = defaultEnvironment(b.Environment, o.environment)
+		if l.canonicalJSON {
+			b.Metadata = canonicalizeMetadata(b.Metadata)
+		}
+	}
+	return true
+}
+
+// sampled decides whether traceID is recorded. A call override decides for
+// the whole trace; otherwise an earlier decision or the trace ID decides. The
+// decision made when the trace is created is remembered.
+func (l *Langfuse) sampled(traceID string, o callOptions, createsTrace bool) bool {
+	if o.sampled {
+		decision := sampleTrace(traceID, o.sampleRate)
+		l.samplingDecisions.store(traceID, decision)
+		return decision
+	}
+	if decision, decided := l.samplingDecisions.get(traceID); decided {
+		return decision
+	}
+	decision := sampleTrace(traceID, o.sampleRate)
+	if createsTrace {
+		l.samplingDecisions.store(traceID, decision)
+	}
+	return decision
+}
+
+// IsSampled reports whether events of traceID are recorded, following the
+// decision remembered for the trace or else the client's sample rate. Traces
+// are sampled by ID in one place, so the langgraph hook, observers and the
+// langchain handler contributing to a trace keep or drop it together; they
+// can check IsSampled to skip work for dropped traces.
+func (l *Langfuse) IsSampled(traceID string) bool {
+	return l.sampled(traceID, callOptions{sampleRate: l.sampleRate}, false)
+}
+
+// sampleTrace maps traceID onto [0, 1) and keeps it when it falls below rate
+func sampleTrace(traceID string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(traceID))
+	return float64(h.Sum64())/math.MaxUint64 < rate
+}
+
+func clampRate(rate float64) float64 {
+	return math.Max(0, math.Min(1, rate))
+}
+
+func defaultEnvironment(environment string, fallback string) string {
+	if environment == "" {
+		return fallback
+	}
+	return environment
+}
+
+func defaultVersion(version string, fallback string) string {
+	if version == "" {
+		return fallback
+	}
+	return version
+}
+
+func applyMask(mask MaskFunc, data any) any {
+	if mask == nil || data == nil {
+		return data
+	}
+	return mask(data)
+}
+
+// samplingDecisions remembers per-trace sampling decisions
+type samplingDecisions struct {
+	mu        sync.Mutex
+	decisions map[string]bool
+	order     []string
+}
+
+func (s *samplingDecisions) store(traceID string, decision bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.decisions == nil {
+		s.decisions = make(map[string]bool)
+	}
+	if _, exists := s.decisions[traceID]; !exists {
+		s.order = append(s.order, traceID)
+	}
+	s.decisions[traceID] = decision
+
+	for len(s.order) > maxSamplingDecisions {
+		delete(s.decisions, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *samplingDecisions) get(traceID string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	decision, decided := s.decisions[traceID]
+	return decision, decided
+}
@@ -0,0 +1,50 @@
+package langfuse
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// WithCanonicalJSON rewrites the input, output and metadata of traces,
+// observations and scores into their generic JSON form before they are
+// queued, so equal values always serialize to the same bytes. encoding/json
+// already sorts the keys of Go maps; canonical mode also sorts the keys of
+// objects written by MarshalJSON methods and json.RawMessage values, and keeps
+// numbers exactly as encoded. It is off by default, as it encodes each payload
+// an extra time.
+func (l *Langfuse) WithCanonicalJSON(enabled bool) *Langfuse {
+	l.canonicalJSON = enabled
+	return l
+}
+
+// canonicalize returns v decoded from its JSON encoding, so every object in it
+// is a map[string]interface{} that encodes with sorted keys. Values that cannot
+// be encoded are returned unchanged.
+func canonicalize(v any) any {
+	if v == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return v
+	}
+	return generic
+}
+
+// canonicalizeMetadata canonicalizes each value of a score's metadata
+func canonicalizeMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return nil
+	}
+	canonical := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		canonical[k] = canonicalize(v)
+	}
+	return canonical
+}
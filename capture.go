@@ -0,0 +1,223 @@
+package langfuse
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxCaptureDepth bounds how deeply captured values are copied unless
+// WithMaxCaptureDepth sets another bound, guarding against cyclic pointers
+const maxCaptureDepth = 32
+
+// captureLimits bounds the size of captured values
+type captureLimits struct {
+	// maxDepth is the nesting depth below which values are replaced by their type
+	maxDepth int
+	// maxElements caps the elements kept of each slice, array and map; 0 keeps all
+	maxElements int
+}
+
+var defaultCaptureLimits = captureLimits{maxDepth: maxCaptureDepth}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// captureValue deep-copies v into maps, slices and primitives that json.Marshal
+// always accepts. Structs become maps keyed like encoding/json would key them,
+// without their unexported fields; functions, channels and other values JSON
+// cannot represent are replaced by a description of their type. Types that
+// marshal themselves, such as time.Time, are kept as they are. Values nested
+// deeper than the limits allow are replaced by their type, and collections are
+// cut to the element limit with a marker counting the elements left out.
+func (c captureLimits) captureValue(v reflect.Value, depth int) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if depth > c.maxDepth {
+		return fmt.Sprintf("<%s>", v.Type().String())
+	}
+
+	if v.CanInterface() && (v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType)) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return nil
+		}
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return c.captureValue(v.Elem(), depth+1)
+	case reflect.Func:
+		return fmt.Sprintf("function<%s>", v.Type().String())
+	case reflect.Chan:
+		return fmt.Sprintf("channel<%s>", v.Type().String())
+	case reflect.UnsafePointer, reflect.Uintptr, reflect.Complex64, reflect.Complex128:
+		return fmt.Sprintf("<%s>", v.Type().String())
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Sprint(f)
+		}
+		return f
+	case reflect.String:
+		return v.String()
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append([]byte(nil), v.Bytes()...)
+		}
+		return c.captureElements(v, depth)
+	case reflect.Array:
+		return c.captureElements(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		return c.captureMap(v, depth)
+	case reflect.Struct:
+		captured := make(map[string]interface{})
+		c.captureFields(v, depth, captured)
+		return captured
+	}
+
+	return fmt.Sprintf("<%s>", v.Type().String())
+}
+
+// captureElements copies the elements of a slice or array, ending with a
+// marker when elements beyond the limit are left out
+func (c captureLimits) captureElements(v reflect.Value, depth int) []interface{} {
+	n := v.Len()
+	if c.maxElements > 0 && n > c.maxElements {
+		n = c.maxElements
+	}
+	captured := make([]interface{}, n, n+1)
+	for i := range captured {
+		captured[i] = c.captureValue(v.Index(i), depth+1)
+	}
+	if omitted := v.Len() - n; omitted > 0 {
+		captured = append(captured, omittedMarker(omitted))
+	}
+	return captured
+}
+
+// captureMap copies the entries of a map. Beyond the limit, the entries with
+// the lowest keys are kept and a "..." entry counts those left out.
+func (c captureLimits) captureMap(v reflect.Value, depth int) map[string]interface{} {
+	if c.maxElements <= 0 || v.Len() <= c.maxElements {
+		captured := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			captured[captureKey(iter.Key())] = c.captureValue(iter.Value(), depth+1)
+		}
+		return captured
+	}
+
+	values := make(map[string]reflect.Value, v.Len())
+	keys := make([]string, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := captureKey(iter.Key())
+		values[key] = iter.Value()
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	captured := make(map[string]interface{}, c.maxElements+1)
+	for _, key := range keys[:c.maxElements] {
+		captured[key] = c.captureValue(values[key], depth+1)
+	}
+	captured[omittedKey] = omittedMarker(v.Len() - c.maxElements)
+	return captured
+}
+
+// omittedKey holds the marker of map entries left out of a capture
+const omittedKey = "..."
+
+func omittedMarker(omitted int) string {
+	return fmt.Sprintf("<%d more elements>", omitted)
+}
+
+// captureKey formats a map key as a JSON object key
+func captureKey(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return key.String()
+	}
+	if key.CanInterface() {
+		if marshaler, ok := key.Interface().(encoding.TextMarshaler); ok {
+			if text, err := marshaler.MarshalText(); err == nil {
+				return string(text)
+			}
+		}
+		return fmt.Sprint(key.Interface())
+	}
+	return fmt.Sprintf("<%s>", key.Type().String())
+}
+
+// captureFields adds the exported fields of struct v to captured, honoring json
+// tag names, "-" and omitempty. Untagged embedded structs are flattened.
+func (c captureLimits) captureFields(v reflect.Value, depth int, captured map[string]interface{}) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		fv := v.Field(i)
+		if field.Anonymous && name == "" {
+			embedded := fv
+			if embedded.Kind() == reflect.Ptr {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				c.captureFields(embedded, depth+1, captured)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		captured[name] = c.captureValue(fv, depth+1)
+	}
+}
+
+// isEmptyValue reports whether encoding/json's omitempty would omit v
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Ptr:
+		return v.IsZero()
+	}
+	return false
+}
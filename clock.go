@@ -0,0 +1,17 @@
+package langfuse
+
+import "time"
+
+// Clock provides the current time for timestamps and durations.
+// A fake implementation can be injected to make timing deterministic in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the default Clock backed by time.Now
+type realClock struct{}
+
+// Now returns the current wall-clock time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,20 @@
+package langfuse
+
+// metadataKeyComment holds observation comments, which the ingestion API does not support natively
+const metadataKeyComment = "comment"
+
+// withObservationComment returns metadata with comment stored under its
+// "comment" key, replacing any comment stored there
+func withObservationComment(metadata any, comment string) any {
+	if comment == "" {
+		return metadata
+	}
+
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+	extended[metadataKeyComment] = comment
+	return extended
+}
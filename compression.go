@@ -0,0 +1,27 @@
+package langfuse
+
+import (
+	"compress/gzip"
+	"log"
+)
+
+// WithCompression gzip-encodes ingestion requests, trading CPU for bandwidth on
+// large batches. The server, or a proxy in front of it, must accept
+// gzip-encoded request bodies. Compression is disabled by default.
+func (l *Langfuse) WithCompression(enabled bool) *Langfuse {
+	l.client.WithCompression(enabled)
+	return l
+}
+
+// WithCompressionLevel enables gzip compression of ingestion requests at level,
+// from gzip.HuffmanOnly (-2) to gzip.BestCompression (9). Lower levels use less
+// CPU, higher levels produce smaller requests. Invalid levels fall back to
+// gzip.DefaultCompression, a balance between the two.
+func (l *Langfuse) WithCompressionLevel(level int) *Langfuse {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		log.Printf("Invalid compression level %d, using the default level", level)
+		level = gzip.DefaultCompression
+	}
+	l.client.WithCompressionLevel(level).WithCompression(true)
+	return l
+}
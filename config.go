@@ -0,0 +1,106 @@
+package langfuse
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
+)
+
+// Config configures a client explicitly, e.g. with credentials from a secrets manager
+type Config struct {
+	PublicKey string
+	SecretKey string
+	// PublicKeyFile and SecretKeyFile name files holding the keys, e.g. mounted
+	// secrets; they are read when PublicKey or SecretKey is empty
+	PublicKeyFile string
+	SecretKeyFile string
+	// Host takes precedence over Region; the EU cloud host is used when both are empty
+	Host   string
+	Region Region
+
+	FlushInterval         time.Duration
+	MaxBatchSize          int
+	MaxConcurrentRequests int
+	Clock                 Clock
+	Logger                *slog.Logger
+	ErrorHandler          func(error)
+	StrictValidation      bool
+	ModelNormalizer       *ModelNormalizer
+	ProviderResolver      *ProviderResolver
+}
+
+// NewWithConfig creates a client from cfg without reading environment variables.
+// Zero-valued fields keep their defaults.
+func NewWithConfig(ctx context.Context, cfg Config) *Langfuse {
+	defaultHost := RegionEU.Host()
+	l := newLangfuse(ctx, api.NewWithCredentials(defaultHost, cfg.PublicKey, cfg.SecretKey), defaultHost)
+
+	publicKeyFile, secretKeyFile := cfg.PublicKeyFile, cfg.SecretKeyFile
+	if cfg.PublicKey != "" {
+		publicKeyFile = ""
+	}
+	if cfg.SecretKey != "" {
+		secretKeyFile = ""
+	}
+	l.WithKeyFiles(publicKeyFile, secretKeyFile)
+
+	if cfg.Region != "" {
+		l.WithRegion(cfg.Region)
+	}
+	if cfg.Host != "" {
+		l.WithHost(cfg.Host)
+	}
+	if cfg.FlushInterval > 0 {
+		l.WithFlushInterval(cfg.FlushInterval)
+	}
+	if cfg.MaxBatchSize > 0 {
+		l.WithMaxBatchSize(cfg.MaxBatchSize)
+	}
+	if cfg.MaxConcurrentRequests > 0 {
+		l.WithMaxConcurrentRequests(cfg.MaxConcurrentRequests)
+	}
+	if cfg.Clock != nil {
+		l.WithClock(cfg.Clock)
+	}
+	if cfg.Logger != nil {
+		l.WithLogger(cfg.Logger)
+	}
+	if cfg.ErrorHandler != nil {
+		l.WithErrorHandler(cfg.ErrorHandler)
+	}
+	if cfg.StrictValidation {
+		l.WithStrictValidation(true)
+	}
+	if cfg.ModelNormalizer != nil {
+		l.WithModelNormalizer(cfg.ModelNormalizer)
+	}
+	if cfg.ProviderResolver != nil {
+		l.WithProviderResolver(cfg.ProviderResolver)
+	}
+
+	return l
+}
+
+// WithKeyFiles reads the public and secret keys from files, e.g. Docker or
+// Kubernetes secret mounts, trimming surrounding whitespace. An empty path
+// keeps the current key; a file that cannot be read is logged and its key kept.
+func (l *Langfuse) WithKeyFiles(publicKeyFile string, secretKeyFile string) *Langfuse {
+	if publicKeyFile != "" {
+		if key, err := api.ReadKeyFile(publicKeyFile); err != nil {
+			log.Printf("Failed to read Langfuse public key: %v", err)
+		} else {
+			l.client.WithPublicKey(key)
+		}
+	}
+	if secretKeyFile != "" {
+		if key, err := api.ReadKeyFile(secretKeyFile); err != nil {
+			log.Printf("Failed to read Langfuse secret key: %v", err)
+		} else {
+			l.client.WithSecretKey(key)
+		}
+	}
+	return l
+}
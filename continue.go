@@ -0,0 +1,129 @@
+package langfuse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// TraceContextHeader is the HTTP header used to propagate a TraceContext between services
+const TraceContextHeader = "X-Langfuse-Trace-Context"
+
+// traceContextSeparator separates the trace ID from the parent observation ID
+const traceContextSeparator = ";"
+
+// TraceContext identifies where a service continues a trace started elsewhere
+type TraceContext struct {
+	TraceID             string
+	ParentObservationID string
+}
+
+// String serializes the context for TraceContextHeader
+func (tc TraceContext) String() string {
+	if tc.ParentObservationID == "" {
+		return tc.TraceID
+	}
+	return tc.TraceID + traceContextSeparator + tc.ParentObservationID
+}
+
+// ParseTraceContext parses a header value produced by TraceContext.String
+func ParseTraceContext(header string) (TraceContext, error) {
+	traceID, parentID, _ := strings.Cut(strings.TrimSpace(header), traceContextSeparator)
+	if traceID == "" {
+		return TraceContext{}, fmt.Errorf("trace context %q has no trace ID", header)
+	}
+	if strings.Contains(parentID, traceContextSeparator) {
+		return TraceContext{}, fmt.Errorf("trace context %q has more than two fields", header)
+	}
+	return TraceContext{TraceID: traceID, ParentObservationID: parentID}, nil
+}
+
+// TraceHandle creates observations under an existing trace without recreating it
+type TraceHandle struct {
+	l        *Langfuse
+	traceID  string
+	parentID string
+	// environment is given to observations and scores that have none
+	environment string
+}
+
+// ContinueTrace returns a handle attaching observations to the trace traceID,
+// typically propagated by the calling service via TraceContextHeader
+func (l *Langfuse) ContinueTrace(traceID string) *TraceHandle {
+	return &TraceHandle{l: l, traceID: traceID}
+}
+
+// ContinueTraceContext returns a handle attaching observations to the trace and
+// parent observation of tc
+func (l *Langfuse) ContinueTraceContext(tc TraceContext) *TraceHandle {
+	return l.ContinueTrace(tc.TraceID).WithParent(tc.ParentObservationID)
+}
+
+// WithParent returns a handle nesting observations under the observation parentID
+func (h *TraceHandle) WithParent(parentID string) *TraceHandle {
+	return &TraceHandle{l: h.l, traceID: h.traceID, parentID: parentID, environment: h.environment}
+}
+
+// TraceID returns the ID of the continued trace
+func (h *TraceHandle) TraceID() string {
+	return h.traceID
+}
+
+// Context returns the trace context to propagate to a downstream service so its
+// observations nest under observationID (or under this handle's parent if empty)
+func (h *TraceHandle) Context(observationID string) TraceContext {
+	if observationID == "" {
+		observationID = h.parentID
+	}
+	return TraceContext{TraceID: h.traceID, ParentObservationID: observationID}
+}
+
+// Span creates a span in the continued trace
+func (h *TraceHandle) Span(s *model.Span) (*model.Span, error) {
+	if h.traceID == "" {
+		return nil, fmt.Errorf("trace ID is required")
+	}
+	s.TraceID = h.traceID
+	if s.ParentObservationID == "" {
+		s.ParentObservationID = h.parentID
+	}
+	s.Environment = defaultEnvironment(s.Environment, h.environment)
+	return h.l.Span(s, nil)
+}
+
+// Generation creates a generation in the continued trace
+func (h *TraceHandle) Generation(g *model.Generation) (*model.Generation, error) {
+	if h.traceID == "" {
+		return nil, fmt.Errorf("trace ID is required")
+	}
+	g.TraceID = h.traceID
+	if g.ParentObservationID == "" {
+		g.ParentObservationID = h.parentID
+	}
+	g.Environment = defaultEnvironment(g.Environment, h.environment)
+	return h.l.Generation(g, nil)
+}
+
+// Event creates an event in the continued trace
+func (h *TraceHandle) Event(e *model.Event) (*model.Event, error) {
+	if h.traceID == "" {
+		return nil, fmt.Errorf("trace ID is required")
+	}
+	e.TraceID = h.traceID
+	if e.ParentObservationID == "" {
+		e.ParentObservationID = h.parentID
+	}
+	e.Environment = defaultEnvironment(e.Environment, h.environment)
+	return h.l.Event(e, nil)
+}
+
+// Score scores the continued trace
+func (h *TraceHandle) Score(s *model.Score) (*model.Score, error) {
+	if h.traceID == "" {
+		return nil, fmt.Errorf("trace ID is required")
+	}
+	s.TraceID = h.traceID
+	s.Environment = defaultEnvironment(s.Environment, h.environment)
+	return h.l.Score(s)
+}
@@ -2,14 +2,21 @@ package langfuse
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
 	"github.com/paulnegz/langfuse-go/model"
 )
 
+// ErrDatasetItemNotPersisted is returned when updating or deleting a dataset item
+// that does not exist server-side
+var ErrDatasetItemNotPersisted = errors.New("dataset item was never persisted")
+
 // Dataset represents a Langfuse dataset
 type Dataset struct {
 	ID          string                 `json:"id"`
@@ -34,6 +41,7 @@ type DatasetItem struct {
 	CreatedAt      time.Time              `json:"createdAt"`
 	UpdatedAt      time.Time              `json:"updatedAt"`
 	client         *Langfuse
+	dataset        *Dataset
 }
 
 // DatasetRun represents an execution run of a dataset item
@@ -48,8 +56,10 @@ type DatasetRun struct {
 	SpanID      string                 `json:"spanId,omitempty"`
 	StartedAt   time.Time              `json:"startedAt"`
 	EndedAt     *time.Time             `json:"endedAt,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
 	client      *Langfuse
 	item        *DatasetItem
+	trace       *model.Trace
 }
 
 // DatasetClient provides dataset management functionality
@@ -110,6 +120,45 @@ func (dc *DatasetClient) CreateDataset(ctx context.Context, name string, descrip
 	return dataset, nil
 }
 
+// EnsureDataset returns the dataset called name, creating it with description
+// and metadata when it does not exist yet, so eval setup can be re-run safely.
+// An existing dataset is returned as stored, even if its description or
+// metadata differ. Unlike GetDataset and CreateDataset, it reads and writes
+// the dataset through the Langfuse API.
+func (dc *DatasetClient) EnsureDataset(ctx context.Context, name string, description string, metadata map[string]interface{}) (*Dataset, error) {
+	if name == "" {
+		return nil, fmt.Errorf("dataset name is required")
+	}
+
+	dataset := &Dataset{client: dc.client}
+	err := dc.client.client.GetDataset(ctx, name, dataset)
+	if err == nil {
+		return dataset.linkItems(), nil
+	}
+	if !isStatus(err, http.StatusNotFound) {
+		return nil, fmt.Errorf("dataset %s: %w", name, err)
+	}
+
+	req := &api.DatasetRequest{Name: name, Description: description, Metadata: metadata}
+	err = dc.client.client.CreateDataset(ctx, req, dataset)
+	if isStatus(err, http.StatusConflict) {
+		// Created concurrently since the lookup
+		err = dc.client.client.GetDataset(ctx, name, dataset)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dataset %s: %w", name, err)
+	}
+	return dataset.linkItems(), nil
+}
+
+// linkItems attaches items decoded from an API response to the dataset
+func (d *Dataset) linkItems() *Dataset {
+	for _, item := range d.Items {
+		item.client, item.dataset = d.client, d
+	}
+	return d
+}
+
 // ListDatasets retrieves all datasets with pagination
 func (dc *DatasetClient) ListDatasets(ctx context.Context, page int, limit int) ([]*Dataset, error) {
 	// In real implementation, this would call the Langfuse API with pagination
@@ -133,6 +182,7 @@ func (d *Dataset) LoadItems(ctx context.Context) error {
 			CreatedAt:      time.Now(),
 			UpdatedAt:      time.Now(),
 			client:         d.client,
+			dataset:        d,
 		},
 	}
 
@@ -150,6 +200,7 @@ func (d *Dataset) CreateItem(input interface{}, expectedOutput interface{}, meta
 		CreatedAt:      time.Now(),
 		UpdatedAt:      time.Now(),
 		client:         d.client,
+		dataset:        d,
 	}
 
 	// In real implementation, save to API
@@ -171,6 +222,7 @@ func (d *Dataset) CreateItemFromTrace(traceID string, spanID string, metadata ma
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 		client:        d.client,
+		dataset:       d,
 	}
 
 	d.Items = append(d.Items, item)
@@ -178,6 +230,33 @@ func (d *Dataset) CreateItemFromTrace(traceID string, spanID string, metadata ma
 	return item, nil
 }
 
+// ToDatasetItem adds the observation to dataset as an item, so an interesting
+// production case becomes an eval case in one call. The item's input is the
+// input given to SetInput and its expected output the output the observation
+// ended with, or was given with SetOutput; the observation's trace and ID are
+// recorded as its source. Unlike CreateItemFromTrace, the I/O is taken from
+// memory rather than fetched from the API.
+func (oc *ObserveContext) ToDatasetItem(dataset *Dataset, metadata map[string]interface{}) (*DatasetItem, error) {
+	if dataset == nil {
+		return nil, fmt.Errorf("dataset is required")
+	}
+
+	oc.mu.Lock()
+	input, output := oc.input, oc.output
+	oc.mu.Unlock()
+	if input == nil {
+		return nil, fmt.Errorf("observation %s has no captured input", oc.observationID)
+	}
+
+	item, err := dataset.CreateItem(input, output, metadata)
+	if err != nil {
+		return nil, err
+	}
+	item.SourceTraceID = oc.observer.TraceID()
+	item.SourceSpanID = oc.observationID
+	return item, nil
+}
+
 // GetItem retrieves a specific item by ID
 func (d *Dataset) GetItem(itemID string) (*DatasetItem, error) {
 	for _, item := range d.Items {
@@ -188,10 +267,90 @@ func (d *Dataset) GetItem(itemID string) (*DatasetItem, error) {
 	return nil, fmt.Errorf("item not found: %s", itemID)
 }
 
+// removeItem drops an item from the in-memory item list
+func (d *Dataset) removeItem(itemID string) {
+	for i, item := range d.Items {
+		if item.ID == itemID {
+			d.Items = append(d.Items[:i], d.Items[i+1:]...)
+			return
+		}
+	}
+}
+
 // DatasetItem methods
 
+// Update replaces the input, expected output and metadata of a persisted item
+func (di *DatasetItem) Update(ctx context.Context, input interface{}, expectedOutput interface{}, metadata map[string]interface{}) error {
+	if di.dataset == nil || di.dataset.Name == "" {
+		return fmt.Errorf("dataset item %s does not belong to a named dataset", di.ID)
+	}
+
+	// The upsert endpoint would silently create a missing item, so check it exists first
+	if err := di.client.client.GetDatasetItem(ctx, di.ID, nil); err != nil {
+		return datasetItemError(di.ID, err)
+	}
+
+	req := &api.DatasetItemRequest{
+		ID:                  di.ID,
+		DatasetName:         di.dataset.Name,
+		Input:               input,
+		ExpectedOutput:      expectedOutput,
+		Metadata:            metadata,
+		SourceTraceID:       di.SourceTraceID,
+		SourceObservationID: di.SourceSpanID,
+	}
+	if err := di.client.client.UpsertDatasetItem(ctx, req, nil); err != nil {
+		return datasetItemError(di.ID, err)
+	}
+
+	di.Input = input
+	di.ExpectedOutput = expectedOutput
+	di.Metadata = metadata
+	di.UpdatedAt = di.client.Now()
+
+	return nil
+}
+
+// Delete removes the item server-side and from its dataset's Items
+func (di *DatasetItem) Delete(ctx context.Context) error {
+	if err := di.client.client.DeleteDatasetItem(ctx, di.ID); err != nil {
+		return datasetItemError(di.ID, err)
+	}
+
+	if di.dataset != nil {
+		di.dataset.removeItem(di.ID)
+	}
+
+	return nil
+}
+
+// datasetItemError maps a not found response to ErrDatasetItemNotPersisted
+func datasetItemError(itemID string, err error) error {
+	if isStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("%w: %s", ErrDatasetItemNotPersisted, itemID)
+	}
+	return fmt.Errorf("dataset item %s: %w", itemID, err)
+}
+
+// isStatus reports whether err is an API response with the given status code
+func isStatus(err error, statusCode int) bool {
+	var statusErr *api.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
+
 // Run creates a new run for this dataset item
 func (di *DatasetItem) Run(name string, description string) (*DatasetRun, error) {
+	return di.RunInEnvironment(name, description, "")
+}
+
+// RunInEnvironment creates a run whose trace, spans and scores are recorded in
+// the given environment, keeping them out of production dashboards
+func (di *DatasetItem) RunInEnvironment(name string, description string, environment string) (*DatasetRun, error) {
+	return di.runWithTraceID(name, description, environment, uuid.New().String())
+}
+
+// runWithTraceID creates a run recorded in the trace with the given ID
+func (di *DatasetItem) runWithTraceID(name string, description string, environment string, traceID string) (*DatasetRun, error) {
 	run := &DatasetRun{
 		ID:          uuid.New().String(),
 		DatasetID:   di.DatasetID,
@@ -199,15 +358,16 @@ func (di *DatasetItem) Run(name string, description string) (*DatasetRun, error)
 		Name:        name,
 		Description: description,
 		Metadata:    make(map[string]interface{}),
-		StartedAt:   time.Now(),
+		StartedAt:   di.client.Now(),
+		Environment: environment,
 		client:      di.client,
 		item:        di,
 	}
 
 	// Create associated trace
-	startTime := time.Now()
+	startTime := run.StartedAt
 	trace := &model.Trace{
-		ID:        uuid.New().String(),
+		ID:        traceID,
 		Name:      fmt.Sprintf("dataset-run-%s", name),
 		Timestamp: &startTime,
 		Input:     di.Input,
@@ -217,6 +377,7 @@ func (di *DatasetItem) Run(name string, description string) (*DatasetRun, error)
 			"run_name":        name,
 			"run_description": description,
 		},
+		Environment: environment,
 	}
 
 	createdTrace, err := di.client.Trace(trace)
@@ -225,6 +386,7 @@ func (di *DatasetItem) Run(name string, description string) (*DatasetRun, error)
 	}
 
 	run.TraceID = createdTrace.ID
+	run.trace = trace
 
 	return run, nil
 }
@@ -234,20 +396,22 @@ type RunContext struct {
 	run       *DatasetRun
 	span      *model.Span
 	startTime time.Time
+	retries   int
 }
 
 // Start begins execution tracking for a run
 func (dr *DatasetRun) Start() *RunContext {
-	startTime := time.Now()
+	startTime := dr.client.Now()
 
 	// Create span for this run
 	span := &model.Span{
-		ID:        uuid.New().String(),
-		TraceID:   dr.TraceID,
-		Name:      dr.Name,
-		StartTime: &startTime,
-		Input:     dr.item.Input,
-		Metadata:  dr.Metadata,
+		ID:          uuid.New().String(),
+		TraceID:     dr.TraceID,
+		Name:        dr.Name,
+		StartTime:   &startTime,
+		Input:       dr.item.Input,
+		Metadata:    dr.Metadata,
+		Environment: dr.Environment,
 	}
 
 	_, err := dr.client.Span(span, nil)
@@ -265,7 +429,7 @@ func (dr *DatasetRun) Start() *RunContext {
 
 // End completes the run execution
 func (rc *RunContext) End(output interface{}, err error) error {
-	endTime := time.Now()
+	endTime := rc.run.client.Now()
 	rc.run.EndedAt = &endTime
 
 	// Update span with results
@@ -275,17 +439,52 @@ func (rc *RunContext) End(output interface{}, err error) error {
 	if err != nil {
 		metadata["error"] = err.Error()
 	}
+	if rc.retries > 0 {
+		metadata[RunRetriesKey] = rc.retries
+	}
 
 	rc.span.EndTime = &endTime
 	rc.span.Output = output
 	rc.span.Metadata = metadata
 
 	_, spanErr := rc.run.client.SpanEnd(rc.span)
+
+	// Record the outcome on the trace so resumed evaluations can skip the item
+	if trace := rc.run.trace; trace != nil {
+		status := RunStatusSucceeded
+		if err != nil {
+			status = RunStatusFailed
+		}
+		traceMetadata := make(map[string]interface{})
+		if existing, isMap := trace.Metadata.(map[string]interface{}); isMap {
+			for k, v := range existing {
+				traceMetadata[k] = v
+			}
+		}
+		traceMetadata[RunStatusKey] = status
+		trace.Metadata = traceMetadata
+		if _, traceErr := rc.run.client.Trace(&model.Trace{ID: trace.ID, Metadata: traceMetadata, Environment: trace.Environment}); traceErr != nil {
+			log.Printf("Failed to record run status: %v", traceErr)
+		}
+	}
+
 	return spanErr
 }
 
-// Score adds a score to the run
+// Trace returns a handle recording observations and scores in the run's trace,
+// nested under the run's span and in the run's environment
+func (rc *RunContext) Trace() *TraceHandle {
+	return &TraceHandle{l: rc.run.client, traceID: rc.run.TraceID, parentID: rc.run.SpanID, environment: rc.run.Environment}
+}
+
+// Score adds a score to the run. The score is queued and sent in the
+// background, so scoring every item of an evaluation does not wait on the network.
 func (rc *RunContext) Score(name string, value float64, comment string) error {
+	return rc.ScoreWithMetadata(name, value, comment, nil)
+}
+
+// ScoreWithMetadata adds a score carrying metadata such as the annotator or rubric version
+func (rc *RunContext) ScoreWithMetadata(name string, value float64, comment string, metadata map[string]interface{}) error {
 	score := &model.Score{
 		ID:            uuid.New().String(),
 		TraceID:       rc.run.TraceID,
@@ -293,58 +492,257 @@ func (rc *RunContext) Score(name string, value float64, comment string) error {
 		Value:         value,
 		Comment:       comment,
 		ObservationID: rc.run.SpanID,
+		Metadata:      metadata,
+		Environment:   rc.run.Environment,
 	}
 
 	_, err := rc.run.client.Score(score)
 	return err
 }
 
+// ScoreTrace adds a score for the run's whole trace rather than its span
+func (rc *RunContext) ScoreTrace(name string, value float64, comment string) error {
+	score := &model.Score{
+		ID:          uuid.New().String(),
+		TraceID:     rc.run.TraceID,
+		Name:        name,
+		Value:       value,
+		Comment:     comment,
+		Environment: rc.run.Environment,
+	}
+
+	_, err := rc.run.client.Score(score)
+	return err
+}
+
+// DefaultEvaluationEnvironment is the environment of traces recorded by a
+// DatasetEvaluator unless WithEnvironment overrides it
+const DefaultEvaluationEnvironment = "evaluation"
+
+// RunStatusKey is the trace metadata key holding the outcome of a dataset run
+const RunStatusKey = "run_status"
+
+// RunRetriesKey is the run metadata key holding how many times the runner was
+// retried, recorded for runs retried with WithItemRetries
+const RunRetriesKey = "retries"
+
+// Dataset run outcomes recorded under RunStatusKey when a run ends
+const (
+	RunStatusSucceeded = "succeeded"
+	RunStatusFailed    = "failed"
+)
+
 // DatasetEvaluator provides evaluation capabilities for datasets
 type DatasetEvaluator struct {
-	dataset   *Dataset
-	evaluator func(input interface{}, expectedOutput interface{}, actualOutput interface{}) (float64, error)
+	dataset     *Dataset
+	evaluator   func(input interface{}, expectedOutput interface{}, actualOutput interface{}) (float64, error)
+	environment string
+	resume      bool
+	progress    func(done int, total int, running EvaluationResult)
+	scorers     []namedScorer
+	weighted    []weightedScore
+	retries     int
+	backoff     time.Duration
 }
 
 // NewDatasetEvaluator creates a new dataset evaluator
 func NewDatasetEvaluator(dataset *Dataset, evaluator func(interface{}, interface{}, interface{}) (float64, error)) *DatasetEvaluator {
 	return &DatasetEvaluator{
-		dataset:   dataset,
-		evaluator: evaluator,
+		dataset:     dataset,
+		evaluator:   evaluator,
+		environment: DefaultEvaluationEnvironment,
+	}
+}
+
+// WithEnvironment sets the environment of the traces, spans and scores recorded
+// by evaluation runs. An empty environment records them like production traffic.
+// Langfuse reserves environment names starting with "langfuse".
+func (de *DatasetEvaluator) WithEnvironment(environment string) *DatasetEvaluator {
+	de.environment = environment
+	return de
+}
+
+// WithResume makes evaluations resumable: each item's run is recorded in a trace
+// whose ID is derived from the dataset name, item ID and run name, and items
+// whose trace already records a succeeded run are skipped. A run succeeds when
+// the runner returns no error, whatever its score; failed and unfinished runs
+// are retried, reusing their trace. Items whose completion cannot be checked are
+// run again.
+func (de *DatasetEvaluator) WithResume(enabled bool) *DatasetEvaluator {
+	de.resume = enabled
+	return de
+}
+
+// WithItemRetries retries an item's runner up to n times when it returns an
+// error, so transient failures such as timeouts do not count against the
+// evaluation. It waits backoff before the first retry, doubling the wait for
+// each further retry. The item is recorded as failed with the last error only
+// once its retries are exhausted, or when ctx is done while waiting. Retried
+// runs record the number of retries under RunRetriesKey in their metadata.
+func (de *DatasetEvaluator) WithItemRetries(n int, backoff time.Duration) *DatasetEvaluator {
+	de.retries = max(n, 0)
+	de.backoff = max(backoff, 0)
+	return de
+}
+
+// waitRetry waits before the given retry of an item, returning ctx's error if
+// it is done first
+func (de *DatasetEvaluator) waitRetry(ctx context.Context, retry int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	delay := de.backoff << (retry - 1)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// WithProgress calls progress after each item is run, skipped or fails to
+// start, with the number of items done, the number of items, and a snapshot of
+// the result so far whose "average" score covers the items run. Calls are made
+// one at a time from the goroutine running the evaluation, so progress should
+// return quickly.
+func (de *DatasetEvaluator) WithProgress(progress func(done int, total int, running EvaluationResult)) *DatasetEvaluator {
+	de.progress = progress
+	return de
+}
+
+// reportProgress passes a snapshot of results to the progress callback
+func (de *DatasetEvaluator) reportProgress(done int, results *EvaluationResult, totalScore float64, scored int) {
+	if de.progress == nil {
+		return
+	}
+
+	running := *results
+	running.Items = append([]*ItemResult(nil), results.Items...)
+	running.Scores = make(map[string]float64, len(results.Scores)+1)
+	for name, score := range results.Scores {
+		running.Scores[name] = score
+	}
+	if scored > 0 {
+		running.Scores["average"] = totalScore / float64(scored)
+	}
+	running.EndedAt = de.dataset.client.Now()
+
+	de.progress(done, len(de.dataset.Items), running)
+}
+
+// runTraceID returns the trace ID of an item's run, stable across invocations
+// when resuming
+func (de *DatasetEvaluator) runTraceID(item *DatasetItem, runName string) string {
+	if !de.resume {
+		return uuid.New().String()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("langfuse-go/dataset-run/"+de.dataset.Name+"/"+item.ID+"/"+runName)).String()
+}
+
+// runSucceeded reports whether the trace with traceID records a succeeded run
+func (de *DatasetEvaluator) runSucceeded(ctx context.Context, traceID string) bool {
+	trace, err := de.dataset.client.getTrace(ctx, traceID)
+	if err != nil {
+		var statusErr *api.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+			log.Printf("Failed to check dataset run %s: %v", traceID, err)
+		}
+		return false
+	}
+	metadata, _ := trace.Metadata.(map[string]interface{})
+	return metadata[RunStatusKey] == RunStatusSucceeded
+}
+
 // Evaluate runs evaluation on all dataset items
 func (de *DatasetEvaluator) Evaluate(ctx context.Context, runner func(interface{}) (interface{}, error)) (*EvaluationResult, error) {
+	return de.evaluate(ctx, "evaluation", "Automated evaluation run", nil, func(_ *RunContext, input interface{}) (interface{}, error) {
+		return runner(input)
+	})
+}
+
+// EvaluateWithRun runs evaluation on all dataset items like Evaluate, passing
+// runner the context of each item's run. Observations the runner records with
+// rc.Trace() nest under the run and share its environment.
+func (de *DatasetEvaluator) EvaluateWithRun(ctx context.Context, runner func(rc *RunContext, input interface{}) (interface{}, error)) (*EvaluationResult, error) {
+	return de.evaluate(ctx, "evaluation", "Automated evaluation run", nil, runner)
+}
+
+// evaluate runs every item through runner in a run with the given name and
+// metadata. The runner receives the run context so it can nest observations
+// under the run's trace.
+func (de *DatasetEvaluator) evaluate(ctx context.Context, runName string, runDescription string, runMetadata map[string]interface{}, runner func(*RunContext, interface{}) (interface{}, error)) (*EvaluationResult, error) {
+	if err := de.validateScorers(); err != nil {
+		return nil, err
+	}
+
 	results := &EvaluationResult{
 		DatasetID:   de.dataset.ID,
 		DatasetName: de.dataset.Name,
-		StartedAt:   time.Now(),
+		StartedAt:   de.dataset.client.Now(),
 		Items:       make([]*ItemResult, 0),
 		Scores:      make(map[string]float64),
 	}
 
 	totalScore := 0.0
+	scored := 0
+	var averages scoreAverages
+
+	for i, item := range de.dataset.Items {
+		traceID := de.runTraceID(item, runName)
+		if de.resume && de.runSucceeded(ctx, traceID) {
+			results.Items = append(results.Items, &ItemResult{
+				ItemID:         item.ID,
+				Input:          item.Input,
+				ExpectedOutput: item.ExpectedOutput,
+				TraceID:        traceID,
+				Skipped:        true,
+			})
+			de.reportProgress(i+1, results, totalScore, scored)
+			continue
+		}
 
-	for _, item := range de.dataset.Items {
 		// Create run for this item
-		run, err := item.Run("evaluation", "Automated evaluation run")
+		run, err := item.runWithTraceID(runName, runDescription, de.environment, traceID)
 		if err != nil {
+			de.reportProgress(i+1, results, totalScore, scored)
 			continue
 		}
+		for k, v := range runMetadata {
+			run.Metadata[k] = v
+		}
 
 		runCtx := run.Start()
 
-		// Execute runner
-		output, runErr := runner(item.Input)
+		// Execute runner, retrying failures
+		output, runErr := runner(runCtx, item.Input)
+		for retry := 1; runErr != nil && retry <= de.retries; retry++ {
+			if waitErr := de.waitRetry(ctx, retry); waitErr != nil {
+				break
+			}
+			runCtx.retries = retry
+			output, runErr = runner(runCtx, item.Input)
+		}
+		if runCtx.retries > 0 {
+			run.Metadata[RunRetriesKey] = runCtx.retries
+		}
 
 		// Calculate score
 		score := 0.0
+		var evaluation *float64
 		if runErr == nil && de.evaluator != nil {
 			evalScore, evalErr := de.evaluator(item.Input, item.ExpectedOutput, output)
 			if evalErr != nil {
 				log.Printf("Evaluator error: %v", evalErr)
 			} else {
 				score = evalScore
+				evaluation = &score
 			}
 		}
 
@@ -352,9 +750,11 @@ func (de *DatasetEvaluator) Evaluate(ctx context.Context, runner func(interface{
 		if endErr := runCtx.End(output, runErr); endErr != nil {
 			log.Printf("Failed to end run context: %v", endErr)
 		}
-		if scoreErr := runCtx.Score("evaluation", score, ""); scoreErr != nil {
+		if scoreErr := runCtx.Score(EvaluationScoreName, score, ""); scoreErr != nil {
 			log.Printf("Failed to record score: %v", scoreErr)
 		}
+		itemScores := de.scoreItem(runCtx, item, output, runErr, evaluation)
+		averages.add(itemScores)
 
 		// Record result
 		itemResult := &ItemResult{
@@ -363,20 +763,25 @@ func (de *DatasetEvaluator) Evaluate(ctx context.Context, runner func(interface{
 			ExpectedOutput: item.ExpectedOutput,
 			ActualOutput:   output,
 			Score:          score,
+			Scores:         itemScores,
 			Error:          runErr,
 			TraceID:        run.TraceID,
+			Retries:        runCtx.retries,
 		}
 
 		results.Items = append(results.Items, itemResult)
 		totalScore += score
+		scored++
+		de.reportProgress(i+1, results, totalScore, scored)
 	}
 
-	results.EndedAt = time.Now()
+	results.EndedAt = de.dataset.client.Now()
 
-	// Calculate aggregate scores
-	if len(results.Items) > 0 {
-		results.Scores["average"] = totalScore / float64(len(results.Items))
+	// Calculate aggregate scores over the items run in this invocation
+	if scored > 0 {
+		results.Scores["average"] = totalScore / float64(scored)
 	}
+	averages.apply(results.Scores)
 
 	return results, nil
 }
@@ -390,6 +795,7 @@ type EvaluationResult struct {
 	Items       []*ItemResult          `json:"items"`
 	Scores      map[string]float64     `json:"scores"`
 	Metadata    map[string]interface{} `json:"metadata"`
+	threshold   *float64
 }
 
 // ItemResult contains the result of evaluating a single dataset item
@@ -401,6 +807,12 @@ type ItemResult struct {
 	Score          float64     `json:"score"`
 	Error          error       `json:"error,omitempty"`
 	TraceID        string      `json:"traceId"`
+	// Skipped is set for items not run because a previous run succeeded
+	Skipped bool `json:"skipped,omitempty"`
+	// Retries counts the runner's retries under WithItemRetries
+	Retries int `json:"retries,omitempty"`
+	// Scores holds the scores of WithScorer and WithWeightedScore, with the evaluator's as "evaluation"
+	Scores map[string]float64 `json:"scores,omitempty"`
 }
 
 // Convenience methods on Langfuse client
@@ -411,6 +823,12 @@ func (l *Langfuse) GetDataset(ctx context.Context, nameOrID string) (*Dataset, e
 	return dc.GetDataset(ctx, nameOrID)
 }
 
+// EnsureDataset returns the dataset called name, creating it when missing (convenience method)
+func (l *Langfuse) EnsureDataset(ctx context.Context, name string, description string, metadata map[string]interface{}) (*Dataset, error) {
+	dc := l.NewDatasetClient()
+	return dc.EnsureDataset(ctx, name, description, metadata)
+}
+
 // CreateDataset creates a new dataset (convenience method)
 func (l *Langfuse) CreateDataset(ctx context.Context, name string, description string) (*Dataset, error) {
 	dc := l.NewDatasetClient()
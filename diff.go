@@ -0,0 +1,137 @@
+package langfuse
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxDiffLines bounds the differences listed by ItemResult.Diff
+const maxDiffLines = 50
+
+// Diff describes how the actual output differs from the expected output, one
+// difference per line with its JSON path, e.g. `$.answer: expected "Paris", got "Lyon"`.
+// Maps, slices and multi-line strings are compared element by element at any depth.
+// It returns an empty string when the outputs are equal.
+func (r *ItemResult) Diff() string {
+	var lines []string
+	diffValues("$", normalizeForDiff(r.ExpectedOutput), normalizeForDiff(r.ActualOutput), &lines)
+
+	if len(lines) > maxDiffLines {
+		omitted := len(lines) - maxDiffLines
+		lines = append(lines[:maxDiffLines], fmt.Sprintf("... %d more differences", omitted))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeForDiff converts v to its generic JSON form so that structs, typed
+// maps and numbers compare the same way the outputs are stored
+func normalizeForDiff(v interface{}) interface{} {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var generic interface{}
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return v
+	}
+	return generic
+}
+
+// diffValues appends the differences between expected and actual at path
+func diffValues(path string, expected, actual interface{}, lines *[]string) {
+	switch exp := expected.(type) {
+	case map[string]interface{}:
+		if act, isMap := actual.(map[string]interface{}); isMap {
+			diffMaps(path, exp, act, lines)
+			return
+		}
+	case []interface{}:
+		if act, isSlice := actual.([]interface{}); isSlice {
+			diffSlices(path, exp, act, lines)
+			return
+		}
+	case string:
+		if act, isString := actual.(string); isString && strings.Contains(exp+act, "\n") {
+			diffLines(path, exp, act, lines)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		*lines = append(*lines, fmt.Sprintf("%s: expected %s, got %s", path, formatDiffValue(expected), formatDiffValue(actual)))
+	}
+}
+
+func diffMaps(path string, expected, actual map[string]interface{}, lines *[]string) {
+	keys := make([]string, 0, len(expected)+len(actual))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	for k := range actual {
+		if _, inExpected := expected[k]; !inExpected {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		keyPath := path + "." + k
+		exp, inExpected := expected[k]
+		act, inActual := actual[k]
+		switch {
+		case !inActual:
+			*lines = append(*lines, fmt.Sprintf("%s: missing, expected %s", keyPath, formatDiffValue(exp)))
+		case !inExpected:
+			*lines = append(*lines, fmt.Sprintf("%s: unexpected %s", keyPath, formatDiffValue(act)))
+		default:
+			diffValues(keyPath, exp, act, lines)
+		}
+	}
+}
+
+func diffSlices(path string, expected, actual []interface{}, lines *[]string) {
+	for i := 0; i < max(len(expected), len(actual)); i++ {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(actual):
+			*lines = append(*lines, fmt.Sprintf("%s: missing, expected %s", itemPath, formatDiffValue(expected[i])))
+		case i >= len(expected):
+			*lines = append(*lines, fmt.Sprintf("%s: unexpected %s", itemPath, formatDiffValue(actual[i])))
+		default:
+			diffValues(itemPath, expected[i], actual[i], lines)
+		}
+	}
+}
+
+// diffLines compares multi-line strings line by line
+func diffLines(path string, expected, actual string, lines *[]string) {
+	expLines := strings.Split(expected, "\n")
+	actLines := strings.Split(actual, "\n")
+	for i := 0; i < max(len(expLines), len(actLines)); i++ {
+		linePath := fmt.Sprintf("%s line %d", path, i+1)
+		switch {
+		case i >= len(actLines):
+			*lines = append(*lines, fmt.Sprintf("%s: missing, expected %q", linePath, expLines[i]))
+		case i >= len(expLines):
+			*lines = append(*lines, fmt.Sprintf("%s: unexpected %q", linePath, actLines[i]))
+		case expLines[i] != actLines[i]:
+			*lines = append(*lines, fmt.Sprintf("%s: expected %q, got %q", linePath, expLines[i], actLines[i]))
+		}
+	}
+}
+
+// formatDiffValue renders a value compactly, as JSON where possible
+func formatDiffValue(v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	const maxLen = 120
+	if len(encoded) > maxLen {
+		return string(encoded[:maxLen]) + "..."
+	}
+	return string(encoded)
+}
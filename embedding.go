@@ -0,0 +1,182 @@
+package langfuse
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// metadataKeyObservationType holds the type of observations the ingestion API
+// stores under a more general type, such as embeddings recorded as generations
+const metadataKeyObservationType = "observation_type"
+
+// defaultEmbeddingName names embedding observations of observers without a name
+const defaultEmbeddingName = "embedding"
+
+// EmbeddingObservation is an embedding call started with Observer.Embedding
+type EmbeddingObservation struct {
+	observer  *Observer
+	client    *Langfuse
+	id        string
+	traceID   string
+	version   string
+	model     string
+	startTime time.Time
+	metadata  map[string]interface{}
+
+	endOnce sync.Once
+}
+
+// WithEmbeddingVectors records the full vectors passed to
+// EmbeddingObservation.EndWithVector as the output of embedding observations.
+// By default only their dimensionality is recorded, as vectors are large and
+// rarely useful to read.
+func WithEmbeddingVectors(include bool) ObserveOption {
+	return func(o *Observer) {
+		o.embeddingVectors = include
+	}
+}
+
+// Embedding starts an embedding observation of a call to modelName for input,
+// e.g. the query embedded in a retrieval step. It is recorded as a generation
+// marked with the "embedding" observation type, nested under the observation
+// active in ctx (see Observe) or else under the observer's parent, and named
+// after the observer or "embedding". Finish it with End or EndWithVector.
+func (o *Observer) Embedding(ctx context.Context, modelName string, input interface{}) *EmbeddingObservation {
+	startTime := o.clock.Now()
+	name := o.name
+	if name == "" {
+		name = defaultEmbeddingName
+	}
+	name = o.sanitizeName(name)
+
+	// The observation active in ctx takes precedence over the observer's own parent
+	scope := o.scopeFor(ctx)
+	if ambient := ObserverFromContext(ctx); ambient != nil && ambient != o {
+		if traceID := ambient.TraceID(); traceID != "" {
+			scope.traceID, scope.parentID = traceID, ambient.parentID
+		}
+	}
+	if scope.traceID == "" {
+		scope.traceID = o.joinOrCreateTrace(func() (string, bool) {
+			created, err := o.client.Trace(&model.Trace{
+				ID:        uuid.New().String(),
+				Name:      name,
+				Timestamp: &startTime,
+				SessionID: o.sessionID,
+				UserID:    o.userID,
+				Version:   o.version,
+				Metadata:  o.metadata,
+			})
+			if err != nil {
+				return "", false
+			}
+			return created.ID, true
+		})
+	}
+
+	metadata := make(map[string]interface{}, len(o.metadata)+1)
+	for key, value := range o.metadata {
+		metadata[key] = value
+	}
+	metadata[metadataKeyObservationType] = ObservationTypeEmbedding
+
+	e := &EmbeddingObservation{
+		observer:  o,
+		client:    scope.client,
+		id:        uuid.New().String(),
+		traceID:   scope.traceID,
+		version:   scope.version,
+		model:     scope.client.NormalizeModel(modelName),
+		startTime: startTime,
+		metadata:  metadata,
+	}
+
+	generation := &model.Generation{
+		ID:        e.id,
+		TraceID:   e.traceID,
+		Version:   e.version,
+		Name:      name,
+		Model:     e.model,
+		StartTime: &startTime,
+		Metadata:  metadata,
+		Level:     o.level,
+	}
+	if o.captureIO && !e.client.filtersLevel(o.level) {
+		generation.Input = o.limits.captureValue(reflect.ValueOf(input), 0)
+	}
+	if _, err := e.client.Generation(generation, scope.parentID); err != nil {
+		log.Printf("Failed to create embedding: %v", err)
+	}
+	return e
+}
+
+// ID returns the observation ID of the embedding
+func (e *EmbeddingObservation) ID() string {
+	return e.id
+}
+
+// End records the dimensionality of the vectors produced and the usage of the
+// call. Only the first call of End, EndWithVector or EndWithError is recorded.
+func (e *EmbeddingObservation) End(vectorDims int, usage model.Usage) {
+	e.end(map[string]interface{}{"dimensions": vectorDims}, usage, nil)
+}
+
+// EndWithVector is End for the vector produced: its length is recorded as the
+// dimensionality, and the vector itself when the observer was created with
+// WithEmbeddingVectors(true)
+func (e *EmbeddingObservation) EndWithVector(vector interface{}, usage model.Usage) {
+	output := map[string]interface{}{"dimensions": 0}
+	if v := reflect.ValueOf(vector); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		output["dimensions"] = v.Len()
+	}
+	if e.observer.embeddingVectors {
+		output["vector"] = vector
+	}
+	e.end(output, usage, nil)
+}
+
+// EndWithError records the embedding call as failed with err
+func (e *EmbeddingObservation) EndWithError(err error) {
+	e.end(nil, model.Usage{}, err)
+}
+
+func (e *EmbeddingObservation) end(output map[string]interface{}, usage model.Usage, err error) {
+	e.endOnce.Do(func() {
+		endTime := e.observer.clock.Now()
+
+		// Ingestion replaces metadata on upsert, so resend the start metadata
+		metadata := make(map[string]interface{}, len(e.metadata)+2)
+		for key, value := range e.metadata {
+			metadata[key] = value
+		}
+		metadata["duration_ms"] = endTime.Sub(e.startTime).Milliseconds()
+
+		generation := &model.Generation{
+			ID:      e.id,
+			TraceID: e.traceID,
+			Version: e.version,
+			Model:   e.model,
+			EndTime: &endTime,
+			Usage:   usage,
+		}
+		if output != nil {
+			generation.Output = output
+		}
+		if err != nil {
+			metadata["error"] = err.Error()
+			generation.Level = model.ObservationLevelError
+			generation.StatusMessage = err.Error()
+		}
+		generation.Metadata = metadata
+
+		if _, endErr := e.client.GenerationEnd(generation); endErr != nil {
+			log.Printf("Failed to end embedding: %v", endErr)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package langfuse
+
+import (
+	"log"
+	"sync"
+)
+
+const errorQueueSize = 64
+
+// errorReporter delivers ingestion errors to a user handler on its own goroutine,
+// so a slow or blocking handler never stalls ingestion
+type errorReporter struct {
+	mu      sync.RWMutex
+	handler func(error)
+	errs    chan error
+	start   sync.Once
+}
+
+// setHandler sets the handler; nil disables reporting
+func (r *errorReporter) setHandler(fn func(error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handler = fn
+}
+
+// enabled reports whether a handler is set
+func (r *errorReporter) enabled() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.handler != nil
+}
+
+// report queues err for the handler. Errors are discarded when the queue is full.
+func (r *errorReporter) report(err error) {
+	if err == nil || !r.enabled() {
+		return
+	}
+
+	r.start.Do(func() {
+		r.errs = make(chan error, errorQueueSize)
+		go r.run()
+	})
+
+	select {
+	case r.errs <- err:
+	default:
+		log.Printf("Dropped ingestion error, error handler is falling behind: %v", err)
+	}
+}
+
+// run calls the handler for each queued error
+func (r *errorReporter) run() {
+	for err := range r.errs {
+		r.mu.RLock()
+		handler := r.handler
+		r.mu.RUnlock()
+
+		if handler != nil {
+			r.call(handler, err)
+		}
+	}
+}
+
+// call invokes the handler, recovering from panics so reporting keeps running
+func (r *errorReporter) call(handler func(error), err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Error handler panicked: %v", p)
+		}
+	}()
+	handler(err)
+}
+
+// WithErrorHandler sets a function called whenever an ingestion batch fails to
+// send, the server rejects an event, or an event too large to send is dropped.
+// The ingestion queue is unbounded, so events are never dropped for lack of
+// space. The handler runs on a separate goroutine, so it may block without
+// stalling ingestion or Flush.
+func (l *Langfuse) WithErrorHandler(handler func(error)) *Langfuse {
+	l.errReporter.setHandler(handler)
+	return l
+}
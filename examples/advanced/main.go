@@ -18,9 +18,6 @@ func main() {
 	_ = os.Setenv("LANGFUSE_PUBLIC_KEY", "your_public_key")
 	_ = os.Setenv("LANGFUSE_SECRET_KEY", "your_secret_key")
 
-	// Create advanced workflow with conditional logic
-	workflow := createAdvancedWorkflow()
-
 	// Use builder pattern for configuration
 	hook := langgraph.NewBuilder().
 		WithTraceName("advanced_ai_workflow").
@@ -33,9 +30,13 @@ func main() {
 			"environment": "production",
 		}).
 		WithTags("advanced", "ai", "conditional").
+		WithEdgeTracing(true). // Record which branch check_cache takes
 		WithAutoFlush(true).
 		Build()
 
+	// Create advanced workflow with conditional logic
+	workflow := createAdvancedWorkflow(hook)
+
 	// Create tracer with hook
 	tracer := graph.NewTracer()
 	tracer.AddHook(hook)
@@ -74,7 +75,7 @@ func main() {
 }
 
 // createAdvancedWorkflow creates a complex workflow with multiple paths
-func createAdvancedWorkflow() *graph.StateGraph {
+func createAdvancedWorkflow(hook graph.TraceHook) *graph.StateGraph {
 	type AIResponse struct {
 		Content    string
 		Model      string
@@ -237,7 +238,7 @@ func createAdvancedWorkflow() *graph.StateGraph {
 
 	// Conditional routing based on cache and complexity
 	workflow.AddConditionalEdges("check_cache",
-		func(ctx context.Context, state interface{}) string {
+		langgraph.TracedCondition(hook, "check_cache", func(ctx context.Context, state interface{}) string {
 			ws := state.(WorkflowState)
 			// If cached, skip to formatting
 			if ws.Response.Content != "" {
@@ -248,7 +249,7 @@ func createAdvancedWorkflow() *graph.StateGraph {
 				return "complex_ai_generation"
 			}
 			return "simple_ai_generation"
-		},
+		}),
 		map[string]string{
 			"simple_ai_generation":  "simple_ai_generation",
 			"complex_ai_generation": "complex_ai_generation",
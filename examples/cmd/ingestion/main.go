@@ -11,17 +11,12 @@ import (
 func main() {
 	l := langfuse.New(context.Background())
 
-	trace, err := l.Trace(&model.Trace{Name: "test-trace"})
-	if err != nil {
-		panic(err)
-	}
+	// The trace, span and generation are needed by later calls, so stop on failure
+	trace := langfuse.Must(l.Trace(&model.Trace{Name: "test-trace"}))
 
-	span, spanErr := l.Span(&model.Span{Name: "test-span", TraceID: trace.ID}, nil)
-	if spanErr != nil {
-		panic(spanErr)
-	}
+	span := langfuse.Must(l.Span(&model.Span{Name: "test-span", TraceID: trace.ID}, nil))
 
-	generation, genErr := l.Generation(
+	generation := langfuse.Must(l.Generation(
 		&model.Generation{
 			TraceID: trace.ID,
 			Name:    "test-generation",
@@ -45,12 +40,10 @@ func main() {
 			},
 		},
 		&span.ID,
-	)
-	if genErr != nil {
-		panic(genErr)
-	}
+	))
 
-	_, eventErr := l.Event(
+	// Events, scores and ends are not fatal: log their errors and carry on
+	langfuse.LogError(l.Event(
 		&model.Event{
 			Name:    "test-event",
 			TraceID: trace.ID,
@@ -65,37 +58,27 @@ func main() {
 			},
 		},
 		&generation.ID,
-	)
-	if eventErr != nil {
-		panic(eventErr)
-	}
+	))
 
 	generation.Output = model.M{
 		"completion": "The Q3 OKRs contain goals for multiple teams...",
 	}
-	_, genEndErr := l.GenerationEnd(generation)
-	if genEndErr != nil {
-		panic(genEndErr)
-	}
+	langfuse.LogError(l.GenerationEnd(generation))
 
-	_, scoreErr := l.Score(
+	langfuse.LogError(l.Score(
 		&model.Score{
 			TraceID: trace.ID,
 			Name:    "test-score",
 			Value:   0.9,
 		},
-	)
-	if scoreErr != nil {
-		panic(scoreErr)
-	}
+	))
 
-	_, spanEndErr := l.SpanEnd(span)
-	if spanEndErr != nil {
-		panic(spanEndErr)
-	}
+	langfuse.LogError(l.SpanEnd(span))
 
-	if err := l.Flush(context.Background()); err != nil {
-		log.Printf("Failed to flush: %v", err)
+	result := l.FlushWithResult(context.Background())
+	log.Printf("Flushed %d events (%d failed)", result.Sent, result.Failed)
+	for _, flushErr := range result.Errors {
+		log.Printf("Failed to flush: %v", flushErr)
 	}
 
 }
@@ -4,4 +4,6 @@ go 1.22.0
 
 require github.com/paulnegz/langfuse-go v0.0.0
 
-replace github.com/paulnegz/langfuse-go => ../
\ No newline at end of file
+require github.com/google/uuid v1.6.0 // indirect
+
+replace github.com/paulnegz/langfuse-go => ../
@@ -17,9 +17,6 @@ func main() {
 	_ = os.Setenv("LANGFUSE_PUBLIC_KEY", "your_public_key")
 	_ = os.Setenv("LANGFUSE_SECRET_KEY", "your_secret_key")
 
-	// Create streaming workflow
-	workflow := createStreamingWorkflow()
-
 	// Create hook with streaming optimizations
 	hook := langgraph.NewHook(
 		langgraph.WithTraceName("streaming_workflow"),
@@ -31,6 +28,9 @@ func main() {
 		langgraph.WithAutoFlush(true), // Important for streaming
 	)
 
+	// Create streaming workflow; its nodes record chunks through the hook
+	workflow := createStreamingWorkflow(hook)
+
 	// Create traced runnable using helper
 	tracedWorkflow := langgraph.NewTracedRunnable(
 		workflow.Compile(),
@@ -43,30 +43,21 @@ func main() {
 		"chunk_size": 5,
 	}
 
-	// Execute with streaming
-	ctx := context.Background()
+	// Execute with streaming. State graphs deliver their final state once;
+	// graphs compiled from graph.NewMessageGraph stream each node's output.
+	// The timeout stops the stream even while a node is still running.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 	outputChan, errorChan := tracedWorkflow.Stream(ctx, input)
 
 	// Process streaming results
 	fmt.Println("Starting streaming workflow...")
 
-	done := false
-	for !done {
-		select {
-		case output, ok := <-outputChan:
-			if !ok {
-				done = true
-				break
-			}
-			fmt.Printf("Received chunk: %v\n", output)
-		case err, ok := <-errorChan:
-			if ok && err != nil {
-				log.Printf("Stream error: %v", err)
-			}
-		case <-time.After(5 * time.Second):
-			log.Println("Stream timeout")
-			done = true
-		}
+	for output := range outputChan {
+		fmt.Printf("Received chunk: %v\n", output)
+	}
+	if err := <-errorChan; err != nil {
+		log.Printf("Stream error: %v", err)
 	}
 
 	fmt.Println("Streaming completed!")
@@ -76,7 +67,7 @@ func main() {
 }
 
 // createStreamingWorkflow creates a workflow that processes data in streams
-func createStreamingWorkflow() *graph.StateGraph {
+func createStreamingWorkflow(hook *langgraph.Hook) *graph.StateGraph {
 	type StreamState struct {
 		Text       string
 		ChunkSize  int
@@ -128,6 +119,9 @@ func createStreamingWorkflow() *graph.StateGraph {
 		processed := fmt.Sprintf("[PROCESSED: %s]", chunk)
 		state.Processed = append(state.Processed, processed)
 
+		// Record the chunk as an event under this node's observation
+		hook.RecordChunk(ctx, "process_chunk", state.CurrentIdx, processed)
+
 		log.Printf("Processed chunk %d/%d", state.CurrentIdx+1, len(state.Chunks))
 		state.CurrentIdx++
 
@@ -0,0 +1,267 @@
+package langfuse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
+)
+
+// ExportFormat selects the format ExportTrace writes
+type ExportFormat string
+
+const (
+	// ExportJSON writes the trace bundle as indented JSON
+	ExportJSON ExportFormat = "json"
+	// ExportHTML writes a standalone HTML page rendering the trace, with the
+	// JSON bundle embedded in it
+	ExportHTML ExportFormat = "html"
+)
+
+// mediaAccessNote explains why exported media references cannot be opened offline
+const mediaAccessNote = "Media is not embedded in the export. Viewing it requires access to the Langfuse project the trace was exported from."
+
+// langfuseMediaToken matches the media reference tokens Langfuse stores in payloads
+var langfuseMediaToken = regexp.MustCompile(`@@@langfuseMedia:[^@]*@@@`)
+
+// TraceExport is the self-contained bundle ExportTrace writes: a trace with
+// its observations and scores, and the media referenced by their payloads
+type TraceExport struct {
+	ExportedAt time.Time         `json:"exportedAt"`
+	Host       string            `json:"host"`
+	Trace      *api.TraceDetails `json:"trace"`
+	Media      []ExportedMedia   `json:"media,omitempty"`
+	// MediaNote explains how to view the media, set when there is any
+	MediaNote string `json:"mediaNote,omitempty"`
+}
+
+// ExportedMedia is a media reference found in an exported payload
+type ExportedMedia struct {
+	Reference string `json:"reference"`
+	// ObservationID is empty for media of the trace itself
+	ObservationID string `json:"observationId,omitempty"`
+	// Field is "input", "output" or "metadata"
+	Field string `json:"field"`
+}
+
+// ExportTrace fetches a trace with its observations and scores and writes it
+// to w as a self-contained bundle, e.g. to attach to a support ticket or review
+// offline without access to Langfuse. ExportJSON writes the bundle as JSON;
+// ExportHTML writes a page rendering the trace, its observation tree and
+// scores, with the JSON embedded. Media is not downloaded: references to it
+// are listed in the bundle, and viewing them requires access to the project.
+func (l *Langfuse) ExportTrace(ctx context.Context, traceID string, w io.Writer, format ExportFormat) error {
+	if format != ExportJSON && format != ExportHTML {
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	trace, err := l.getTrace(ctx, traceID)
+	if err != nil {
+		return fmt.Errorf("trace %s: %w", traceID, err)
+	}
+
+	export := &TraceExport{
+		ExportedAt: l.Now().UTC(),
+		Host:       l.client.BaseURL(),
+		Trace:      trace,
+		Media:      exportedMedia(trace),
+	}
+	if len(export.Media) > 0 {
+		export.MediaNote = mediaAccessNote
+	}
+
+	if format == ExportJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	}
+	return writeTraceHTML(w, export)
+}
+
+// exportedMedia lists the media references in the payloads of trace and its observations
+func exportedMedia(trace *api.TraceDetails) []ExportedMedia {
+	var media []ExportedMedia
+	collect := func(observationID string, field string, value interface{}) {
+		for _, reference := range mediaReferences(value, nil) {
+			media = append(media, ExportedMedia{Reference: reference, ObservationID: observationID, Field: field})
+		}
+	}
+
+	collect("", "input", trace.Input)
+	collect("", "output", trace.Output)
+	collect("", "metadata", trace.Metadata)
+	for _, observation := range trace.Observations {
+		collect(observation.ID, "input", observation.Input)
+		collect(observation.ID, "output", observation.Output)
+		collect(observation.ID, "metadata", observation.Metadata)
+	}
+	return media
+}
+
+// mediaReferences appends the media references found in value to references
+func mediaReferences(value interface{}, references []string) []string {
+	switch v := value.(type) {
+	case string:
+		if IsMediaReference(v) {
+			return append(references, v)
+		}
+		return append(references, langfuseMediaToken.FindAllString(v, -1)...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			references = mediaReferences(v[key], references)
+		}
+	case []interface{}:
+		for _, item := range v {
+			references = mediaReferences(item, references)
+		}
+	}
+	return references
+}
+
+// exportedObservation is an observation as rendered in the HTML export
+type exportedObservation struct {
+	api.Observation
+	Depth  int
+	Input  string
+	Output string
+}
+
+// writeTraceHTML renders export as a standalone HTML page
+func writeTraceHTML(w io.Writer, export *TraceExport) error {
+	bundle, err := json.Marshal(export)
+	if err != nil {
+		return fmt.Errorf("failed to encode trace: %w", err)
+	}
+
+	return traceHTMLTemplate.Execute(w, map[string]interface{}{
+		"Export":       export,
+		"Input":        prettyJSON(export.Trace.Input),
+		"Output":       prettyJSON(export.Trace.Output),
+		"Observations": observationTree(export.Trace.Observations),
+		"Bundle":       template.JS(bundle),
+	})
+}
+
+// observationTree orders observations depth-first by start time, children
+// after their parent, recording each one's nesting depth
+func observationTree(observations []api.Observation) []exportedObservation {
+	children := make(map[string][]api.Observation)
+	known := make(map[string]bool, len(observations))
+	for _, observation := range observations {
+		known[observation.ID] = true
+	}
+	for _, observation := range observations {
+		parent := observation.ParentObservationID
+		if !known[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], observation)
+	}
+
+	var tree []exportedObservation
+	var visit func(parentID string, depth int)
+	visit = func(parentID string, depth int) {
+		siblings := children[parentID]
+		sort.SliceStable(siblings, func(i, j int) bool {
+			a, b := siblings[i].StartTime, siblings[j].StartTime
+			return a != nil && (b == nil || a.Before(*b))
+		})
+		for _, observation := range siblings {
+			tree = append(tree, exportedObservation{
+				Observation: observation,
+				Depth:       depth,
+				Input:       prettyJSON(observation.Input),
+				Output:      prettyJSON(observation.Output),
+			})
+			visit(observation.ID, depth+1)
+		}
+	}
+	visit("", 0)
+	return tree
+}
+
+// prettyJSON formats a payload for display, "" when there is none
+func prettyJSON(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, isString := value.(string); isString {
+		return s
+	}
+	formatted, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(formatted)
+}
+
+var traceHTMLTemplate = template.Must(template.New("trace").Funcs(template.FuncMap{
+	"indent": func(depth int) string { return fmt.Sprintf("%dem", depth*2) },
+	"score": func(s api.Score) string {
+		if s.StringValue != "" {
+			return s.StringValue
+		}
+		if s.Value != nil {
+			return fmt.Sprint(*s.Value)
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Trace {{.Export.Trace.Name}} {{.Export.Trace.ID}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+pre { background: #f5f5f5; padding: 0.5em; white-space: pre-wrap; word-break: break-word; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+.observation { border-left: 3px solid #8ab; margin: 0.5em 0; padding-left: 0.8em; }
+.level-ERROR { border-left-color: #c33; }
+.level-WARNING { border-left-color: #d90; }
+</style>
+</head>
+<body>
+<h1>{{.Export.Trace.Name}}</h1>
+<p>Trace <code>{{.Export.Trace.ID}}</code> from {{.Export.Host}}, exported {{.Export.ExportedAt.Format "2006-01-02 15:04:05 MST"}}</p>
+<table>
+<tr><th>Timestamp</th><td>{{.Export.Trace.Timestamp.Format "2006-01-02 15:04:05.000 MST"}}</td></tr>
+{{with .Export.Trace.UserID}}<tr><th>User</th><td>{{.}}</td></tr>{{end}}
+{{with .Export.Trace.SessionID}}<tr><th>Session</th><td>{{.}}</td></tr>{{end}}
+{{with .Export.Trace.Tags}}<tr><th>Tags</th><td>{{range $i, $tag := .}}{{if $i}}, {{end}}{{$tag}}{{end}}</td></tr>{{end}}
+</table>
+{{with .Input}}<h2>Input</h2><pre>{{.}}</pre>{{end}}
+{{with .Output}}<h2>Output</h2><pre>{{.}}</pre>{{end}}
+{{with .Observations}}<h2>Observations</h2>
+{{range .}}<div class="observation level-{{.Level}}" style="margin-left: {{indent .Depth}}">
+<strong>{{.Name}}</strong> {{.Type}}{{with .Model}} · {{.}}{{end}}{{with .StartTime}} · {{.Format "15:04:05.000"}}{{end}}{{with .EndTime}} – {{.Format "15:04:05.000"}}{{end}}
+{{with .StatusMessage}}<p>{{.}}</p>{{end}}
+{{with .Input}}<details><summary>Input</summary><pre>{{.}}</pre></details>{{end}}
+{{with .Output}}<details><summary>Output</summary><pre>{{.}}</pre></details>{{end}}
+</div>
+{{end}}{{end}}
+{{with .Export.Trace.Scores}}<h2>Scores</h2>
+<table>
+<tr><th>Name</th><th>Value</th><th>Observation</th><th>Comment</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{score .}}</td><td>{{.ObservationID}}</td><td>{{.Comment}}</td></tr>
+{{end}}</table>{{end}}
+{{with .Export.Media}}<h2>Media</h2>
+<p>{{$.Export.MediaNote}}</p>
+<ul>
+{{range .}}<li><code>{{.Reference}}</code> in the {{.Field}} of {{with .ObservationID}}observation <code>{{.}}</code>{{else}}the trace{{end}}</li>
+{{end}}</ul>{{end}}
+<script type="application/json" id="trace-export">{{.Bundle}}</script>
+</body>
+</html>
+`))
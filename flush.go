@@ -0,0 +1,119 @@
+package langfuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
+)
+
+// ErrIngestionCanceled is reported for batches whose context was done before they
+// were sent, e.g. during shutdown. Canceled batches are not retried.
+var ErrIngestionCanceled = api.ErrCanceled
+
+// ErrPayloadTooLarge is reported for events the server rejected as too large
+// even when sent alone. Larger batches it rejects are split and resent.
+var ErrPayloadTooLarge = api.ErrPayloadTooLarge
+
+// FlushResult reports the outcome of the ingestion batches sent since the previous flush
+type FlushResult struct {
+	// Sent is the number of events accepted by the server
+	Sent int
+	// Failed is the number of events that were rejected or could not be sent
+	Failed int
+	// Errors holds one entry per failed batch or rejected event
+	Errors []error
+}
+
+// Err returns the joined errors of the flush, or nil if every event was sent
+func (r FlushResult) Err() error {
+	return errors.Join(r.Errors...)
+}
+
+// flushStats accumulates ingestion outcomes between flushes
+type flushStats struct {
+	mu     sync.Mutex
+	result FlushResult
+}
+
+// record adds the outcome of one ingestion batch and returns its errors
+func (s *flushStats) record(events int, res *api.IngestionResponse, err error) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		batchErr := fmt.Errorf("batch of %d events: %w", events, err)
+		s.result.Failed += events
+		s.result.Errors = append(s.result.Errors, batchErr)
+		return []error{batchErr}
+	}
+
+	errs := make([]error, 0, len(res.Errors))
+	for _, eventErr := range res.Errors {
+		errs = append(errs, fmt.Errorf("event %s: status %d: %s", eventErr.ID, eventErr.Status, eventErr.Message))
+	}
+	s.result.Errors = append(s.result.Errors, errs...)
+	s.result.Failed += len(res.Errors)
+	s.result.Sent += events - len(res.Errors)
+	return errs
+}
+
+// take returns the accumulated outcome and resets it
+func (s *flushStats) take() FlushResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := s.result
+	s.result = FlushResult{}
+	return result
+}
+
+// FlushWithResult sends all pending events, waits for in-flight batches and
+// reports what happened to the batches sent since the previous flush
+func (l *Langfuse) FlushWithResult(ctx context.Context) FlushResult {
+	l.observer.Wait(ctx)
+
+	idle := make(chan struct{})
+	go func() {
+		l.limiter.waitIdle()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		result := l.stats.take()
+		result.Errors = append(result.Errors, fmt.Errorf("flush interrupted: %w", ctx.Err()))
+		return result
+	}
+
+	return l.stats.take()
+}
+
+// Flush sends all pending events and returns the aggregate ingestion error, if any.
+// With WithAsyncFlush it only asks the background loop to send them and returns nil.
+func (l *Langfuse) Flush(ctx context.Context) error {
+	if l.asyncFlush.Load() {
+		l.observer.Signal()
+		return nil
+	}
+	return l.FlushSync(ctx)
+}
+
+// FlushSync sends all pending events and waits for them regardless of
+// WithAsyncFlush, returning the aggregate ingestion error, if any
+func (l *Langfuse) FlushSync(ctx context.Context) error {
+	return l.FlushWithResult(ctx).Err()
+}
+
+// WithAsyncFlush makes Flush return immediately after asking the background
+// loop to send pending events, keeping delivery off the request path when
+// Flush is called at the end of every request. Flushes requested while one
+// is pending are coalesced. Ingestion errors still reach the WithErrorHandler
+// handler. FlushSync, FlushWithResult and Shutdown always wait for delivery.
+func (l *Langfuse) WithAsyncFlush(enabled bool) *Langfuse {
+	l.asyncFlush.Store(enabled)
+	return l
+}
@@ -0,0 +1,101 @@
+package langfuse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GateError lists the requirements an evaluation did not meet
+type GateError struct {
+	Failures []string
+}
+
+func (e *GateError) Error() string {
+	return "evaluation gate failed: " + strings.Join(e.Failures, "; ")
+}
+
+// GateOption adds a requirement to Check and PassesGate
+type GateOption func(*gate)
+
+type gate struct {
+	passRates []passRate
+}
+
+type passRate struct {
+	rate     float64
+	minScore float64
+}
+
+// WithItemPassRate requires at least rate (0 to 1) of the items run to score
+// minScore or more without an error, e.g. WithItemPassRate(0.95, 0.8) for
+// "95% of items must score at least 0.8". Skipped items are not counted.
+func WithItemPassRate(rate float64, minScore float64) GateOption {
+	return func(g *gate) {
+		g.passRates = append(g.passRates, passRate{rate: rate, minScore: minScore})
+	}
+}
+
+// ItemPassRate returns the fraction of items run that scored minScore or more
+// without an error, or zero when no item ran
+func (r *EvaluationResult) ItemPassRate(minScore float64) float64 {
+	run, passed := 0, 0
+	for _, item := range r.Items {
+		if item.Skipped {
+			continue
+		}
+		run++
+		if item.Error == nil && item.Score >= minScore {
+			passed++
+		}
+	}
+	if run == 0 {
+		return 0
+	}
+	return float64(passed) / float64(run)
+}
+
+// Check verifies that each named aggregate score, such as "average", reaches
+// its minimum in thresholds and that the item pass rates are met. It returns
+// a *GateError listing every unmet requirement, or nil when all are met. A
+// threshold on a score the result does not have fails.
+func (r *EvaluationResult) Check(thresholds map[string]float64, opts ...GateOption) error {
+	var g gate
+	for _, opt := range opts {
+		opt(&g)
+	}
+
+	names := make([]string, 0, len(thresholds))
+	for name := range thresholds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []string
+	for _, name := range names {
+		minimum := thresholds[name]
+		score, found := r.Scores[name]
+		switch {
+		case !found:
+			failures = append(failures, fmt.Sprintf("score %s is missing (minimum %.3f)", name, minimum))
+		case score < minimum:
+			failures = append(failures, fmt.Sprintf("score %s is %.3f, below %.3f", name, score, minimum))
+		}
+	}
+	for _, required := range g.passRates {
+		if rate := r.ItemPassRate(required.minScore); rate < required.rate {
+			failures = append(failures, fmt.Sprintf("%.1f%% of items scored at least %.3f, below %.1f%%", rate*100, required.minScore, required.rate*100))
+		}
+	}
+
+	if len(failures) > 0 {
+		return &GateError{Failures: failures}
+	}
+	return nil
+}
+
+// PassesGate reports whether the evaluation meets every requirement of Check.
+// Use Check to find out which requirements failed.
+func (r *EvaluationResult) PassesGate(thresholds map[string]float64, opts ...GateOption) bool {
+	return r.Check(thresholds, opts...) == nil
+}
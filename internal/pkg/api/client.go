@@ -2,13 +2,16 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 )
@@ -17,40 +20,168 @@ const (
 	langfuseDefaultEndpoint = "https://cloud.langfuse.com"
 	ingestionPath           = "/api/public/ingestion"
 	defaultTimeout          = 30 * time.Second
+
+	// SDKName identifies this SDK in request headers and batch metadata
+	SDKName = "langfuse-go"
+	// defaultSDKVersion is reported until WithSDKVersion sets the release version
+	defaultSDKVersion = "dev"
 )
 
+// ErrCanceled is returned when a request's context is done before the request
+// completes. Canceled requests must not be retried.
+var ErrCanceled = errors.New("request canceled")
+
+// ErrPayloadTooLarge matches errors of requests the server rejected with
+// 413 Request Entity Too Large
+var ErrPayloadTooLarge = errors.New("payload too large")
+
 type Client struct {
 	httpClient *http.Client
 	baseURL    string
 	publicKey  string
 	secretKey  string
+
+	compress         bool
+	compressionLevel int
+
+	sdkVersion    string
+	schemaVersion int
+	userAgent     string
+
+	checksumHeader string
 }
 
-func New() *Client {
+// DefaultBaseURL returns LANGFUSE_HOST, or the Langfuse Cloud endpoint when unset
+func DefaultBaseURL() string {
 	langfuseHost := os.Getenv("LANGFUSE_HOST")
 	if langfuseHost == "" {
 		langfuseHost = langfuseDefaultEndpoint
 	}
+	return langfuseHost
+}
 
-	publicKey := os.Getenv("LANGFUSE_PUBLIC_KEY")
-	secretKey := os.Getenv("LANGFUSE_SECRET_KEY")
+// New creates a client configured from the LANGFUSE_* environment variables
+func New() *Client {
+	publicKey, secretKey := EnvCredentials()
+	return NewWithCredentials(DefaultBaseURL(), publicKey, secretKey)
+}
 
+// NewWithCredentials creates a client without reading the environment
+func NewWithCredentials(baseURL string, publicKey string, secretKey string) *Client {
 	return &Client{
 		httpClient: &http.Client{
 			Timeout: defaultTimeout,
 		},
-		baseURL:   langfuseHost,
-		publicKey: publicKey,
-		secretKey: secretKey,
+		baseURL:          baseURL,
+		publicKey:        publicKey,
+		secretKey:        secretKey,
+		compressionLevel: gzip.DefaultCompression,
+		sdkVersion:       defaultSDKVersion,
+		checksumHeader:   DefaultChecksumHeader,
 	}
 }
 
+// Clone returns a client with the same settings and HTTP client sending to
+// another project
+func (c *Client) Clone(baseURL string, publicKey string, secretKey string) *Client {
+	clone := *c
+	clone.baseURL = baseURL
+	clone.publicKey = publicKey
+	clone.secretKey = secretKey
+	return &clone
+}
+
+// BaseURL returns the host requests are sent to
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+func (c *Client) WithBaseURL(baseURL string) *Client {
+	c.baseURL = baseURL
+	return c
+}
+
+// WithProxy sends requests through the proxy chosen by proxy, as with
+// http.Transport.Proxy, on a copy of the default transport
+func (c *Client) WithProxy(proxy func(*http.Request) (*url.URL, error)) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	c.httpClient.Transport = transport
+	return c
+}
+
+// WithCompression enables gzip encoding of ingestion request bodies
+func (c *Client) WithCompression(enabled bool) *Client {
+	c.compress = enabled
+	return c
+}
+
+// WithCompressionLevel sets the gzip level used when compression is enabled.
+// The level must be valid for gzip.NewWriterLevel.
+func (c *Client) WithCompressionLevel(level int) *Client {
+	c.compressionLevel = level
+	return c
+}
+
+// WithSDKVersion sets the SDK version reported in request headers and batch metadata
+func (c *Client) WithSDKVersion(version string) *Client {
+	c.sdkVersion = version
+	return c
+}
+
+// WithSchemaVersion sets the payload schema version reported in batch metadata
+func (c *Client) WithSchemaVersion(version int) *Client {
+	c.schemaVersion = version
+	return c
+}
+
+// WithUserAgent replaces the default "langfuse-go/<version>" User-Agent header
+func (c *Client) WithUserAgent(userAgent string) *Client {
+	c.userAgent = userAgent
+	return c
+}
+
+// UserAgent returns the User-Agent header sent with every request
+func (c *Client) UserAgent() string {
+	if c.userAgent != "" {
+		return c.userAgent
+	}
+	return SDKName + "/" + c.sdkVersion
+}
+
+// setSDKHeaders identifies the SDK and its version to the Langfuse API
+func (c *Client) setSDKHeaders(httpReq *http.Request) {
+	httpReq.Header.Set("User-Agent", c.UserAgent())
+	httpReq.Header.Set("X-Langfuse-Sdk-Name", SDKName)
+	httpReq.Header.Set("X-Langfuse-Sdk-Version", c.sdkVersion)
+}
+
+// Ingestion sends a batch of events. Batches without metadata are sent with
+// the SDK name and version, the schema version and the batch size.
 func (c *Client) Ingestion(ctx context.Context, req *Ingestion, res *IngestionResponse) error {
+	if req.Metadata == nil {
+		req = &Ingestion{
+			Batch: req.Batch,
+			Metadata: map[string]interface{}{
+				"sdk_name":           SDKName,
+				"sdk_version":        c.sdkVersion,
+				"sdk_schema_version": c.schemaVersion,
+				"batch_size":         len(req.Batch),
+			},
+		}
+	}
+
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	if c.compress {
+		if jsonData, err = gzipData(jsonData, c.compressionLevel); err != nil {
+			return fmt.Errorf("failed to compress request: %w", err)
+		}
+	}
+
 	url := c.baseURL + ingestionPath
 	httpReq, reqErr := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if reqErr != nil {
@@ -58,11 +189,15 @@ func (c *Client) Ingestion(ctx context.Context, req *Ingestion, res *IngestionRe
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	if c.compress {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
 	httpReq.Header.Set("Authorization", c.basicAuth())
+	c.setSDKHeaders(httpReq)
 
 	resp, respErr := c.httpClient.Do(httpReq)
 	if respErr != nil {
-		return fmt.Errorf("failed to send request: %w", respErr)
+		return requestError(ctx, "failed to send request", respErr)
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
@@ -72,11 +207,11 @@ func (c *Client) Ingestion(ctx context.Context, req *Ingestion, res *IngestionRe
 
 	body, bodyErr := io.ReadAll(resp.Body)
 	if bodyErr != nil {
-		return fmt.Errorf("failed to read response: %w", bodyErr)
+		return requestError(ctx, "failed to read response", bodyErr)
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMultiStatus {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	if unmarshalErr := json.Unmarshal(body, res); unmarshalErr != nil {
@@ -86,7 +221,32 @@ func (c *Client) Ingestion(ctx context.Context, req *Ingestion, res *IngestionRe
 	return nil
 }
 
+// gzipData compresses data at the given gzip level
+func gzipData(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func (c *Client) basicAuth() string {
 	auth := c.publicKey + ":" + c.secretKey
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
 }
+
+// requestError wraps a transport error, reporting ErrCanceled together with the
+// context error when the request failed because ctx is done
+func requestError(ctx context.Context, msg string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, ctxErr)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// Test that canceled requests are reported as ErrCanceled
+func TestIngestionCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewWithCredentials(server.URL, "pk", "sk")
+
+	t.Run("Canceled before sending", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := client.Ingestion(ctx, &Ingestion{}, &IngestionResponse{})
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected ErrCanceled wrapping context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("Deadline during the request", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := client.Ingestion(ctx, &Ingestion{}, &IngestionResponse{})
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected ErrCanceled wrapping context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("Transport failure", func(t *testing.T) {
+		unreachable := NewWithCredentials("http://127.0.0.1:1", "pk", "sk")
+
+		err := unreachable.Ingestion(context.Background(), &Ingestion{}, &IngestionResponse{})
+		if err == nil || errors.Is(err, ErrCanceled) {
+			t.Errorf("Expected a non-cancellation error, got %v", err)
+		}
+	})
+}
+
+// Test that ingestion bodies are gzip-encoded when compression is enabled
+func TestIngestionCompression(t *testing.T) {
+	var encoding string
+	var decoded Ingestion
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		body := r.Body
+		if encoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Failed to read gzip body: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		if err := json.NewDecoder(body).Decode(&decoded); err != nil {
+			t.Errorf("Failed to decode body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewWithCredentials(server.URL, "pk", "sk")
+	req := &Ingestion{Batch: []model.IngestionEvent{{ID: "event-1"}}}
+
+	if err := client.Ingestion(context.Background(), req, &IngestionResponse{}); err != nil {
+		t.Fatalf("Ingestion failed: %v", err)
+	}
+	if encoding != "" {
+		t.Errorf("Expected no Content-Encoding by default, got %q", encoding)
+	}
+
+	for _, level := range []int{gzip.HuffmanOnly, gzip.BestSpeed, gzip.BestCompression} {
+		decoded = Ingestion{}
+		client.WithCompressionLevel(level).WithCompression(true)
+		if err := client.Ingestion(context.Background(), req, &IngestionResponse{}); err != nil {
+			t.Fatalf("Ingestion at level %d failed: %v", level, err)
+		}
+		if encoding != "gzip" {
+			t.Errorf("Expected gzip Content-Encoding at level %d, got %q", level, encoding)
+		}
+		if len(decoded.Batch) != 1 || decoded.Batch[0].ID != "event-1" {
+			t.Errorf("Expected the decompressed request to round-trip at level %d", level)
+		}
+	}
+}
+
+// Test that requests identify the SDK in headers and batch metadata
+func TestSDKIdentification(t *testing.T) {
+	var header http.Header
+	var body struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewWithCredentials(server.URL, "pk", "sk").WithSDKVersion("v1.2.3").WithSchemaVersion(2)
+	req := &Ingestion{Batch: []model.IngestionEvent{{ID: "1"}, {ID: "2"}}}
+	if err := client.Ingestion(context.Background(), req, &IngestionResponse{}); err != nil {
+		t.Fatalf("Ingestion: %v", err)
+	}
+
+	if got := header.Get("User-Agent"); got != "langfuse-go/v1.2.3" {
+		t.Errorf("Expected default User-Agent, got %q", got)
+	}
+	if header.Get("X-Langfuse-Sdk-Name") != SDKName || header.Get("X-Langfuse-Sdk-Version") != "v1.2.3" {
+		t.Errorf("Expected SDK headers, got %v", header)
+	}
+	if body.Metadata["sdk_name"] != SDKName || body.Metadata["sdk_version"] != "v1.2.3" || body.Metadata["batch_size"] != float64(2) || body.Metadata["sdk_schema_version"] != float64(2) {
+		t.Errorf("Expected SDK batch metadata, got %v", body.Metadata)
+	}
+	if req.Metadata != nil {
+		t.Error("Expected the caller's request to be left unchanged")
+	}
+
+	client.WithUserAgent("checkout-service/2.0")
+	if err := client.Ingestion(context.Background(), &Ingestion{}, &IngestionResponse{}); err != nil {
+		t.Fatalf("Ingestion: %v", err)
+	}
+	if got := header.Get("User-Agent"); got != "checkout-service/2.0" {
+		t.Errorf("Expected custom User-Agent, got %q", got)
+	}
+}
+
+// Test that requests are sent through the configured proxy
+func TestProxy(t *testing.T) {
+	var target string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.URL.String()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewWithCredentials("http://langfuse.internal", "pk", "sk").WithProxy(http.ProxyURL(proxyURL))
+	if err := client.Ingestion(context.Background(), &Ingestion{}, &IngestionResponse{}); err != nil {
+		t.Fatalf("Ingestion: %v", err)
+	}
+	if target != "http://langfuse.internal"+ingestionPath {
+		t.Errorf("Expected the proxy to receive the ingestion request, got %q", target)
+	}
+}
+
+// Test that keys are read from the files named by the _FILE variables
+func TestEnvCredentials(t *testing.T) {
+	secretKeyFile := filepath.Join(t.TempDir(), "secret_key")
+	if err := os.WriteFile(secretKeyFile, []byte("sk-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LANGFUSE_PUBLIC_KEY", "pk")
+	t.Setenv("LANGFUSE_PUBLIC_KEY_FILE", "")
+	t.Setenv("LANGFUSE_SECRET_KEY", "sk")
+	t.Setenv("LANGFUSE_SECRET_KEY_FILE", secretKeyFile)
+
+	publicKey, secretKey := EnvCredentials()
+	if publicKey != "pk" || secretKey != "sk-file" {
+		t.Errorf("Expected pk and sk-file, got %q and %q", publicKey, secretKey)
+	}
+
+	// An unreadable file falls back to the direct variable
+	t.Setenv("LANGFUSE_SECRET_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, secretKey := EnvCredentials(); secretKey != "sk" {
+		t.Errorf("Expected fallback to sk, got %q", secretKey)
+	}
+}
+
+// Test that media uploads carry their length and checksums
+func TestUploadMediaHeaders(t *testing.T) {
+	var header http.Header
+	var contentLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		contentLength = r.ContentLength
+	}))
+	defer server.Close()
+
+	client := NewWithCredentials(server.URL, "pk", "sk")
+	if _, err := client.UploadMedia(context.Background(), server.URL, "text/plain", "sha", []byte("hello")); err != nil {
+		t.Fatalf("UploadMedia: %v", err)
+	}
+	if contentLength != 5 {
+		t.Errorf("Expected a content length of 5, got %d", contentLength)
+	}
+	if got := header.Get("Content-MD5"); got != "XUFAKrxLKna5cZ2REBfFkg==" {
+		t.Errorf("Expected the MD5 of the content, got %q", got)
+	}
+	if got := header.Get(DefaultChecksumHeader); got != "sha" {
+		t.Errorf("Expected the SHA-256 checksum header, got %q", got)
+	}
+
+	client.WithChecksumHeader("x-goog-meta-sha256")
+	if _, err := client.UploadMedia(context.Background(), server.URL, "text/plain", "sha", []byte("hello")); err != nil {
+		t.Fatalf("UploadMedia: %v", err)
+	}
+	if header.Get("X-Goog-Meta-Sha256") != "sha" || header.Get(DefaultChecksumHeader) != "" {
+		t.Errorf("Expected the checksum under the configured header, got %v", header)
+	}
+}
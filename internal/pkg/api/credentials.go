@@ -0,0 +1,49 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ReadKeyFile reads a key from a mounted secret file, trimming surrounding
+// whitespace such as a trailing newline
+func ReadKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// EnvCredentials returns the keys named by LANGFUSE_PUBLIC_KEY and
+// LANGFUSE_SECRET_KEY. A key whose _FILE variant, e.g. LANGFUSE_SECRET_KEY_FILE,
+// is set is read from that file instead, as with Docker and Kubernetes secret
+// mounts; a file that cannot be read is logged and the direct variable is used.
+func EnvCredentials() (publicKey string, secretKey string) {
+	return envKey("LANGFUSE_PUBLIC_KEY"), envKey("LANGFUSE_SECRET_KEY")
+}
+
+func envKey(name string) string {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		key, err := ReadKeyFile(path)
+		if err == nil {
+			return key
+		}
+		log.Printf("Failed to read %s_FILE: %v", name, err)
+	}
+	return os.Getenv(name)
+}
+
+// WithPublicKey replaces the public key used to authenticate requests
+func (c *Client) WithPublicKey(publicKey string) *Client {
+	c.publicKey = publicKey
+	return c
+}
+
+// WithSecretKey replaces the secret key used to authenticate requests
+func (c *Client) WithSecretKey(secretKey string) *Client {
+	c.secretKey = secretKey
+	return c
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+const (
+	datasetsPath     = "/api/public/v2/datasets"
+	datasetItemsPath = "/api/public/dataset-items"
+)
+
+// DatasetRequest is the body of a dataset creation
+type DatasetRequest struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// DatasetItemRequest is the body of a dataset item upsert
+type DatasetItemRequest struct {
+	ID                  string                 `json:"id,omitempty"`
+	DatasetName         string                 `json:"datasetName"`
+	Input               interface{}            `json:"input,omitempty"`
+	ExpectedOutput      interface{}            `json:"expectedOutput,omitempty"`
+	Metadata            map[string]interface{} `json:"metadata,omitempty"`
+	SourceTraceID       string                 `json:"sourceTraceId,omitempty"`
+	SourceObservationID string                 `json:"sourceObservationId,omitempty"`
+}
+
+// StatusError is returned when the API responds with an unexpected status code
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
+// Is reports whether a 413 response matches ErrPayloadTooLarge
+func (e *StatusError) Is(target error) bool {
+	return target == ErrPayloadTooLarge && e.StatusCode == http.StatusRequestEntityTooLarge
+}
+
+// GetDataset fetches a dataset by name
+func (c *Client) GetDataset(ctx context.Context, name string, res interface{}) error {
+	return c.do(ctx, http.MethodGet, datasetsPath+"/"+url.PathEscape(name), nil, res)
+}
+
+// CreateDataset creates a dataset
+func (c *Client) CreateDataset(ctx context.Context, req *DatasetRequest, res interface{}) error {
+	return c.do(ctx, http.MethodPost, datasetsPath, req, res)
+}
+
+// GetDatasetItem fetches a dataset item by ID
+func (c *Client) GetDatasetItem(ctx context.Context, id string, res interface{}) error {
+	return c.do(ctx, http.MethodGet, datasetItemsPath+"/"+url.PathEscape(id), nil, res)
+}
+
+// UpsertDatasetItem creates a dataset item, or updates it when the ID already exists
+func (c *Client) UpsertDatasetItem(ctx context.Context, req *DatasetItemRequest, res interface{}) error {
+	return c.do(ctx, http.MethodPost, datasetItemsPath, req, res)
+}
+
+// DeleteDatasetItem deletes a dataset item by ID
+func (c *Client) DeleteDatasetItem(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, datasetItemsPath+"/"+url.PathEscape(id), nil, nil)
+}
+
+// do sends a JSON request to the public API and decodes the response into res when non-nil
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, res interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	httpReq, reqErr := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if reqErr != nil {
+		return fmt.Errorf("failed to create request: %w", reqErr)
+	}
+
+	if body != nil {
+		httpReq.Header.Set("Content-Type", ContentTypeJSON)
+	}
+	httpReq.Header.Set("Authorization", c.basicAuth())
+	c.setSDKHeaders(httpReq)
+
+	resp, respErr := c.httpClient.Do(httpReq)
+	if respErr != nil {
+		return requestError(ctx, "failed to send request", respErr)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	respBody, bodyErr := io.ReadAll(resp.Body)
+	if bodyErr != nil {
+		return requestError(ctx, "failed to read response", bodyErr)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if res == nil || len(respBody) == 0 {
+		return nil
+	}
+
+	if unmarshalErr := json.Unmarshal(respBody, res); unmarshalErr != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", unmarshalErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const mediaPath = "/api/public/media"
+
+// DefaultChecksumHeader carries the base64 SHA-256 of uploaded media, as
+// validated by S3 and compatible object stores
+const DefaultChecksumHeader = "x-amz-checksum-sha256"
+
+// MediaUploadRequest associates a media file with a trace or observation field
+// and requests a presigned upload URL
+type MediaUploadRequest struct {
+	TraceID       string `json:"traceId"`
+	ObservationID string `json:"observationId,omitempty"`
+	ContentType   string `json:"contentType"`
+	ContentLength int    `json:"contentLength"`
+	SHA256Hash    string `json:"sha256Hash"`
+	Field         string `json:"field"`
+}
+
+// MediaUploadResponse holds the media ID and, unless the file already exists, the upload URL
+type MediaUploadResponse struct {
+	UploadURL *string `json:"uploadUrl"`
+	MediaID   string  `json:"mediaId"`
+}
+
+// MediaUploadedRequest reports the outcome of uploading to the presigned URL
+type MediaUploadedRequest struct {
+	UploadedAt       time.Time `json:"uploadedAt"`
+	UploadHTTPStatus int       `json:"uploadHttpStatus"`
+	UploadHTTPError  string    `json:"uploadHttpError,omitempty"`
+}
+
+// GetMediaUploadURL registers a media file and returns its ID and upload URL
+func (c *Client) GetMediaUploadURL(ctx context.Context, req *MediaUploadRequest, res *MediaUploadResponse) error {
+	return c.do(ctx, http.MethodPost, mediaPath, req, res)
+}
+
+// PatchMedia records the upload status of a media file
+func (c *Client) PatchMedia(ctx context.Context, mediaID string, req *MediaUploadedRequest) error {
+	return c.do(ctx, http.MethodPatch, mediaPath+"/"+url.PathEscape(mediaID), req, nil)
+}
+
+// WithChecksumHeader sets the header carrying the base64 SHA-256 of uploaded
+// media, for object stores that expect another name. An empty name omits it.
+func (c *Client) WithChecksumHeader(name string) *Client {
+	c.checksumHeader = name
+	return c
+}
+
+// UploadMedia puts the file content to a presigned upload URL and returns the
+// HTTP status. The request carries an explicit Content-Length, a Content-MD5
+// and the SHA-256 checksum header, which some object stores require.
+func (c *Client) UploadMedia(ctx context.Context, uploadURL string, contentType string, sha256Hash string, data []byte) (int, error) {
+	httpReq, reqErr := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL, bytes.NewReader(data))
+	if reqErr != nil {
+		return 0, fmt.Errorf("failed to create request: %w", reqErr)
+	}
+
+	md5Sum := md5.Sum(data)
+	httpReq.ContentLength = int64(len(data))
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+	if c.checksumHeader != "" {
+		httpReq.Header.Set(c.checksumHeader, sha256Hash)
+	}
+	httpReq.Header.Set("User-Agent", c.UserAgent())
+
+	resp, respErr := c.httpClient.Do(httpReq)
+	if respErr != nil {
+		return 0, requestError(ctx, "failed to send request", respErr)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return resp.StatusCode, nil
+}
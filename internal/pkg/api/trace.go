@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const tracesPath = "/api/public/traces"
+
+// ListTracesParams filters a trace listing
+type ListTracesParams struct {
+	Page          int
+	Limit         int
+	Name          string
+	UserID        string
+	SessionID     string
+	Tags          []string
+	FromTimestamp *time.Time
+	ToTimestamp   *time.Time
+}
+
+// query encodes the parameters as URL query values
+func (p *ListTracesParams) query() url.Values {
+	q := url.Values{}
+	if p.Page > 0 {
+		q.Set("page", strconv.Itoa(p.Page))
+	}
+	if p.Limit > 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.Name != "" {
+		q.Set("name", p.Name)
+	}
+	if p.UserID != "" {
+		q.Set("userId", p.UserID)
+	}
+	if p.SessionID != "" {
+		q.Set("sessionId", p.SessionID)
+	}
+	for _, tag := range p.Tags {
+		q.Add("tags", tag)
+	}
+	if p.FromTimestamp != nil {
+		q.Set("fromTimestamp", p.FromTimestamp.UTC().Format(time.RFC3339))
+	}
+	if p.ToTimestamp != nil {
+		q.Set("toTimestamp", p.ToTimestamp.UTC().Format(time.RFC3339))
+	}
+	return q
+}
+
+// TraceSummary is a trace as returned by the trace listing
+type TraceSummary struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Timestamp time.Time   `json:"timestamp"`
+	UserID    string      `json:"userId"`
+	SessionID string      `json:"sessionId"`
+	Tags      []string    `json:"tags"`
+	Input     interface{} `json:"input"`
+	Output    interface{} `json:"output"`
+	Metadata  interface{} `json:"metadata"`
+}
+
+// Observation is an observation of a fetched trace
+type Observation struct {
+	ID                  string      `json:"id"`
+	TraceID             string      `json:"traceId"`
+	Type                string      `json:"type"`
+	Name                string      `json:"name"`
+	Model               string      `json:"model"`
+	ParentObservationID string      `json:"parentObservationId"`
+	StartTime           *time.Time  `json:"startTime"`
+	EndTime             *time.Time  `json:"endTime"`
+	Level               string      `json:"level"`
+	StatusMessage       string      `json:"statusMessage"`
+	Input               interface{} `json:"input"`
+	Output              interface{} `json:"output"`
+	Metadata            interface{} `json:"metadata"`
+}
+
+// Score is a score of a fetched trace
+type Score struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Value         *float64   `json:"value"`
+	StringValue   string     `json:"stringValue,omitempty"`
+	DataType      string     `json:"dataType"`
+	Comment       string     `json:"comment"`
+	ObservationID string     `json:"observationId"`
+	Timestamp     *time.Time `json:"timestamp"`
+}
+
+// TraceDetails is a single trace including its observations
+type TraceDetails struct {
+	TraceSummary
+	Observations []Observation `json:"observations"`
+	Scores       []Score       `json:"scores"`
+	// SchemaVersion is the SDK schema version the trace was written with, 0 for
+	// traces written before versioning or by other SDKs
+	SchemaVersion int `json:"-"`
+}
+
+// PageMeta describes a page of results
+type PageMeta struct {
+	Page       int `json:"page"`
+	Limit      int `json:"limit"`
+	TotalItems int `json:"totalItems"`
+	TotalPages int `json:"totalPages"`
+}
+
+// ListTracesResponse is a page of traces
+type ListTracesResponse struct {
+	Data []TraceSummary `json:"data"`
+	Meta PageMeta       `json:"meta"`
+}
+
+// ListTraces fetches a page of traces matching params
+func (c *Client) ListTraces(ctx context.Context, params *ListTracesParams, res *ListTracesResponse) error {
+	path := tracesPath
+	if q := params.query().Encode(); q != "" {
+		path += "?" + q
+	}
+	return c.do(ctx, http.MethodGet, path, nil, res)
+}
+
+// GetTrace fetches a trace with its observations
+func (c *Client) GetTrace(ctx context.Context, id string, res *TraceDetails) error {
+	return c.do(ctx, http.MethodGet, tracesPath+"/"+url.PathEscape(id), nil, res)
+}
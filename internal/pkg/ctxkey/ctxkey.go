@@ -0,0 +1,31 @@
+// Package ctxkey provides typed context keys for values the SDK stores in a context.Context.
+package ctxkey
+
+import "context"
+
+// Key identifies a context value of type T. Keys compare by pointer, so two keys
+// never collide, even with the same name, and no string key can match them.
+type Key[T any] struct {
+	name string
+}
+
+// New creates a key; the name is only used for debugging
+func New[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// With returns a copy of ctx carrying value under the key
+func (k *Key[T]) With(ctx context.Context, value T) context.Context {
+	return context.WithValue(ctx, k, value)
+}
+
+// Value returns the value stored under the key, reporting whether it was present
+func (k *Key[T]) Value(ctx context.Context) (T, bool) {
+	value, ok := ctx.Value(k).(T)
+	return value, ok
+}
+
+// String returns the key name
+func (k *Key[T]) String() string {
+	return "langfuse context key " + k.name
+}
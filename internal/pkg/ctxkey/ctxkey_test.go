@@ -0,0 +1,27 @@
+package ctxkey
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	key := New[*int]("langfuse_observer")
+	value := 42
+
+	ctx := key.With(context.Background(), &value)
+	if got, ok := key.Value(ctx); !ok || got != &value {
+		t.Errorf("Round trip: got %v (ok %v), want %v", got, ok, &value)
+	}
+
+	//nolint:staticcheck // deliberately using a string key to check it cannot collide
+	ctx = context.WithValue(context.Background(), "langfuse_observer", &value)
+	if _, ok := key.Value(ctx); ok {
+		t.Error("A string key with the same name should not match")
+	}
+
+	other := New[*int]("langfuse_observer")
+	if _, ok := other.Value(key.With(context.Background(), &value)); ok {
+		t.Error("Keys with the same name should not match each other")
+	}
+}
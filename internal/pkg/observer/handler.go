@@ -2,6 +2,7 @@ package observer
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
@@ -10,50 +11,68 @@ type command int
 const (
 	commanFlush command = iota
 	commandFlushAndWait
-	commandFlushDone
 )
 
 const (
 	defaultTickerPeriod = 1 * time.Second
 )
 
+type request struct {
+	cmd  command
+	done chan struct{}
+}
+
 type handler[T any] struct {
 	queue        *queue[T]
 	fn           EventHandler[T]
-	commandCh    chan command
+	commandCh    chan request
+	signalCh     chan struct{}
+	tickCh       chan time.Duration
 	tickerPeriod time.Duration
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	running int // handle calls started by ticks and signals that have not returned
 }
 
 func newHandler[T any](queue *queue[T], fn EventHandler[T]) *handler[T] {
-	return &handler[T]{
+	h := &handler[T]{
 		queue:        queue,
 		fn:           fn,
-		commandCh:    make(chan command),
+		commandCh:    make(chan request),
+		signalCh:     make(chan struct{}, 1),
+		tickCh:       make(chan time.Duration),
 		tickerPeriod: defaultTickerPeriod,
 	}
+	h.idle = sync.NewCond(&h.mu)
+	return h
 }
 
+// withTick changes the ticker period of the running listen loop
 func (h *handler[T]) withTick(period time.Duration) *handler[T] {
-	h.tickerPeriod = period
+	if period > 0 {
+		h.tickCh <- period
+	}
 	return h
 }
 
 func (h *handler[T]) listen(ctx context.Context) {
 	ticker := time.NewTicker(h.tickerPeriod)
+	defer ticker.Stop()
 
 	for {
 		select {
+		case period := <-h.tickCh:
+			h.tickerPeriod = period
+			ticker.Reset(period)
 		case <-ticker.C:
-			go h.handle(ctx)
-		case cmd, ok := <-h.commandCh:
-			if !ok {
-				return
-			}
-
+			h.handleInBackground(ctx)
+		case <-h.signalCh:
+			h.handleInBackground(ctx)
+		case req := <-h.commandCh:
 			h.handle(ctx)
-			if cmd == commandFlushAndWait {
-				ticker.Stop()
-				close(h.commandCh)
+			if req.cmd == commandFlushAndWait {
+				close(req.done)
 			}
 		}
 	}
@@ -63,11 +82,46 @@ func (h *handler[T]) handle(ctx context.Context) {
 	h.fn(ctx, h.queue.All())
 }
 
+// handleInBackground handles the queued events on a new goroutine, tracking it
+// so flushAndWait can wait for it
+func (h *handler[T]) handleInBackground(ctx context.Context) {
+	h.mu.Lock()
+	h.running++
+	h.mu.Unlock()
+
+	go func() {
+		defer func() {
+			h.mu.Lock()
+			h.running--
+			h.idle.Broadcast()
+			h.mu.Unlock()
+		}()
+		h.handle(ctx)
+	}()
+}
+
 func (h *handler[T]) flush() {
-	h.commandCh <- commanFlush
+	h.commandCh <- request{cmd: commanFlush}
+}
+
+// signal requests a flush without waiting for it. Signals sent while one is
+// pending are coalesced into a single flush.
+func (h *handler[T]) signal() {
+	select {
+	case h.signalCh <- struct{}{}:
+	default:
+	}
 }
 
 func (h *handler[T]) flushAndWait() {
-	h.commandCh <- commandFlushAndWait
-	<-h.commandCh
+	done := make(chan struct{})
+	h.commandCh <- request{cmd: commandFlushAndWait, done: done}
+	<-done
+
+	// Events taken by earlier background handles are part of the drain
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for h.running > 0 {
+		h.idle.Wait()
+	}
 }
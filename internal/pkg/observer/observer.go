@@ -33,12 +33,27 @@ func (o *Observer[T]) Dispatch(event T) {
 	o.queue.Enqueue(event)
 }
 
+// DispatchAll queues events together, so they are handled in the same call and order
+func (o *Observer[T]) DispatchAll(events []T) {
+	o.queue.EnqueueAll(events)
+}
+
+// Len returns the number of queued events
+func (o *Observer[T]) Len() int {
+	return o.queue.Len()
+}
+
 func (o *Observer[T]) Flush() {
 	o.handler.flush()
 }
 
+// Signal requests that queued events be handled soon and returns immediately
+func (o *Observer[T]) Signal() {
+	o.handler.signal()
+}
+
 func (o *Observer[T]) Wait(ctx context.Context) {
-	done := make(chan struct{})
+	done := make(chan struct{}, 1)
 	go func() {
 		o.handler.flushAndWait()
 		done <- struct{}{}
@@ -13,6 +13,13 @@ func (q *queue[T]) Enqueue(item T) {
 	q.items = append(q.items, item)
 }
 
+// EnqueueAll appends items atomically, so a concurrent All never splits them
+func (q *queue[T]) EnqueueAll(items []T) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.items = append(q.items, items...)
+}
+
 func (q *queue[T]) Dequeue() T {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
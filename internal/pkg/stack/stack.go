@@ -0,0 +1,95 @@
+// Package stack captures bounded, readable stack traces for error metadata.
+package stack
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// MaxFrames bounds the frames kept in a captured stack trace
+const MaxFrames = 32
+
+// Capture returns the calling goroutine's stack as "function\n\tfile:line" lines,
+// skipping skip frames above the caller of Capture. Frames of the Go runtime and
+// of reflection are dropped, and at most MaxFrames frames are kept.
+func Capture(skip int) string {
+	return Format(Callers(skip + 1))
+}
+
+// Callers returns the program counters of the calling goroutine's stack,
+// skipping skip frames above the caller of Callers
+func Callers(skip int) []uintptr {
+	pcs := make([]uintptr, MaxFrames*2)
+	n := runtime.Callers(skip+2, pcs)
+	return pcs[:n]
+}
+
+// Format renders program counters returned by runtime.Callers like Capture
+func Format(pcs []uintptr) string {
+	if len(pcs) == 0 {
+		return ""
+	}
+	frames := runtime.CallersFrames(pcs)
+
+	var b strings.Builder
+	kept := 0
+	for kept < MaxFrames {
+		frame, more := frames.Next()
+		if !isInternalFrame(frame.Function) {
+			b.WriteString(frame.Function)
+			b.WriteString("\n\t")
+			b.WriteString(frame.File)
+			b.WriteByte(':')
+			b.WriteString(strconv.Itoa(frame.Line))
+			b.WriteByte('\n')
+			kept++
+		}
+		if !more {
+			break
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// FromError returns the stack recorded by the first error in err's chain that
+// carries one, formatted like Capture, or "" when none does. Errors carry a
+// stack through a Callers() []uintptr method, or a StackTrace method returning
+// program counters such as that of github.com/pkg/errors.
+func FromError(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if pcs := errorCallers(err); len(pcs) > 0 {
+			return Format(pcs)
+		}
+	}
+	return ""
+}
+
+// errorCallers returns the program counters recorded by err itself
+func errorCallers(err error) []uintptr {
+	if carrier, ok := err.(interface{ Callers() []uintptr }); ok {
+		return carrier.Callers()
+	}
+
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() {
+		return nil
+	}
+	t := method.Type()
+	if t.NumIn() != 0 || t.NumOut() != 1 || t.Out(0).Kind() != reflect.Slice || t.Out(0).Elem().Kind() != reflect.Uintptr {
+		return nil
+	}
+	frames := method.Call(nil)[0]
+	pcs := make([]uintptr, frames.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(frames.Index(i).Uint())
+	}
+	return pcs
+}
+
+// isInternalFrame reports frames that add noise without locating the error
+func isInternalFrame(function string) bool {
+	return strings.HasPrefix(function, "runtime.") || strings.HasPrefix(function, "reflect.")
+}
@@ -0,0 +1,88 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func recurse(depth int) string {
+	if depth == 0 {
+		return Capture(0)
+	}
+	return recurse(depth - 1)
+}
+
+func TestCapture(t *testing.T) {
+	trace := recurse(MaxFrames * 2)
+
+	if !strings.HasPrefix(trace, "github.com/paulnegz/langfuse-go/internal/pkg/stack.recurse\n\t") {
+		t.Errorf("Expected the stack to start at the caller, got %q", strings.SplitN(trace, "\n", 2)[0])
+	}
+	if frames := strings.Count(trace, "\n\t"); frames != MaxFrames {
+		t.Errorf("Expected %d frames, got %d", MaxFrames, frames)
+	}
+	if strings.Contains(trace, "\nruntime.") {
+		t.Error("Runtime frames should be dropped")
+	}
+}
+
+// stackError records its stack like errors of github.com/go-errors/errors
+type stackError struct {
+	pcs []uintptr
+}
+
+func (e *stackError) Error() string      { return "failed" }
+func (e *stackError) Callers() []uintptr { return e.pcs }
+
+// frame is a program counter like the frames of github.com/pkg/errors
+type frame uintptr
+
+// tracedError records its stack like errors of github.com/pkg/errors
+type tracedError struct {
+	frames []frame
+}
+
+func (e *tracedError) Error() string       { return "failed" }
+func (e *tracedError) StackTrace() []frame { return e.frames }
+
+func failAt() error {
+	return &stackError{pcs: Callers(0)}
+}
+
+func tracedFailAt() error {
+	var frames []frame
+	for _, pc := range Callers(0) {
+		frames = append(frames, frame(pc))
+	}
+	return &tracedError{frames: frames}
+}
+
+func TestFromError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "Callers", err: fmt.Errorf("wrapped: %w", failAt()), want: "stack.failAt\n\t"},
+		{name: "StackTrace", err: tracedFailAt(), want: "stack.tracedFailAt\n\t"},
+		{name: "No stack", err: errors.New("failed")},
+		{name: "Nil"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := FromError(tt.err)
+			if tt.want == "" {
+				if trace != "" {
+					t.Errorf("Expected no stack, got %q", trace)
+				}
+				return
+			}
+			if !strings.HasPrefix(trace, "github.com/paulnegz/langfuse-go/internal/pkg/"+tt.want) {
+				t.Errorf("Expected the stack to start at the error site, got %q", strings.SplitN(trace, "\n", 2)[0])
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package langchain
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
@@ -12,29 +13,49 @@ import (
 	"github.com/paulnegz/langfuse-go/model"
 )
 
+// errNotEnded is the status of observations closed by Finalize
+var errNotEnded = errors.New("observation was not ended before the handler was finalized")
+
 // CallbackHandler implements LangChain-compatible callbacks for Langfuse
 // This matches Python's langfuse.langchain.CallbackHandler
 type CallbackHandler struct {
 	client       *langfuse.Langfuse
 	traces       map[string]*model.Trace
 	observations map[string]interface{} // Can be Span or Generation
+	tokenCounts  map[string]int         // Streamed tokens per generation run
 	traceName    string
 	userID       string
 	sessionID    string
 	metadata     map[string]interface{}
+	media        *langfuse.MediaProcessor
+	sanitizeName langfuse.NameSanitizer
+	scoreDocs    RetrievalScorer
 	mu           sync.RWMutex
 	ctx          context.Context
+
+	// Prompt templates of running chains, recorded when capturePrompts is set
+	capturePrompts bool
+	templates      map[string]promptTemplate
+	managedPrompts map[string]*langfuse.Prompt
 }
 
-// NewCallbackHandler creates a new Langfuse callback handler
+// NewCallbackHandler creates a new Langfuse callback handler configured from the environment
 func NewCallbackHandler() *CallbackHandler {
+	return NewCallbackHandlerWithClient(langfuse.New(context.Background()))
+}
+
+// NewCallbackHandlerWithClient creates a callback handler sending events through client,
+// e.g. one created with langfuse.NewWithConfig for a specific project
+func NewCallbackHandlerWithClient(client *langfuse.Langfuse) *CallbackHandler {
 	ctx := context.Background()
-	client := langfuse.New(ctx)
 
 	return &CallbackHandler{
 		client:       client,
 		traces:       make(map[string]*model.Trace),
 		observations: make(map[string]interface{}),
+		tokenCounts:  make(map[string]int),
+		templates:    make(map[string]promptTemplate),
+		media:        langfuse.NewMediaProcessor(client.MediaUploader()),
 		ctx:          ctx,
 		mu:           sync.RWMutex{},
 	}
@@ -51,6 +72,32 @@ func (h *CallbackHandler) SetTraceParams(traceName, userID, sessionID string, me
 	h.metadata = metadata
 }
 
+// SetNameSanitizer sets the sanitizer applied to observation names (nil leaves them unchanged)
+func (h *CallbackHandler) SetNameSanitizer(sanitizer langfuse.NameSanitizer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sanitizeName = sanitizer
+}
+
+// SetRetrievalScorer sets the scorer whose scores are recorded on each
+// retriever observation, e.g. RelevanceScores() or RankingScores(isRelevant)
+// (nil records none)
+func (h *CallbackHandler) SetRetrievalScorer(scorer RetrievalScorer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.scoreDocs = scorer
+}
+
+// observationName applies the configured name sanitizer
+func (h *CallbackHandler) observationName(name string) string {
+	if h.sanitizeName == nil {
+		return name
+	}
+	return h.sanitizeName(name)
+}
+
 // OnChainStart is called when a chain/graph starts
 func (h *CallbackHandler) OnChainStart(ctx context.Context, serialized map[string]interface{}, inputs map[string]interface{}, runID string, parentRunID *string, tags []string, metadata map[string]interface{}) {
 	h.mu.Lock()
@@ -66,6 +113,11 @@ func (h *CallbackHandler) OnChainStart(ctx context.Context, serialized map[strin
 	}
 
 	now := time.Now()
+	if h.capturePrompts {
+		if tmpl, found := promptTemplateOf(serialized); found {
+			h.templates[runID] = tmpl
+		}
+	}
 
 	if parentRunID == nil {
 		// Root trace
@@ -92,7 +144,7 @@ func (h *CallbackHandler) OnChainStart(ctx context.Context, serialized map[strin
 			ID:                  runID,
 			TraceID:             h.findTraceID(*parentRunID),
 			ParentObservationID: parentObsID,
-			Name:                name,
+			Name:                h.observationName(name),
 			StartTime:           &now,
 			Input:               inputs,
 			Metadata:            metadata,
@@ -112,6 +164,7 @@ func (h *CallbackHandler) OnChainEnd(ctx context.Context, outputs map[string]int
 	defer h.mu.Unlock()
 
 	now := time.Now()
+	delete(h.templates, runID)
 
 	if trace, traceExists := h.traces[runID]; traceExists {
 		// Update trace
@@ -140,25 +193,106 @@ func (h *CallbackHandler) OnChainEnd(ctx context.Context, outputs map[string]int
 
 // OnChainError is called when a chain/graph errors
 func (h *CallbackHandler) OnChainError(ctx context.Context, err error, runID string) {
+	if !h.failChain(err, runID) {
+		return
+	}
+	if flushErr := h.client.Flush(ctx); flushErr != nil {
+		_, _ = fmt.Printf("Failed to flush trace: %v\n", flushErr)
+	}
+}
+
+// failChain records err on the trace of a root chain run and ends its open
+// observations. It reports whether runID is a root chain, whose trace is then
+// flushed without holding h.mu.
+func (h *CallbackHandler) failChain(err error, runID string) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	delete(h.templates, runID)
+	trace, exists := h.traces[runID]
+	if !exists {
+		return false
+	}
+
 	errorMsg := err.Error()
 	metadata := map[string]interface{}{
 		"error":  errorMsg,
 		"status": "error",
 	}
 
-	if trace, exists := h.traces[runID]; exists {
-		// Update trace with error
-		trace.Output = map[string]interface{}{"error": errorMsg}
-		trace.Metadata = h.mergeMetadata(metadata)
-		if _, updateErr := h.client.Trace(&model.Trace{
-			ID:       runID,
-			Output:   trace.Output,
-			Metadata: trace.Metadata,
-		}); updateErr != nil {
-			_, _ = fmt.Printf("Failed to update trace with error: %v\n", updateErr)
+	// Update trace with error
+	trace.Output = map[string]interface{}{"error": errorMsg}
+	trace.Metadata = h.mergeMetadata(metadata)
+	if _, updateErr := h.client.Trace(&model.Trace{
+		ID:       runID,
+		Output:   trace.Output,
+		Metadata: trace.Metadata,
+	}); updateErr != nil {
+		_, _ = fmt.Printf("Failed to update trace with error: %v\n", updateErr)
+	}
+
+	// The failure may have skipped the end callbacks of nested runs
+	h.endOpenObservations(runID, err)
+	return true
+}
+
+// Finalize ends every observation that is still open, e.g. because a chain
+// failed in a way that skipped their end callbacks, with an error status and
+// flushes the client. Durations run from each observation's start time to now.
+// OnChainError finalizes the observations of a failed root chain itself.
+func (h *CallbackHandler) Finalize(ctx context.Context) error {
+	h.mu.Lock()
+	h.endOpenObservations("", errNotEnded)
+	h.mu.Unlock()
+
+	return h.client.Flush(ctx)
+}
+
+// endOpenObservations ends the open observations of traceID, or of every
+// trace when traceID is empty, as errors caused by cause. Callers must hold h.mu.
+func (h *CallbackHandler) endOpenObservations(traceID string, cause error) {
+	now := time.Now()
+
+	for runID, obs := range h.observations {
+		switch o := obs.(type) {
+		case *model.Span:
+			if o.EndTime != nil || (traceID != "" && o.TraceID != traceID) {
+				continue
+			}
+			o.EndTime = &now
+			o.Level = model.ObservationLevelError
+			o.StatusMessage = cause.Error()
+			delete(h.templates, runID)
+
+			if _, err := h.client.Span(&model.Span{
+				ID:            runID,
+				TraceID:       o.TraceID,
+				StartTime:     o.StartTime,
+				EndTime:       &now,
+				Level:         model.ObservationLevelError,
+				StatusMessage: o.StatusMessage,
+			}, nil); err != nil {
+				_, _ = fmt.Printf("Failed to end open span: %v\n", err)
+			}
+		case *model.Generation:
+			if o.EndTime != nil || (traceID != "" && o.TraceID != traceID) {
+				continue
+			}
+			o.EndTime = &now
+			o.Level = model.ObservationLevelError
+			o.StatusMessage = cause.Error()
+			delete(h.tokenCounts, runID)
+
+			if _, err := h.client.Generation(&model.Generation{
+				ID:            runID,
+				TraceID:       o.TraceID,
+				StartTime:     o.StartTime,
+				EndTime:       &now,
+				Level:         model.ObservationLevelError,
+				StatusMessage: o.StatusMessage,
+			}, nil); err != nil {
+				_, _ = fmt.Printf("Failed to end open generation: %v\n", err)
+			}
 		}
 	}
 }
@@ -168,6 +302,31 @@ func (h *CallbackHandler) OnLLMStart(ctx context.Context, serialized map[string]
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	input := make([]interface{}, len(prompts))
+	for i, prompt := range prompts {
+		input[i] = prompt
+	}
+	h.startGeneration(serialized, input, runID, parentRunID, metadata)
+}
+
+// OnChatModelStart is called when a chat model call starts.
+// Tools or functions listed in serialized are recorded on the generation.
+// Images given as *langfuse.MediaContent or base64 data URIs inside the
+// messages are uploaded and replaced with @media references.
+func (h *CallbackHandler) OnChatModelStart(ctx context.Context, serialized map[string]interface{}, messages [][]map[string]interface{}, runID string, parentRunID *string, tags []string, metadata map[string]interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	input := make([]interface{}, len(messages))
+	for i, conversation := range messages {
+		input[i] = conversation
+	}
+	h.startGeneration(serialized, input, runID, parentRunID, metadata)
+}
+
+// startGeneration records a generation for an LLM or chat model call.
+// Callers must hold h.mu.
+func (h *CallbackHandler) startGeneration(serialized map[string]interface{}, input []interface{}, runID string, parentRunID *string, metadata map[string]interface{}) {
 	now := time.Now()
 
 	modelName := "unknown"
@@ -176,21 +335,30 @@ func (h *CallbackHandler) OnLLMStart(ctx context.Context, serialized map[string]
 	} else if metaModelStr, metaExists := metadata["model"].(string); metaExists {
 		modelName = metaModelStr
 	}
+	provider := providerOf(serialized, metadata)
+	if provider == "" {
+		provider = h.client.ResolveProvider(modelName)
+	}
+	modelName = h.client.NormalizeModel(modelName)
 
 	parentObsID := ""
 	if parentRunID != nil {
 		parentObsID = *parentRunID
 	}
+	traceID := h.findTraceID(runID)
 	generation := &model.Generation{
 		ID:                  runID,
-		TraceID:             h.findTraceID(runID),
+		TraceID:             traceID,
 		ParentObservationID: parentObsID,
-		Name:                fmt.Sprintf("%s-generation", modelName),
+		Name:                h.observationName(fmt.Sprintf("%s-generation", modelName)),
 		Model:               modelName,
+		Provider:            provider,
 		StartTime:           &now,
-		Input:               prompts,
+		Input:               h.media.ProcessInput(input, traceID),
 		Metadata:            metadata,
+		Tools:               toolDefinitions(serialized),
 	}
+	h.recordPromptTemplate(generation, serialized, parentRunID)
 
 	if _, err := h.client.Generation(generation, nil); err != nil {
 		_, _ = fmt.Printf("Failed to create generation: %v\n", err)
@@ -199,6 +367,52 @@ func (h *CallbackHandler) OnLLMStart(ctx context.Context, serialized map[string]
 	h.observations[runID] = generation
 }
 
+// providerOf returns the provider LangChain reports for a model call: the
+// "ls_provider" or "provider" metadata key, or the provider module in the
+// serialized model's "id" path, e.g. "openai" in
+// ["langchain", "chat_models", "openai", "ChatOpenAI"]
+func providerOf(serialized map[string]interface{}, metadata map[string]interface{}) string {
+	for _, key := range []string{"ls_provider", langfuse.MetadataKeyProvider} {
+		if provider, reported := metadata[key].(string); reported && provider != "" {
+			return provider
+		}
+	}
+	path, _ := serialized["id"].([]interface{})
+	if len(path) < 4 {
+		return ""
+	}
+	if kind, _ := path[1].(string); kind != "llms" && kind != "chat_models" {
+		return ""
+	}
+	provider, _ := path[2].(string)
+	return provider
+}
+
+// OnLLMNewToken is called for each token streamed by an LLM call.
+// The first token marks the completion start used for time-to-first-token.
+func (h *CallbackHandler) OnLLMNewToken(ctx context.Context, token string, runID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	obs, exists := h.observations[runID]
+	if !exists {
+		return
+	}
+	gen, isGen := obs.(*model.Generation)
+	if !isGen {
+		return
+	}
+
+	if gen.CompletionStartTime == nil {
+		now := time.Now()
+		gen.CompletionStartTime = &now
+		if gen.StartTime != nil {
+			gen.TimeToFirstToken = now.Sub(*gen.StartTime)
+		}
+	}
+	h.tokenCounts[runID]++
+}
+
 // OnLLMEnd is called when an LLM call ends
 func (h *CallbackHandler) OnLLMEnd(ctx context.Context, response interface{}, runID string) {
 	h.mu.Lock()
@@ -214,19 +428,46 @@ func (h *CallbackHandler) OnLLMEnd(ctx context.Context, response interface{}, ru
 			// Try to extract token usage if available
 			if respMap, isMap := response.(map[string]interface{}); isMap {
 				if usage, hasUsage := respMap["usage"].(map[string]interface{}); hasUsage {
-					if total, hasTotal := usage["total_tokens"].(int); hasTotal {
-						gen.Usage = model.Usage{
-							TotalTokens: total,
+					prompt, hasPrompt := usage["prompt_tokens"].(int)
+					completion, hasCompletion := usage["completion_tokens"].(int)
+					total, hasTotal := usage["total_tokens"].(int)
+					if hasPrompt || hasCompletion || hasTotal {
+						gen.Usage = model.NewUsage(prompt, completion)
+						if hasTotal {
+							gen.Usage.Total = total
 						}
 					}
 				}
+
+				// Reasoning models report their thinking apart from the answer
+				var answer map[string]interface{}
+				gen.Reasoning, gen.ReasoningTokens, answer = reasoningOf(respMap)
+				gen.Output = answer
+			}
+
+			// Streamed calls report throughput from the observed tokens when
+			// the provider does not return completion token usage
+			if streamed := h.tokenCounts[runID]; streamed > 0 && gen.CompletionStartTime != nil {
+				completionTokens := gen.Usage.Output
+				if completionTokens == 0 {
+					completionTokens = streamed
+				}
+				if elapsed := now.Sub(*gen.CompletionStartTime).Seconds(); elapsed > 0 {
+					gen.CompletionTokensPerSecond = float64(completionTokens) / elapsed
+				}
 			}
+			delete(h.tokenCounts, runID)
 
 			if _, err := h.client.Generation(&model.Generation{
-				ID:      runID,
-				EndTime: &now,
-				Output:  response,
-				Usage:   gen.Usage,
+				ID:                        runID,
+				EndTime:                   &now,
+				Output:                    gen.Output,
+				Usage:                     gen.Usage,
+				Reasoning:                 gen.Reasoning,
+				ReasoningTokens:           gen.ReasoningTokens,
+				CompletionStartTime:       gen.CompletionStartTime,
+				TimeToFirstToken:          gen.TimeToFirstToken,
+				CompletionTokensPerSecond: gen.CompletionTokensPerSecond,
 			}, nil); err != nil {
 				_, _ = fmt.Printf("Failed to update generation: %v\n", err)
 			}
@@ -245,6 +486,7 @@ func (h *CallbackHandler) OnLLMError(ctx context.Context, err error, runID strin
 		if gen, isGen := obs.(*model.Generation); isGen {
 			gen.EndTime = &now
 			gen.StatusMessage = err.Error()
+			delete(h.tokenCounts, runID)
 
 			if _, updateErr := h.client.Generation(&model.Generation{
 				ID:            runID,
@@ -277,7 +519,7 @@ func (h *CallbackHandler) OnToolStart(ctx context.Context, serialized map[string
 		ID:                  runID,
 		TraceID:             h.findTraceID(runID),
 		ParentObservationID: parentObsIDTool,
-		Name:                toolName,
+		Name:                h.observationName(toolName),
 		StartTime:           &now,
 		Input:               inputStr,
 		Metadata:            metadata,
@@ -342,11 +584,33 @@ func (h *CallbackHandler) OnRetrieverStart(ctx context.Context, serialized map[s
 	h.OnToolStart(ctx, serialized, query, runID, parentRunID, tags, metadata)
 }
 
-// OnRetrieverEnd is called when a retriever ends
+// OnRetrieverEnd is called when a retriever ends. The scores of the
+// configured RetrievalScorer are recorded on the retriever observation.
 func (h *CallbackHandler) OnRetrieverEnd(ctx context.Context, documents []interface{}, runID string) {
 	// Implement if needed for retriever tracing
 	output := fmt.Sprintf("Retrieved %d documents", len(documents))
 	h.OnToolEnd(ctx, output, runID)
+	h.scoreRetrieval(documents, runID)
+}
+
+// scoreRetrieval records the retrieval scores of the documents returned by runID
+func (h *CallbackHandler) scoreRetrieval(documents []interface{}, runID string) {
+	h.mu.RLock()
+	scorer := h.scoreDocs
+	span, isSpan := h.observations[runID].(*model.Span)
+	h.mu.RUnlock()
+	if scorer == nil || !isSpan {
+		return
+	}
+
+	query, _ := span.Input.(string)
+	for _, score := range scorer(query, documents) {
+		score.TraceID = span.TraceID
+		score.ObservationID = runID
+		if _, err := h.client.Score(score); err != nil {
+			_, _ = fmt.Printf("Failed to record retrieval score: %v\n", err)
+		}
+	}
 }
 
 // OnRetrieverError is called when a retriever errors
@@ -0,0 +1,179 @@
+package langchain
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	langfuse "github.com/paulnegz/langfuse-go"
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// newTestHandler returns a handler recording its events
+func newTestHandler() (*CallbackHandler, *langfuse.ObserverRecorder) {
+	recorder := langfuse.NewObserverRecorder()
+	return NewCallbackHandlerWithClient(recorder.Client()), recorder
+}
+
+// Test that a failed root chain closes the observations whose end callbacks were skipped
+func TestOnChainErrorEndsOpenObservations(t *testing.T) {
+	handler, recorder := newTestHandler()
+	ctx := context.Background()
+	root := "run-1"
+
+	handler.OnChainStart(ctx, map[string]interface{}{"name": "agent"}, map[string]interface{}{"q": "hi"}, root, nil, nil, nil)
+	handler.OnChainStart(ctx, map[string]interface{}{"name": "step"}, nil, "run-2", &root, nil, nil)
+	handler.OnLLMStart(ctx, map[string]interface{}{"model": "gpt-4"}, []string{"hi"}, "run-3", &root, nil, nil)
+	handler.OnChainError(ctx, errors.New("boom"), root)
+
+	for _, id := range []string{"run-2", "run-3"} {
+		var ended *langfuse.RecordedObservation
+		for _, obs := range recorder.Observations() {
+			if obs.ID == id {
+				ended = obs
+			}
+		}
+		if ended == nil {
+			t.Fatalf("Observation %s was not recorded", id)
+		}
+		if ended.Level != model.ObservationLevelError || ended.StatusMessage != "boom" {
+			t.Errorf("%s: got level %v and status %q, want ERROR and boom", id, ended.Level, ended.StatusMessage)
+		}
+		if ended.StartTime == nil || ended.EndTime == nil || ended.EndTime.Before(*ended.StartTime) {
+			t.Errorf("%s: got start %v and end %v", id, ended.StartTime, ended.EndTime)
+		}
+	}
+
+	traces := recorder.Traces()
+	if len(traces) != 1 {
+		t.Fatalf("Expected 1 trace, got %d", len(traces))
+	}
+	if metadata, _ := traces[0].Metadata.(map[string]interface{}); metadata["status"] != "error" {
+		t.Errorf("Expected the trace to be marked as failed, got %v", traces[0].Metadata)
+	}
+
+	// The handler stays usable after the flush
+	handler.OnChainStart(ctx, map[string]interface{}{"name": "agent"}, nil, "run-4", nil, nil, nil)
+	if got := len(recorder.Traces()); got != 2 {
+		t.Errorf("Expected a second trace, got %d", got)
+	}
+}
+
+// Test that Finalize closes the observations still open in every trace
+func TestFinalize(t *testing.T) {
+	handler, recorder := newTestHandler()
+	ctx := context.Background()
+	root := "run-1"
+
+	handler.OnChainStart(ctx, map[string]interface{}{"name": "agent"}, nil, root, nil, nil, nil)
+	handler.OnToolStart(ctx, map[string]interface{}{"name": "search"}, "query", "run-2", &root, nil, nil)
+	handler.OnChainStart(ctx, map[string]interface{}{"name": "done"}, nil, "run-3", &root, nil, nil)
+	handler.OnChainEnd(ctx, map[string]interface{}{"answer": 42}, "run-3")
+
+	if err := handler.Finalize(ctx); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	checked := 0
+	for _, obs := range recorder.Observations() {
+		switch obs.ID {
+		case "run-2":
+			checked++
+			if obs.Level != model.ObservationLevelError || obs.StatusMessage != errNotEnded.Error() || obs.StartTime == nil || obs.EndTime == nil {
+				t.Errorf("Expected the open tool span to end as an error, got %+v", obs)
+			}
+		case "run-3":
+			checked++
+			if obs.Level == model.ObservationLevelError {
+				t.Errorf("Expected the ended span to be left alone, got %+v", obs)
+			}
+		}
+	}
+	if checked != 2 {
+		t.Errorf("Expected both spans to be recorded, found %d", checked)
+	}
+}
+
+// Test that inline images in chat messages are uploaded and replaced with media references
+func TestOnChatModelStartMedia(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"mediaId":"media-1"}`))
+	}))
+	defer server.Close()
+
+	recorder := langfuse.NewObserverRecorder()
+	client := recorder.Client().WithHost(server.URL)
+	handler := NewCallbackHandlerWithClient(client)
+	ctx := context.Background()
+
+	image := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("\x89PNG image bytes"))
+	messages := [][]map[string]interface{}{{
+		{"role": "system", "content": "Describe images"},
+		{"role": "user", "content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "What is this?"},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": image}},
+		}},
+	}}
+	handler.OnChatModelStart(ctx, map[string]interface{}{"model": "gpt-4o"}, messages, "run-1", nil, nil, nil)
+	if err := client.MediaUploader().Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	observations := recorder.Observations()
+	var recorded *langfuse.RecordedObservation
+	for _, obs := range observations {
+		if obs.ID == "run-1" {
+			recorded = obs
+		}
+	}
+	if recorded == nil || recorded.Type != "generation" {
+		t.Fatalf("Expected a generation for the chat model call, got %+v", observations)
+	}
+	conversation := recorded.Input.([]interface{})[0].([]interface{})
+	if system := conversation[0].(map[string]interface{}); system["content"] != "Describe images" {
+		t.Errorf("Expected text messages unchanged, got %v", system)
+	}
+	parts := conversation[1].(map[string]interface{})["content"].([]interface{})
+	url := parts[1].(map[string]interface{})["image_url"].(map[string]interface{})["url"].(string)
+	if !strings.HasPrefix(url, "@media/") || strings.Contains(url, "base64") {
+		t.Errorf("Expected the image replaced with a media reference, got %q", url)
+	}
+}
+
+// Test that streamed tokens give the generation its time to first token and throughput
+func TestOnLLMNewTokenMetrics(t *testing.T) {
+	handler, recorder := newTestHandler()
+	ctx := context.Background()
+
+	handler.OnLLMStart(ctx, map[string]interface{}{"model": "gpt-4"}, []string{"hi"}, "run-1", nil, nil, nil)
+	time.Sleep(5 * time.Millisecond)
+	for _, token := range []string{"Hel", "lo", "!"} {
+		handler.OnLLMNewToken(ctx, token, "run-1")
+	}
+	time.Sleep(5 * time.Millisecond)
+	handler.OnLLMEnd(ctx, map[string]interface{}{"text": "Hello!"}, "run-1")
+
+	var generation *model.Generation
+	for _, obs := range recorder.Observations() {
+		if obs.ID == "run-1" {
+			generation, _ = obs.Body.(*model.Generation)
+		}
+	}
+	if generation == nil || generation.CompletionStartTime == nil || generation.StartTime == nil {
+		t.Fatalf("Expected the generation with its completion start, got %+v", generation)
+	}
+	if !generation.CompletionStartTime.After(*generation.StartTime) || generation.CompletionStartTime.After(*generation.EndTime) {
+		t.Errorf("Expected the first token between start and end, got %v", generation.CompletionStartTime)
+	}
+	metadata, _ := generation.Metadata.(map[string]interface{})
+	ttft, _ := metadata["time_to_first_token_ms"].(int64)
+	throughput, _ := metadata["completion_tokens_per_second"].(float64)
+	if ttft < 5 || throughput <= 0 || throughput > 3000 {
+		t.Errorf("Expected the streaming metrics from the 3 streamed tokens, got %v", metadata)
+	}
+}
@@ -0,0 +1,178 @@
+package langchain
+
+import (
+	"strings"
+
+	langfuse "github.com/paulnegz/langfuse-go"
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+const (
+	// MetadataKeyPromptTemplate holds the prompt template that produced a
+	// generation's input: a string for text templates, a list of messages for
+	// chat templates
+	MetadataKeyPromptTemplate = "prompt_template"
+	// MetadataKeyPromptTemplateName holds the name of that template, when it has one
+	MetadataKeyPromptTemplateName = "prompt_template_name"
+)
+
+// promptTemplate is a prompt template read from a serialized chain or model
+type promptTemplate struct {
+	name     string
+	template interface{}
+}
+
+// SetPromptTemplateCapture records the prompt template of the chain running an
+// LLM or chat model call on its generation, under the "prompt_template" metadata
+// key, so it is clear which template produced an output. Templates are read from
+// the serialized chain or model, in LangChain's text and chat template shapes.
+func (h *CallbackHandler) SetPromptTemplateCapture(enabled bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.capturePrompts = enabled
+}
+
+// SetManagedPrompts links generations to the given Langfuse prompts: a captured
+// template named like one of them records the generation as using that prompt
+// and version. Templates are named by the "name" of the serialized template.
+func (h *CallbackHandler) SetManagedPrompts(prompts ...*langfuse.Prompt) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.managedPrompts = make(map[string]*langfuse.Prompt, len(prompts))
+	for _, prompt := range prompts {
+		if prompt != nil && prompt.Name != "" {
+			h.managedPrompts[prompt.Name] = prompt
+		}
+	}
+}
+
+// recordPromptTemplate adds the template used by the generation's run, read
+// from serialized or from the closest enclosing chain, to its metadata.
+// Callers must hold h.mu.
+func (h *CallbackHandler) recordPromptTemplate(generation *model.Generation, serialized map[string]interface{}, parentRunID *string) {
+	if !h.capturePrompts {
+		return
+	}
+
+	tmpl, found := promptTemplateOf(serialized)
+	for runID := parentRunID; !found && runID != nil; runID = h.parentRunID(*runID) {
+		tmpl, found = h.templates[*runID]
+	}
+	if !found {
+		return
+	}
+
+	metadata := make(map[string]interface{})
+	if existing, isMap := generation.Metadata.(map[string]interface{}); isMap {
+		for k, v := range existing {
+			metadata[k] = v
+		}
+	}
+	metadata[MetadataKeyPromptTemplate] = tmpl.template
+	if tmpl.name != "" {
+		metadata[MetadataKeyPromptTemplateName] = tmpl.name
+	}
+	generation.Metadata = metadata
+
+	if prompt, managed := h.managedPrompts[tmpl.name]; managed {
+		generation.PromptName = prompt.Name
+		generation.PromptVersion = prompt.Version
+	}
+}
+
+// parentRunID returns the run enclosing runID, nil for root chains.
+// Callers must hold h.mu.
+func (h *CallbackHandler) parentRunID(runID string) *string {
+	span, isSpan := h.observations[runID].(*model.Span)
+	if !isSpan || span.ParentObservationID == "" {
+		return nil
+	}
+	parent := span.ParentObservationID
+	return &parent
+}
+
+// promptTemplateOf reads a prompt template from a serialized template, or from
+// the "prompt" of a serialized chain. Serialized objects keep their fields
+// under "kwargs"; text templates have a "template" string and chat templates a
+// list of "messages".
+func promptTemplateOf(serialized map[string]interface{}) (promptTemplate, bool) {
+	fields := serialized
+	if kwargs, hasKwargs := serialized["kwargs"].(map[string]interface{}); hasKwargs {
+		fields = kwargs
+	}
+	if prompt, hasPrompt := fields["prompt"].(map[string]interface{}); hasPrompt {
+		return promptTemplateOf(prompt)
+	}
+
+	name, _ := fields["name"].(string)
+	if template, isText := fields["template"].(string); isText {
+		return promptTemplate{name: name, template: template}, true
+	}
+	entries, isChat := fields["messages"].([]interface{})
+	if !isChat {
+		return promptTemplate{}, false
+	}
+	messages := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		if message, ok := templateMessage(entry); ok {
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) == 0 {
+		return promptTemplate{}, false
+	}
+	return promptTemplate{name: name, template: messages}, true
+}
+
+// templateMessage reads one message of a chat template: a message template
+// such as HumanMessagePromptTemplate, a fixed message such as SystemMessage, a
+// MessagesPlaceholder, or a plain {"role", "content"} message
+func templateMessage(entry interface{}) (map[string]interface{}, bool) {
+	message, isMap := entry.(map[string]interface{})
+	if !isMap {
+		return nil, false
+	}
+	if _, hasRole := message["role"]; hasRole {
+		return message, true
+	}
+
+	fields, _ := message["kwargs"].(map[string]interface{})
+	role := messageRole(message)
+	if variable, isPlaceholder := fields["variable_name"].(string); isPlaceholder {
+		return map[string]interface{}{"type": "placeholder", "name": variable}, true
+	}
+	if prompt, hasPrompt := fields["prompt"].(map[string]interface{}); hasPrompt {
+		if tmpl, found := promptTemplateOf(prompt); found {
+			return map[string]interface{}{"role": role, "content": tmpl.template}, true
+		}
+	}
+	if content, hasContent := fields["content"].(string); hasContent {
+		return map[string]interface{}{"role": role, "content": content}, true
+	}
+	return nil, false
+}
+
+// messageRole maps the class name at the end of a serialized message's "id"
+// path to a chat role
+func messageRole(message map[string]interface{}) string {
+	path, _ := message["id"].([]interface{})
+	if len(path) == 0 {
+		return "user"
+	}
+	class, _ := path[len(path)-1].(string)
+	switch {
+	case strings.HasPrefix(class, "System"):
+		return "system"
+	case strings.HasPrefix(class, "AI"):
+		return "assistant"
+	case strings.HasPrefix(class, "ChatMessage"):
+		if fields, hasFields := message["kwargs"].(map[string]interface{}); hasFields {
+			if role, hasRole := fields["role"].(string); hasRole {
+				return role
+			}
+		}
+	}
+	return "user"
+}
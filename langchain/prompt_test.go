@@ -0,0 +1,123 @@
+package langchain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	langfuse "github.com/paulnegz/langfuse-go"
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// Test that prompt templates are read from serialized chains and templates
+func TestPromptTemplateOf(t *testing.T) {
+	tests := []struct {
+		name       string
+		serialized map[string]interface{}
+		want       promptTemplate
+		found      bool
+	}{
+		{
+			name:       "Text template",
+			serialized: map[string]interface{}{"kwargs": map[string]interface{}{"name": "qa", "template": "Answer {question}"}},
+			want:       promptTemplate{name: "qa", template: "Answer {question}"},
+			found:      true,
+		},
+		{
+			name: "Chain prompt",
+			serialized: map[string]interface{}{"kwargs": map[string]interface{}{
+				"prompt": map[string]interface{}{"kwargs": map[string]interface{}{"template": "Summarize {text}"}},
+			}},
+			want:  promptTemplate{template: "Summarize {text}"},
+			found: true,
+		},
+		{
+			name: "Chat template",
+			serialized: map[string]interface{}{"kwargs": map[string]interface{}{"messages": []interface{}{
+				map[string]interface{}{
+					"id":     []interface{}{"langchain", "prompts", "chat", "SystemMessagePromptTemplate"},
+					"kwargs": map[string]interface{}{"prompt": map[string]interface{}{"kwargs": map[string]interface{}{"template": "Be brief"}}},
+				},
+				map[string]interface{}{
+					"id":     []interface{}{"langchain", "prompts", "chat", "MessagesPlaceholder"},
+					"kwargs": map[string]interface{}{"variable_name": "history"},
+				},
+				map[string]interface{}{
+					"id":     []interface{}{"langchain", "schema", "messages", "AIMessage"},
+					"kwargs": map[string]interface{}{"content": "Hello"},
+				},
+				map[string]interface{}{"role": "user", "content": "{question}"},
+				"not a message",
+			}}},
+			want: promptTemplate{template: []map[string]interface{}{
+				{"role": "system", "content": "Be brief"},
+				{"type": "placeholder", "name": "history"},
+				{"role": "assistant", "content": "Hello"},
+				{"role": "user", "content": "{question}"},
+			}},
+			found: true,
+		},
+		{
+			name:       "No template",
+			serialized: map[string]interface{}{"name": "agent"},
+		},
+		{
+			name:       "Chat template without messages",
+			serialized: map[string]interface{}{"messages": []interface{}{"not a message"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := promptTemplateOf(tt.serialized)
+			if found != tt.found || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, %v; want %+v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+// Test that generations record the template of their enclosing chain and link managed prompts
+func TestPromptTemplateCapture(t *testing.T) {
+	handler, recorder := newTestHandler()
+	ctx := context.Background()
+	handler.SetPromptTemplateCapture(true)
+	prompt := langfuse.TextPrompt("qa", "Answer {{question}}")
+	prompt.Version = 4
+	handler.SetManagedPrompts(prompt, nil)
+
+	root := "run-1"
+	chain := "run-2"
+	serialized := map[string]interface{}{"kwargs": map[string]interface{}{"name": "qa", "template": "Answer {question}"}}
+	handler.OnChainStart(ctx, map[string]interface{}{"name": "agent"}, nil, root, nil, nil, nil)
+	handler.OnChainStart(ctx, serialized, nil, chain, &root, nil, nil)
+	handler.OnLLMStart(ctx, map[string]interface{}{"model": "gpt-4"}, []string{"Answer why"}, "run-3", &chain, nil, nil)
+
+	var generation *model.Generation
+	for _, obs := range recorder.Observations() {
+		if obs.ID == "run-3" {
+			generation, _ = obs.Body.(*model.Generation)
+		}
+	}
+	if generation == nil {
+		t.Fatal("Expected the generation to be recorded")
+	}
+	metadata, _ := generation.Metadata.(map[string]interface{})
+	if metadata[MetadataKeyPromptTemplate] != "Answer {question}" || metadata[MetadataKeyPromptTemplateName] != "qa" {
+		t.Errorf("Expected the chain's template in metadata, got %v", metadata)
+	}
+	if generation.PromptName != "qa" || generation.PromptVersion != 4 {
+		t.Errorf("Expected the generation linked to qa v4, got %q v%d", generation.PromptName, generation.PromptVersion)
+	}
+
+	// Templates are released when their chain ends or fails
+	handler.OnChainEnd(ctx, nil, chain)
+	handler.OnChainStart(ctx, serialized, nil, "run-4", &root, nil, nil)
+	handler.OnChainError(ctx, errors.New("boom"), root)
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	if len(handler.templates) != 0 {
+		t.Errorf("Expected the templates of ended chains to be released, got %v", handler.templates)
+	}
+}
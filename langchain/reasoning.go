@@ -0,0 +1,48 @@
+package langchain
+
+// reasoningKeys are the response keys holding the thinking content of
+// reasoning models, in the shapes used by OpenAI-compatible, DeepSeek and
+// Anthropic responses
+var reasoningKeys = []string{"reasoning_content", "reasoning", "thinking"}
+
+// reasoningOf reads the reasoning content and reasoning token count of an LLM
+// response, and returns the response without the reasoning content so it is
+// not recorded twice. Reasoning tokens are read from the usage's
+// "completion_tokens_details", as OpenAI reports them, or its "reasoning_tokens".
+func reasoningOf(response map[string]interface{}) (reasoning string, tokens int, answer map[string]interface{}) {
+	answer = response
+	for _, key := range reasoningKeys {
+		content, isString := response[key].(string)
+		if !isString || content == "" {
+			continue
+		}
+		reasoning = content
+		answer = make(map[string]interface{}, len(response))
+		for k, v := range response {
+			if k != key {
+				answer[k] = v
+			}
+		}
+		break
+	}
+
+	usage, _ := response["usage"].(map[string]interface{})
+	if details, hasDetails := usage["completion_tokens_details"].(map[string]interface{}); hasDetails {
+		tokens, _ = tokenCount(details["reasoning_tokens"])
+	}
+	if tokens == 0 {
+		tokens, _ = tokenCount(usage["reasoning_tokens"])
+	}
+	return reasoning, tokens, answer
+}
+
+// tokenCount reads a token count decoded as an int or, from JSON, a float64
+func tokenCount(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,133 @@
+package langchain
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// RetrievalScorer computes scores for the documents a retriever returned for
+// query. The handler records them on the retriever observation, filling in
+// their trace and observation IDs.
+type RetrievalScorer func(query string, documents []interface{}) []*model.Score
+
+// relevanceKeys are the document fields read as relevance scores, in order
+var relevanceKeys = []string{"score", "relevance_score", "relevance"}
+
+// RelevanceScores returns a scorer that records each document's own relevance
+// score as a "document_relevance" score with its rank, plus "max_relevance"
+// and "mean_relevance" aggregates. The relevance is read from a "score",
+// "relevance_score" or "relevance" key of map documents or their "metadata"
+// map, or from a numeric Score field of struct documents such as langchaingo's
+// schema.Document. Documents without one are skipped.
+func RelevanceScores() RetrievalScorer {
+	return func(query string, documents []interface{}) []*model.Score {
+		var scores []*model.Score
+		sum, maxRelevance, found := 0.0, math.Inf(-1), 0
+		for rank, doc := range documents {
+			relevance, ok := documentRelevance(doc)
+			if !ok {
+				continue
+			}
+			scores = append(scores, &model.Score{
+				Name:     "document_relevance",
+				Value:    relevance,
+				Comment:  fmt.Sprintf("Document %d of %d", rank+1, len(documents)),
+				Metadata: map[string]interface{}{"rank": rank + 1},
+			})
+			sum += relevance
+			maxRelevance = math.Max(maxRelevance, relevance)
+			found++
+		}
+		if found == 0 {
+			return nil
+		}
+
+		return append(scores,
+			&model.Score{Name: "max_relevance", Value: maxRelevance},
+			&model.Score{Name: "mean_relevance", Value: sum / float64(found)},
+		)
+	}
+}
+
+// RankingScores returns a scorer that compares the retrieved ranking with
+// ground truth: relevant reports whether a document answers query. It records
+// the reciprocal rank of the first relevant document as "mrr" (averaging it
+// over queries in Langfuse gives the mean reciprocal rank) and the binary
+// normalized discounted cumulative gain as "ndcg". Both are 0 when no
+// retrieved document is relevant.
+func RankingScores(relevant func(query string, document interface{}) bool) RetrievalScorer {
+	return func(query string, documents []interface{}) []*model.Score {
+		reciprocalRank, dcg, hits := 0.0, 0.0, 0
+		for i, doc := range documents {
+			if !relevant(query, doc) {
+				continue
+			}
+			if hits == 0 {
+				reciprocalRank = 1 / float64(i+1)
+			}
+			dcg += 1 / math.Log2(float64(i+2))
+			hits++
+		}
+
+		// The ideal ranking places every relevant document first
+		idealDCG := 0.0
+		for i := 0; i < hits; i++ {
+			idealDCG += 1 / math.Log2(float64(i+2))
+		}
+		ndcg := 0.0
+		if idealDCG > 0 {
+			ndcg = dcg / idealDCG
+		}
+
+		return []*model.Score{
+			{Name: "mrr", Value: reciprocalRank},
+			{Name: "ndcg", Value: ndcg},
+		}
+	}
+}
+
+// documentRelevance reads the relevance score of a retrieved document
+func documentRelevance(doc interface{}) (float64, bool) {
+	if m, isMap := doc.(map[string]interface{}); isMap {
+		for _, key := range relevanceKeys {
+			if relevance, ok := toFloat(m[key]); ok {
+				return relevance, true
+			}
+		}
+		if metadata, hasMetadata := m["metadata"].(map[string]interface{}); hasMetadata {
+			return documentRelevance(metadata)
+		}
+		return 0, false
+	}
+
+	v := reflect.ValueOf(doc)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+	field := v.FieldByName("Score")
+	if !field.IsValid() || !field.CanInterface() {
+		return 0, false
+	}
+	return toFloat(field.Interface())
+}
+
+// toFloat converts numeric values to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,143 @@
+package langchain
+
+import (
+	"math"
+	"testing"
+)
+
+// Test that relevance scores are read from map and struct documents
+func TestDocumentRelevance(t *testing.T) {
+	type document struct {
+		PageContent string
+		Score       float32
+	}
+	type unscored struct {
+		PageContent string
+	}
+
+	tests := []struct {
+		name  string
+		doc   interface{}
+		want  float64
+		found bool
+	}{
+		{name: "Score key", doc: map[string]interface{}{"score": 0.9}, want: 0.9, found: true},
+		{name: "Relevance score key", doc: map[string]interface{}{"relevance_score": 2}, want: 2, found: true},
+		{name: "Key order", doc: map[string]interface{}{"relevance": 0.1, "score": 0.5}, want: 0.5, found: true},
+		{name: "Metadata", doc: map[string]interface{}{"metadata": map[string]interface{}{"relevance": int64(3)}}, want: 3, found: true},
+		{name: "Non-numeric score", doc: map[string]interface{}{"score": "high"}},
+		{name: "Map without score", doc: map[string]interface{}{"text": "doc"}},
+		{name: "Struct", doc: document{Score: 0.5}, want: 0.5, found: true},
+		{name: "Struct pointer", doc: &document{Score: 0.25}, want: 0.25, found: true},
+		{name: "Struct without score", doc: unscored{}},
+		{name: "Nil pointer", doc: (*document)(nil)},
+		{name: "String", doc: "doc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := documentRelevance(tt.doc)
+			if found != tt.found || got != tt.want {
+				t.Errorf("got %v, %v; want %v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+// Test that relevance scores are recorded per document with their aggregates
+func TestRelevanceScores(t *testing.T) {
+	tests := []struct {
+		name      string
+		documents []interface{}
+		want      map[string]float64
+		scored    int
+	}{
+		{
+			name: "Scored documents",
+			documents: []interface{}{
+				map[string]interface{}{"score": 0.9},
+				map[string]interface{}{"text": "unscored"},
+				map[string]interface{}{"score": 0.3},
+			},
+			want:   map[string]float64{"max_relevance": 0.9, "mean_relevance": 0.6},
+			scored: 2,
+		},
+		{
+			name:      "Negative scores",
+			documents: []interface{}{map[string]interface{}{"score": -2}, map[string]interface{}{"score": -1}},
+			want:      map[string]float64{"max_relevance": -1, "mean_relevance": -1.5},
+			scored:    2,
+		},
+		{name: "No scores", documents: []interface{}{map[string]interface{}{"text": "unscored"}}},
+		{name: "No documents"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := RelevanceScores()("query", tt.documents)
+			if tt.want == nil {
+				if scores != nil {
+					t.Errorf("Expected no scores, got %d", len(scores))
+				}
+				return
+			}
+
+			documents := 0
+			for _, score := range scores {
+				if score.Name == "document_relevance" {
+					documents++
+					continue
+				}
+				if want, expected := tt.want[score.Name]; !expected || math.Abs(score.Value-want) > 1e-9 {
+					t.Errorf("%s: got %v, want %v", score.Name, score.Value, want)
+				}
+			}
+			if documents != tt.scored || len(scores) != tt.scored+len(tt.want) {
+				t.Errorf("Expected %d document scores and the aggregates, got %d scores", tt.scored, len(scores))
+			}
+		})
+	}
+
+	// Document scores keep the rank of the document among all retrieved ones
+	scores := RelevanceScores()("query", []interface{}{"unscored", map[string]interface{}{"score": 0.5}})
+	if rank := scores[0].Metadata["rank"]; rank != 2 || scores[0].Comment != "Document 2 of 2" {
+		t.Errorf("Expected the second document's rank, got %v and %q", rank, scores[0].Comment)
+	}
+}
+
+// Test that ranking scores compare the retrieved order with ground truth
+func TestRankingScores(t *testing.T) {
+	relevant := func(query string, document interface{}) bool {
+		return document == "relevant"
+	}
+
+	tests := []struct {
+		name      string
+		documents []interface{}
+		mrr       float64
+		ndcg      float64
+	}{
+		{name: "Relevant first", documents: []interface{}{"relevant", "other"}, mrr: 1, ndcg: 1},
+		{name: "Relevant second", documents: []interface{}{"other", "relevant"}, mrr: 0.5, ndcg: 1 / math.Log2(3)},
+		{
+			name:      "Relevant first and third",
+			documents: []interface{}{"relevant", "other", "relevant"},
+			mrr:       1,
+			ndcg:      (1 + 1/math.Log2(4)) / (1 + 1/math.Log2(3)),
+		},
+		{name: "None relevant", documents: []interface{}{"other", "other"}},
+		{name: "No documents"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := RankingScores(relevant)("query", tt.documents)
+			if len(scores) != 2 || scores[0].Name != "mrr" || scores[1].Name != "ndcg" {
+				t.Fatalf("Expected mrr and ndcg, got %+v", scores)
+			}
+			if math.Abs(scores[0].Value-tt.mrr) > 1e-9 || math.Abs(scores[1].Value-tt.ndcg) > 1e-9 {
+				t.Errorf("got mrr %v and ndcg %v, want %v and %v", scores[0].Value, scores[1].Value, tt.mrr, tt.ndcg)
+			}
+		})
+	}
+}
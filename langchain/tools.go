@@ -0,0 +1,42 @@
+package langchain
+
+import (
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// toolDefinitions reads the tools or functions offered to the model from the
+// "tools" or "functions" entry of serialized. Both the OpenAI shape
+// {"type": "function", "function": {...}} and flat {"name": ...} entries are
+// accepted; entries without a name are skipped.
+func toolDefinitions(serialized map[string]interface{}) []model.ToolDefinition {
+	var tools []model.ToolDefinition
+	for _, key := range []string{"tools", "functions"} {
+		entries, _ := serialized[key].([]interface{})
+		for _, entry := range entries {
+			if tool, ok := toolDefinition(entry); ok {
+				tools = append(tools, tool)
+			}
+		}
+	}
+	return tools
+}
+
+func toolDefinition(entry interface{}) (model.ToolDefinition, bool) {
+	switch tool := entry.(type) {
+	case model.ToolDefinition:
+		return tool, tool.Name != ""
+	case *model.ToolDefinition:
+		if tool == nil {
+			return model.ToolDefinition{}, false
+		}
+		return *tool, tool.Name != ""
+	case map[string]interface{}:
+		if function, isFunction := tool["function"].(map[string]interface{}); isFunction {
+			tool = function
+		}
+		name, _ := tool["name"].(string)
+		description, _ := tool["description"].(string)
+		return model.ToolDefinition{Name: name, Description: description, Parameters: tool["parameters"]}, name != ""
+	}
+	return model.ToolDefinition{}, false
+}
@@ -2,7 +2,11 @@ package langfuse
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,65 +16,270 @@ import (
 )
 
 const (
-	defaultFlushInterval = 500 * time.Millisecond
+	defaultFlushInterval         = 500 * time.Millisecond
+	defaultMaxConcurrentRequests = 4
+	defaultMaxBatchSize          = 100
+	// maxIngestionBatchSize keeps requests under the server's per-request limit
+	maxIngestionBatchSize = 1000
 )
 
 type Langfuse struct {
 	flushInterval time.Duration
+	maxBatchSize  atomic.Int64
 	client        *api.Client
 	observer      *observer.Observer[model.IngestionEvent]
+	clock         Clock
+	limiter       *requestLimiter
+	region        Region
+	host          string
+	defaultHost   string
+	loggerMu      sync.RWMutex
+	logger        *slog.Logger
+	stats         flushStats
+	errReporter   errorReporter
+	buffer        atomic.Pointer[traceBuffer]
+	uploader      *MediaUploader
+	uploaderOnce  sync.Once
+
+	strictValidation bool
+	modelNormalizer  *ModelNormalizer
+	providerResolver *ProviderResolver
+	sessions         sessionWindows
+	openSpans        openSpans
+	metrics          clientMetrics
+	signals          signalFlusher
+	offloader        atomic.Pointer[MediaProcessor]
+	rateLimit        atomic.Pointer[rateLimiter]
+	asyncFlush       atomic.Bool
+
+	environment       string
+	testMode          bool
+	testModeSet       bool
+	sink              *MultiSink
+	version           string
+	sampleRate        float64
+	mask              MaskFunc
+	samplingDecisions samplingDecisions
+	canonicalJSON     bool
+	runtimeMetadata   bool
+	runtimeMemStats   bool
+	openInference     bool
+	scoreConfigs      map[string]ScoreConfig
+	minLevel          model.ObservationLevel
+	levelDropped      samplingDecisions
+	schemaStamped     samplingDecisions
+
+	// recorder keeps queued events in memory instead of sending them
+	recorder *ObserverRecorder
 }
 
+// New creates a client configured from the LANGFUSE_HOST, LANGFUSE_PUBLIC_KEY
+// and LANGFUSE_SECRET_KEY environment variables. LANGFUSE_PUBLIC_KEY_FILE and
+// LANGFUSE_SECRET_KEY_FILE name files to read the keys from instead.
 func New(ctx context.Context) *Langfuse {
-	client := api.New()
+	return newLangfuse(ctx, api.New(), api.DefaultBaseURL())
+}
 
+// newLangfuse creates a client sending events through client. defaultHost is
+// used when neither a host nor a region is set.
+func newLangfuse(ctx context.Context, client *api.Client, defaultHost string) *Langfuse {
 	l := &Langfuse{
 		flushInterval: defaultFlushInterval,
-		client:        client,
-		observer: observer.NewObserver(
-			ctx,
-			func(ctx context.Context, events []model.IngestionEvent) {
-				err := ingest(ctx, client, events)
-				if err != nil {
-					_, _ = fmt.Println(err)
-				}
-			},
-		),
+		client:        client.WithSDKVersion(Version).WithSchemaVersion(SchemaVersion),
+		clock:         realClock{},
+		limiter:       newRequestLimiter(defaultMaxConcurrentRequests),
+		defaultHost:   defaultHost,
+		sampleRate:    1,
 	}
 
+	l.maxBatchSize.Store(defaultMaxBatchSize)
+
+	l.observer = observer.NewObserver(
+		ctx,
+		func(ctx context.Context, events []model.IngestionEvent) {
+			batchSize := int(l.maxBatchSize.Load())
+			for len(events) > 0 {
+				n := min(batchSize, len(events))
+				err := l.sendBatch(ctx, events[:n])
+				events = events[n:]
+
+				// The remaining batches would be canceled too; fail them without sending
+				if errors.Is(err, ErrIngestionCanceled) && len(events) > 0 {
+					l.recordBatch(len(events), nil, err)
+					return
+				}
+			}
+		},
+	).WithTick(l.flushInterval)
+
 	return l
 }
 
+// sendBatch sends one ingestion request, records its outcome and returns the
+// request error. Batches the server rejects as too large are split and resent.
+func (l *Langfuse) sendBatch(ctx context.Context, events []model.IngestionEvent) error {
+	if err := l.waitRateLimit(ctx); err != nil {
+		l.recordBatch(len(events), nil, err)
+		return err
+	}
+
+	if err := l.limiter.acquire(ctx); err != nil {
+		l.recordBatch(len(events), nil, err)
+		return err
+	}
+	l.metrics.batches.Add(1)
+	res, err := l.ingest(ctx, events)
+	l.limiter.release()
+
+	if errors.Is(err, ErrPayloadTooLarge) {
+		return l.splitBatch(ctx, events, err)
+	}
+	l.recordBatch(len(events), res, err)
+	return err
+}
+
+// splitBatch resends the halves of a batch rejected as too large, recursing
+// until each piece fits. An event too large to send alone is dropped.
+func (l *Langfuse) splitBatch(ctx context.Context, events []model.IngestionEvent, err error) error {
+	if len(events) == 1 {
+		l.metrics.eventsDropped.Add(1)
+		err = fmt.Errorf("dropped %s event %s: %w", events[0].Type, events[0].ID, err)
+		l.recordBatch(1, nil, err)
+		return err
+	}
+
+	half := len(events) / 2
+	firstErr := l.sendBatch(ctx, events[:half])
+	// The second half would be canceled too; fail it without sending
+	if errors.Is(firstErr, ErrIngestionCanceled) {
+		l.recordBatch(len(events)-half, nil, firstErr)
+		return firstErr
+	}
+	return errors.Join(firstErr, l.sendBatch(ctx, events[half:]))
+}
+
+// recordBatch records the outcome of a batch and reports its errors
+func (l *Langfuse) recordBatch(events int, res *api.IngestionResponse, err error) {
+	if err != nil {
+		l.metrics.eventsFailed.Add(int64(events))
+	} else {
+		l.metrics.eventsFailed.Add(int64(len(res.Errors)))
+		l.metrics.eventsSent.Add(int64(events - len(res.Errors)))
+	}
+	for _, ingestErr := range l.stats.record(events, res, err) {
+		l.errReporter.report(ingestErr)
+	}
+	if err != nil && !l.errReporter.enabled() {
+		_, _ = fmt.Println(err)
+	}
+}
+
+// WithFlushInterval sets how often queued events are sent (defaults to 500ms).
+// Flush sends queued events immediately regardless of the interval.
 func (l *Langfuse) WithFlushInterval(d time.Duration) *Langfuse {
+	if d <= 0 {
+		d = defaultFlushInterval
+	}
 	l.flushInterval = d
+	l.observer.WithTick(d)
 	return l
 }
 
-func ingest(ctx context.Context, client *api.Client, events []model.IngestionEvent) error {
+// WithMaxBatchSize sets the maximum number of events per ingestion request
+// (defaults to 100, capped at 1000). Larger drains are split into several requests.
+func (l *Langfuse) WithMaxBatchSize(n int) *Langfuse {
+	if n <= 0 {
+		n = defaultMaxBatchSize
+	}
+	l.maxBatchSize.Store(int64(min(n, maxIngestionBatchSize)))
+	return l
+}
+
+// WithMaxConcurrentRequests bounds the number of ingestion requests in flight.
+// Flushes beyond the limit wait for a running request to finish.
+func (l *Langfuse) WithMaxConcurrentRequests(n int) *Langfuse {
+	if n <= 0 {
+		n = defaultMaxConcurrentRequests
+	}
+	l.limiter.setLimit(n)
+	return l
+}
+
+// InFlightRequests returns the number of ingestion requests currently in flight
+func (l *Langfuse) InFlightRequests() int {
+	return l.limiter.inFlight()
+}
+
+// WithClock sets the clock used for event timestamps
+func (l *Langfuse) WithClock(c Clock) *Langfuse {
+	if c == nil {
+		c = realClock{}
+	}
+	l.clock = c
+	return l
+}
+
+// Now returns the current time according to the client's clock
+func (l *Langfuse) Now() time.Time {
+	return l.clock.Now()
+}
+
+// ingest sends events to the client's project, and to the mirrored projects of
+// its MultiSink when one is set
+func (l *Langfuse) ingest(ctx context.Context, events []model.IngestionEvent) (*api.IngestionResponse, error) {
+	if l.sink != nil {
+		return l.sink.ingest(ctx, events)
+	}
+	return ingestTo(ctx, l.client, events)
+}
+
+// ingestTo sends events to the project of client
+func ingestTo(ctx context.Context, client *api.Client, events []model.IngestionEvent) (*api.IngestionResponse, error) {
 	req := api.Ingestion{
 		Batch: events,
 	}
 
 	res := api.IngestionResponse{}
-	return client.Ingestion(ctx, &req, &res)
+	if err := client.Ingestion(ctx, &req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
 }
 
-func (l *Langfuse) Trace(t *model.Trace) (*model.Trace, error) {
+// Trace queues a trace. Options override the client's environment, sampling
+// and masking for this call.
+func (l *Langfuse) Trace(t *model.Trace, opts ...CallOption) (*model.Trace, error) {
+	if err := l.validateTrace(t); err != nil {
+		return nil, err
+	}
+
 	t.ID = buildID(&t.ID)
-	l.observer.Dispatch(
+	if !l.prepare(t.ID, t, opts) {
+		return t, nil
+	}
+	l.offloadIO(t.ID, "", &t.Input, &t.Output, opts)
+	t.Metadata = l.withRuntimeMetadata(t.Metadata)
+	t.Metadata = l.withSchemaVersion(t.ID, t.Metadata)
+	l.dispatch(
 		model.IngestionEvent{
 			ID:        buildID(nil),
 			Type:      model.IngestionEventTypeTraceCreate,
-			Timestamp: time.Now().UTC(),
+			Timestamp: l.clock.Now().UTC(),
 			Body:      t,
 		},
 	)
 	return t, nil
 }
 
-func (l *Langfuse) Generation(g *model.Generation, parentID *string) (*model.Generation, error) {
+// Generation queues a generation, creating a trace for it when it has none.
+// Options override the client's environment, sampling and masking for this call.
+func (l *Langfuse) Generation(g *model.Generation, parentID *string, opts ...CallOption) (*model.Generation, error) {
+	if err := l.validateObservation("generation", g.Name, g.TraceID, parentObservationID(g.ParentObservationID, parentID), g.StartTime, &g.EndTime); err != nil {
+		return nil, err
+	}
+
 	if g.TraceID == "" {
-		traceID, err := l.createTrace(g.Name)
+		traceID, err := l.createTrace(g.Name, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -84,11 +293,26 @@ func (l *Langfuse) Generation(g *model.Generation, parentID *string) (*model.Gen
 		g.ParentObservationID = *parentID
 	}
 
-	l.observer.Dispatch(
+	if !l.prepare(g.TraceID, g, opts) {
+		return g, nil
+	}
+
+	l.applyGenerationProvider(g)
+	g.Model = l.NormalizeModel(g.Model)
+	g.Usage = g.Usage.Normalize()
+	l.offloadIO(g.TraceID, g.ID, &g.Input, &g.Output, opts)
+	applyStreamingMetrics(g)
+	applyReasoning(g)
+	applyUsageDetails(g)
+	g.Metadata = withObservationComment(withObservationTags(g.Metadata, g.Tags), g.Comment)
+	g.Metadata = withObservationLinks(withGenerationTools(g.Metadata, g.Tools), g.Links)
+	l.applyOpenInferenceGeneration(g)
+
+	l.dispatch(
 		model.IngestionEvent{
 			ID:        buildID(nil),
 			Type:      model.IngestionEventTypeGenerationCreate,
-			Timestamp: time.Now().UTC(),
+			Timestamp: l.clock.Now().UTC(),
 			Body:      g,
 		},
 	)
@@ -104,11 +328,29 @@ func (l *Langfuse) GenerationEnd(g *model.Generation) (*model.Generation, error)
 		return nil, fmt.Errorf("trace ID is required")
 	}
 
-	l.observer.Dispatch(
+	if err := l.validateObservation("generation", g.Name, g.TraceID, g.ParentObservationID, g.StartTime, &g.EndTime); err != nil {
+		return nil, err
+	}
+	if !l.prepare(g.TraceID, g, nil) {
+		return g, nil
+	}
+
+	l.applyGenerationProvider(g)
+	g.Model = l.NormalizeModel(g.Model)
+	g.Usage = g.Usage.Normalize()
+	l.offloadIO(g.TraceID, g.ID, &g.Input, &g.Output, nil)
+	applyStreamingMetrics(g)
+	applyReasoning(g)
+	applyUsageDetails(g)
+	g.Metadata = withObservationComment(withObservationTags(g.Metadata, g.Tags), g.Comment)
+	g.Metadata = withObservationLinks(withGenerationTools(g.Metadata, g.Tools), g.Links)
+	l.applyOpenInferenceGeneration(g)
+
+	l.dispatch(
 		model.IngestionEvent{
 			ID:        buildID(nil),
 			Type:      model.IngestionEventTypeGenerationUpdate,
-			Timestamp: time.Now().UTC(),
+			Timestamp: l.clock.Now().UTC(),
 			Body:      g,
 		},
 	)
@@ -116,26 +358,101 @@ func (l *Langfuse) GenerationEnd(g *model.Generation) (*model.Generation, error)
 	return g, nil
 }
 
+// Score enqueues a score and returns without waiting for it to be sent.
+// Use ScoreSync when the caller needs confirmation that the server accepted it.
 func (l *Langfuse) Score(s *model.Score) (*model.Score, error) {
-	if s.TraceID == "" {
+	event, err := l.scoreEvent(s)
+	if err != nil {
+		return nil, err
+	}
+	if !l.prepare(s.TraceID, s, nil) {
+		return s, nil
+	}
+
+	l.dispatch(event)
+	return s, nil
+}
+
+// ScoreTrace queues a score for the whole trace rather than one of its
+// observations, e.g. overall user satisfaction
+func (l *Langfuse) ScoreTrace(traceID string, name string, value float64) (*model.Score, error) {
+	if traceID == "" {
 		return nil, fmt.Errorf("trace ID is required")
 	}
-	s.ID = buildID(&s.ID)
+	return l.Score(&model.Score{TraceID: traceID, Name: name, Value: value})
+}
 
-	l.observer.Dispatch(
-		model.IngestionEvent{
-			ID:        buildID(nil),
-			Type:      model.IngestionEventTypeScoreCreate,
-			Timestamp: time.Now().UTC(),
-			Body:      s,
-		},
-	)
+// ScoreSync sends a score in its own ingestion request and blocks until the
+// server has accepted or rejected it, or ctx is done. Errors are returned
+// only, not passed to the WithErrorHandler handler or FlushWithResult, though
+// they count in Stats. When the client records into an ObserverRecorder or
+// buffers traces with WithTraceBuffering, the score is queued like Score and
+// ScoreSync returns without waiting, so it follows its trace.
+func (l *Langfuse) ScoreSync(ctx context.Context, s *model.Score) (*model.Score, error) {
+	event, err := l.scoreEvent(s)
+	if err != nil {
+		return nil, err
+	}
+	if !l.prepare(s.TraceID, s, nil) {
+		return s, nil
+	}
+	if l.recorder != nil || l.buffer.Load() != nil {
+		l.dispatch(event)
+		return s, nil
+	}
+
+	l.metrics.eventsEnqueued.Add(1)
+	if err := l.waitRateLimit(ctx); err != nil {
+		l.metrics.eventsFailed.Add(1)
+		return nil, err
+	}
+	if err := l.limiter.acquire(ctx); err != nil {
+		l.metrics.eventsFailed.Add(1)
+		return nil, err
+	}
+	defer l.limiter.release()
+
+	l.metrics.batches.Add(1)
+	res, err := l.ingest(ctx, []model.IngestionEvent{event})
+	if err == nil && len(res.Errors) > 0 {
+		eventErr := res.Errors[0]
+		err = fmt.Errorf("score %s: status %d: %s", s.ID, eventErr.Status, eventErr.Message)
+	}
+	if err != nil {
+		l.metrics.eventsFailed.Add(1)
+		return nil, err
+	}
+	l.metrics.eventsSent.Add(1)
 	return s, nil
 }
 
-func (l *Langfuse) Span(s *model.Span, parentID *string) (*model.Span, error) {
+// scoreEvent validates a score and wraps it in an ingestion event
+func (l *Langfuse) scoreEvent(s *model.Score) (model.IngestionEvent, error) {
+	if s.TraceID == "" && s.ObservationID == "" {
+		return model.IngestionEvent{}, fmt.Errorf("trace ID or observation ID is required")
+	}
+	if err := l.validateScore(s); err != nil {
+		return model.IngestionEvent{}, err
+	}
+	s.ID = buildID(&s.ID)
+
+	return model.IngestionEvent{
+		ID:        buildID(nil),
+		Type:      model.IngestionEventTypeScoreCreate,
+		Timestamp: l.clock.Now().UTC(),
+		Body:      s,
+	}, nil
+}
+
+// Span queues a span, creating a trace for it when it has none. Options
+// override the client's environment, sampling and masking for this call.
+func (l *Langfuse) Span(s *model.Span, parentID *string, opts ...CallOption) (*model.Span, error) {
+	if err := l.validateObservation("span", s.Name, s.TraceID, parentObservationID(s.ParentObservationID, parentID), s.StartTime, &s.EndTime); err != nil {
+		return nil, err
+	}
+
 	if s.TraceID == "" {
-		traceID, err := l.createTrace(s.Name)
+		traceID, err := l.createTrace(s.Name, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -149,11 +466,29 @@ func (l *Langfuse) Span(s *model.Span, parentID *string) (*model.Span, error) {
 		s.ParentObservationID = *parentID
 	}
 
-	l.observer.Dispatch(
+	s.Metadata = withObservationComment(withObservationTags(s.Metadata, s.Tags), s.Comment)
+	s.Metadata = withObservationLinks(s.Metadata, s.Links)
+	sampled := l.prepare(s.TraceID, s, opts)
+	if sampled {
+		l.offloadIO(s.TraceID, s.ID, &s.Input, &s.Output, opts)
+	}
+
+	// Remember open spans so SpanEndWith can keep their trace and metadata
+	if s.EndTime == nil {
+		l.openSpans.add(s.ID, openSpan{traceID: s.TraceID, name: s.Name, metadata: s.Metadata})
+	} else {
+		l.openSpans.remove(s.ID)
+	}
+	if !sampled {
+		return s, nil
+	}
+	l.applyOpenInferenceSpan(s)
+
+	l.dispatch(
 		model.IngestionEvent{
 			ID:        buildID(nil),
 			Type:      model.IngestionEventTypeSpanCreate,
-			Timestamp: time.Now().UTC(),
+			Timestamp: l.clock.Now().UTC(),
 			Body:      s,
 		},
 	)
@@ -170,11 +505,26 @@ func (l *Langfuse) SpanEnd(s *model.Span) (*model.Span, error) {
 		return nil, fmt.Errorf("trace ID is required")
 	}
 
-	l.observer.Dispatch(
+	if err := l.validateObservation("span", s.Name, s.TraceID, s.ParentObservationID, s.StartTime, &s.EndTime); err != nil {
+		return nil, err
+	}
+
+	s.Metadata = withObservationComment(withObservationTags(s.Metadata, s.Tags), s.Comment)
+	s.Metadata = withObservationLinks(s.Metadata, s.Links)
+	if s.EndTime != nil {
+		l.openSpans.remove(s.ID)
+	}
+	if !l.prepare(s.TraceID, s, nil) {
+		return s, nil
+	}
+	l.offloadIO(s.TraceID, s.ID, &s.Input, &s.Output, nil)
+	l.applyOpenInferenceSpan(s)
+
+	l.dispatch(
 		model.IngestionEvent{
 			ID:        buildID(nil),
 			Type:      model.IngestionEventTypeSpanUpdate,
-			Timestamp: time.Now().UTC(),
+			Timestamp: l.clock.Now().UTC(),
 			Body:      s,
 		},
 	)
@@ -182,7 +532,12 @@ func (l *Langfuse) SpanEnd(s *model.Span) (*model.Span, error) {
 	return s, nil
 }
 
+// Event queues an event like any other observation; it is sent with the next batch
 func (l *Langfuse) Event(e *model.Event, parentID *string) (*model.Event, error) {
+	if err := l.validateObservation("event", e.Name, e.TraceID, parentObservationID(e.ParentObservationID, parentID), e.StartTime, nil); err != nil {
+		return nil, err
+	}
+
 	if e.TraceID == "" {
 		traceID, err := l.createTrace(e.Name)
 		if err != nil {
@@ -191,40 +546,78 @@ func (l *Langfuse) Event(e *model.Event, parentID *string) (*model.Event, error)
 
 		e.TraceID = traceID
 	}
+	if !l.prepare(e.TraceID, e, nil) {
+		return e, nil
+	}
+
+	l.dispatch(l.eventCreate(e, parentID))
+
+	return e, nil
+}
+
+// EventBatch queues several events at once, e.g. the steps of an agent. They
+// are validated up front, so either all are queued or none, and they are sent
+// in order in the same ingestion batch. Events without a trace ID share one
+// new trace named after the first event.
+func (l *Langfuse) EventBatch(events []*model.Event, parentID *string) ([]*model.Event, error) {
+	for _, e := range events {
+		if err := l.validateObservation("event", e.Name, e.TraceID, parentObservationID(e.ParentObservationID, parentID), e.StartTime, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var sharedTraceID string
+	ingestionEvents := make([]model.IngestionEvent, 0, len(events))
+	for _, e := range events {
+		if e.TraceID == "" {
+			if sharedTraceID == "" {
+				traceID, err := l.createTrace(e.Name)
+				if err != nil {
+					return nil, err
+				}
+				sharedTraceID = traceID
+			}
+			e.TraceID = sharedTraceID
+		}
+		if !l.prepare(e.TraceID, e, nil) {
+			continue
+		}
+		ingestionEvents = append(ingestionEvents, l.eventCreate(e, parentID))
+	}
+
+	l.dispatchAll(ingestionEvents)
+	return events, nil
+}
 
+// eventCreate assigns the event's IDs and wraps it in an ingestion event
+func (l *Langfuse) eventCreate(e *model.Event, parentID *string) model.IngestionEvent {
 	e.ID = buildID(&e.ID)
 
 	if parentID != nil {
 		e.ParentObservationID = *parentID
 	}
+	l.offloadIO(e.TraceID, e.ID, &e.Input, &e.Output, nil)
 
-	l.observer.Dispatch(
-		model.IngestionEvent{
-			ID:        uuid.New().String(),
-			Type:      model.IngestionEventTypeEventCreate,
-			Timestamp: time.Now().UTC(),
-			Body:      e,
-		},
-	)
-
-	return e, nil
+	return model.IngestionEvent{
+		ID:        uuid.New().String(),
+		Type:      model.IngestionEventTypeEventCreate,
+		Timestamp: l.clock.Now().UTC(),
+		Body:      e,
+	}
 }
 
-func (l *Langfuse) createTrace(traceName string) (string, error) {
+func (l *Langfuse) createTrace(traceName string, opts ...CallOption) (string, error) {
 	trace, errTrace := l.Trace(
 		&model.Trace{
 			Name: traceName,
 		},
+		opts...,
 	)
 	if errTrace != nil {
 		return "", errTrace
 	}
 
-	return trace.ID, fmt.Errorf("unable to get trace ID")
-}
-
-func (l *Langfuse) Flush(ctx context.Context) {
-	l.observer.Wait(ctx)
+	return trace.ID, nil
 }
 
 func buildID(id *string) string {
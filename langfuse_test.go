@@ -0,0 +1,3972 @@
+package langfuse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// Test that batched events are sent after the span they attach to, in order
+func TestEventBatchOrdering(t *testing.T) {
+	var mu sync.Mutex
+	var received []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Batch []map[string]interface{} `json:"batch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Invalid ingestion body: %v", err)
+		}
+		mu.Lock()
+		received = append(received, req.Batch...)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	now := time.Now()
+	span, err := l.Span(&model.Span{TraceID: "trace-1", Name: "agent", StartTime: &now}, nil)
+	if err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	events := []*model.Event{
+		{TraceID: "trace-1", Name: "thought"},
+		{TraceID: "trace-1", Name: "action"},
+		{TraceID: "trace-1", Name: "observation"},
+	}
+	if _, err := l.EventBatch(events, &span.ID); err != nil {
+		t.Fatalf("EventBatch: %v", err)
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	wantTypes := []string{"span-create", "event-create", "event-create", "event-create"}
+	if len(received) != len(wantTypes) {
+		t.Fatalf("Expected %d ingestion events, got %d", len(wantTypes), len(received))
+	}
+	for i, want := range wantTypes {
+		if received[i]["type"] != want {
+			t.Errorf("Event %d: got type %v, want %s", i, received[i]["type"], want)
+		}
+	}
+
+	for i, name := range []string{"thought", "action", "observation"} {
+		body, _ := received[i+1]["body"].(map[string]interface{})
+		if body["name"] != name {
+			t.Errorf("Event %d: got %v, want %s", i, body["name"], name)
+		}
+		if body["parentObservationId"] != span.ID {
+			t.Errorf("Event %s: got parent %v, want %s", name, body["parentObservationId"], span.ID)
+		}
+	}
+}
+
+// Test that SpanEndWith keeps the trace and merges the start metadata
+func TestSpanEndWith(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	now := time.Now()
+	span, err := l.Span(&model.Span{
+		TraceID:   "trace-1",
+		Name:      "fetch",
+		StartTime: &now,
+		Metadata:  map[string]interface{}{"source": "api", "attempt": 1},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+
+	ended, err := l.SpanEndWith(span.ID, "done",
+		WithEndMetadata(map[string]interface{}{"attempt": 2}),
+		WithEndError(errors.New("timeout")),
+	)
+	if err != nil {
+		t.Fatalf("SpanEndWith: %v", err)
+	}
+
+	if ended.TraceID != "trace-1" || ended.EndTime == nil {
+		t.Errorf("Expected an ended span in trace-1, got %+v", ended)
+	}
+	metadata, _ := ended.Metadata.(map[string]interface{})
+	if metadata["source"] != "api" || metadata["attempt"] != 2 || metadata["error"] != "timeout" {
+		t.Errorf("Metadata not merged: %v", metadata)
+	}
+	if ended.Level != model.ObservationLevelError || ended.StatusMessage != "timeout" {
+		t.Errorf("Expected an ERROR level with the error message, got %s %q", ended.Level, ended.StatusMessage)
+	}
+
+	if _, err := l.SpanEndWith(span.ID, nil); err == nil {
+		t.Error("An ended span should be forgotten")
+	}
+	if _, err := l.SpanEndWith("other", nil, WithEndTraceID("trace-2")); err != nil {
+		t.Errorf("WithEndTraceID should allow ending an unknown span: %v", err)
+	}
+}
+
+// Test that observation comments are recorded as metadata, separate from errors
+func TestObservationComment(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	now := time.Now()
+	span, err := l.Span(&model.Span{TraceID: "trace-1", Name: "answer", StartTime: &now}, nil)
+	if err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	ended, err := l.SpanEndWith(span.ID, "done",
+		WithEndComment("fell back to secondary model"),
+		WithEndError(errors.New("primary model timeout")),
+	)
+	if err != nil {
+		t.Fatalf("SpanEndWith: %v", err)
+	}
+	metadata, _ := ended.Metadata.(map[string]interface{})
+	if metadata["comment"] != "fell back to secondary model" || ended.StatusMessage != "primary model timeout" {
+		t.Errorf("Expected the comment in metadata apart from the status message, got %v and %q", metadata, ended.StatusMessage)
+	}
+
+	generation, err := l.Generation(&model.Generation{
+		TraceID:  "trace-1",
+		Name:     "llm",
+		Metadata: map[string]interface{}{"attempt": 2},
+		Comment:  "cached prompt",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	encoded, _ := json.Marshal(generation)
+	if !strings.Contains(string(encoded), `"metadata":{"attempt":2,"comment":"cached prompt"}`) {
+		t.Errorf("Expected the comment to be sent as metadata, got %s", encoded)
+	}
+}
+
+// Test that the tools available to a generation are sent as metadata
+func TestGenerationTools(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	generation := &model.Generation{TraceID: "trace-1", Name: "llm"}
+	generation.SetTools(model.ToolDefinition{
+		Name:        "get_weather",
+		Description: "Look up the weather",
+		Parameters:  map[string]interface{}{"type": "object"},
+	})
+	if _, err := l.Generation(generation, nil); err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+
+	encoded, _ := json.Marshal(generation)
+	expected := `"metadata":{"tools":[{"name":"get_weather","description":"Look up the weather","parameters":{"type":"object"}}]}`
+	if !strings.Contains(string(encoded), expected) {
+		t.Errorf("Expected the tools to be sent as metadata, got %s", encoded)
+	}
+}
+
+// Test that observation links are sent as metadata
+func TestObservationLinks(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	span := &model.Span{TraceID: "trace-1", Name: "aggregate", Metadata: map[string]interface{}{"chunks": 2}}
+	span.AddLinks(
+		model.ObservationLink{ObservationID: "chunk-1", Relationship: "consumed"},
+		model.ObservationLink{ObservationID: "chunk-2", Relationship: "consumed"},
+	)
+	if _, err := l.Span(span, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+
+	encoded, _ := json.Marshal(span)
+	expected := `"metadata":{"chunks":2,"links":[{"observationId":"chunk-1","relationship":"consumed"},{"observationId":"chunk-2","relationship":"consumed"}]}`
+	if !strings.Contains(string(encoded), expected) {
+		t.Errorf("Expected the links to be sent as metadata, got %s", encoded)
+	}
+
+	generation := (&model.Generation{TraceID: "trace-1", Name: "summarize"}).AddLinks(model.ObservationLink{ObservationID: "span-9", TraceID: "trace-0"})
+	if _, err := l.Generation(generation, nil); err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	encoded, _ = json.Marshal(generation)
+	if !strings.Contains(string(encoded), `"links":[{"observationId":"span-9","traceId":"trace-0"}]`) {
+		t.Errorf("Expected the generation link to be sent as metadata, got %s", encoded)
+	}
+}
+
+// Test that typed payload setters reject values that cannot be encoded
+func TestTypedPayloads(t *testing.T) {
+	type answer struct {
+		Text string `json:"text"`
+	}
+
+	generation := &model.Generation{Name: "llm"}
+	if err := model.SetInput(generation, []string{"question"}); err != nil {
+		t.Fatalf("SetInput: %v", err)
+	}
+	if err := model.SetOutput(generation, answer{Text: "42"}); err != nil {
+		t.Fatalf("SetOutput: %v", err)
+	}
+	if err := model.SetOutput(generation, make(chan int)); err == nil {
+		t.Error("Expected an error for a channel output")
+	}
+	if err := model.SetInput(&model.Span{}, math.NaN()); err == nil {
+		t.Error("Expected an error for a NaN input")
+	}
+	if output, ok := generation.Output.(answer); !ok || output.Text != "42" {
+		t.Errorf("Expected the valid output to be kept, got %#v", generation.Output)
+	}
+	var missing *model.Trace
+	if err := model.SetInput(missing, "question"); err == nil {
+		t.Error("Expected an error for a nil trace")
+	}
+
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	oc := NewObserver(l).Start("step")
+	if err := SetObservationOutput(oc, func() {}); err == nil {
+		t.Error("Expected an error for a function output")
+	}
+	if err := SetObservationOutput(oc, answer{Text: "done"}); err != nil {
+		t.Errorf("SetObservationOutput: %v", err)
+	}
+}
+
+// Test that the rate limit spaces out requests and gives up when the context is done
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithMaxBatchSize(1).
+		WithRateLimit(20, 1)
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := l.Trace(&model.Trace{Name: name}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+	}
+	start := time.Now()
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected 3 requests at 20 per second to take 100ms, took %v", elapsed)
+	}
+
+	l.WithRateLimit(0.001, 1)
+	if _, err := l.ScoreSync(ctx, &model.Score{TraceID: "trace-1", Name: "quality", Value: 1}); err != nil {
+		t.Fatalf("ScoreSync: %v", err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.ScoreSync(timeoutCtx, &model.Score{TraceID: "trace-1", Name: "quality", Value: 1}); !errors.Is(err, ErrIngestionCanceled) {
+		t.Errorf("Expected a request waiting past its context to be canceled, got %v", err)
+	}
+}
+
+// Test that runtime metadata is recorded on traces only when enabled
+func TestRuntimeMetadata(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	trace, err := l.Trace(&model.Trace{Name: "default"})
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if _, recorded := trace.Metadata.(map[string]interface{})[metadataKeyRuntime]; recorded {
+		t.Errorf("Expected no runtime metadata by default, got %v", trace.Metadata)
+	}
+
+	l.WithRuntimeMetadata(true).WithRuntimeMemStats(true)
+	trace, err = l.Trace(&model.Trace{Name: "debug", Metadata: map[string]interface{}{"user": "u1"}})
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	metadata, _ := trace.Metadata.(map[string]interface{})
+	info, _ := metadata["runtime"].(map[string]interface{})
+	if id, _ := info["goroutine_id"].(uint64); metadata["user"] != "u1" || id == 0 || info["gomaxprocs"] != runtime.GOMAXPROCS(0) {
+		t.Errorf("Expected the runtime context alongside the metadata, got %v", metadata)
+	}
+	if _, hasMemStats := info["heap_alloc_bytes"]; !hasMemStats {
+		t.Errorf("Expected memory stats, got %v", info)
+	}
+}
+
+// Test that trace-level scores are sent without an observation and that scores need a target
+func TestScoreTrace(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	score, err := l.ScoreTrace("trace-1", "user_satisfaction", 0)
+	if err != nil {
+		t.Fatalf("ScoreTrace: %v", err)
+	}
+	encoded, _ := json.Marshal(score)
+	if strings.Contains(string(encoded), "observationId") || !strings.Contains(string(encoded), `"value":0`) {
+		t.Errorf("Expected a trace-level score with its zero value, got %s", encoded)
+	}
+
+	if _, err := l.Score(&model.Score{Name: "quality", Value: 1}); err == nil {
+		t.Error("Expected a score without a trace or observation to be rejected")
+	}
+	if _, err := l.Score(&model.Score{ObservationID: "obs-1", Name: "quality", Value: 1}); err != nil {
+		t.Errorf("Expected an observation score to be accepted, got %v", err)
+	}
+}
+
+// Test that OpenInference attributes are added to observation metadata when enabled
+func TestOpenInference(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithOpenInference(true)
+
+	generation, err := l.Generation(&model.Generation{
+		TraceID:  "trace-1",
+		Name:     "llm",
+		Model:    "gpt-4o",
+		Input:    "hello",
+		Usage:    model.Usage{Input: 10, Output: 5},
+		Metadata: map[string]interface{}{"attempt": 1},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	metadata, _ := generation.Metadata.(map[string]interface{})
+	expected := map[string]interface{}{
+		"attempt":                    1,
+		"openinference.span.kind":    "LLM",
+		"llm.model_name":             "gpt-4o",
+		"llm.token_count.prompt":     10,
+		"llm.token_count.completion": 5,
+		"llm.token_count.total":      15,
+		"input.value":                "hello",
+	}
+	for key, value := range expected {
+		if metadata[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, metadata[key])
+		}
+	}
+
+	span, err := l.Span(&model.Span{TraceID: "trace-1", Name: "retrieve", Output: "docs"}, nil)
+	if err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	metadata, _ = span.Metadata.(map[string]interface{})
+	if metadata["openinference.span.kind"] != "CHAIN" || metadata["output.value"] != "docs" {
+		t.Errorf("Expected span attributes, got %v", metadata)
+	}
+}
+
+// Test that score values are checked and coerced against their data type and config
+func TestScoreDataTypes(t *testing.T) {
+	five := 5.0
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithScoreConfigs(
+			ScoreConfig{Name: "rating", DataType: model.ScoreDataTypeNumeric, Max: &five, Clamp: true, ID: "cfg-1"},
+			ScoreConfig{Name: "accuracy", DataType: model.ScoreDataTypeNumeric, Max: &five},
+			ScoreConfig{Name: "helpful", DataType: model.ScoreDataTypeBoolean},
+		)
+
+	score, err := l.Score(&model.Score{TraceID: "trace-1", Name: "rating", StringValue: "true"})
+	if err != nil || score.Value != 1 || score.DataType != model.ScoreDataTypeNumeric || score.ConfigID != "cfg-1" {
+		t.Errorf("Expected true to be coerced to a numeric 1, got %+v, %v", score, err)
+	}
+	if score, err = l.Score(&model.Score{TraceID: "trace-1", Name: "rating", Value: 7}); err != nil || score.Value != 5 {
+		t.Errorf("Expected an out-of-range value to be clamped, got %+v, %v", score, err)
+	}
+	if _, err = l.Score(&model.Score{TraceID: "trace-1", Name: "accuracy", Value: 7}); err == nil {
+		t.Error("Expected an out-of-range value to be rejected")
+	}
+	if _, err = l.Score(&model.Score{TraceID: "trace-1", Name: "rating", StringValue: "good"}); err == nil {
+		t.Error("Expected a categorical value for a numeric score to be rejected")
+	}
+	if _, err = l.Score(&model.Score{TraceID: "trace-1", Name: "helpful", Value: 0.5}); err == nil {
+		t.Error("Expected a boolean score other than 0 or 1 to be rejected")
+	}
+	if _, err = l.Score(&model.Score{TraceID: "trace-1", Name: "tone", DataType: model.ScoreDataTypeCategorical, Value: 2}); err == nil {
+		t.Error("Expected a numeric value for a categorical score to be rejected")
+	}
+
+	score, err = l.Score(&model.Score{TraceID: "trace-1", Name: "tone", StringValue: "friendly"})
+	if err != nil {
+		t.Fatalf("Score: %v", err)
+	}
+	encoded, _ := json.Marshal(score)
+	if !strings.Contains(string(encoded), `"value":"friendly"`) || !strings.Contains(string(encoded), `"dataType":"CATEGORICAL"`) {
+		t.Errorf("Expected a categorical score with its string value, got %s", encoded)
+	}
+}
+
+// Test that the client version is a default that observers and events can override
+func TestObservationVersion(t *testing.T) {
+	var mu sync.Mutex
+	versions := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Batch []struct {
+				Type string `json:"type"`
+				Body struct {
+					Name    string `json:"name"`
+					Version string `json:"version"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		for _, event := range req.Batch {
+			versions[event.Type+":"+event.Body.Name] = event.Body.Version
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithVersion("v1")
+
+	now := time.Now()
+	if _, err := l.Span(&model.Span{TraceID: "trace-1", Name: "default", StartTime: &now}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	if _, err := l.Span(&model.Span{TraceID: "trace-1", Name: "explicit", StartTime: &now, Version: "v0"}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	oc := NewObserver(l, WithObserveVersion("v2")).Start("observed")
+	oc.Child("step", ObservationTypeSpan).End(nil, nil)
+	oc.End(nil, nil)
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := map[string]string{
+		"span-create:default":   "v1",
+		"span-create:explicit":  "v0",
+		"trace-create:observed": "v2",
+		"span-create:observed":  "v2",
+		"span-create:step":      "v2",
+		"span-update:observed":  "v2",
+	}
+	for key, version := range expected {
+		if versions[key] != version {
+			t.Errorf("Expected %s to have version %s, got %q", key, version, versions[key])
+		}
+	}
+}
+
+// Test that a derived observer keeps the trace context and copies its metadata
+func TestObserverWith(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	parentID := "observation-1"
+	base := NewObserver(l, WithObserveSession("session-1"), WithObserveUser("user-1"),
+		WithObserveMetadata(map[string]interface{}{"team": "search"}))
+	base.traceID = "trace-1"
+	base.parentID = &parentID
+
+	derived := base.With(WithObserveName("rerank"), WithObservationType(ObservationTypeGeneration))
+	if derived.TraceID() != "trace-1" || derived.sessionID != "session-1" || derived.userID != "user-1" {
+		t.Errorf("Expected the trace context to be kept, got %q %q %q", derived.TraceID(), derived.sessionID, derived.userID)
+	}
+	if derived.parentID == nil || *derived.parentID != parentID {
+		t.Errorf("Expected the derived observer to nest under %s, got %v", parentID, derived.parentID)
+	}
+	if derived.name != "rerank" || derived.obsType != ObservationTypeGeneration {
+		t.Errorf("Expected the overrides to apply, got %q %q", derived.name, derived.obsType)
+	}
+	if base.name != "" || base.obsType != ObservationTypeSpan {
+		t.Errorf("Expected the base observer to be unchanged, got %q %q", base.name, base.obsType)
+	}
+
+	derived.metadata["team"] = "ranking"
+	if base.metadata["team"] != "search" {
+		t.Errorf("Expected the metadata map not to be shared, got %v", base.metadata)
+	}
+}
+
+// Test that the recorder keeps observed calls in memory for assertions
+func TestObserverRecorder(t *testing.T) {
+	recorder := NewObserverRecorder()
+	observer := recorder.Observer(WithObserveName("lookup"))
+
+	lookup := observer.Observe(func(query string) (string, error) {
+		return "answer to " + query, nil
+	}).(func(string) (string, error))
+	if _, err := lookup("question"); err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if _, err := recorder.Client().Score(&model.Score{TraceID: observer.TraceID(), Name: "quality", Value: 1}); err != nil {
+		t.Fatalf("Score: %v", err)
+	}
+
+	observations := recorder.ObservationsNamed("lookup")
+	if len(observations) != 1 {
+		t.Fatalf("Expected one lookup observation, got %d of %d", len(observations), len(recorder.Observations()))
+	}
+	obs := observations[0]
+	if obs.Type != "span" || obs.TraceID != observer.TraceID() || obs.StartTime == nil || obs.EndTime == nil {
+		t.Errorf("Expected a finished span in the observer's trace, got %+v", obs)
+	}
+	if !strings.Contains(fmt.Sprint(obs.Output), "answer to question") {
+		t.Errorf("Expected the merged output, got %v", obs.Output)
+	}
+	if len(recorder.Traces()) != 1 || len(recorder.Scores()) != 1 {
+		t.Errorf("Expected one trace and one score, got %d and %d", len(recorder.Traces()), len(recorder.Scores()))
+	}
+	if err := recorder.Client().Flush(context.Background()); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+
+	recorder.Reset()
+	if len(recorder.Events()) != 0 {
+		t.Errorf("Expected no events after Reset, got %d", len(recorder.Events()))
+	}
+}
+
+// Test that embeddings are recorded as embedding generations under the active observation
+func TestEmbedding(t *testing.T) {
+	recorder := NewObserverRecorder()
+	observer := recorder.Observer(WithObserveName("retrieve"))
+	embeddings := recorder.Observer(WithEmbeddingVectors(true))
+
+	retrieve := observer.Observe(func(ctx context.Context, query string) error {
+		embedding := embeddings.Embedding(ctx, "text-embedding-3-small", query)
+		embedding.End(1536, model.NewUsage(8, 0))
+		embedding.End(3, model.Usage{}) // ignored
+
+		embeddings.Embedding(ctx, "text-embedding-3-small", query).EndWithVector([]float32{0.1, 0.2}, model.Usage{})
+		return nil
+	}).(func(context.Context, string) error)
+	if err := retrieve(context.Background(), "question"); err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+
+	parents := recorder.ObservationsNamed("retrieve")
+	recorded := recorder.ObservationsNamed("embedding")
+	if len(parents) != 1 || len(recorded) != 2 {
+		t.Fatalf("Expected one retrieve and two embeddings, got %d and %d", len(parents), len(recorded))
+	}
+
+	first := recorded[0]
+	generation, _ := first.Body.(*model.Generation)
+	if first.Type != "generation" || first.ParentObservationID != parents[0].ID || first.TraceID != parents[0].TraceID {
+		t.Errorf("Expected a generation nested under %s, got %+v", parents[0].ID, first)
+	}
+	if generation == nil || generation.Model != "text-embedding-3-small" || generation.Usage.Input != 8 {
+		t.Errorf("Expected the model and usage, got %+v", generation)
+	}
+	metadata, _ := first.Metadata.(map[string]interface{})
+	if metadata[metadataKeyObservationType] != ObservationTypeEmbedding {
+		t.Errorf("Expected the embedding observation type, got %v", metadata)
+	}
+	if output, _ := first.Output.(map[string]interface{}); output["dimensions"] != 1536 || output["vector"] != nil {
+		t.Errorf("Expected only the dimensions, got %v", first.Output)
+	}
+
+	if output, _ := recorded[1].Output.(map[string]interface{}); output["dimensions"] != 2 || output["vector"] == nil {
+		t.Errorf("Expected the dimensions and vector, got %v", recorded[1].Output)
+	}
+}
+
+// Test that observations below the minimum level are dropped with their updates
+func TestMinLevel(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client().WithMinLevel(model.ObservationLevelDefault)
+
+	debug, err := l.Span(&model.Span{TraceID: "trace-1", Name: "verbose", Level: model.ObservationLevelDebug}, nil)
+	if err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	if _, err := l.SpanEnd(&model.Span{ID: debug.ID, TraceID: "trace-1", Level: model.ObservationLevelError}); err != nil {
+		t.Fatalf("SpanEnd: %v", err)
+	}
+	if _, err := l.Event(&model.Event{TraceID: "trace-1", Name: "tick", Level: model.ObservationLevelDebug}, nil); err != nil {
+		t.Fatalf("Event: %v", err)
+	}
+	if _, err := l.Span(&model.Span{TraceID: "trace-1", Name: "kept"}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+
+	verbose := recorder.Observer(WithObserveName("verbose_call"), WithObserveLevel(model.ObservationLevelDebug))
+	call := verbose.Observe(func(input string) string { return input }).(func(string) string)
+	call("payload")
+
+	observations := recorder.Observations()
+	if len(observations) != 1 || observations[0].Name != "kept" {
+		t.Errorf("Expected only the DEFAULT span, got %d observations", len(observations))
+	}
+	if filtered := l.Stats().EventsFiltered; filtered != 5 {
+		t.Errorf("Expected 5 filtered events, got %d", filtered)
+	}
+}
+
+// Test that generation providers are resolved from raw model names and sent as metadata
+func TestProviderResolver(t *testing.T) {
+	resolver := NewProviderResolver().Add("acme-", "self-hosted")
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o-2024-08-06", "openai"},
+		{"Claude-3-5-Sonnet", "anthropic"},
+		{"models/gemini-1.5-pro", "google"},
+		{"bedrock/claude-3-haiku", "bedrock"},
+		{"acme-llm-7b", "self-hosted"},
+		{"some-local-model", ""},
+	}
+	for _, tt := range tests {
+		if got := resolver.Resolve(tt.model); got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+
+	recorder := NewObserverRecorder()
+	l := recorder.Client().WithProviderResolver(resolver).WithModelNormalizer(NewModelNormalizer())
+	if _, err := l.Generation(&model.Generation{TraceID: "trace-1", Name: "routed", Model: "azure/gpt-4-0613"}, nil); err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	if _, err := l.Generation(&model.Generation{TraceID: "trace-1", Name: "explicit", Model: "gpt-4", Provider: "openrouter"}, nil); err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+
+	for name, want := range map[string]string{"routed": "azure", "explicit": "openrouter"} {
+		observations := recorder.ObservationsNamed(name)
+		if len(observations) != 1 {
+			t.Fatalf("Expected one %s generation, got %d", name, len(observations))
+		}
+		metadata, _ := observations[0].Metadata.(map[string]interface{})
+		if metadata[MetadataKeyProvider] != want {
+			t.Errorf("Expected %s provider %q, got %v", name, want, metadata)
+		}
+	}
+}
+
+// Test that EnsureDataset returns existing datasets and creates missing ones
+func TestEnsureDataset(t *testing.T) {
+	var mu sync.Mutex
+	datasets := map[string]string{"existing": `{"id":"ds-1","name":"existing","description":"kept"}`}
+	created := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodGet {
+			body, found := datasets[strings.TrimPrefix(r.URL.Path, "/api/public/v2/datasets/")]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(body))
+			return
+		}
+		var req struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		created++
+		body, _ := json.Marshal(map[string]string{"id": "ds-new", "name": req.Name, "description": req.Description})
+		datasets[req.Name] = string(body)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	existing, err := l.EnsureDataset(ctx, "existing", "ignored", nil)
+	if err != nil {
+		t.Fatalf("EnsureDataset: %v", err)
+	}
+	if existing.ID != "ds-1" || existing.Description != "kept" {
+		t.Errorf("Expected the existing dataset, got %+v", existing)
+	}
+
+	for i := 0; i < 2; i++ {
+		dataset, err := l.EnsureDataset(ctx, "evals", "golden set", nil)
+		if err != nil {
+			t.Fatalf("EnsureDataset: %v", err)
+		}
+		if dataset.ID != "ds-new" || dataset.Description != "golden set" {
+			t.Errorf("Expected the created dataset, got %+v", dataset)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected the dataset to be created once, got %d", created)
+	}
+}
+
+// Test that reasoning content and tokens are recorded apart from the answer
+func TestReasoning(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client()
+	if _, err := l.Generation(&model.Generation{
+		TraceID:         "trace-1",
+		Name:            "solve",
+		Output:          "42",
+		Usage:           model.NewUsage(100, 50),
+		Reasoning:       "The answer is six times seven.",
+		ReasoningTokens: 30,
+	}, nil); err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+
+	observations := recorder.ObservationsNamed("solve")
+	if len(observations) != 1 {
+		t.Fatalf("Expected one generation, got %d", len(observations))
+	}
+	metadata, _ := observations[0].Metadata.(map[string]interface{})
+	if metadata[MetadataKeyReasoning] != "The answer is six times seven." || observations[0].Output != "42" {
+		t.Errorf("Expected the reasoning in metadata apart from the output, got %v and %v", metadata, observations[0].Output)
+	}
+	details := observations[0].Body.(*model.Generation).UsageDetails
+	want := map[string]int{"input": 100, "output": 20, "output_reasoning": 30, "total": 150}
+	if !reflect.DeepEqual(details, want) {
+		t.Errorf("Expected usage details %v, got %v", want, details)
+	}
+}
+
+// Test that usage totals count input and output once, without their breakdowns
+func TestUsageDetailsTotal(t *testing.T) {
+	tests := []struct {
+		name    string
+		details map[string]int
+		want    map[string]int
+	}{
+		{
+			name:    "input and output",
+			details: map[string]int{"input": 100, "output": 50},
+			want:    map[string]int{"input": 100, "output": 50, "total": 150},
+		},
+		{
+			name:    "breakdown keys",
+			details: map[string]int{"input": 100, "input_cached_tokens": 80, "output": 50, "output_reasoning_tokens": 30},
+			want:    map[string]int{"input": 100, "input_cached_tokens": 80, "output": 50, "output_reasoning_tokens": 30, "total": 150},
+		},
+		{
+			name:    "output only",
+			details: map[string]int{"output": 50},
+			want:    map[string]int{"output": 50, "total": 50},
+		},
+		{
+			name:    "explicit total",
+			details: map[string]int{"input": 100, "output": 50, "total": 160},
+			want:    map[string]int{"input": 100, "output": 50, "total": 160},
+		},
+		{
+			name:    "no input or output",
+			details: map[string]int{"audio": 10},
+			want:    map[string]int{"audio": 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := NewObserverRecorder()
+			details := maps.Clone(tt.details)
+			if _, err := recorder.Client().Generation(&model.Generation{TraceID: "trace-1", Name: "llm", UsageDetails: details}, nil); err != nil {
+				t.Fatalf("Generation: %v", err)
+			}
+			got := recorder.ObservationsNamed("llm")[0].Body.(*model.Generation).UsageDetails
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected usage details %v, got %v", tt.want, got)
+			}
+			if !reflect.DeepEqual(details, tt.details) {
+				t.Errorf("Expected the caller's details unchanged, got %v", details)
+			}
+		})
+	}
+
+	recorder := NewObserverRecorder()
+	if _, err := recorder.Client().Generation(&model.Generation{TraceID: "trace-1", Name: "llm", CostDetails: map[string]float64{"input": 0.5, "output": 0.25}}, nil); err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	if got := recorder.ObservationsNamed("llm")[0].Body.(*model.Generation).CostDetails["total"]; got != 0.75 {
+		t.Errorf("Expected a cost total of 0.75, got %v", got)
+	}
+}
+
+// Test that instrumentation mistakes are errors under strict validation and
+// warnings, corrected where safe, otherwise
+func TestStrictValidation(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	early := start.Add(-time.Second)
+	past := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	parent := "span-0"
+	tests := []struct {
+		name string
+		send func(l *Langfuse) error
+	}{
+		{"trace timestamp", func(l *Langfuse) error {
+			_, err := l.Trace(&model.Trace{Name: "old", Timestamp: &past})
+			return err
+		}},
+		{"child without trace", func(l *Langfuse) error {
+			_, err := l.Span(&model.Span{Name: "orphan", StartTime: &start}, &parent)
+			return err
+		}},
+		{"end before start", func(l *Langfuse) error {
+			_, err := l.Span(&model.Span{TraceID: "trace-1", Name: "step", StartTime: &start, EndTime: &early}, nil)
+			return err
+		}},
+		{"unnamed generation", func(l *Langfuse) error {
+			_, err := l.Generation(&model.Generation{TraceID: "trace-1", StartTime: &start}, nil)
+			return err
+		}},
+		{"unnamed score", func(l *Langfuse) error {
+			_, err := l.Score(&model.Score{TraceID: "trace-1", Value: 1})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strict := NewObserverRecorder()
+			if err := tt.send(strict.Client().WithStrictValidation(true)); err == nil {
+				t.Error("Expected an error under strict validation")
+			}
+			if events := strict.Events(); len(events) != 0 {
+				t.Errorf("Expected nothing sent under strict validation, got %d events", len(events))
+			}
+
+			lenient := NewObserverRecorder()
+			var warnings strings.Builder
+			client := lenient.Client().WithLogger(slog.New(slog.NewTextHandler(&warnings, nil)))
+			if err := tt.send(client); err != nil {
+				t.Errorf("Expected only a warning without strict validation, got %v", err)
+			}
+			if events := lenient.Events(); len(events) == 0 {
+				t.Error("Expected the event to be sent without strict validation")
+			}
+			if !strings.Contains(warnings.String(), "level=WARN") {
+				t.Errorf("Expected a warning logged to the client's logger, got %q", warnings.String())
+			}
+		})
+	}
+
+	recorder := NewObserverRecorder()
+	if _, err := recorder.Client().Span(&model.Span{TraceID: "trace-1", Name: "step", StartTime: &start, EndTime: &early}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	if end := recorder.ObservationsNamed("step")[0].EndTime; end == nil || !end.Equal(start) {
+		t.Errorf("Expected the end time clamped to the start, got %v", end)
+	}
+}
+
+// Test that go test runs are recorded as test events unless configured otherwise
+func TestTestMode(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client()
+	if _, err := l.Trace(&model.Trace{ID: "detected", Tags: []string{"checkout"}}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if _, err := l.Span(&model.Span{TraceID: "detected", Name: "step"}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	l.WithEnvironment("staging")
+	if _, err := l.Trace(&model.Trace{ID: "configured"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	l.WithTestMode(true)
+	if _, err := l.Trace(&model.Trace{ID: "forced"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	l.WithTestMode(false).WithEnvironment("")
+	if _, err := l.Trace(&model.Trace{ID: "disabled"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+
+	want := map[string]string{"detected": TestEnvironment, "configured": "staging", "forced": TestEnvironment, "disabled": ""}
+	for _, trace := range recorder.Traces() {
+		if trace.Environment != want[trace.ID] {
+			t.Errorf("Trace %s: expected environment %q, got %q", trace.ID, want[trace.ID], trace.Environment)
+		}
+		if tagged := slices.Contains(trace.Tags, TestEnvironment); tagged != (want[trace.ID] == TestEnvironment) {
+			t.Errorf("Trace %s: unexpected tags %v", trace.ID, trace.Tags)
+		}
+	}
+	if span := recorder.ObservationsNamed("step")[0].Body.(*model.Span); span.Environment != TestEnvironment {
+		t.Errorf("Expected the span in the test environment, got %q", span.Environment)
+	}
+}
+
+// Test that a multi sink sends identical events to every project and reports
+// errors of mirrors
+func TestMultiSink(t *testing.T) {
+	newProject := func(fail bool) (*httptest.Server, *[]map[string]interface{}, *sync.Mutex) {
+		var mu sync.Mutex
+		var received []map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Batch []map[string]interface{} `json:"batch"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("Invalid ingestion body: %v", err)
+			}
+			mu.Lock()
+			received = append(received, req.Batch...)
+			mu.Unlock()
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+		}))
+		return server, &received, &mu
+	}
+
+	primary, primaryEvents, primaryMu := newProject(false)
+	defer primary.Close()
+	mirror, mirrorEvents, mirrorMu := newProject(false)
+	defer mirror.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: primary.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithMultiSink(Project{Host: mirror.URL + "/", PublicKey: "pk-team", SecretKey: "sk-team"})
+
+	trace, err := l.Trace(&model.Trace{Name: "request"})
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if _, err := l.Span(&model.Span{TraceID: trace.ID, Name: "step"}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	primaryMu.Lock()
+	mirrorMu.Lock()
+	if len(*primaryEvents) != 2 || len(*mirrorEvents) != 2 {
+		t.Fatalf("Expected 2 events in each project, got %d and %d", len(*primaryEvents), len(*mirrorEvents))
+	}
+	for i, event := range *primaryEvents {
+		mirrored := (*mirrorEvents)[i]
+		if event["id"] != mirrored["id"] {
+			t.Errorf("Event %d: primary ID %v, mirror ID %v", i, event["id"], mirrored["id"])
+		}
+		body, _ := event["body"].(map[string]interface{})
+		mirroredBody, _ := mirrored["body"].(map[string]interface{})
+		if body["traceId"] != mirroredBody["traceId"] || body["id"] != mirroredBody["id"] {
+			t.Errorf("Event %d: primary body %v, mirror body %v", i, body, mirroredBody)
+		}
+	}
+	mirrorMu.Unlock()
+	primaryMu.Unlock()
+
+	failing, _, _ := newProject(true)
+	defer failing.Close()
+	l.WithMultiSink(Project{Host: failing.URL, PublicKey: "pk-team", SecretKey: "sk-team"})
+
+	if _, err := l.Trace(&model.Trace{Name: "request"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	err = l.Flush(ctx)
+	if err == nil || !strings.Contains(err.Error(), failing.URL) {
+		t.Errorf("Expected Flush error naming the failing mirror, got %v", err)
+	}
+	primaryMu.Lock()
+	defer primaryMu.Unlock()
+	if got := len(*primaryEvents); got != 3 {
+		t.Errorf("Expected the primary project to receive the event, got %d events", got)
+	}
+}
+
+// Test that only the project rejecting a batch as too large gets it split
+func TestMultiSinkPayloadTooLarge(t *testing.T) {
+	newProject := func(maxBatch int) (*httptest.Server, *atomic.Int32) {
+		var received atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Batch []struct {
+					Body struct {
+						Name string `json:"name"`
+					} `json:"body"`
+				} `json:"batch"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			tooLarge := len(req.Batch) > maxBatch
+			for _, event := range req.Batch {
+				tooLarge = tooLarge || event.Body.Name == "huge"
+			}
+			if tooLarge {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			received.Add(int32(len(req.Batch)))
+			_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+		}))
+		return server, &received
+	}
+
+	primary, primaryEvents := newProject(100)
+	defer primary.Close()
+	mirror, mirrorEvents := newProject(1)
+	defer mirror.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: primary.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithMultiSink(Project{Host: mirror.URL, PublicKey: "pk-team", SecretKey: "sk-team"})
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if _, err := l.Trace(&model.Trace{Name: name}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := primaryEvents.Load(); got != 4 {
+		t.Errorf("Expected the primary project to receive each event once, got %d", got)
+	}
+	if got := mirrorEvents.Load(); got != 4 {
+		t.Errorf("Expected the mirror to receive every event in pieces, got %d", got)
+	}
+
+	primaryEvents.Store(0)
+	mirrorEvents.Store(0)
+	if _, err := l.Trace(&model.Trace{Name: "huge"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	result := l.FlushWithResult(ctx)
+	if result.Failed != 1 || len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Error(), "dropped") {
+		t.Errorf("Expected the oversized event to fail once, got %+v", result)
+	}
+	if stats := l.Stats(); stats.EventsDropped != 1 {
+		t.Errorf("Expected 1 dropped event, got %+v", stats)
+	}
+}
+
+// Test that an observation's captured I/O becomes a dataset item linked to it
+func TestObserveContextToDatasetItem(t *testing.T) {
+	recorder := NewObserverRecorder()
+	dataset := &Dataset{ID: "dataset-1", Name: "qa", client: recorder.Client()}
+	oc := recorder.Observer().Start("answer")
+
+	if _, err := oc.ToDatasetItem(dataset, nil); err == nil {
+		t.Error("Expected an error for an observation without input")
+	}
+	if _, err := oc.ToDatasetItem(nil, nil); err == nil {
+		t.Error("Expected an error for a nil dataset")
+	}
+
+	oc.SetInput(map[string]interface{}{"question": "capital of France?"})
+	oc.End("Paris", nil)
+
+	item, err := oc.ToDatasetItem(dataset, map[string]interface{}{"source": "production"})
+	if err != nil {
+		t.Fatalf("ToDatasetItem: %v", err)
+	}
+	input, _ := item.Input.(map[string]interface{})
+	if input["question"] != "capital of France?" || item.ExpectedOutput != "Paris" {
+		t.Errorf("Expected the captured I/O, got %v and %v", item.Input, item.ExpectedOutput)
+	}
+	if item.SourceTraceID != oc.observer.TraceID() || item.SourceSpanID != oc.observationID {
+		t.Errorf("Expected the item linked to the observation, got %s/%s", item.SourceTraceID, item.SourceSpanID)
+	}
+	if item.Metadata["source"] != "production" || item.DatasetID != "dataset-1" {
+		t.Errorf("Unexpected item %+v", item)
+	}
+	if len(dataset.Items) != 1 || dataset.Items[0] != item {
+		t.Errorf("Expected the item added to the dataset, got %v", dataset.Items)
+	}
+}
+
+// Test that the sampling decision made at trace creation is shared by later
+// events and observers joining the trace
+func TestIsSampled(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client().WithSampleRate(0.5)
+
+	var kept, dropped string
+	for i := 0; kept == "" || dropped == ""; i++ {
+		id := fmt.Sprintf("trace-%d", i)
+		if sampleTrace(id, 0.5) {
+			kept = id
+		} else {
+			dropped = id
+		}
+	}
+	if !l.IsSampled(kept) || l.IsSampled(dropped) {
+		t.Fatalf("Expected IsSampled to follow the sample rate")
+	}
+	for _, id := range []string{kept, dropped} {
+		if _, err := l.Trace(&model.Trace{ID: id, Name: "request"}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+	}
+
+	// Changing the rate does not split traces already decided
+	l.WithSampleRate(0)
+	if !l.IsSampled(kept) || l.IsSampled(dropped) {
+		t.Errorf("Expected the decisions made at trace creation to be kept")
+	}
+
+	// Observers with their own rate follow the decision of the trace they join
+	observe := func(traceID string) {
+		ctx := ContextWithTraceID(context.Background(), traceID)
+		fn := recorder.Observer(WithObserveName("step"), WithSampleRate(0)).Observe(func(ctx context.Context) error { return nil })
+		if err := fn.(func(context.Context) error)(ctx); err != nil {
+			t.Fatalf("Observed call: %v", err)
+		}
+	}
+	observe(kept)
+	observe(dropped)
+
+	steps := recorder.ObservationsNamed("step")
+	if len(steps) != 1 || steps[0].TraceID != kept {
+		t.Errorf("Expected one step in the kept trace, got %+v", steps)
+	}
+	if traces := recorder.Traces(); len(traces) != 1 || traces[0].ID != kept {
+		t.Errorf("Expected only the kept trace recorded, got %+v", traces)
+	}
+}
+
+// Test that Logger tags log lines with the IDs of the enclosing observation
+func TestLogger(t *testing.T) {
+	recorder := NewObserverRecorder()
+	var out strings.Builder
+	client := recorder.Client().WithLogger(slog.New(slog.NewJSONHandler(&out, nil)))
+
+	// Replacing the logger while it is in use is safe
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client.WithLogger(client.Logger(context.Background()))
+	}()
+	client.Logger(context.Background()).Info("outside any trace")
+	wg.Wait()
+
+	fetch := recorder.Observer(WithObserveName("fetch")).Observe(func(ctx context.Context) error {
+		client.Logger(ctx).Info("fetching")
+		return nil
+	})
+	if err := fetch.(func(context.Context) error)(context.Background()); err != nil {
+		t.Fatalf("Observed call: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %q", lines)
+	}
+	var outside, inside map[string]interface{}
+	_ = json.Unmarshal([]byte(lines[0]), &outside)
+	_ = json.Unmarshal([]byte(lines[1]), &inside)
+	if _, tagged := outside[LogKeyTraceID]; tagged {
+		t.Errorf("Expected no trace ID outside an observation, got %v", outside)
+	}
+	fetched := recorder.ObservationsNamed("fetch")
+	if len(fetched) != 1 || inside[LogKeyTraceID] != fetched[0].TraceID || inside[LogKeyObservationID] != fetched[0].ID {
+		t.Errorf("Expected the IDs of the fetch observation, got %v", inside)
+	}
+}
+
+// Test that slog records become events of the observation in their context,
+// and are ignored without one or below the client's minimum level
+func TestSlogHandler(t *testing.T) {
+	recorder := NewObserverRecorder()
+	recorder.Client().WithMinLevel(model.ObservationLevelDefault)
+	logger := slog.New(NewSlogHandler(recorder.Client())).With("service", "search").WithGroup("request")
+
+	logger.Info("outside any trace")
+	fetch := recorder.Observer(WithObserveName("fetch")).Observe(func(ctx context.Context) error {
+		logger.DebugContext(ctx, "cache lookup")
+		logger.WarnContext(ctx, "slow query", "rows", 3, slog.Group("db", "table", "docs"), "err", errors.New("timeout"))
+		return nil
+	})
+	if err := fetch.(func(context.Context) error)(context.Background()); err != nil {
+		t.Fatalf("Observed call: %v", err)
+	}
+
+	logs := recorder.ObservationsNamed("slow query")
+	if len(logs) != 1 || len(recorder.ObservationsNamed("cache lookup")) != 0 || len(recorder.ObservationsNamed("outside any trace")) != 0 {
+		t.Fatalf("Expected only the warning recorded, got %+v", recorder.Observations())
+	}
+	event := logs[0]
+	fetched := recorder.ObservationsNamed("fetch")
+	if event.Type != "event" || event.Level != model.ObservationLevelWarning || len(fetched) != 1 || event.ParentObservationID != fetched[0].ID {
+		t.Errorf("Expected a warning event nested under the observation, got %+v", event)
+	}
+	metadata, _ := json.Marshal(event.Metadata)
+	if want := `{"request":{"db":{"table":"docs"},"err":"timeout","rows":3},"service":"search"}`; string(metadata) != want {
+		t.Errorf("Expected metadata %s, got %s", want, metadata)
+	}
+}
+
+// lookupFailure fails with an error recording where it occurred
+func lookupFailure() error {
+	return ErrorWithStack(errors.New("not found"))
+}
+
+// panicInStep panics in a frame that stack traces of the panic should show
+func panicInStep() {
+	panic("step failed")
+}
+
+// Test that stack traces locate errors and panics of observed calls
+func TestCaptureStackTrace(t *testing.T) {
+	stackOf := func(t *testing.T, recorder *ObserverRecorder, name string) string {
+		t.Helper()
+		for _, obs := range recorder.ObservationsNamed(name) {
+			metadata, _ := obs.Metadata.(map[string]interface{})
+			if trace, captured := metadata[metadataKeyStackTrace].(string); captured {
+				return trace
+			}
+		}
+		t.Fatalf("Expected a stack trace on %s, got %+v", name, recorder.ObservationsNamed(name))
+		return ""
+	}
+	const pkg = "github.com/paulnegz/langfuse-go."
+
+	t.Run("Error carrying its stack", func(t *testing.T) {
+		recorder := NewObserverRecorder()
+		fn := recorder.Observer(WithObserveName("lookup"), WithCaptureStackTrace(true)).Observe(func() error {
+			return fmt.Errorf("lookup: %w", lookupFailure())
+		}).(func() error)
+		if err := fn(); err == nil || err.Error() != "lookup: not found" {
+			t.Fatalf("Expected the wrapped error, got %v", err)
+		}
+		if trace := stackOf(t, recorder, "lookup"); !strings.HasPrefix(trace, pkg+"lookupFailure\n\t") {
+			t.Errorf("Expected the stack to start at the error site, got %q", trace)
+		}
+	})
+
+	t.Run("Plain error", func(t *testing.T) {
+		recorder := NewObserverRecorder()
+		fn := recorder.Observer(WithObserveName("lookup"), WithCaptureStackTrace(true)).Observe(func() error {
+			return errors.New("not found")
+		}).(func() error)
+		_ = fn()
+		if trace := stackOf(t, recorder, "lookup"); !strings.HasPrefix(trace, pkg+"TestCaptureStackTrace.func") {
+			t.Errorf("Expected the stack to start at the observed call, got %q", trace)
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		recorder := NewObserverRecorder()
+		fn := recorder.Observer(WithObserveName("step"), WithCaptureStackTrace(true)).Observe(func() {
+			panicInStep()
+		}).(func())
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != "step failed" {
+					t.Errorf("Expected the panic to propagate, got %v", recovered)
+				}
+			}()
+			fn()
+		}()
+		if trace := stackOf(t, recorder, "step"); !strings.Contains(trace, pkg+"panicInStep\n\t") {
+			t.Errorf("Expected the stack to include the panicking frame, got %q", trace)
+		}
+	})
+
+	t.Run("End with an error", func(t *testing.T) {
+		recorder := NewObserverRecorder()
+		oc := recorder.Observer(WithCaptureStackTrace(true)).Start("manual")
+		oc.End(nil, errors.New("failed"))
+		if trace := stackOf(t, recorder, "manual"); !strings.Contains(trace, pkg+"TestCaptureStackTrace.func") {
+			t.Errorf("Expected the stack to include the caller of End, got %q", trace)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		recorder := NewObserverRecorder()
+		fn := recorder.Observer(WithObserveName("lookup")).Observe(func() error { return lookupFailure() }).(func() error)
+		_ = fn()
+		for _, obs := range recorder.ObservationsNamed("lookup") {
+			if metadata, _ := obs.Metadata.(map[string]interface{}); metadata[metadataKeyStackTrace] != nil {
+				t.Errorf("Expected no stack trace, got %v", metadata[metadataKeyStackTrace])
+			}
+		}
+	})
+}
+
+// Test that an exported trace bundles its observations, scores and media
+// references as JSON or as an HTML page
+func TestExportTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/public/traces/trace-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+			"id": "trace-1", "name": "support-chat", "timestamp": "2026-01-02T10:00:00Z",
+			"input": {"question": "Why was I charged twice?"},
+			"observations": [
+				{"id": "gen-1", "traceId": "trace-1", "type": "GENERATION", "name": "answer", "parentObservationId": "span-1",
+				 "startTime": "2026-01-02T10:00:02Z", "output": "<b>refund</b>"},
+				{"id": "span-1", "traceId": "trace-1", "type": "SPAN", "name": "retrieve", "startTime": "2026-01-02T10:00:01Z",
+				 "input": {"image": "@@@langfuseMedia:type=image/png|id=media-1|source=bytes@@@"}}
+			],
+			"scores": [{"id": "score-1", "name": "helpfulness", "value": 0.8, "observationId": "gen-1"}]
+		}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	var buf strings.Builder
+	if err := l.ExportTrace(ctx, "trace-1", &buf, ExportJSON); err != nil {
+		t.Fatalf("ExportTrace: %v", err)
+	}
+	var export TraceExport
+	if err := json.Unmarshal([]byte(buf.String()), &export); err != nil {
+		t.Fatalf("Invalid JSON export: %v", err)
+	}
+	if export.Trace.ID != "trace-1" || len(export.Trace.Observations) != 2 || len(export.Trace.Scores) != 1 || export.Host != server.URL {
+		t.Errorf("Expected the full trace in the export, got %+v", export)
+	}
+	wantMedia := []ExportedMedia{{Reference: "@@@langfuseMedia:type=image/png|id=media-1|source=bytes@@@", ObservationID: "span-1", Field: "input"}}
+	if !reflect.DeepEqual(export.Media, wantMedia) || export.MediaNote == "" {
+		t.Errorf("Expected the media reference with a note, got %+v and %q", export.Media, export.MediaNote)
+	}
+
+	buf.Reset()
+	if err := l.ExportTrace(ctx, "trace-1", &buf, ExportHTML); err != nil {
+		t.Fatalf("ExportTrace: %v", err)
+	}
+	page := buf.String()
+	retrieve, answer := strings.Index(page, "<strong>retrieve</strong>"), strings.Index(page, "<strong>answer</strong>")
+	if retrieve < 0 || answer < retrieve || !strings.Contains(page, "helpfulness") || !strings.Contains(page, `id="trace-export"`) {
+		t.Errorf("Expected the observation tree, scores and bundle in the page, got %s", page)
+	}
+	if strings.Contains(page, "<b>refund</b>") {
+		t.Error("Expected payloads to be escaped in the page")
+	}
+
+	if err := l.ExportTrace(ctx, "trace-1", &buf, "pdf"); err == nil {
+		t.Error("Expected an error for an unsupported format")
+	}
+	if err := l.ExportTrace(ctx, "missing", &buf, ExportJSON); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
+
+// Test that events arriving after EndTrace are not buffered again
+func TestTraceBufferingLateEvents(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client()
+	l.WithTraceBuffering(func(trace *BufferedTrace) bool { return trace.Errored }, 10)
+
+	dropped, _ := l.Trace(&model.Trace{Name: "dropped"})
+	if l.EndTrace(dropped.ID) {
+		t.Error("Expected the trace without errors to be discarded")
+	}
+	if _, err := l.Span(&model.Span{TraceID: dropped.ID, Name: "late"}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+
+	kept, _ := l.Trace(&model.Trace{Name: "kept"})
+	_, _ = l.Span(&model.Span{TraceID: kept.ID, Name: "failed", Level: model.ObservationLevelError}, nil)
+	if !l.EndTrace(kept.ID) {
+		t.Error("Expected the errored trace to be kept")
+	}
+	_, _ = l.Score(&model.Score{TraceID: kept.ID, Name: "late-score", Value: 1})
+
+	if buffered := len(l.buffer.Load().traces); buffered != 0 {
+		t.Errorf("Expected no buffered traces after they ended, got %d", buffered)
+	}
+	if stats := l.Stats(); stats.EventsSampledOut != 2 {
+		t.Errorf("Expected the dropped trace and its late span sampled out, got %+v", stats)
+	}
+	if scores := recorder.Scores(); len(scores) != 1 || scores[0].Name != "late-score" {
+		t.Errorf("Expected the late score of the kept trace to be sent, got %v", scores)
+	}
+	if late := recorder.ObservationsNamed("late"); len(late) != 0 {
+		t.Errorf("Expected the late span of the dropped trace to be dropped, got %v", late)
+	}
+}
+
+// Test that a trace evicted from a full buffer is sent with its later events
+func TestTraceBufferingEviction(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client()
+	l.WithTraceBuffering(func(trace *BufferedTrace) bool { return trace.Errored }, 1)
+
+	evicted, _ := l.Trace(&model.Trace{Name: "evicted"})
+	start := l.Now()
+	span, _ := l.Span(&model.Span{TraceID: evicted.ID, Name: "work", StartTime: &start}, nil)
+	if _, err := l.Trace(&model.Trace{Name: "next"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if got := len(recorder.Traces()); got != 1 {
+		t.Fatalf("Expected the oldest trace to be sent when the buffer is full, got %d traces", got)
+	}
+
+	// The end update would be sampled out on its own, as the trace has no errors
+	end := l.Now()
+	if _, err := l.SpanEnd(&model.Span{ID: span.ID, TraceID: evicted.ID, EndTime: &end}); err != nil {
+		t.Fatalf("SpanEnd: %v", err)
+	}
+	if !l.EndTrace(evicted.ID) {
+		t.Error("Expected EndTrace to report the evicted trace as sent")
+	}
+	if ended := recorder.ObservationsNamed("work"); len(ended) != 1 || ended[0].EndTime == nil {
+		t.Errorf("Expected the span end of the evicted trace to be sent, got %v", ended)
+	}
+	if stats := l.Stats(); stats.EventsSampledOut != 0 {
+		t.Errorf("Expected no events of the evicted trace sampled out, got %+v", stats)
+	}
+}
+
+// Test that waiting for a request slot stops when the context is done
+func TestRequestLimiterCancel(t *testing.T) {
+	limiter := newRequestLimiter(1)
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- limiter.acquire(ctx)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrIngestionCanceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected a canceled acquire, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire did not return after its context was canceled")
+	}
+	if active := limiter.inFlight(); active != 1 {
+		t.Errorf("Expected the canceled acquire to take no slot, got %d in flight", active)
+	}
+
+	// The canceled waiter no longer counts as pending
+	limiter.release()
+	idle := make(chan struct{})
+	go func() {
+		limiter.waitIdle()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitIdle blocked on the canceled acquire")
+	}
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Errorf("Expected the released slot to be available, got %v", err)
+	}
+}
+
+// Test that ScoreSync reports errors through its return value only and
+// follows buffered and recorded traces
+func TestScoreSync(t *testing.T) {
+	var reject atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reject.Load() {
+			_, _ = w.Write([]byte(`{"successes":[],"errors":[{"id":"event-1","status":400,"message":"invalid value"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	var handled atomic.Int32
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithErrorHandler(func(error) { handled.Add(1) })
+
+	if _, err := l.ScoreSync(ctx, &model.Score{TraceID: "trace-1", Name: "quality", Value: 1}); err != nil {
+		t.Fatalf("ScoreSync: %v", err)
+	}
+	reject.Store(true)
+	if _, err := l.ScoreSync(ctx, &model.Score{TraceID: "trace-1", Name: "quality", Value: 1}); err == nil || !strings.Contains(err.Error(), "invalid value") {
+		t.Errorf("Expected the rejection to be returned, got %v", err)
+	}
+	if got := handled.Load(); got != 0 {
+		t.Errorf("Expected the error handler not to be called, got %d calls", got)
+	}
+	if result := l.FlushWithResult(ctx); len(result.Errors) != 0 {
+		t.Errorf("Expected no flush errors, got %v", result.Errors)
+	}
+	if stats := l.Stats(); stats.EventsSent != 1 || stats.EventsFailed != 1 {
+		t.Errorf("Expected 1 sent and 1 failed score, got %+v", stats)
+	}
+
+	recorder := NewObserverRecorder()
+	buffered := recorder.Client().WithTraceBuffering(func(*BufferedTrace) bool { return true }, 10)
+	trace, _ := buffered.Trace(&model.Trace{Name: "buffered"})
+	if _, err := buffered.ScoreSync(ctx, &model.Score{TraceID: trace.ID, Name: "quality", Value: 1}); err != nil {
+		t.Fatalf("ScoreSync: %v", err)
+	}
+	if scores := recorder.Scores(); len(scores) != 0 {
+		t.Errorf("Expected the score to wait for its buffered trace, got %v", scores)
+	}
+	buffered.EndTrace(trace.ID)
+	if scores := recorder.Scores(); len(scores) != 1 {
+		t.Errorf("Expected the score to be sent with its trace, got %v", scores)
+	}
+}
+
+// Test that a request waiting for a slot fails when its context is done
+func TestScoreSyncCanceledWhileWaitingForSlot(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	l := NewWithConfig(context.Background(), Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithMaxConcurrentRequests(1)
+	// Hold the only slot so the score has to wait
+	if err := l.limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer l.limiter.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := l.ScoreSync(ctx, &model.Score{TraceID: "trace-1", Name: "quality", Value: 1})
+	if !errors.Is(err, ErrIngestionCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the score to be canceled, got %v", err)
+	}
+	if got := requests.Load(); got != 0 {
+		t.Errorf("Expected no request to be sent, got %d", got)
+	}
+}
+
+// Test that batches and created traces carry the schema version and fetched
+// traces are unstamped
+func TestSchemaVersion(t *testing.T) {
+	var mu sync.Mutex
+	var stamped []interface{}
+	var batchVersion interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			if strings.HasSuffix(r.URL.Path, "/legacy") {
+				_, _ = w.Write([]byte(`{"id":"legacy","metadata":{"team":"search"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"current","metadata":{"_sdk_schema_version":1}}`))
+			return
+		}
+		var req struct {
+			Batch []struct {
+				Body struct {
+					Metadata map[string]interface{} `json:"metadata"`
+				} `json:"body"`
+			} `json:"batch"`
+			Metadata map[string]interface{} `json:"metadata"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		for _, event := range req.Batch {
+			stamped = append(stamped, event.Body.Metadata[metadataKeySchemaVersion])
+		}
+		batchVersion = req.Metadata["sdk_schema_version"]
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	trace, err := l.Trace(&model.Trace{Name: "checkout"})
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if _, err := l.Trace(&model.Trace{ID: trace.ID, Metadata: map[string]interface{}{"team": "search"}}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	mu.Lock()
+	if len(stamped) != 2 || stamped[0] != float64(SchemaVersion) || stamped[1] != nil {
+		t.Errorf("Expected only the trace create to be stamped with version %d, got %v", SchemaVersion, stamped)
+	}
+	if batchVersion != float64(SchemaVersion) {
+		t.Errorf("Expected the batch metadata to carry version %d, got %v", SchemaVersion, batchVersion)
+	}
+	mu.Unlock()
+
+	current, err := l.getTrace(ctx, "current")
+	if err != nil {
+		t.Fatalf("getTrace: %v", err)
+	}
+	if current.SchemaVersion != SchemaVersion || current.Metadata != nil {
+		t.Errorf("Expected version %d with the key removed, got %d and %v", SchemaVersion, current.SchemaVersion, current.Metadata)
+	}
+	legacy, err := l.getTrace(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("getTrace: %v", err)
+	}
+	if legacy.SchemaVersion != 0 || legacy.Metadata.(map[string]interface{})["team"] != "search" {
+		t.Errorf("Expected an unversioned trace with its metadata, got %d and %v", legacy.SchemaVersion, legacy.Metadata)
+	}
+}
+
+// Test that attributes set concurrently are merged into the metadata at End
+func TestObserveContextAttributes(t *testing.T) {
+	var mu sync.Mutex
+	var ended map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Batch []struct {
+				Type string `json:"type"`
+				Body struct {
+					Metadata map[string]interface{} `json:"metadata"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		for _, event := range req.Batch {
+			if event.Type == string(model.IngestionEventTypeSpanUpdate) {
+				ended = event.Body.Metadata
+			}
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	oc := NewObserver(l).Start("route")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			oc.SetAttribute(fmt.Sprintf("worker_%d", i), i)
+		}(i)
+	}
+	wg.Wait()
+	oc.SetAttribute("region", "eu")
+	oc.SetAttribute("region", "us")
+	oc.End(nil, nil)
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if ended["region"] != "us" || ended["worker_9"] != float64(9) || ended["duration_ms"] == nil {
+		t.Errorf("Expected the attributes next to the duration, got %v", ended)
+	}
+}
+
+// Test that Must panics on errors and LogError carries on
+func TestMustAndLogError(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	trace := Must(l.Trace(&model.Trace{Name: "script"}))
+	if trace.ID == "" {
+		t.Error("Expected Must to return the trace")
+	}
+	if span := LogError(l.SpanEnd(&model.Span{ID: "span-1"})); span != nil {
+		t.Errorf("Expected a nil result for a failed call, got %+v", span)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Must to panic on an error")
+		}
+	}()
+	Must(l.SpanEnd(&model.Span{ID: "span-1"}))
+}
+
+// Test that capture limits cut deep values and large collections with markers
+func TestCaptureLimits(t *testing.T) {
+	observer := NewObserver(nil, WithMaxCaptureDepth(2), WithMaxCaptureElements(3))
+
+	captured := observer.reflectValueToInterface(reflect.ValueOf(make([]int, 1000000)))
+	if fmt.Sprint(captured) != "[0 0 0 <999997 more elements>]" {
+		t.Errorf("Expected the slice to be cut to 3 elements, got %v", captured)
+	}
+
+	m := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+	capturedMap := observer.reflectValueToInterface(reflect.ValueOf(m)).(map[string]interface{})
+	if len(capturedMap) != 4 || capturedMap["a"] != int64(1) || capturedMap["..."] != "<2 more elements>" {
+		t.Errorf("Expected the lowest 3 keys and a marker, got %v", capturedMap)
+	}
+
+	nested := map[string]interface{}{"l1": map[string]interface{}{"l2": map[string]interface{}{"l3": 1}}}
+	capturedNested := observer.reflectValueToInterface(reflect.ValueOf(nested)).(map[string]interface{})
+	if l2, replaced := capturedNested["l1"].(map[string]interface{})["l2"].(string); !replaced {
+		t.Errorf("Expected values below the depth limit to be replaced, got %v", l2)
+	}
+
+	if unlimited := NewObserver(nil).reflectValueToInterface(reflect.ValueOf(make([]int, 100))); len(unlimited.([]interface{})) != 100 {
+		t.Error("Expected collections to be captured whole by default")
+	}
+}
+
+// Test that annotating a trace twice upserts its note and scores
+func TestAnnotate(t *testing.T) {
+	var mu sync.Mutex
+	var comments []interface{}
+	scoreIDs := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Batch []struct {
+				Type string `json:"type"`
+				Body struct {
+					ID       string                 `json:"id"`
+					Metadata map[string]interface{} `json:"metadata"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		for _, event := range req.Batch {
+			switch event.Type {
+			case string(model.IngestionEventTypeTraceCreate):
+				comments = append(comments, event.Body.Metadata[metadataKeyComment])
+			case string(model.IngestionEventTypeScoreCreate):
+				scoreIDs[event.Body.ID]++
+			}
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	for _, note := range []string{"wrong refund policy", "escalated to billing"} {
+		err := l.Annotate("trace-1", note, []*model.Score{
+			{Name: "correctness", Value: 0},
+			{Name: "helpfulness", Value: 0.5, ObservationID: "answer"},
+		})
+		if err != nil {
+			t.Fatalf("Annotate: %v", err)
+		}
+	}
+	if err := l.Annotate("trace-1", "", []*model.Score{{TraceID: "trace-2", Name: "correctness"}}); err == nil {
+		t.Error("Expected a score of another trace to be rejected")
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(comments) != "[wrong refund policy escalated to billing]" {
+		t.Errorf("Expected each note to update the trace, got %v", comments)
+	}
+	if len(scoreIDs) != 2 {
+		t.Errorf("Expected repeated annotations to reuse two score IDs, got %v", scoreIDs)
+	}
+}
+
+// Test that keys are read from files named in the config
+func TestKeyFiles(t *testing.T) {
+	var user, pass atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, _ := r.BasicAuth()
+		user.Store(u)
+		pass.Store(p)
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	publicKeyFile := filepath.Join(dir, "public_key")
+	secretKeyFile := filepath.Join(dir, "secret_key")
+	if err := os.WriteFile(publicKeyFile, []byte("pk-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secretKeyFile, []byte("  sk-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKeyFile: publicKeyFile, SecretKeyFile: secretKeyFile, FlushInterval: time.Hour})
+	if _, err := l.Trace(&model.Trace{Name: "checkout"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if user.Load() != "pk-file" || pass.Load() != "sk-file" {
+		t.Errorf("Expected keys from files, got %v:%v", user.Load(), pass.Load())
+	}
+
+	// A missing file keeps the current key
+	l.WithKeyFiles(filepath.Join(dir, "missing"), "")
+	if _, err := l.Trace(&model.Trace{Name: "checkout"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if user.Load() != "pk-file" {
+		t.Errorf("Expected the public key to be kept, got %v", user.Load())
+	}
+}
+
+// Test that Stats counts queued, sent and sampled-out events
+func TestStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := l.Trace(&model.Trace{Name: name}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+	}
+	if stats := l.Stats(); stats.EventsEnqueued != 2 || stats.QueueDepth != 2 {
+		t.Errorf("Before flush: got %+v", stats)
+	}
+
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	stats := l.Stats()
+	if stats.EventsSent != 2 || stats.Batches != 1 || stats.QueueDepth != 0 || stats.EventsFailed != 0 {
+		t.Errorf("After flush: got %+v", stats)
+	}
+
+	l.WithTraceBuffering(func(*BufferedTrace) bool { return false }, 10)
+	trace, _ := l.Trace(&model.Trace{Name: "sampled"})
+	l.EndTrace(trace.ID)
+	if stats := l.Stats(); stats.EventsSampledOut != 1 || stats.EventsEnqueued != 2 {
+		t.Errorf("After sampling: got %+v", stats)
+	}
+}
+
+// Test that batches rejected as too large are split and oversized events dropped
+func TestPayloadTooLarge(t *testing.T) {
+	var mu sync.Mutex
+	var accepted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Batch []struct {
+				Body struct {
+					Name string `json:"name"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		tooLarge := len(batch.Batch) > 2
+		for _, event := range batch.Batch {
+			tooLarge = tooLarge || event.Body.Name == "huge"
+		}
+		if tooLarge {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		mu.Lock()
+		for _, event := range batch.Batch {
+			accepted = append(accepted, event.Body.Name)
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	for _, name := range []string{"a", "b", "huge", "c", "d"} {
+		if _, err := l.Trace(&model.Trace{Name: name}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+	}
+
+	result := l.FlushWithResult(ctx)
+	if result.Sent != 4 || result.Failed != 1 || len(result.Errors) != 1 || !errors.Is(result.Errors[0], ErrPayloadTooLarge) {
+		t.Errorf("Expected four events sent and one dropped as too large, got %+v", result)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(accepted, ",") != "a,b,c,d" {
+		t.Errorf("Expected the other events to be sent in order, got %v", accepted)
+	}
+	if stats := l.Stats(); stats.EventsDropped != 1 || stats.EventsFailed != 1 || stats.EventsSent != 4 {
+		t.Errorf("Expected one dropped event in stats, got %+v", stats)
+	}
+}
+
+// Test that concurrent calls through a shared observer join one trace; run with -race
+func TestObserverConcurrentCalls(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	var ref ObservationRef
+	observer := NewObserver(l, WithObservationRef(&ref))
+	const calls = 20
+	traceIDs := make(chan string, 2*calls)
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			// Wrapping concurrently derives each function's name without touching the observer
+			fn := observer.Observe(func(ctx context.Context) error {
+				traceID, _ := TraceIDFromContext(ctx)
+				traceIDs <- traceID
+				return nil
+			}).(func(context.Context) error)
+			_ = fn(context.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			oc := observer.Start("manual")
+			oc.End(nil, nil)
+			traceIDs <- observer.TraceID()
+		}()
+	}
+	wg.Wait()
+	close(traceIDs)
+
+	shared := observer.TraceID()
+	if shared == "" {
+		t.Fatal("Expected the observer to record into a trace")
+	}
+	for traceID := range traceIDs {
+		if traceID != shared {
+			t.Errorf("Expected every call to join trace %s, got %s", shared, traceID)
+		}
+	}
+	// One trace, plus a start and an end event per call
+	if enqueued := l.Stats().EventsEnqueued; enqueued != 1+2*2*calls {
+		t.Errorf("Expected a single trace to be created, got %d events", enqueued)
+	}
+}
+
+// Test that observations can be backfilled with explicit timestamps
+func TestObserverBackfill(t *testing.T) {
+	var mu sync.Mutex
+	type received struct {
+		ID        string     `json:"id"`
+		Name      string     `json:"name"`
+		StartTime *time.Time `json:"startTime"`
+		EndTime   *time.Time `json:"endTime"`
+	}
+	var bodies []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Batch []struct {
+				Type string   `json:"type"`
+				Body received `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		for _, event := range batch.Batch {
+			if event.Type != string(model.IngestionEventTypeTraceCreate) {
+				bodies = append(bodies, event.Body)
+			}
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithStrictValidation(true)
+	observer := NewObserver(l, WithObserveNameSanitizer(nil))
+
+	start := time.Date(2023, 3, 1, 9, 0, 0, 0, time.UTC)
+	oc := observer.StartAt("import", start)
+	child := oc.ChildAt("step", ObservationTypeSpan, start.Add(time.Second))
+	child.EndAt(nil, nil, start.Add(2*time.Second))
+	// Ends before the start are rejected under strict validation
+	oc.EndAt(nil, nil, start.Add(-time.Minute))
+	oc.EndAt(nil, nil, start.Add(3*time.Second))
+	if err := l.FlushSync(ctx); err != nil {
+		t.Fatalf("FlushSync: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []struct {
+		name       string
+		start, end time.Time
+	}{
+		{"import", start, time.Time{}},
+		{"step", start.Add(time.Second), time.Time{}},
+		{"step", start.Add(time.Second), start.Add(2 * time.Second)},
+		{"import", start, start.Add(3 * time.Second)},
+	}
+	if len(bodies) != len(want) {
+		t.Fatalf("Expected %d observation events, got %+v", len(want), bodies)
+	}
+	for i, body := range bodies {
+		var end time.Time
+		if body.EndTime != nil {
+			end = *body.EndTime
+		}
+		if body.Name != want[i].name || body.StartTime == nil || !body.StartTime.Equal(want[i].start) || !end.Equal(want[i].end) {
+			t.Errorf("Event %d: got %s %v-%v, want %+v", i, body.Name, body.StartTime, body.EndTime, want[i])
+		}
+	}
+
+	if _, err := l.Span(&model.Span{TraceID: "trace-1", Name: "unset", StartTime: &time.Time{}}, nil); err == nil {
+		t.Error("Expected a zero start time to be rejected under strict validation")
+	}
+}
+
+// Test that observation tags are merged into metadata like AddTags merges them
+func TestObservationTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata any
+		tags     []string
+		want     any
+	}{
+		{name: "No tags", metadata: map[string]interface{}{"k": "v"}, want: map[string]interface{}{"k": "v"}},
+		{name: "New metadata", tags: []string{"a", "b"}, want: map[string]interface{}{"tags": []string{"a", "b"}}},
+		{
+			name:     "Merged with stored tags",
+			metadata: map[string]interface{}{"tags": []interface{}{"a"}},
+			tags:     []string{"b", "a"},
+			want:     map[string]interface{}{"tags": []string{"a", "b"}},
+		},
+		{name: "Empty tags skipped", tags: []string{"", "a"}, want: map[string]interface{}{"tags": []string{"a"}}},
+		{name: "Only empty tags", tags: []string{""}, want: nil},
+		{name: "Metadata of another shape", metadata: "note", tags: []string{"a"}, want: "note"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withObservationTags(tt.metadata, tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// Test that trace contexts round-trip through their header value
+func TestTraceContext(t *testing.T) {
+	for _, tc := range []TraceContext{{TraceID: "trace-1"}, {TraceID: "trace-1", ParentObservationID: "span-1"}} {
+		parsed, err := ParseTraceContext(tc.String())
+		if err != nil || parsed != tc {
+			t.Errorf("Round trip of %+v: got %+v and %v", tc, parsed, err)
+		}
+	}
+
+	for _, header := range []string{"", " ", ";span-1", "a;b;c"} {
+		if _, err := ParseTraceContext(header); err == nil {
+			t.Errorf("Expected %q to be rejected", header)
+		}
+	}
+}
+
+// Test that a trace handle attaches observations and scores to the continued trace
+func TestContinueTrace(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client()
+	handle := l.ContinueTraceContext(TraceContext{TraceID: "trace-1", ParentObservationID: "span-0"})
+
+	if _, err := handle.Span(&model.Span{Name: "step"}); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	if _, err := handle.Generation(&model.Generation{Name: "llm", ParentObservationID: "span-9"}); err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	if _, err := handle.WithParent("").Event(&model.Event{Name: "done"}); err != nil {
+		t.Fatalf("Event: %v", err)
+	}
+	if _, err := handle.Score(&model.Score{Name: "quality", Value: 1}); err != nil {
+		t.Fatalf("Score: %v", err)
+	}
+
+	if traces := recorder.Traces(); len(traces) != 0 {
+		t.Errorf("Expected the trace not to be recreated, got %d traces", len(traces))
+	}
+	wantParents := map[string]string{"step": "span-0", "llm": "span-9", "done": ""}
+	for name, parent := range wantParents {
+		observations := recorder.ObservationsNamed(name)
+		if len(observations) != 1 || observations[0].TraceID != "trace-1" {
+			t.Fatalf("Expected %s in trace-1, got %+v", name, observations)
+		}
+		if got := observationParent(observations[0].Body); got != parent {
+			t.Errorf("Expected %s under %q, got %q", name, parent, got)
+		}
+	}
+	if scores := recorder.Scores(); len(scores) != 1 || scores[0].TraceID != "trace-1" {
+		t.Errorf("Expected a score of trace-1, got %+v", scores)
+	}
+	if tc := handle.Context("span-2"); tc.TraceID != "trace-1" || tc.ParentObservationID != "span-2" {
+		t.Errorf("Expected the context of span-2, got %+v", tc)
+	}
+	if tc := handle.Context(""); tc.ParentObservationID != "span-0" {
+		t.Errorf("Expected the context of the handle's parent, got %+v", tc)
+	}
+
+	empty := l.ContinueTrace("")
+	if _, err := empty.Span(&model.Span{Name: "orphan"}); err == nil {
+		t.Error("Expected Span to require a trace ID")
+	}
+	if _, err := empty.Generation(&model.Generation{Name: "orphan"}); err == nil {
+		t.Error("Expected Generation to require a trace ID")
+	}
+	if _, err := empty.Event(&model.Event{Name: "orphan"}); err == nil {
+		t.Error("Expected Event to require a trace ID")
+	}
+	if _, err := empty.Score(&model.Score{Name: "orphan", Value: 1}); err == nil {
+		t.Error("Expected Score to require a trace ID")
+	}
+}
+
+// observationParent returns the parent observation ID of a recorded body
+func observationParent(body interface{}) string {
+	switch b := body.(type) {
+	case *model.Span:
+		return b.ParentObservationID
+	case *model.Generation:
+		return b.ParentObservationID
+	case *model.Event:
+		return b.ParentObservationID
+	}
+	return ""
+}
+
+// Test that the HTTP middleware traces requests and continues incoming traces
+func TestHTTPMiddleware(t *testing.T) {
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+
+	var inner string
+	handler := HTTPMiddleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner, _ = TraceIDFromContext(r.Context())
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chat", nil))
+	traceID := rec.Header().Get(TraceIDHeader)
+	if traceID == "" || inner != traceID || rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the handler to run in the response's trace, got %q (inside %q), status %d", traceID, inner, rec.Code)
+	}
+	// The trace, the request span's start and end, and the trace's status
+	if enqueued := l.Stats().EventsEnqueued; enqueued != 4 {
+		t.Errorf("Expected a new trace and request span, got %d events", enqueued)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	req.Header.Set(TraceContextHeader, TraceContext{TraceID: "upstream", ParentObservationID: "call"}.String())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get(TraceIDHeader) != "upstream" || inner != "upstream" {
+		t.Errorf("Expected the request to continue the upstream trace, got %q", rec.Header().Get(TraceIDHeader))
+	}
+	if enqueued := l.Stats().EventsEnqueued; enqueued != 6 {
+		t.Errorf("Expected only a request span in the continued trace, got %d events", enqueued-4)
+	}
+
+	healthz := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	skipped := HTTPMiddleware(l, WithMiddlewareSkip(func(r *http.Request) bool { return r.URL.Path == "/healthz" }))(healthz)
+	rec = httptest.NewRecorder()
+	skipped.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if enqueued := l.Stats().EventsEnqueued; enqueued != 6 || rec.Header().Get(TraceIDHeader) != "" {
+		t.Errorf("Expected a skipped request to be untraced, got %d events", enqueued-6)
+	}
+}
+
+// Test that W3C traceparent headers map to Langfuse trace IDs and back
+func TestTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, err := ParseTraceparent(header)
+	if err != nil {
+		t.Fatalf("ParseTraceparent: %v", err)
+	}
+	if tc.TraceID != "4bf92f35-77b3-4da6-a3ce-929d0e0e4736" || tc.ParentObservationID != "" {
+		t.Errorf("Expected the trace ID in UUID form, got %+v", tc)
+	}
+	if got := tc.Traceparent(); !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || len(got) != len(header) {
+		t.Errorf("Expected the trace ID to round-trip, got %q", got)
+	}
+	if a, b := (TraceContext{TraceID: "checkout-1"}).Traceparent(), (TraceContext{TraceID: "checkout-1"}).Traceparent(); a != b {
+		t.Errorf("Expected non-UUID trace IDs to map deterministically, got %q and %q", a, b)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	handler := HTTPMiddleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/chat", nil)
+	req.Header.Set(TraceparentHeader, header)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get(TraceIDHeader); got != tc.TraceID {
+		t.Errorf("Expected the request to join the distributed trace, got %q", got)
+	}
+}
+
+// Test that Shutdown sends pending events and removes the signal handler
+func TestShutdown(t *testing.T) {
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		received.Add(int64(len(batch.Batch)))
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).WithFlushOnSignal()
+	if _, err := l.Trace(&model.Trace{Name: "last"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+
+	if err := l.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if received.Load() != 1 {
+		t.Errorf("Expected the pending event to be sent, got %d", received.Load())
+	}
+	if l.signals.stop != nil {
+		t.Error("Shutdown should remove the signal handler")
+	}
+}
+
+// Test that oversized payloads are replaced by media references
+func TestMaxInlineSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk"}).WithMaxInlineSize(16)
+
+	span, err := l.Span(&model.Span{
+		TraceID: "trace-1",
+		Name:    "ocr",
+		Input: map[string]interface{}{
+			"document": "a document much longer than sixteen bytes",
+			"page":     "1",
+		},
+		Output: []byte("\x89PNG\r\n\x1a\n binary image data"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+
+	input := span.Input.(map[string]interface{})
+	if ref, _ := input["document"].(string); !IsMediaReference(ref) {
+		t.Errorf("Expected the document to be offloaded, got %v", input["document"])
+	}
+	if input["page"] != "1" {
+		t.Errorf("Small values should stay inline, got %v", input["page"])
+	}
+	if ref, _ := span.Output.(string); !IsMediaReference(ref) {
+		t.Errorf("Expected the image to be offloaded, got %v", span.Output)
+	}
+
+	inline, _ := l.Span(&model.Span{TraceID: "trace-1", Name: "inline", Input: "a document much longer than sixteen bytes"}, nil, WithCallMaxInlineSize(0))
+	if inline.Input != "a document much longer than sixteen bytes" {
+		t.Errorf("Expected the call to keep its payload inline, got %v", inline.Input)
+	}
+	plain := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk"})
+	offloaded, _ := plain.Span(&model.Span{TraceID: "trace-1", Name: "offloaded", Input: "a document much longer than sixteen bytes"}, nil, WithCallMaxInlineSize(16))
+	if ref, _ := offloaded.Input.(string); !IsMediaReference(ref) {
+		t.Errorf("Expected the call to offload its payload, got %v", offloaded.Input)
+	}
+	_ = l.Flush(ctx)
+	_ = plain.Flush(ctx)
+}
+
+// Test that reused media content is associated with every trace field showing it
+func TestMediaDedupAssociations(t *testing.T) {
+	var mu sync.Mutex
+	var associations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			TraceID       string `json:"traceId"`
+			ObservationID string `json:"observationId"`
+			Field         string `json:"field"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		associations = append(associations, req.TraceID+"/"+req.ObservationID+"/"+req.Field)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"mediaId":"media-1"}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	uploader := NewMediaUploader(l, 1)
+	defer uploader.Shutdown()
+
+	uploads := []struct {
+		traceID, spanID, field string
+	}{
+		{"trace-1", "", MediaFieldInput},
+		{"trace-2", "span-1", MediaFieldOutput},
+		{"trace-2", "span-1", MediaFieldOutput},
+	}
+	for i, upload := range uploads {
+		media := NewMediaFromBytes([]byte("image"), "image/png", "a.png")
+		refID, err := uploader.UploadToField(media, upload.traceID, upload.spanID, upload.field)
+		if err != nil {
+			t.Fatalf("UploadToField: %v", err)
+		}
+		if err := uploader.Drain(ctx); err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+		if i > 0 && refID != "media-1" {
+			t.Errorf("Upload %d: expected the cached reference, got %q", i, refID)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"trace-1//input", "trace-2/span-1/output"}
+	if len(associations) != len(want) {
+		t.Fatalf("Expected associations %v, got %v", want, associations)
+	}
+	for i := range want {
+		if associations[i] != want[i] {
+			t.Errorf("Association %d: got %q, want %q", i, associations[i], want[i])
+		}
+	}
+}
+
+// Test that Drain waits for queued uploads without shutting the uploader down
+func TestMediaUploaderDrain(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = w.Write([]byte(`{"mediaId":"media-1"}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	uploader := NewMediaUploader(l, 1)
+	defer uploader.Shutdown()
+
+	if err := uploader.Drain(ctx); err != nil {
+		t.Errorf("Expected an idle uploader to drain at once, got %v", err)
+	}
+
+	media := NewMediaFromBytes([]byte("image"), "image/png", "a.png")
+	if _, err := uploader.Upload(media, "trace-1", ""); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := uploader.Drain(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Drain to stop at the deadline, got %v", err)
+	}
+
+	close(release)
+	if err := uploader.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if status := uploader.GetStatus(media.ID); status.Status != "completed" {
+		t.Errorf("Expected the upload to be settled after Drain, got %+v", status)
+	}
+
+	second := NewMediaFromBytes([]byte("another image"), "image/png", "b.png")
+	if _, err := uploader.Upload(second, "trace-1", ""); err != nil {
+		t.Fatalf("Expected the uploader to accept uploads after Drain, got %v", err)
+	}
+	if err := uploader.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+}
+
+// Test that media hosted elsewhere is referenced by URL without being uploaded
+func TestMediaFromURL(t *testing.T) {
+	if _, err := NewMediaFromURL("file:///etc/passwd", ""); err == nil {
+		t.Error("Expected a non-HTTP URL to be rejected")
+	}
+	media, err := NewMediaFromURL("https://cdn.example.com/images/cat.png", "")
+	if err != nil {
+		t.Fatalf("NewMediaFromURL: %v", err)
+	}
+	if media.ContentType != "image/png" || media.ToReferenceString() != "https://cdn.example.com/images/cat.png" {
+		t.Errorf("Expected a PNG referenced by its URL, got %+v", media)
+	}
+
+	l := NewWithConfig(context.Background(), Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	processor := NewMediaProcessor(l.MediaUploader())
+	processed := processor.ProcessInput(map[string]interface{}{"image": media}, "trace-1")
+	if processed.(map[string]interface{})["image"] != media.URL {
+		t.Errorf("Expected the processor to pass the URL through, got %v", processed)
+	}
+	if l.Stats().UploadsInFlight != 0 {
+		t.Error("Expected no upload for media hosted elsewhere")
+	}
+}
+
+// Test that observed calls expose their trace ID and join traces from the context
+func TestObserverTraceID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk"})
+
+	var ref ObservationRef
+	if _, err := ObserveWithResult(l, func() (int, error) { return 1, nil }, WithObservationRef(&ref)); err != nil {
+		t.Fatalf("ObserveWithResult: %v", err)
+	}
+	if ref.TraceID == "" || ref.ObservationID == "" {
+		t.Errorf("Expected the trace and observation IDs, got %+v", ref)
+	}
+
+	observer := NewObserver(l, WithObserveName("handler"), WithObservationRef(&ref))
+	var inner string
+	fn := observer.Observe(func(ctx context.Context) error {
+		inner, _ = TraceIDFromContext(ctx)
+		return nil
+	}).(func(context.Context) error)
+
+	if err := fn(ContextWithTraceID(ctx, "request-trace")); err != nil {
+		t.Fatalf("Observed call: %v", err)
+	}
+	if ref.TraceID != "request-trace" || inner != "request-trace" {
+		t.Errorf("Expected the call to join request-trace, got %q (inside %q)", ref.TraceID, inner)
+	}
+	if observer.TraceID() != "" {
+		t.Errorf("Joining a context trace should not bind the observer, got %q", observer.TraceID())
+	}
+	_ = l.Flush(ctx)
+}
+
+// Test that asynchronous flushes return before delivery and Shutdown still drains
+func TestAsyncFlush(t *testing.T) {
+	release := make(chan struct{})
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var batch struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		received.Add(int64(len(batch.Batch)))
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).WithAsyncFlush(true)
+	if _, err := l.Trace(&model.Trace{Name: "request"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- l.Flush(ctx) }()
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Flush blocked on delivery")
+	}
+
+	close(release)
+	if err := l.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if received.Load() != 1 {
+		t.Errorf("Expected the event to be delivered by shutdown, got %d", received.Load())
+	}
+}
+
+// Test that resumed evaluations skip items whose run already succeeded
+func TestDatasetEvaluatorResume(t *testing.T) {
+	var mu sync.Mutex
+	statuses := make(map[string]interface{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodGet {
+			id := strings.TrimPrefix(r.URL.Path, "/api/public/traces/")
+			status, found := statuses[id]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "metadata": map[string]interface{}{RunStatusKey: status}})
+			return
+		}
+
+		var batch struct {
+			Batch []struct {
+				Type string `json:"type"`
+				Body struct {
+					ID       string                 `json:"id"`
+					Metadata map[string]interface{} `json:"metadata"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		for _, event := range batch.Batch {
+			if status, recorded := event.Body.Metadata[RunStatusKey]; event.Type == "trace-create" && recorded {
+				statuses[event.Body.ID] = status
+			}
+		}
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	dataset := &Dataset{ID: "dataset-1", Name: "qa", client: l}
+	for _, input := range []string{"a", "b"} {
+		item, _ := dataset.CreateItem(input, nil, nil)
+		item.ID = "item-" + input
+	}
+
+	evaluate := func(failing string) []string {
+		var ran []string
+		_, err := NewDatasetEvaluator(dataset, nil).WithResume(true).Evaluate(ctx, func(input interface{}) (interface{}, error) {
+			ran = append(ran, input.(string))
+			if input == failing {
+				return nil, errors.New("timeout")
+			}
+			return input, nil
+		})
+		if err != nil {
+			t.Fatalf("Evaluate: %v", err)
+		}
+		if err := l.FlushSync(ctx); err != nil {
+			t.Fatalf("FlushSync: %v", err)
+		}
+		return ran
+	}
+
+	if ran := evaluate("b"); len(ran) != 2 {
+		t.Fatalf("Expected both items to run first, got %v", ran)
+	}
+	if ran := evaluate(""); len(ran) != 1 || ran[0] != "b" {
+		t.Errorf("Expected only the failed item to run again, got %v", ran)
+	}
+	if ran := evaluate(""); len(ran) != 0 {
+		t.Errorf("Expected a completed evaluation to run nothing, got %v", ran)
+	}
+}
+
+// Test that observed arguments with unexported and unsupported fields are captured as JSON
+func TestObserverCaptureUnsupportedFields(t *testing.T) {
+	var mu sync.Mutex
+	var inputs []json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Batch []struct {
+				Body struct {
+					Input json.RawMessage `json:"input"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("Failed to decode batch: %v", err)
+		}
+		mu.Lock()
+		for _, event := range batch.Batch {
+			if len(event.Body.Input) > 0 {
+				inputs = append(inputs, event.Body.Input)
+			}
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	type request struct {
+		Query   string `json:"query"`
+		Limit   int    `json:"limit,omitempty"`
+		secret  string
+		Updates chan string
+		At      time.Time
+	}
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	fn := NewObserver(l, WithObserveName("search")).Observe(func(req request) error {
+		return nil
+	}).(func(request) error)
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := fn(request{Query: "weather", secret: "token", Updates: make(chan string), At: at}); err != nil {
+		t.Fatalf("Observed call: %v", err)
+	}
+	if err := l.FlushSync(ctx); err != nil {
+		t.Fatalf("FlushSync: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(inputs) != 1 {
+		t.Fatalf("Expected one captured input, got %d", len(inputs))
+	}
+	var input map[string]interface{}
+	if err := json.Unmarshal(inputs[0], &input); err != nil {
+		t.Fatalf("Failed to decode input %s: %v", inputs[0], err)
+	}
+	want := map[string]interface{}{
+		"query":   "weather",
+		"Updates": "channel<chan string>",
+		"At":      "2024-05-01T12:00:00Z",
+	}
+	if len(input) != len(want) {
+		t.Errorf("Expected fields %v, got %v", want, input)
+	}
+	for key, value := range want {
+		if input[key] != value {
+			t.Errorf("Field %s: got %v, want %v", key, input[key], value)
+		}
+	}
+}
+
+// Test that per-call options override the client's environment, sampling and masking
+func TestCallOptions(t *testing.T) {
+	var mu sync.Mutex
+	type received struct {
+		ID          string `json:"id"`
+		TraceID     string `json:"traceId"`
+		Environment string `json:"environment"`
+		Input       any    `json:"input"`
+	}
+	var bodies []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Batch []struct {
+				Body received `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		for _, event := range batch.Batch {
+			bodies = append(bodies, event.Body)
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	redact := func(any) any { return "[redacted]" }
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithEnvironment("production").
+		WithSampleRate(0).
+		WithMask(redact)
+
+	if _, err := l.Trace(&model.Trace{ID: "sampled-out", Input: "hello"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+
+	debug, err := l.Trace(&model.Trace{ID: "debug", Input: "hello"},
+		WithCallSampleRate(1), WithCallEnvironment("debug"), WithCallMask(nil))
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	// Later calls on the trace follow its sampling override but keep the client mask
+	if _, err := l.Span(&model.Span{ID: "debug-span", TraceID: debug.ID, Input: "secret"}, nil); err != nil {
+		t.Fatalf("Span: %v", err)
+	}
+	if err := l.FlushSync(ctx); err != nil {
+		t.Fatalf("FlushSync: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []received{
+		{ID: "debug", Environment: "debug", Input: "hello"},
+		{ID: "debug-span", TraceID: "debug", Environment: "production", Input: "[redacted]"},
+	}
+	if len(bodies) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), bodies)
+	}
+	for i, body := range bodies {
+		if body != want[i] {
+			t.Errorf("Event %d: got %+v, want %+v", i, body, want[i])
+		}
+	}
+	if sampledOut := l.Stats().EventsSampledOut; sampledOut != 1 {
+		t.Errorf("Expected one sampled out event, got %d", sampledOut)
+	}
+}
+
+// Test that canonical mode sorts object keys written by custom marshalers
+func TestCanonicalJSON(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Batch []struct {
+				Body struct {
+					Input json.RawMessage `json:"input"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		for _, event := range batch.Batch {
+			payloads = append(payloads, string(event.Body.Input))
+		}
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	input := map[string]any{"z": json.RawMessage(`{"b":1,"a":12345678901234567890}`), "a": "x"}
+	for _, canonical := range []bool{false, true} {
+		l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+			WithCanonicalJSON(canonical)
+		if _, err := l.Trace(&model.Trace{Input: input}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+		if err := l.FlushSync(ctx); err != nil {
+			t.Fatalf("FlushSync: %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		`{"a":"x","z":{"b":1,"a":12345678901234567890}}`,
+		`{"a":"x","z":{"a":12345678901234567890,"b":1}}`,
+	}
+	if len(payloads) != len(want) {
+		t.Fatalf("Expected %d payloads, got %v", len(want), payloads)
+	}
+	for i, payload := range payloads {
+		if payload != want[i] {
+			t.Errorf("Payload %d: got %s, want %s", i, payload, want[i])
+		}
+	}
+}
+
+// Test that progress is reported after each evaluated item with a running average
+func TestDatasetEvaluatorProgress(t *testing.T) {
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	dataset := &Dataset{ID: "dataset-1", Name: "qa", client: l}
+	for _, input := range []string{"a", "b", "c"} {
+		_, _ = dataset.CreateItem(input, input, nil)
+	}
+
+	var done []int
+	var averages []float64
+	evaluator := NewDatasetEvaluator(dataset, func(_, expected, actual interface{}) (float64, error) {
+		if expected == actual {
+			return 1, nil
+		}
+		return 0, nil
+	}).WithProgress(func(n, total int, running EvaluationResult) {
+		if total != 3 || len(running.Items) != n {
+			t.Errorf("Expected %d of 3 items in the snapshot, got %d of %d", n, len(running.Items), total)
+		}
+		done = append(done, n)
+		averages = append(averages, running.Scores["average"])
+	})
+
+	result, err := evaluator.Evaluate(ctx, func(input interface{}) (interface{}, error) {
+		if input == "b" {
+			return "wrong", nil
+		}
+		return input, nil
+	})
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if fmt.Sprint(done) != "[1 2 3]" || fmt.Sprintf("%.2f", averages) != "[1.00 0.50 0.67]" {
+		t.Errorf("Unexpected progress: done %v, averages %v", done, averages)
+	}
+	if _, averaged := result.Scores["average"]; !averaged || len(result.Items) != 3 {
+		t.Errorf("Expected the final result to cover every item, got %+v", result)
+	}
+}
+
+// Test that failing runners are retried, recording the retries, until they
+// succeed or the retries or the context run out
+func TestDatasetEvaluatorItemRetries(t *testing.T) {
+	recorder := NewObserverRecorder()
+	dataset := &Dataset{ID: "dataset-1", Name: "qa", client: recorder.Client()}
+	for _, input := range []string{"flaky", "broken"} {
+		_, _ = dataset.CreateItem(input, input, nil)
+	}
+
+	calls := map[interface{}]int{}
+	runner := func(input interface{}) (interface{}, error) {
+		calls[input]++
+		if input == "broken" || calls[input] < 3 {
+			return nil, errors.New("timeout")
+		}
+		return input, nil
+	}
+	result, err := NewDatasetEvaluator(dataset, nil).WithItemRetries(3, time.Millisecond).Evaluate(context.Background(), runner)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+
+	flaky, broken := result.Items[0], result.Items[1]
+	if flaky.Error != nil || flaky.Retries != 2 || calls["flaky"] != 3 {
+		t.Errorf("Expected the flaky item to succeed after 2 retries, got %v after %d", flaky.Error, flaky.Retries)
+	}
+	if broken.Error == nil || broken.Retries != 3 || calls["broken"] != 4 {
+		t.Errorf("Expected the broken item to fail after 3 retries, got %v after %d", broken.Error, broken.Retries)
+	}
+	var retries []interface{}
+	for _, run := range recorder.ObservationsNamed("evaluation") {
+		metadata, _ := run.Metadata.(map[string]interface{})
+		retries = append(retries, metadata[RunRetriesKey])
+	}
+	if fmt.Sprint(retries) != "[2 3]" {
+		t.Errorf("Expected the retries in the run metadata, got %v", retries)
+	}
+
+	// Retries stop once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = map[interface{}]int{}
+	result, err = NewDatasetEvaluator(dataset, nil).WithItemRetries(3, time.Hour).Evaluate(ctx, runner)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if calls["flaky"] != 1 || result.Items[0].Error == nil || result.Items[0].Retries != 0 {
+		t.Errorf("Expected no retries after cancellation, got %d calls", calls["flaky"])
+	}
+}
+
+// Test that evaluation runs, and the observations runners record in them, are
+// kept out of production in the evaluation environment
+func TestDatasetEvaluatorEnvironment(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		environment *string
+		want        string
+	}{
+		{name: "Default", want: DefaultEvaluationEnvironment},
+		{name: "Override", environment: func() *string { env := "staging-eval"; return &env }(), want: "staging-eval"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := NewObserverRecorder()
+			dataset := &Dataset{ID: "dataset-1", Name: "qa", client: recorder.Client()}
+			_, _ = dataset.CreateItem("question", "answer", nil)
+
+			evaluator := NewDatasetEvaluator(dataset, nil)
+			if tt.environment != nil {
+				evaluator.WithEnvironment(*tt.environment)
+			}
+			_, err := evaluator.EvaluateWithRun(context.Background(), func(rc *RunContext, input interface{}) (interface{}, error) {
+				if _, err := rc.Trace().Generation(&model.Generation{Name: "answer", Input: input}); err != nil {
+					return nil, err
+				}
+				_, err := rc.Trace().Score(&model.Score{Name: "runner", Value: 1})
+				return "answer", err
+			})
+			if err != nil {
+				t.Fatalf("EvaluateWithRun: %v", err)
+			}
+
+			traces := recorder.Traces()
+			if len(traces) == 0 {
+				t.Fatal("Expected the run's trace")
+			}
+			for _, trace := range traces {
+				if trace.Environment != tt.want {
+					t.Errorf("Trace %s: expected environment %q, got %q", trace.ID, tt.want, trace.Environment)
+				}
+			}
+			runs := recorder.ObservationsNamed("evaluation")
+			answers := recorder.ObservationsNamed("answer")
+			if len(runs) == 0 || len(answers) != 1 {
+				t.Fatalf("Expected the run span and the runner's generation, got %d and %d", len(runs), len(answers))
+			}
+			generation := answers[0].Body.(*model.Generation)
+			if generation.Environment != tt.want || generation.TraceID != traces[0].ID || generation.ParentObservationID != runs[0].ID {
+				t.Errorf("Expected the generation under the run in %q, got %+v", tt.want, generation)
+			}
+			if span := runs[0].Body.(*model.Span); span.Environment != tt.want {
+				t.Errorf("Expected the run span in %q, got %q", tt.want, span.Environment)
+			}
+			for _, score := range recorder.Scores() {
+				if score.Environment != tt.want {
+					t.Errorf("Score %s: expected environment %q, got %q", score.Name, tt.want, score.Environment)
+				}
+			}
+		})
+	}
+}
+
+// Test that weighted scores combine named scorers and skip missing sub-scores
+func TestDatasetEvaluatorWeightedScore(t *testing.T) {
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	dataset := &Dataset{ID: "dataset-1", Name: "qa", client: l}
+	for _, input := range []string{"a", "bb"} {
+		_, _ = dataset.CreateItem(input, input, nil)
+	}
+
+	evaluator := NewDatasetEvaluator(dataset, nil).
+		WithScorer("exact", func(_, expected, actual interface{}) (float64, error) {
+			if expected == actual {
+				return 1, nil
+			}
+			return 0, nil
+		}).
+		WithScorer("brevity", func(input, _, _ interface{}) (float64, error) {
+			if input == "bb" {
+				return 0, errors.New("judge unavailable")
+			}
+			return 0.5, nil
+		}).
+		WithWeightedScore(map[string]float64{"exact": 3, "brevity": 1}, "quality")
+
+	result, err := evaluator.Evaluate(ctx, func(input interface{}) (interface{}, error) {
+		return input, nil
+	})
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if got := result.Items[0].Scores["quality"]; got != 0.875 {
+		t.Errorf("Expected a weighted quality of 0.875, got %v", got)
+	}
+	if got, found := result.Items[1].Scores["quality"]; got != 1 || !found {
+		t.Errorf("Expected the missing brevity score to be left out, got %v", result.Items[1].Scores)
+	}
+	if result.Scores["quality"] != 0.9375 || result.Scores["exact"] != 1 || result.Scores["brevity"] != 0.5 {
+		t.Errorf("Expected averaged named scores, got %v", result.Scores)
+	}
+
+	for _, weights := range []map[string]float64{
+		{"exact": -1},
+		{"exact": 0},
+		{"unknown": 1},
+	} {
+		invalid := NewDatasetEvaluator(dataset, nil).
+			WithScorer("exact", func(_, _, _ interface{}) (float64, error) { return 1, nil }).
+			WithWeightedScore(weights, "quality")
+		if _, err := invalid.Evaluate(ctx, func(input interface{}) (interface{}, error) { return input, nil }); err == nil {
+			t.Errorf("Expected weights %v to be rejected", weights)
+		}
+	}
+}
+
+// Test that evaluation results export as JSON and render a report
+func TestEvaluationReport(t *testing.T) {
+	result := &EvaluationResult{
+		DatasetName: "qa",
+		Items: []*ItemResult{
+			{ItemID: "item-1", Score: 1, TraceID: "trace-1"},
+			{ItemID: "item-2", Score: 0, TraceID: "trace-2", Error: errors.New("timeout")},
+			{ItemID: "item-3", TraceID: "trace-3", Skipped: true},
+		},
+		Scores: map[string]float64{"average": 0.5},
+	}
+
+	var buf strings.Builder
+	if err := result.ExportJSON(&buf); err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	var exported struct {
+		Items []struct {
+			TraceID string `json:"traceId"`
+			Error   string `json:"error"`
+		} `json:"items"`
+		ErrorCount int  `json:"errorCount"`
+		Passed     bool `json:"passed"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &exported); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+	if exported.ErrorCount != 1 || exported.Passed || len(exported.Items) != 3 || exported.Items[1].Error != "timeout" {
+		t.Errorf("Unexpected export: %s", buf.String())
+	}
+
+	report := result.Report()
+	for _, want := range []string{"qa: FAILED", "1 errors", "1 skipped", "trace-1", "error: timeout", "skipped"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected the report to contain %q:\n%s", want, report)
+		}
+	}
+
+	result.Items = result.Items[:1]
+	if !result.WithThreshold(0.5).Passed() || result.WithThreshold(0.9).Passed() {
+		t.Errorf("Expected the threshold to decide the verdict")
+	}
+}
+
+// Test that evaluation gates check aggregate scores and item pass rates
+func TestEvaluationGate(t *testing.T) {
+	result := &EvaluationResult{Scores: map[string]float64{"average": 0.85}}
+	for i := 0; i < 20; i++ {
+		item := &ItemResult{ItemID: fmt.Sprintf("item-%d", i), Score: 1}
+		if i == 0 {
+			item.Score = 0.5
+		}
+		result.Items = append(result.Items, item)
+	}
+
+	if !result.PassesGate(map[string]float64{"average": 0.8}, WithItemPassRate(0.95, 0.8)) {
+		t.Errorf("Expected 19 of 20 passing items to meet a 95%% pass rate: %v", result.Check(map[string]float64{"average": 0.8}, WithItemPassRate(0.95, 0.8)))
+	}
+
+	result.Items[1].Error = errors.New("timeout")
+	err := result.Check(map[string]float64{"average": 0.9, "accuracy": 0.5}, WithItemPassRate(0.95, 0.8))
+	var gateErr *GateError
+	if !errors.As(err, &gateErr) || len(gateErr.Failures) != 3 {
+		t.Fatalf("Expected three failures, got %v", err)
+	}
+	if result.PassesGate(nil, WithItemPassRate(0.95, 0.8)) {
+		t.Errorf("Expected an errored item to count against the pass rate")
+	}
+}
+
+// Test that Diff lists the differences between expected and actual output by path
+func TestItemResultDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected interface{}
+		actual   interface{}
+		want     []string
+	}{
+		{
+			name:     "Missing keys",
+			expected: map[string]interface{}{"answer": "Paris", "score": 1},
+			actual:   map[string]int{"score": 1},
+			want:     []string{`$.answer: missing, expected "Paris"`},
+		},
+		{
+			name:     "Nested values",
+			expected: map[string]interface{}{"city": map[string]interface{}{"name": "Paris", "tags": []string{"fr", "eu"}}},
+			actual:   map[string]interface{}{"city": map[string]interface{}{"name": "Lyon", "tags": []string{"fr"}, "zip": "69000"}},
+			want: []string{
+				`$.city.name: expected "Paris", got "Lyon"`,
+				`$.city.tags[1]: missing, expected "eu"`,
+				`$.city.zip: unexpected "69000"`,
+			},
+		},
+		{
+			name:     "Multi-line strings",
+			expected: "line one\nline two",
+			actual:   "line one\nline 2\nline three",
+			want: []string{
+				`$ line 2: expected "line two", got "line 2"`,
+				`$ line 3: unexpected "line three"`,
+			},
+		},
+		{
+			name:     "Mismatched types",
+			expected: []int{1, 2},
+			actual:   "1, 2",
+			want:     []string{`$: expected [1,2], got "1, 2"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &ItemResult{ExpectedOutput: tt.expected, ActualOutput: tt.actual}
+			if got := result.Diff(); got != strings.Join(tt.want, "\n") {
+				t.Errorf("Got diff\n%s\nwant\n%s", got, strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+
+	t.Run("Structs compare as JSON", func(t *testing.T) {
+		type answer struct {
+			Text string `json:"text"`
+		}
+		result := &ItemResult{ExpectedOutput: answer{Text: "yes"}, ActualOutput: map[string]interface{}{"text": "yes"}}
+		if got := result.Diff(); got != "" {
+			t.Errorf("Expected no differences, got %q", got)
+		}
+	})
+
+	t.Run("Long diffs are truncated", func(t *testing.T) {
+		expected := make([]int, maxDiffLines+5)
+		actual := make([]int, maxDiffLines+5)
+		for i := range actual {
+			actual[i] = 1
+		}
+		lines := strings.Split((&ItemResult{ExpectedOutput: expected, ActualOutput: actual}).Diff(), "\n")
+		if len(lines) != maxDiffLines+1 || lines[maxDiffLines] != "... 5 more differences" {
+			t.Errorf("Expected %d differences and a truncation note, got %d lines ending %q", maxDiffLines, len(lines), lines[len(lines)-1])
+		}
+	})
+}
+
+// fakeClock is a Clock whose time only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Test that SessionFor continues a user's session until they are idle for the window
+func TestSessionFor(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	client := NewObserverRecorder().Client().WithClock(clock).WithSessionWindow(10 * time.Minute)
+
+	first := client.SessionFor("alice")
+	if first == "" {
+		t.Fatal("Expected a session ID")
+	}
+	other := client.SessionFor("bob")
+	if other == first {
+		t.Errorf("Expected users to get their own sessions")
+	}
+
+	// Activity within the window extends the session
+	clock.advance(9 * time.Minute)
+	if got := client.SessionFor("alice"); got != first {
+		t.Errorf("Expected activity after 9 minutes to continue the session, got %s", got)
+	}
+	clock.advance(9 * time.Minute)
+	if got := client.SessionFor("alice"); got != first {
+		t.Errorf("Expected the window to count from the latest activity, got %s", got)
+	}
+
+	// A longer idle period starts a new session
+	clock.advance(11 * time.Minute)
+	second := client.SessionFor("alice")
+	if second == first {
+		t.Errorf("Expected a new session after 11 idle minutes")
+	}
+	if got := client.SessionFor("bob"); got == other {
+		t.Errorf("Expected bob's session to have expired as well")
+	}
+	if got := client.SessionFor("alice"); got != second {
+		t.Errorf("Expected the new session to continue, got %s", got)
+	}
+}
+
+// Test that checkpoints record progress events under the observation
+func TestObserveContextCheckpoint(t *testing.T) {
+	recorder := NewObserverRecorder()
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	oc := recorder.Observer(WithObserveClock(clock)).Start("ingest")
+
+	clock.advance(time.Minute)
+	oc.Checkpoint("batch 1", map[string]interface{}{"rows": 100})
+	clock.advance(2 * time.Minute)
+	oc.Checkpoint("batch 2", map[string]interface{}{"rows": 250})
+	oc.End(nil, nil)
+
+	var checkpoints []*RecordedObservation
+	for _, obs := range recorder.Observations() {
+		if obs.Type == "event" {
+			checkpoints = append(checkpoints, obs)
+		}
+	}
+	if len(checkpoints) != 2 {
+		t.Fatalf("Expected 2 checkpoint events, got %d", len(checkpoints))
+	}
+	for i, checkpoint := range checkpoints {
+		if checkpoint.ParentObservationID != oc.observationID || checkpoint.TraceID != oc.observer.TraceID() {
+			t.Errorf("Checkpoint %d: expected it under observation %s, got parent %q", i, oc.observationID, checkpoint.ParentObservationID)
+		}
+	}
+
+	second := checkpoints[1]
+	metadata, _ := second.Metadata.(map[string]interface{})
+	if second.Name != "batch 2" || metadata["checkpoint"] != 2 || metadata["elapsed_ms"] != int64(3*time.Minute/time.Millisecond) {
+		t.Errorf("Expected the second checkpoint after 3 minutes, got %q with %v", second.Name, metadata)
+	}
+	if output, _ := second.Output.(map[string]interface{}); output["rows"] != 250 {
+		t.Errorf("Expected the checkpoint data as output, got %v", second.Output)
+	}
+	if !second.StartTime.Equal(clock.Now()) {
+		t.Errorf("Expected the checkpoint at %v, got %v", clock.Now(), second.StartTime)
+	}
+}
+
+// Test that SetInput updates the observation created by Start and SetOutput
+// provides the output End records
+func TestObserveContextSetInputOutput(t *testing.T) {
+	recorder := NewObserverRecorder()
+	observer := recorder.Observer()
+
+	span := observer.Start("retrieve")
+	span.SetInput("query")
+	span.SetOutput([]string{"doc-1"})
+	span.End(nil, nil)
+
+	generation := span.Child("answer", ObservationTypeGeneration)
+	generation.End("Paris", nil)
+	generation.SetInput("capital of France?")
+
+	observations := recorder.Observations()
+	if len(observations) != 2 {
+		t.Fatalf("Expected the inputs to update 2 observations, got %d", len(observations))
+	}
+	if retrieved, _ := observations[0].Output.([]string); observations[0].Input != "query" || len(retrieved) != 1 {
+		t.Errorf("Expected the span's input and deferred output, got %v and %v", observations[0].Input, observations[0].Output)
+	}
+	if answer := observations[1]; answer.Type != "generation" || answer.Input != "capital of France?" || answer.Output != "Paris" || answer.EndTime == nil {
+		t.Errorf("Expected input set after End to update the generation, got %+v", answer)
+	}
+
+	// An explicit output takes precedence over SetOutput
+	explicit := observer.Start("explicit")
+	explicit.SetOutput("deferred")
+	explicit.End("explicit", nil)
+	if got := recorder.ObservationsNamed("explicit")[0].Output; got != "explicit" {
+		t.Errorf("Expected End's output to win, got %v", got)
+	}
+}
+
+// Test that dataset items are updated and deleted through the API, with the
+// caller's context, and that items never persisted are reported
+func TestDatasetItemUpdateDelete(t *testing.T) {
+	var mu sync.Mutex
+	stored := map[string]map[string]interface{}{"item-1": {"id": "item-1"}, "item-2": {"id": "item-2"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		id := strings.TrimPrefix(r.URL.Path, "/api/public/dataset-items/")
+		switch {
+		case r.Method == http.MethodPost:
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			stored[body["id"].(string)] = body
+			_ = json.NewEncoder(w).Encode(body)
+		case stored[id] == nil:
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+		case r.Method == http.MethodDelete:
+			delete(stored, id)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			_ = json.NewEncoder(w).Encode(stored[id])
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	dataset := &Dataset{ID: "dataset-1", Name: "qa", client: l}
+	for _, id := range []string{"item-1", "item-2", "item-3"} {
+		dataset.Items = append(dataset.Items, &DatasetItem{ID: id, DatasetID: dataset.ID, Input: "question", client: l, dataset: dataset})
+	}
+	first, second, unsaved := dataset.Items[0], dataset.Items[1], dataset.Items[2]
+
+	if err := first.Update(ctx, "new question", "new answer", map[string]interface{}{"reviewed": true}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if first.Input != "new question" || first.ExpectedOutput != "new answer" || first.Metadata["reviewed"] != true {
+		t.Errorf("Expected the item to hold the new values, got %+v", first)
+	}
+	mu.Lock()
+	if saved := stored["item-1"]; saved["expectedOutput"] != "new answer" || saved["datasetName"] != "qa" {
+		t.Errorf("Expected the update to be sent to the API, got %v", saved)
+	}
+	mu.Unlock()
+
+	if err := unsaved.Update(ctx, "q", "a", nil); !errors.Is(err, ErrDatasetItemNotPersisted) {
+		t.Errorf("Expected ErrDatasetItemNotPersisted updating an unsaved item, got %v", err)
+	}
+	if err := unsaved.Delete(ctx); !errors.Is(err, ErrDatasetItemNotPersisted) {
+		t.Errorf("Expected ErrDatasetItemNotPersisted deleting an unsaved item, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := second.Update(canceled, "q", "a", nil); !errors.Is(err, context.Canceled) || second.Input != "question" {
+		t.Errorf("Expected the caller's canceled context to stop the update, got %v", err)
+	}
+
+	if err := second.Delete(ctx); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if len(dataset.Items) != 2 || dataset.Items[0] != first || dataset.Items[1] != unsaved {
+		t.Errorf("Expected the deleted item to be removed from the dataset, got %d items", len(dataset.Items))
+	}
+	mu.Lock()
+	if _, exists := stored["item-2"]; exists {
+		t.Error("Expected the item to be deleted server-side")
+	}
+	mu.Unlock()
+}
+
+// Test that the error handler receives failed batches, rejected and dropped
+// events off the flush path
+func TestErrorHandler(t *testing.T) {
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch code := int(status.Load()); code {
+		case http.StatusOK:
+			_, _ = w.Write([]byte(`{"successes":[],"errors":[{"id":"event-1","status":400,"message":"invalid value"}]}`))
+		default:
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(`{"message":"failed"}`))
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	release := make(chan struct{})
+	handled := make(chan error, 10)
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).
+		WithErrorHandler(func(err error) {
+			handled <- err
+			<-release
+			panic("handlers may panic")
+		})
+
+	// A blocked handler does not stall the flush loop
+	for _, code := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusRequestEntityTooLarge} {
+		status.Store(int32(code))
+		if _, err := l.Trace(&model.Trace{Name: "traced"}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+		done := make(chan struct{})
+		go func() {
+			_ = l.Flush(ctx)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Flush with a %d response blocked on the error handler", code)
+		}
+	}
+	close(release)
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case err := <-handled:
+			got = append(got, err.Error())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected 3 handled errors, got %q", got)
+		}
+	}
+	for i, want := range []string{"500", "invalid value", "dropped"} {
+		if !strings.Contains(got[i], want) {
+			t.Errorf("Handled error %d: expected it to mention %q, got %q", i, want, got[i])
+		}
+	}
+	if stats := l.Stats(); stats.EventsDropped != 1 {
+		t.Errorf("Expected the oversized event to be counted as dropped, got %+v", stats)
+	}
+}
+
+// Test that an injected clock times the client's events and the observers
+// created for it, unless an observer has its own clock
+func TestClockInjection(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	recorder := NewObserverRecorder()
+	client := recorder.Client().WithClock(clock)
+
+	if _, err := client.Trace(&model.Trace{Name: "clocked"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if events := recorder.Events(); len(events) != 1 || !events[0].Timestamp.Equal(start) {
+		t.Errorf("Expected the event stamped at %v, got %+v", start, events)
+	}
+	if got := client.Now(); !got.Equal(start) {
+		t.Errorf("Expected Now to read the injected clock, got %v", got)
+	}
+
+	step := recorder.Observer(WithObserveName("step")).Observe(func() string {
+		clock.advance(1500 * time.Millisecond)
+		return "done"
+	})
+	step.(func() string)()
+	observed := recorder.ObservationsNamed("step")
+	if len(observed) != 1 || !observed[0].StartTime.Equal(start) || !observed[0].EndTime.Equal(start.Add(1500*time.Millisecond)) {
+		t.Fatalf("Expected the observer to time the call with the client's clock, got %+v", observed)
+	}
+
+	own := &fakeClock{now: start.Add(time.Hour)}
+	oc := recorder.Observer(WithObserveClock(own)).Start("own")
+	own.advance(time.Second)
+	oc.End(nil, nil)
+	recorded := recorder.ObservationsNamed("own")
+	if len(recorded) != 1 || !recorded[0].StartTime.Equal(own.Now().Add(-time.Second)) || !recorded[0].EndTime.Equal(own.Now()) {
+		t.Errorf("Expected WithObserveClock to take precedence, got %+v", recorded)
+	}
+	if metadata, _ := recorded[0].Metadata.(map[string]interface{}); metadata["duration_ms"] != int64(1000) {
+		t.Errorf("Expected a duration of exactly 1000ms, got %v", recorded[0].Metadata)
+	}
+}
+
+// Test that observed calls inherit the client, trace, parent, session and user
+// of an observer in their context, with their own settings taking precedence
+func TestObserveAmbientObserver(t *testing.T) {
+	recorder := NewObserverRecorder()
+	ctx := WithObserver(context.Background(), recorder.Observer(WithObserveSession("session-1"), WithObserveUser("user-1")))
+
+	// Without a client of its own, the call uses the ambient observer's
+	if err := ObserveFunc(nil, func() error { return nil }, WithObserveName("configured"), WithObserveContext(ctx)); err != nil {
+		t.Fatalf("ObserveFunc: %v", err)
+	}
+	if _, err := ObserveWithResult(nil, func() (int, error) { return 1, nil }, WithObserveName("override"), WithObserveContext(ctx), WithObserveUser("user-2")); err != nil {
+		t.Fatalf("ObserveWithResult: %v", err)
+	}
+	traces := recorder.Traces()
+	if len(traces) != 2 {
+		t.Fatalf("Expected a trace per call, got %d", len(traces))
+	}
+	if traces[0].SessionID != "session-1" || traces[0].UserID != "user-1" {
+		t.Errorf("Expected the ambient session and user, got %q and %q", traces[0].SessionID, traces[0].UserID)
+	}
+	if traces[1].SessionID != "session-1" || traces[1].UserID != "user-2" {
+		t.Errorf("Expected the call's own user to take precedence, got %q and %q", traces[1].SessionID, traces[1].UserID)
+	}
+
+	// Observed calls nest under the observed call whose context they receive
+	recorder.Reset()
+	inner := recorder.Observer(WithObserveName("inner")).Observe(func(ctx context.Context) error { return nil })
+	outer := recorder.Observer(WithObserveName("outer")).Observe(func(ctx context.Context) error {
+		return inner.(func(context.Context) error)(ctx)
+	})
+	if err := outer.(func(context.Context) error)(context.Background()); err != nil {
+		t.Fatalf("Observed call: %v", err)
+	}
+	outerObs, innerObs := recorder.ObservationsNamed("outer"), recorder.ObservationsNamed("inner")
+	if len(recorder.Traces()) != 1 || len(outerObs) != 1 || len(innerObs) != 1 {
+		t.Fatalf("Expected both calls in one trace, got %d traces", len(recorder.Traces()))
+	}
+	if innerObs[0].TraceID != outerObs[0].TraceID || innerObs[0].ParentObservationID != outerObs[0].ID {
+		t.Errorf("Expected the inner call nested under the outer one, got %+v", innerObs[0])
+	}
+
+	// Without an ambient observer, a trace set in the context is joined
+	recorder.Reset()
+	if err := ObserveFunc(recorder.Client(), func() error { return nil }, WithObserveName("joined"), WithObserveContext(ContextWithTraceID(context.Background(), "trace-1"))); err != nil {
+		t.Fatalf("ObserveFunc: %v", err)
+	}
+	if joined := recorder.ObservationsNamed("joined"); len(recorder.Traces()) != 0 || len(joined) != 1 || joined[0].TraceID != "trace-1" {
+		t.Errorf("Expected the call to join trace-1 without creating a trace, got %d traces and %+v", len(recorder.Traces()), joined)
+	}
+}
+
+// Test that LinkTraces records the parent trace in the child's metadata
+func TestLinkTraces(t *testing.T) {
+	recorder := NewObserverRecorder()
+	client := recorder.Client()
+
+	parent, _ := client.Trace(&model.Trace{Name: "service-a"})
+	child, _ := client.Trace(&model.Trace{Name: "service-b"})
+	if err := client.LinkTraces(child.ID, parent.ID); err != nil {
+		t.Fatalf("LinkTraces: %v", err)
+	}
+
+	traces := recorder.Traces()
+	if len(traces) != 2 {
+		t.Fatalf("Expected the link to update the child trace, got %d traces", len(traces))
+	}
+	metadata, _ := traces[1].Metadata.(map[string]interface{})
+	if traces[1].Name != "service-b" || metadata[MetadataKeyParentTraceID] != parent.ID {
+		t.Errorf("Expected service-b to reference %s, got %s with %v", parent.ID, traces[1].Name, metadata)
+	}
+
+	for _, ids := range [][2]string{{"", parent.ID}, {child.ID, ""}, {child.ID, child.ID}} {
+		if err := client.LinkTraces(ids[0], ids[1]); err == nil {
+			t.Errorf("Expected an error linking %q to %q", ids[0], ids[1])
+		}
+	}
+}
+
+// Test that region presets pick the cloud host unless a host is set explicitly
+func TestRegion(t *testing.T) {
+	ctx := context.Background()
+	newClient := func(cfg Config) *Langfuse {
+		cfg.PublicKey, cfg.SecretKey, cfg.FlushInterval = "pk", "sk", time.Hour
+		return NewWithConfig(ctx, cfg)
+	}
+
+	tests := []struct {
+		name   string
+		client *Langfuse
+		want   string
+	}{
+		{name: "Default", client: newClient(Config{}), want: "https://cloud.langfuse.com"},
+		{name: "US", client: newClient(Config{Region: RegionUS}), want: "https://us.cloud.langfuse.com"},
+		{name: "EU", client: newClient(Config{Region: RegionEU}), want: "https://cloud.langfuse.com"},
+		{name: "Host over region", client: newClient(Config{Region: RegionUS, Host: "https://langfuse.internal"}), want: "https://langfuse.internal"},
+		{name: "Host before region", client: newClient(Config{}).WithHost("https://langfuse.internal/").WithRegion(RegionUS), want: "https://langfuse.internal"},
+		{name: "Unknown region", client: newClient(Config{}).WithRegion("apac"), want: "https://cloud.langfuse.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.client.BaseURL(); got != tt.want {
+				t.Errorf("Expected host %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// Test that generations record time to first token and completion throughput
+func TestStreamingMetrics(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	recorder := NewObserverRecorder()
+	client := recorder.Client().WithClock(clock)
+
+	// Metrics are derived from the timestamps and usage of a generation
+	firstToken, end := start.Add(300*time.Millisecond), start.Add(2300*time.Millisecond)
+	generation, err := client.Generation(&model.Generation{
+		TraceID:             "trace-1",
+		Name:                "derived",
+		StartTime:           &start,
+		CompletionStartTime: &firstToken,
+		EndTime:             &end,
+		Usage:               model.NewUsage(10, 50),
+	}, nil)
+	if err != nil {
+		t.Fatalf("Generation: %v", err)
+	}
+	if generation.TimeToFirstToken != 300*time.Millisecond || generation.CompletionTokensPerSecond != 25 {
+		t.Errorf("Expected 300ms to first token and 25 tokens/s, got %v and %v", generation.TimeToFirstToken, generation.CompletionTokensPerSecond)
+	}
+	metadata, _ := generation.Metadata.(map[string]interface{})
+	if metadata[metadataKeyTimeToFirstToken] != int64(300) || metadata[metadataKeyTokensPerSecond] != 25.0 {
+		t.Errorf("Expected the metrics in the metadata, got %v", metadata)
+	}
+
+	// Streaming observations measure them from the recorded tokens
+	oc := recorder.Observer().StartAt("stream", start).ChildAt("answer", ObservationTypeGeneration, start)
+	clock.advance(200 * time.Millisecond)
+	oc.RecordToken(1)
+	clock.advance(time.Second)
+	oc.RecordToken(9)
+	clock.advance(time.Second)
+	oc.End("answer", nil)
+
+	answers := recorder.ObservationsNamed("answer")
+	if len(answers) != 1 {
+		t.Fatalf("Expected the streamed generation, got %d", len(answers))
+	}
+	streamed := answers[0].Body.(*model.Generation)
+	if streamed.CompletionStartTime == nil || !streamed.CompletionStartTime.Equal(start.Add(200*time.Millisecond)) {
+		t.Errorf("Expected the first token to mark the completion start, got %v", streamed.CompletionStartTime)
+	}
+	if metadata, _ := streamed.Metadata.(map[string]interface{}); metadata[metadataKeyTimeToFirstToken] != int64(200) || metadata[metadataKeyTokensPerSecond] != 5.0 {
+		t.Errorf("Expected 200ms to first token and 5 tokens/s, got %v", metadata)
+	}
+}
+
+// Test that children nest under their observation and ending a parent with
+// open children warns
+func TestObserveContextChild(t *testing.T) {
+	recorder := NewObserverRecorder()
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	root := recorder.Observer().Start("pipeline")
+	retrieve := root.Child("retrieve", ObservationTypeSpan)
+	rerank := retrieve.Child("rerank", ObservationTypeSpan)
+	rerank.End(nil, nil)
+	retrieve.End(nil, nil)
+	answer := root.Child("answer", ObservationTypeGeneration)
+	root.End(nil, nil)
+	if !strings.Contains(logs.String(), "with 1 open child observations") {
+		t.Errorf("Expected a warning for the open answer generation, got %q", logs.String())
+	}
+
+	// Ending a child twice releases the parent only once
+	answer.End("done", nil)
+	answer.End("done", nil)
+	root.mu.Lock()
+	open := root.openChildren
+	root.mu.Unlock()
+	if open != 0 {
+		t.Errorf("Expected no open children, got %d", open)
+	}
+
+	parents := make(map[string]string)
+	for _, obs := range recorder.Observations() {
+		if obs.TraceID != root.observer.TraceID() {
+			t.Errorf("%s: expected trace %s, got %s", obs.Name, root.observer.TraceID(), obs.TraceID)
+		}
+		parents[obs.Name] = obs.ParentObservationID
+	}
+	want := map[string]string{
+		"pipeline": "",
+		"retrieve": root.observationID,
+		"rerank":   retrieve.observationID,
+		"answer":   root.observationID,
+	}
+	if !maps.Equal(parents, want) {
+		t.Errorf("Expected parents %v, got %v", want, parents)
+	}
+	if answers := recorder.ObservationsNamed("answer"); answers[0].Type != "generation" {
+		t.Errorf("Expected the answer to be a generation, got %s", answers[0].Type)
+	}
+}
+
+// Test that ReplayTraces re-runs the generations of listed traces and scores
+// the new outputs against the recorded ones
+func TestReplayTraces(t *testing.T) {
+	generations := map[string][]map[string]interface{}{
+		"trace-1": {
+			{"id": "gen-1", "type": "GENERATION", "name": "chat", "input": "q1", "output": "a1"},
+			{"id": "span-1", "type": "SPAN", "name": "retrieve", "input": "q1"},
+			{"id": "gen-2", "type": "GENERATION", "name": "chat"},
+		},
+		"trace-2": {{"id": "gen-3", "type": "GENERATION", "name": "chat", "input": "q2", "output": "a2"}},
+		"trace-3": {{"id": "gen-4", "type": "GENERATION", "name": "chat", "input": "q3", "output": "a3"}},
+		"trace-4": {{"id": "gen-5", "type": "GENERATION", "name": "chat", "input": "q4", "output": "a4"}},
+	}
+	var mu sync.Mutex
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != http.MethodGet:
+			_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+		case r.URL.Path == "/api/public/traces":
+			mu.Lock()
+			queries = append(queries, r.URL.Query())
+			mu.Unlock()
+			first := 1
+			if r.URL.Query().Get("page") == "2" {
+				first = 3
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": []map[string]interface{}{{"id": fmt.Sprintf("trace-%d", first)}, {"id": fmt.Sprintf("trace-%d", first+1)}},
+				"meta": map[string]interface{}{"page": 1, "totalPages": 2},
+			})
+		default:
+			id := strings.TrimPrefix(r.URL.Path, "/api/public/traces/")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "observations": generations[id]})
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	result, err := l.ReplayTraces(ctx, TraceFilter{Name: "chat", Tags: []string{"prod"}, Limit: 3}, func(input interface{}) (interface{}, error) {
+		if input == "q2" {
+			return "changed", nil
+		}
+		return "a" + strings.TrimPrefix(input.(string), "q"), nil
+	})
+	if err != nil {
+		t.Fatalf("ReplayTraces: %v", err)
+	}
+
+	mu.Lock()
+	if len(queries) != 2 || queries[0].Get("name") != "chat" || queries[0].Get("tags") != "prod" {
+		t.Errorf("Expected two filtered pages, got %v", queries)
+	}
+	mu.Unlock()
+
+	var scores []float64
+	for _, item := range result.Items {
+		scores = append(scores, item.Score)
+	}
+	if !slices.Equal(scores, []float64{1, 0, 1}) {
+		t.Errorf("Expected the generations of the first 3 traces scored 1, 0 and 1, got %v", scores)
+	}
+	if result.Items[1].ExpectedOutput != "a2" || result.Items[1].ActualOutput != "changed" {
+		t.Errorf("Expected the recorded output to be the expected one, got %+v", result.Items[1])
+	}
+
+	if _, err := l.ReplayTraces(ctx, TraceFilter{}, nil); err == nil {
+		t.Error("Expected an error without a runner")
+	}
+}
+
+// Test that scores of observations and dataset runs carry their metadata
+func TestScoreMetadata(t *testing.T) {
+	recorder := NewObserverRecorder()
+	provenance := map[string]interface{}{"annotator": "alice", "rubric": "v2"}
+
+	oc := recorder.Observer().Start("answer")
+	if err := oc.ScoreWithMetadata("helpfulness", 0.8, "clear", provenance); err != nil {
+		t.Fatalf("ScoreWithMetadata: %v", err)
+	}
+	if err := oc.Score("plain", 1, ""); err != nil {
+		t.Fatalf("Score: %v", err)
+	}
+	oc.End(nil, nil)
+
+	dataset := &Dataset{ID: "dataset-1", Name: "qa", client: recorder.Client()}
+	_, _ = dataset.CreateItem("question", "answer", nil)
+	_, err := NewDatasetEvaluator(dataset, nil).EvaluateWithRun(context.Background(), func(rc *RunContext, input interface{}) (interface{}, error) {
+		return "answer", rc.ScoreWithMetadata("reviewed", 1, "", provenance)
+	})
+	if err != nil {
+		t.Fatalf("EvaluateWithRun: %v", err)
+	}
+
+	byName := make(map[string]*model.Score)
+	for _, score := range recorder.Scores() {
+		byName[score.Name] = score
+	}
+	for _, name := range []string{"helpfulness", "reviewed"} {
+		score := byName[name]
+		if score == nil || !maps.Equal(score.Metadata, provenance) || score.ObservationID == "" {
+			t.Errorf("Expected %s scored on its observation with the provenance metadata, got %+v", name, score)
+		}
+	}
+	if byName["helpfulness"].ObservationID != oc.observationID || byName["helpfulness"].TraceID != oc.observer.TraceID() {
+		t.Errorf("Expected the score on the answer observation, got %+v", byName["helpfulness"])
+	}
+
+	encoded, _ := json.Marshal(byName["plain"])
+	if strings.Contains(string(encoded), "metadata") {
+		t.Errorf("Expected no metadata field on a plain score, got %s", encoded)
+	}
+	encoded, _ = json.Marshal(byName["helpfulness"])
+	if !strings.Contains(string(encoded), `"metadata":{"annotator":"alice","rubric":"v2"}`) {
+		t.Errorf("Expected the metadata to be serialized, got %s", encoded)
+	}
+}
+
+// Test that clients created from a config use its credentials, host and
+// settings rather than the environment
+func TestNewWithConfig(t *testing.T) {
+	t.Setenv("LANGFUSE_PUBLIC_KEY", "pk-env")
+	t.Setenv("LANGFUSE_SECRET_KEY", "sk-env")
+
+	type request struct{ user, pass string }
+	newServer := func(requests chan<- request) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, _ := r.BasicAuth()
+			requests <- request{user, pass}
+			_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+		}))
+	}
+	first, second := make(chan request, 10), make(chan request, 10)
+	firstServer, secondServer := newServer(first), newServer(second)
+	defer firstServer.Close()
+	defer secondServer.Close()
+
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	tenantA := NewWithConfig(ctx, Config{Host: firstServer.URL, PublicKey: "pk-a", SecretKey: "sk-a", FlushInterval: time.Hour, Clock: clock, StrictValidation: true})
+	tenantB := NewWithConfig(ctx, Config{Host: secondServer.URL, PublicKey: "pk-b", SecretKey: "sk-b", FlushInterval: time.Hour})
+
+	for _, client := range []*Langfuse{tenantA, tenantB} {
+		if _, err := client.Trace(&model.Trace{Name: "request"}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+		if err := client.Flush(ctx); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+	}
+	if got := <-first; got != (request{"pk-a", "sk-a"}) || len(first) != 0 {
+		t.Errorf("Expected tenant A's events sent once with its keys, got %+v", got)
+	}
+	if got := <-second; got != (request{"pk-b", "sk-b"}) || len(second) != 0 {
+		t.Errorf("Expected tenant B's events sent once with its keys, got %+v", got)
+	}
+
+	if !tenantA.Now().Equal(clock.Now()) {
+		t.Errorf("Expected the configured clock, got %v", tenantA.Now())
+	}
+	stale := time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := tenantA.Trace(&model.Trace{Name: "stale", Timestamp: &stale}); err == nil {
+		t.Error("Expected strict validation to reject an implausible timestamp")
+	}
+	if _, err := tenantB.Trace(&model.Trace{Name: "stale", Timestamp: &stale}); err != nil {
+		t.Errorf("Expected the default lenient validation, got %v", err)
+	}
+}
+
+// Test that clients created in one process keep their queues, uploaders,
+// prompt caches and credentials apart
+func TestClientIsolation(t *testing.T) {
+	type tenant struct {
+		client   *Langfuse
+		mu       sync.Mutex
+		requests []string
+	}
+	ctx := context.Background()
+	newTenant := func(name string) *tenant {
+		tn := &tenant{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _, _ := r.BasicAuth()
+			tn.mu.Lock()
+			tn.requests = append(tn.requests, user+" "+r.URL.Path)
+			tn.mu.Unlock()
+			if strings.HasPrefix(r.URL.Path, "/api/public/media") {
+				_, _ = w.Write([]byte(`{"mediaId":"media-` + name + `"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+		}))
+		t.Cleanup(server.Close)
+		tn.client = NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk-" + name, SecretKey: "sk-" + name, FlushInterval: time.Hour})
+		return tn
+	}
+	a, b := newTenant("a"), newTenant("b")
+
+	if a.client.MediaUploader() == b.client.MediaUploader() {
+		t.Fatal("Expected each client to have its own media uploader")
+	}
+	if GetGlobalUploader(a.client) != a.client.MediaUploader() {
+		t.Error("Expected GetGlobalUploader to return the client's uploader")
+	}
+	for _, tn := range []*tenant{a, b} {
+		media := NewMediaFromBytes([]byte("same image"), "image/png", "a.png")
+		if _, err := tn.client.MediaUploader().Upload(media, "trace-1", ""); err != nil {
+			t.Fatalf("Upload: %v", err)
+		}
+		if err := tn.client.MediaUploader().Drain(ctx); err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+	}
+	mediaA := NewMediaFromBytes([]byte("same image"), "image/png", "a.png")
+	if refID, _ := a.client.MediaUploader().Upload(mediaA, "trace-2", ""); refID != "media-a" {
+		t.Errorf("Expected tenant A's own media reference, got %q", refID)
+	}
+	mediaB := NewMediaFromBytes([]byte("same image"), "image/png", "a.png")
+	if refID, _ := b.client.MediaUploader().Upload(mediaB, "trace-2", ""); refID != "media-b" {
+		t.Errorf("Expected tenant B's own media reference, got %q", refID)
+	}
+	_ = a.client.MediaUploader().Drain(ctx)
+	_ = b.client.MediaUploader().Drain(ctx)
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.client.Trace(&model.Trace{Name: "request"}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+	}
+	if _, err := b.client.Trace(&model.Trace{Name: "request"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if err := a.client.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if stats := a.client.Stats(); stats.EventsSent != 2 || stats.QueueDepth != 0 {
+		t.Errorf("Expected tenant A's events sent, got %+v", stats)
+	}
+	if stats := b.client.Stats(); stats.EventsEnqueued != 1 || stats.EventsSent != 0 || stats.QueueDepth != 1 {
+		t.Errorf("Expected tenant B's event still queued after tenant A flushed, got %+v", stats)
+	}
+
+	if _, err := a.client.NewPromptClient().GetPrompt(ctx, "greeting"); err != nil {
+		t.Fatalf("GetPrompt: %v", err)
+	}
+	if stats := b.client.Stats(); stats.PromptCacheHits+stats.PromptCacheMisses != 0 {
+		t.Errorf("Expected tenant A's prompt lookups kept out of tenant B's cache, got %+v", stats)
+	}
+
+	for name, tn := range map[string]*tenant{"a": a, "b": b} {
+		tn.mu.Lock()
+		for _, request := range tn.requests {
+			if !strings.HasPrefix(request, "pk-"+name+" ") {
+				t.Errorf("Tenant %s's server got a request for another tenant: %q", name, request)
+			}
+		}
+		if name == "b" && slices.Contains(tn.requests, "pk-b /api/public/ingestion") {
+			t.Error("Expected tenant A's flush to leave tenant B's queue alone")
+		}
+		tn.mu.Unlock()
+	}
+}
+
+// Test that the flush interval and batch size tune the ingestion loop, and that
+// Flush drains at once regardless of them
+func TestIngestionTuning(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		batches = append(batches, len(req.Batch))
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer server.Close()
+	sent := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes := slices.Clone(batches)
+		slices.Sort(sizes)
+		batches = nil
+		return sizes
+	}
+
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{Host: server.URL, PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour}).WithMaxBatchSize(2)
+	for i := 0; i < 5; i++ {
+		if _, err := l.Trace(&model.Trace{Name: "request"}); err != nil {
+			t.Fatalf("Trace: %v", err)
+		}
+	}
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got := sent(); !slices.Equal(got, []int{1, 2, 2}) {
+		t.Errorf("Expected the drain split into requests of at most 2 events, got %v", got)
+	}
+
+	l.WithMaxBatchSize(5000)
+	if got := l.maxBatchSize.Load(); got != maxIngestionBatchSize {
+		t.Errorf("Expected the batch size capped at the server limit, got %d", got)
+	}
+	l.WithMaxBatchSize(0)
+	if got := l.maxBatchSize.Load(); got != defaultMaxBatchSize {
+		t.Errorf("Expected the default batch size, got %d", got)
+	}
+	l.WithFlushInterval(0)
+	if l.flushInterval != defaultFlushInterval {
+		t.Errorf("Expected the default flush interval, got %v", l.flushInterval)
+	}
+
+	l.WithFlushInterval(10 * time.Millisecond)
+	if _, err := l.Trace(&model.Trace{Name: "request"}); err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for l.Stats().EventsSent < 6 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := sent(); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected the short interval to send the event without Flush, got %v", got)
+	}
+}
@@ -0,0 +1,79 @@
+package langgraph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// BranchDecision records the target chosen at a conditional edge
+type BranchDecision struct {
+	// Condition reads "from -> to"
+	Condition string `json:"condition"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// TracedCondition wraps the condition of a conditional edge so each decision is
+// reported to hook as an edge traversal from the from node. langgraphgo does not
+// report conditional routing itself; use it when adding the edge:
+//
+//	workflow.AddConditionalEdge("check_cache", langgraph.TracedCondition(hook, "check_cache", route))
+func TracedCondition(hook graph.TraceHook, from string, condition func(ctx context.Context, state interface{}) string) func(ctx context.Context, state interface{}) string {
+	return func(ctx context.Context, state interface{}) string {
+		to := condition(ctx, state)
+
+		now := time.Now()
+		span := &graph.TraceSpan{
+			ID:        fmt.Sprintf("branch_%s_%d", from, now.UnixNano()),
+			Event:     graph.TraceEventEdgeTraversal,
+			FromNode:  from,
+			ToNode:    to,
+			StartTime: now,
+			EndTime:   now,
+			State:     state,
+			Metadata:  map[string]interface{}{ConditionalKey: true},
+		}
+		// Conditions run inside a node's context or the graph's; attach to the graph
+		if parent := graph.SpanFromContext(ctx); parent != nil {
+			span.ParentID = parent.ID
+			if parent.Event != graph.TraceEventGraphStart && parent.Event != graph.TraceEventGraphEnd {
+				span.ParentID = parent.ParentID
+			}
+		}
+		hook.OnEvent(ctx, span)
+
+		return to
+	}
+}
+
+// isConditionalEdge reports whether a traversal from span.FromNode was a
+// branch decision: the span is marked conditional, or the topology lists a
+// condition or several successors for the source node. h.mu must be held.
+func (h *Hook) isConditionalEdge(span *graph.TraceSpan) bool {
+	if conditional, _ := span.Metadata[ConditionalKey].(bool); conditional {
+		return true
+	}
+	if h.topology == nil {
+		return false
+	}
+	if _, hasCondition := h.topology.ConditionalEdges[span.FromNode]; hasCondition {
+		return true
+	}
+	return len(h.topology.Edges[span.FromNode]) > 1
+}
+
+// recordBranch adds a branch decision to the run of the graph span that
+// contains the traversal. h.mu must be held.
+func (h *Hook) recordBranch(span *graph.TraceSpan) {
+	if _, inRun := h.traces[span.ParentID]; !inRun {
+		return
+	}
+	h.branches[span.ParentID] = append(h.branches[span.ParentID], BranchDecision{
+		Condition: fmt.Sprintf("%s -> %s", span.FromNode, span.ToNode),
+		From:      span.FromNode,
+		To:        span.ToNode,
+	})
+}
@@ -0,0 +1,69 @@
+package langgraph
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/paulnegz/langfuse-go/model"
+	"github.com/tmc/langgraphgo/graph"
+)
+
+const (
+	// ChunkIndexKey holds the position of a chunk recorded with RecordChunk
+	ChunkIndexKey = "chunk_index"
+	// ChunkLatencyKey holds the milliseconds since the node's previous chunk, or
+	// since the node started for its first chunk
+	ChunkLatencyKey = "chunk_latency_ms"
+)
+
+// RecordChunk records a chunk streamed or processed by a running node as an
+// event nested under the node's observation, carrying the chunk index, the
+// latency since the node's previous chunk and the content, so streaming nodes
+// get a per-chunk timeline. The node is found from the span langgraphgo's
+// TracedRunnable stores in the node's context, or else as the most recently
+// active running node named nodeName. Chunks of nodes that are not running
+// are ignored.
+//
+//	workflow.AddNode("process_chunk", func(ctx context.Context, state interface{}) (interface{}, error) {
+//	    for i, chunk := range chunks {
+//	        hook.RecordChunk(ctx, "process_chunk", i, process(chunk))
+//	    }
+//	    ...
+//	})
+func (h *Hook) RecordChunk(ctx context.Context, nodeName string, index int, content interface{}) {
+	if !h.enabled {
+		return
+	}
+
+	now := h.timeOrNow(time.Time{})
+	var spanID string
+	if span := graph.SpanFromContext(ctx); span != nil {
+		spanID = span.ID
+	}
+	spanID, run, latency, running := h.nodes.markChunk(spanID, nodeName, now)
+	if !running {
+		return
+	}
+
+	parentID := run.observationID
+	event := &model.Event{
+		ID:        uuid.New().String(),
+		TraceID:   run.traceID,
+		Name:      fmt.Sprintf("%s_chunk", run.nodeName),
+		StartTime: &now,
+		Output:    h.nodeIO(content),
+		Metadata: map[string]interface{}{
+			"node_name":     run.nodeName,
+			"graph_span_id": spanID,
+			ChunkIndexKey:   index,
+			ChunkLatencyKey: latency.Milliseconds(),
+		},
+		Version: h.nodeVersion(run.nodeName),
+	}
+	if _, err := h.client.Event(event, &parentID); err != nil {
+		log.Printf("Failed to record chunk: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package langgraph
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// FlattenedStateKey holds the non-promoted state fields of a flattened state
+const FlattenedStateKey = "_state"
+
+// defaultPromotedFields are promoted when WithStateFlattening is given no names
+var defaultPromotedFields = []string{"Response", "Output"}
+
+// flattenState promotes the named fields of a map or struct state. States of
+// other kinds, or without any promoted field, are returned unchanged.
+func flattenState(state interface{}, promoted []string) interface{} {
+	if len(promoted) == 0 {
+		return state
+	}
+
+	fields := stateFields(state)
+	if fields == nil {
+		return state
+	}
+
+	result := make(map[string]interface{})
+	rest := make(map[string]interface{})
+	for key, value := range fields {
+		if isPromoted(key, promoted) {
+			result[key] = value
+		} else {
+			rest[key] = value
+		}
+	}
+
+	if len(result) == 0 {
+		return state
+	}
+	if len(rest) > 0 {
+		result[FlattenedStateKey] = rest
+	}
+	return result
+}
+
+// stateFields returns the top-level fields of a map or struct state
+func stateFields(state interface{}) map[string]interface{} {
+	if fields, isMap := state.(map[string]interface{}); isMap {
+		return fields
+	}
+
+	v := reflect.ValueOf(state)
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct && v.Kind() != reflect.Map {
+		return nil
+	}
+
+	// Round-trip through JSON so field names match the recorded encoding
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// isPromoted reports whether key matches one of the promoted names
+func isPromoted(key string, promoted []string) bool {
+	for _, name := range promoted {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package langgraph
+
+import "testing"
+
+// Test state flattening promotes configured fields
+func TestFlattenState(t *testing.T) {
+	type response struct {
+		Content string
+	}
+	type workflowState struct {
+		Query    string
+		Response response
+		Cache    map[string]interface{}
+	}
+
+	state := workflowState{Query: "hi", Response: response{Content: "hello"}}
+	flat, isMap := flattenState(state, defaultPromotedFields).(map[string]interface{})
+	if !isMap {
+		t.Fatalf("Expected a map, got %T", flattenState(state, defaultPromotedFields))
+	}
+	if _, hasResponse := flat["Response"]; !hasResponse {
+		t.Error("Response should be promoted")
+	}
+	rest, _ := flat[FlattenedStateKey].(map[string]interface{})
+	if rest["Query"] != "hi" {
+		t.Errorf("Remaining fields should be nested under %s, got %v", FlattenedStateKey, flat)
+	}
+
+	custom := flattenState(map[string]interface{}{"answer": 42, "trace": "x"}, []string{"Answer"}).(map[string]interface{})
+	if custom["answer"] != 42 {
+		t.Errorf("Custom field should be promoted case-insensitively, got %v", custom)
+	}
+
+	if got := flattenState("plain", defaultPromotedFields); got != "plain" {
+		t.Errorf("Non-struct state should be unchanged, got %v", got)
+	}
+	if got := flattenState(map[string]interface{}{"a": 1}, defaultPromotedFields).(map[string]interface{}); got["a"] != 1 {
+		t.Errorf("State without promoted fields should be unchanged, got %v", got)
+	}
+}
@@ -2,46 +2,160 @@ package langgraph // v1.0.1 - lint fixes
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"log/slog"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	langfuse "github.com/paulnegz/langfuse-go"
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
+	"github.com/paulnegz/langfuse-go/internal/pkg/stack"
 	"github.com/paulnegz/langfuse-go/model"
 	"github.com/tmc/langgraphgo/graph"
 )
 
+// client is the subset of the Langfuse client used by the hook
+type client interface {
+	Trace(t *model.Trace, opts ...langfuse.CallOption) (*model.Trace, error)
+	Span(s *model.Span, parentID *string, opts ...langfuse.CallOption) (*model.Span, error)
+	Generation(g *model.Generation, parentID *string, opts ...langfuse.CallOption) (*model.Generation, error)
+	Event(e *model.Event, parentID *string) (*model.Event, error)
+	Flush(ctx context.Context) error
+	EndTrace(traceID string) bool
+}
+
 // Hook implements graph.TraceHook to send traces to Langfuse
 type Hook struct {
-	client       *langfuse.Langfuse
-	enabled      bool
-	traces       map[string]*model.Trace // Map graph span IDs to Langfuse traces
-	observations map[string]string       // Map node span IDs to Langfuse observation IDs
-	parents      map[string]string       // Map observation IDs to their parent IDs
-	initialInput interface{}             // Store the initial workflow input for root span
-	mu           sync.RWMutex
-	ctx          context.Context
-	config       *Config
+	client           client
+	enabled          bool
+	traces           map[string]*model.Trace // Map graph span IDs to Langfuse traces
+	observations     map[string]string       // Map graph span IDs to Langfuse root span IDs
+	initialInput     interface{}             // Store the initial workflow input for root span
+	topology         *GraphTopology          // Graph structure supplied by the caller or compiled graph
+	topologySet      bool                    // Whether the topology was supplied with SetGraphTopology
+	observed         *GraphTopology          // Graph structure accumulated from edge traversal events of the current run
+	pendingRoots     map[string]*model.Span  // Root spans whose creation failed, keyed by graph span ID
+	nodes            nodeStore               // Running nodes, keyed by node span ID, with their own locks
+	steps            map[string]int          // Last step number assigned in each Langfuse trace, guarded by stepsMu
+	stepsMu          sync.Mutex
+	activeRuns       map[string]activeRun        // Runs with a run ID, keyed by graph span ID
+	interrupted      map[string]*graphRun        // Interrupted runs awaiting resumption, keyed by run ID
+	interruptedOrder []string                    // Interrupted run IDs, oldest first
+	branches         map[string][]BranchDecision // Conditional edge decisions, keyed by graph span ID
+	timings          map[string][]NodeTiming     // Finished node executions, keyed by graph span ID
+	missingInput     bool                        // Whether a graph start without initial input was logged
+	payloads         payloadIndex                // Payloads recorded in each trace, for WithPayloadReferences
+	mu               sync.RWMutex
+	ctx              context.Context
+	config           *Config
 }
 
+// DefaultTraceName names traces when no trace name is configured or derived
+const DefaultTraceName = "langgraph_workflow"
+
 // Config holds configuration options for the hook
 type Config struct {
 	// AutoFlush enables automatic flushing of traces at graph end
 	AutoFlush bool
 	// DefaultMetadata is added to all traces
 	DefaultMetadata map[string]interface{}
+	// MetadataFromInput are dotted paths into the initial input copied into trace metadata
+	MetadataFromInput []string
 	// TraceName allows customizing the trace name
 	TraceName string
+	// TraceNameFunc derives the trace name from the initial input when TraceName is unset
+	TraceNameFunc func(state interface{}) string
+	// TraceNameFromEntryPoint names traces after the graph's entry node when TraceName is unset
+	TraceNameFromEntryPoint bool
 	// SessionID for grouping related traces
 	SessionID string
 	// UserID for identifying the user
 	UserID string
+	// UserIDFunc derives the user ID from the initial input, falling back to UserID when empty
+	UserIDFunc func(state interface{}) string
+	// SessionIDFunc derives the session ID from the initial input, falling back to SessionID when empty
+	SessionIDFunc func(state interface{}) string
+	// Version of the code run, recorded on the trace and every observation (empty uses the client's version)
+	Version string
+	// NodeVersions overrides Version for the observations of individual nodes
+	NodeVersions map[string]string
 	// Tags to add to traces
 	Tags []string
+	// Public makes traces viewable by anyone with their link
+	Public bool
+	// Clock supplies timestamps when spans carry none (defaults to the system clock)
+	Clock langfuse.Clock
+	// GraphTopology attaches the graph's nodes and edges to the root span
+	GraphTopology bool
+	// EdgeTracing records the branch chosen at each conditional edge on the root span
+	EdgeTracing bool
+	// LatencyBreakdown records the time spent in each node in the trace metadata
+	LatencyBreakdown bool
+	// TagInheritance copies trace tags onto node observations
+	TagInheritance bool
+	// InheritedTags limits the inherited trace tags to these (empty inherits all)
+	InheritedTags []string
+	// NameSanitizer rewrites node observation names (nil leaves them unchanged)
+	NameSanitizer langfuse.NameSanitizer
+	// IOScope controls where input and output payloads are recorded
+	IOScope IOScope
+	// TailSampler decides at graph end whether a buffered trace is sent (nil disables buffering)
+	TailSampler func(*langfuse.BufferedTrace) bool
+	// MaxBufferedTraces bounds the traces held for tail sampling
+	MaxBufferedTraces int
+	// PromotedStateFields are lifted to the top of recorded outputs (nil records state as is)
+	PromotedStateFields []string
+	// OutputExtractor projects the final state onto the trace output (nil records the full state)
+	OutputExtractor func(finalState interface{}) interface{}
+	// ModelNormalizer maps raw model names to canonical ones (nil records them as reported)
+	ModelNormalizer *langfuse.ModelNormalizer
+	// ProviderResolver derives the provider of AI nodes from their model name (nil records only providers nodes report)
+	ProviderResolver *langfuse.ProviderResolver
+	// CanonicalJSON serializes recorded payloads with sorted object keys
+	CanonicalJSON bool
+	// MaxInlineMediaSize uploads larger string and byte payloads as media (zero keeps them inline)
+	MaxInlineMediaSize int
+	// NodeTypeClassifier assigns observation types such as tool or retriever to nodes; embedding and generation nodes are recorded as generations (nil records non-AI nodes as spans)
+	NodeTypeClassifier func(nodeName string, metadata map[string]interface{}) langfuse.ObservationType
+	// CaptureStackTrace records the graph execution stack on errored nodes
+	CaptureStackTrace bool
+	// SkipCachedUsage records no usage for AI nodes that report a cache hit
+	SkipCachedUsage bool
+	// ModelPath locates the model name in node state or metadata (empty reads the "model" metadata key)
+	ModelPath string
+	// UsagePath locates token usage in node state or metadata (empty reads the "usage" metadata key)
+	UsagePath string
+	// MaxMetadataKeys bounds the metadata keys of each event (zero uses DefaultMaxMetadataKeys)
+	MaxMetadataKeys int
+	// MaxMetadataBytes bounds the JSON size of each event's metadata (zero uses DefaultMaxMetadataBytes)
+	MaxMetadataBytes int
+	// Logger receives the hook's notices, such as tracing being disabled (nil uses slog.Default())
+	Logger *slog.Logger
+	// SuppressDisabledLog skips the notice logged when Langfuse is not configured
+	SuppressDisabledLog bool
+	// SuppressedEvents are ignored by OnEvent, e.g. edge traversals
+	SuppressedEvents []graph.TraceEvent
+	// PayloadReferences records repeats of an input or output within a trace as references to the first copy
+	PayloadReferences bool
 }
 
+// IOScope controls which observations record input and output payloads
+type IOScope int
+
+const (
+	// IOScopeAllNodes records I/O on the trace, the root span and every node
+	IOScopeAllNodes IOScope = iota
+	// IOScopeGraphOnly records I/O on the trace and root span only; nodes are
+	// recorded without state payloads
+	IOScopeGraphOnly
+	// IOScopeNone records no input or output payloads
+	IOScopeNone
+)
+
 // Option is a functional option for configuring the hook
 type Option func(*Config)
 
@@ -52,13 +166,40 @@ func WithAutoFlush(enabled bool) Option {
 	}
 }
 
-// WithMetadata adds default metadata to all traces
+// WithMetadata sets the default metadata of all traces, replacing any set
+// before; use WithMetadataMerge to add to it
 func WithMetadata(metadata map[string]interface{}) Option {
 	return func(c *Config) {
 		c.DefaultMetadata = metadata
 	}
 }
 
+// WithMetadataFromInput copies values from the initial input into the trace
+// metadata at graph start, for filtering traces by request fields such as a
+// tenant or model. Paths are dotted, like "config.tenant_id" or
+// "$.messages[0].model", and each value is stored under the last segment of
+// its path ("tenant_id", "model"). Paths missing from the input are skipped.
+func WithMetadataFromInput(paths ...string) Option {
+	return func(c *Config) {
+		c.MetadataFromInput = append(c.MetadataFromInput, paths...)
+	}
+}
+
+// WithMetadataMerge adds metadata to the default metadata of all traces.
+// Keys already set are overwritten.
+func WithMetadataMerge(metadata map[string]interface{}) Option {
+	return func(c *Config) {
+		merged := make(map[string]interface{}, len(c.DefaultMetadata)+len(metadata))
+		for k, v := range c.DefaultMetadata {
+			merged[k] = v
+		}
+		for k, v := range metadata {
+			merged[k] = v
+		}
+		c.DefaultMetadata = merged
+	}
+}
+
 // WithTraceName sets a custom trace name
 func WithTraceName(name string) Option {
 	return func(c *Config) {
@@ -80,32 +221,340 @@ func WithUserID(id string) Option {
 	}
 }
 
-// WithTags adds tags to traces
+// WithTraceNameFunc derives the name of each trace from the workflow's initial
+// input when no trace name is set with WithTraceName
+func WithTraceNameFunc(fn func(state interface{}) string) Option {
+	return func(c *Config) {
+		c.TraceNameFunc = fn
+	}
+}
+
+// WithEntryPointTraceName names each trace after the graph's entry node when no
+// trace name is set with WithTraceName. The entry node is read from the graph
+// topology, so supply one with SetGraphTopology or TopologyFromRunnable.
+func WithEntryPointTraceName(enabled bool) Option {
+	return func(c *Config) {
+		c.TraceNameFromEntryPoint = enabled
+	}
+}
+
+// WithUserIDFunc derives the user ID of each trace from the workflow's initial input
+func WithUserIDFunc(fn func(state interface{}) string) Option {
+	return func(c *Config) {
+		c.UserIDFunc = fn
+	}
+}
+
+// WithSessionIDFunc derives the session ID of each trace from the workflow's initial input
+func WithSessionIDFunc(fn func(state interface{}) string) Option {
+	return func(c *Config) {
+		c.SessionIDFunc = fn
+	}
+}
+
+// WithTags sets the tags of traces, replacing the default "golang" and
+// "langgraph" tags; use WithTagsMerge to keep them
 func WithTags(tags []string) Option {
 	return func(c *Config) {
 		c.Tags = tags
 	}
 }
 
-// NewHook creates a new Langfuse trace hook
-func NewHook(opts ...Option) *Hook {
-	config := &Config{
+// WithTagsMerge adds tags to the trace tags, keeping the defaults and
+// dropping duplicates
+func WithTagsMerge(tags []string) Option {
+	return func(c *Config) {
+		c.Tags = mergeTags(c.Tags, tags)
+	}
+}
+
+// mergeTags appends the tags not already in base to a copy of base
+func mergeTags(base []string, tags []string) []string {
+	merged := make([]string, 0, len(base)+len(tags))
+	seen := make(map[string]bool, len(base)+len(tags))
+	for _, tag := range append(base[:len(base):len(base)], tags...) {
+		if !seen[tag] {
+			seen[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// WithClock sets the clock used for timestamps
+func WithClock(clock langfuse.Clock) Option {
+	return func(c *Config) {
+		c.Clock = clock
+	}
+}
+
+// WithGraphTopology records the graph structure in the root span metadata
+func WithGraphTopology(enabled bool) Option {
+	return func(c *Config) {
+		c.GraphTopology = enabled
+	}
+}
+
+// WithEdgeTracing records the branch chosen at each conditional edge, e.g.
+// "check_cache -> complex_ai_generation", under the branch_decisions metadata
+// key of the root span. Edges count as conditional when the traversal is
+// reported by TracedCondition or the graph topology lists a condition or
+// several successors for the source node.
+func WithEdgeTracing(enabled bool) Option {
+	return func(c *Config) {
+		c.EdgeTracing = enabled
+	}
+}
+
+// WithLatencyBreakdown records the time spent in each node, its share of the
+// run and the time no node was running under the latency_breakdown key of the
+// trace metadata at graph end, so bottlenecks stand out without manual analysis
+func WithLatencyBreakdown(enabled bool) Option {
+	return func(c *Config) {
+		c.LatencyBreakdown = enabled
+	}
+}
+
+// WithVersion records version, e.g. the release of the graph's code, on traces
+// and every observation, so regressions can be tracked per version
+func WithVersion(version string) Option {
+	return func(c *Config) {
+		c.Version = version
+	}
+}
+
+// WithNodeVersions records the version of individual nodes on their
+// observations instead of the WithVersion version, e.g. when one node's
+// implementation changed
+func WithNodeVersions(versions map[string]string) Option {
+	return func(c *Config) {
+		c.NodeVersions = versions
+	}
+}
+
+// WithPublic makes traces shareable via their link, e.g. for support tickets
+func WithPublic(public bool) Option {
+	return func(c *Config) {
+		c.Public = public
+	}
+}
+
+// WithTagInheritance copies the trace tags onto node observations so they can
+// be filtered by tag. Observations have no tags field, so they are stored in
+// the "tags" metadata key. When tags are given, only those trace tags are
+// copied.
+func WithTagInheritance(enabled bool, tags ...string) Option {
+	return func(c *Config) {
+		c.TagInheritance = enabled
+		c.InheritedTags = tags
+	}
+}
+
+// WithNameSanitizer sets the sanitizer applied to node observation names, e.g.
+// langfuse.NewNameSanitizer("_generation") to drop the generation suffix
+func WithNameSanitizer(sanitizer langfuse.NameSanitizer) Option {
+	return func(c *Config) {
+		c.NameSanitizer = sanitizer
+	}
+}
+
+// WithIOScope sets where input and output payloads are recorded (defaults to IOScopeAllNodes)
+func WithIOScope(scope IOScope) Option {
+	return func(c *Config) {
+		c.IOScope = scope
+	}
+}
+
+// WithTailSampling buffers each graph's events and sends them at graph end only
+// if keep returns true, e.g. to keep only traces that errored or ran slowly.
+// At most maxTraces traces are buffered; see langfuse.WithTraceBuffering.
+func WithTailSampling(keep func(*langfuse.BufferedTrace) bool, maxTraces int) Option {
+	return func(c *Config) {
+		c.TailSampler = keep
+		c.MaxBufferedTraces = maxTraces
+	}
+}
+
+// WithStateFlattening records node and graph outputs with the named state fields
+// (matched case-insensitively, "Response" and "Output" by default) at the top
+// level and the remaining fields nested under "_state", so the main result is
+// easy to spot in the Langfuse UI.
+func WithStateFlattening(promoted ...string) Option {
+	return func(c *Config) {
+		if len(promoted) == 0 {
+			promoted = defaultPromotedFields
+		}
+		c.PromotedStateFields = promoted
+	}
+}
+
+// WithOutputExtractor sets the trace output to a projection of the final state,
+// such as the response field of a chat workflow
+func WithOutputExtractor(fn func(finalState interface{}) interface{}) Option {
+	return func(c *Config) {
+		c.OutputExtractor = fn
+	}
+}
+
+// WithNodeTypeClassifier records the observation type of each node, e.g.
+// langfuse.ObservationTypeTool for tool calls, under the observation_type metadata key.
+// Nodes classified as langfuse.ObservationTypeEmbedding or ObservationTypeGeneration
+// are recorded as generations, so their model and token usage count towards cost,
+// e.g. for retrieval embeddings. Langfuse spans cannot carry usage. An empty result
+// records the node as a span unless it is an AI node.
+func WithNodeTypeClassifier(classify func(nodeName string, metadata map[string]interface{}) langfuse.ObservationType) Option {
+	return func(c *Config) {
+		c.NodeTypeClassifier = classify
+	}
+}
+
+// WithCaptureStackTrace records a trimmed stack trace under the stack_trace
+// metadata key of errored nodes. The stack is taken when the node error is
+// reported, so it shows the graph execution leading to the node. Defaults to false.
+func WithCaptureStackTrace(capture bool) Option {
+	return func(c *Config) {
+		c.CaptureStackTrace = capture
+	}
+}
+
+// WithPayloadReferences records each distinct input and output once per trace:
+// a payload identical to one already recorded in the trace, such as a node's
+// input repeating the previous node's output, is replaced by a
+// PayloadReference to the first copy. State-carrying workflows otherwise
+// record the same state many times over. Small payloads and payloads that
+// cannot be serialized are always inlined.
+func WithPayloadReferences(enabled bool) Option {
+	return func(c *Config) {
+		c.PayloadReferences = enabled
+	}
+}
+
+// WithSkipCachedUsage records no token usage on AI nodes that report a cache
+// hit, since nothing was generated. Cache hits are recorded either way.
+func WithSkipCachedUsage(skip bool) Option {
+	return func(c *Config) {
+		c.SkipCachedUsage = skip
+	}
+}
+
+// WithModelPath reads the model name of AI nodes from a dotted path such as
+// "llm.model", resolved against node state first and then metadata
+func WithModelPath(path string) Option {
+	return func(c *Config) {
+		c.ModelPath = path
+	}
+}
+
+// WithUsagePath reads token usage of AI nodes from a dotted path such as
+// "llm.usage", resolved against node state first and then metadata
+func WithUsagePath(path string) Option {
+	return func(c *Config) {
+		c.UsagePath = path
+	}
+}
+
+// WithProviderResolver records the provider of AI nodes, e.g. "openai" or
+// "anthropic", derived from their raw model name, for per-provider cost and
+// latency dashboards. Use langfuse.NewProviderResolver() for the built-in
+// table. Nodes reporting a "provider" in their metadata keep that provider.
+func WithProviderResolver(r *langfuse.ProviderResolver) Option {
+	return func(c *Config) {
+		c.ProviderResolver = r
+	}
+}
+
+// WithModelNormalizer maps the model names of AI nodes to canonical names, e.g.
+// langfuse.NewModelNormalizer() for the built-in OpenAI, Anthropic and Google table
+func WithModelNormalizer(n *langfuse.ModelNormalizer) Option {
+	return func(c *Config) {
+		c.ModelNormalizer = n
+	}
+}
+
+// WithCanonicalJSON records node and graph payloads in canonical JSON form, so
+// runs with equal state produce byte-identical traces (see
+// langfuse.Langfuse.WithCanonicalJSON)
+func WithCanonicalJSON(enabled bool) Option {
+	return func(c *Config) {
+		c.CanonicalJSON = enabled
+	}
+}
+
+// WithMaxInlineMediaSize uploads string and byte payloads in node input and output
+// larger than size bytes as media attachments and records @media references
+// in their place, keeping traces of nodes that handle images or documents small
+func WithMaxInlineMediaSize(size int) Option {
+	return func(c *Config) {
+		c.MaxInlineMediaSize = size
+	}
+}
+
+// WithMetadataLimits bounds the number of keys and the JSON size of the metadata
+// sent with each event. Excess keys are dropped and long strings shortened, keeping
+// SDK keys and WithMetadata keys first.
+func WithMetadataLimits(maxKeys, maxBytes int) Option {
+	return func(c *Config) {
+		c.MaxMetadataKeys = maxKeys
+		c.MaxMetadataBytes = maxBytes
+	}
+}
+
+// defaultConfig returns the configuration options are applied to
+func defaultConfig() *Config {
+	return &Config{
 		AutoFlush:       true,
 		DefaultMetadata: make(map[string]interface{}),
-		TraceName:       "langgraph_workflow",
+		TraceName:       DefaultTraceName,
 		Tags:            []string{"golang", "langgraph"},
 	}
+}
+
+// WithLogger routes the hook's notices, such as tracing being disabled, to
+// logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+// WithSuppressDisabledLog skips the notice logged when the hook is created
+// without Langfuse credentials, e.g. in tests. Check Hook.Enabled instead.
+func WithSuppressDisabledLog(suppress bool) Option {
+	return func(c *Config) {
+		c.SuppressDisabledLog = suppress
+	}
+}
+
+// WithoutEvents makes the hook ignore events of the given types, e.g.
+// graph.TraceEventEdgeTraversal, without wrapping it in a FilteredHook. Nodes
+// are recorded from their start event, so suppressing graph.TraceEventNodeStart
+// leaves nodes out of traces.
+func WithoutEvents(events ...graph.TraceEvent) Option {
+	return func(c *Config) {
+		c.SuppressedEvents = append(c.SuppressedEvents, events...)
+	}
+}
 
+// NewHook creates a new Langfuse trace hook. Tracing is disabled, with a notice
+// logged, when LANGFUSE_PUBLIC_KEY or LANGFUSE_SECRET_KEY is unset, or empty in
+// the file named by its _FILE variant.
+func NewHook(opts ...Option) *Hook {
+	config := defaultConfig()
 	for _, opt := range opts {
 		opt(config)
 	}
 
+	hook := newHook(config)
+	hook.logDisabled()
+	return hook
+}
+
+// newHook creates a hook from the environment without logging
+func newHook(config *Config) *Hook {
 	// Check if Langfuse is configured
-	publicKey := os.Getenv("LANGFUSE_PUBLIC_KEY")
-	secretKey := os.Getenv("LANGFUSE_SECRET_KEY")
+	publicKey, secretKey := api.EnvCredentials()
 
 	if publicKey == "" || secretKey == "" {
-		log.Println("Langfuse not configured, tracing disabled")
 		return &Hook{
 			enabled: false,
 			config:  config,
@@ -115,57 +564,142 @@ func NewHook(opts ...Option) *Hook {
 	// Create context and client
 	ctx := context.Background()
 	client := langfuse.New(ctx)
-
-	return &Hook{
-		client:       client,
-		enabled:      true,
-		traces:       make(map[string]*model.Trace),
-		observations: make(map[string]string),
-		parents:      make(map[string]string),
-		ctx:          ctx,
-		config:       config,
-		mu:           sync.RWMutex{},
+	if config.Clock != nil {
+		client.WithClock(config.Clock)
+	}
+	if config.CanonicalJSON {
+		client.WithCanonicalJSON(true)
+	}
+	if config.TailSampler != nil {
+		client.WithTraceBuffering(config.TailSampler, config.MaxBufferedTraces)
 	}
+
+	return newEnabledHook(ctx, client, config)
 }
 
-// NewHookWithClient creates a new hook with an existing Langfuse client
+// NewHookWithClient creates a new hook with an existing Langfuse client. The
+// client may be shared, so WithTailSampling does not enable trace buffering on
+// it: enable buffering with client.WithTraceBuffering, and WithTailSampling
+// still makes the hook end each trace at graph end.
 func NewHookWithClient(client *langfuse.Langfuse, opts ...Option) *Hook {
-	config := &Config{
-		AutoFlush:       true,
-		DefaultMetadata: make(map[string]interface{}),
-		TraceName:       "langgraph_workflow",
-		Tags:            []string{"golang", "langgraph"},
-	}
-
+	config := defaultConfig()
 	for _, opt := range opts {
 		opt(config)
 	}
 
+	if config.CanonicalJSON && client != nil {
+		client.WithCanonicalJSON(true)
+	}
+
+	return newEnabledHook(context.Background(), client, config)
+}
+
+// newEnabledHook creates a hook sending events through c
+func newEnabledHook(ctx context.Context, c client, config *Config) *Hook {
 	return &Hook{
-		client:       client,
+		client:       c,
 		enabled:      true,
 		traces:       make(map[string]*model.Trace),
 		observations: make(map[string]string),
-		parents:      make(map[string]string),
-		ctx:          context.Background(),
+		pendingRoots: make(map[string]*model.Span),
+		steps:        make(map[string]int),
+		activeRuns:   make(map[string]activeRun),
+		interrupted:  make(map[string]*graphRun),
+		branches:     make(map[string][]BranchDecision),
+		timings:      make(map[string][]NodeTiming),
+		ctx:          ctx,
 		config:       config,
 		mu:           sync.RWMutex{},
 	}
 }
 
-// SetInitialInput stores the initial workflow input for use in traces
+// Enabled reports whether the hook sends traces. Hooks created by NewHook
+// without Langfuse credentials are disabled.
+func (h *Hook) Enabled() bool {
+	return h.enabled
+}
+
+// logDisabled notes that tracing is off unless the notice is suppressed
+func (h *Hook) logDisabled() {
+	if h.enabled || h.config.SuppressDisabledLog {
+		return
+	}
+	h.logger().Info("Langfuse not configured, tracing disabled")
+}
+
+// logger returns the configured logger, or slog's default
+func (h *Hook) logger() *slog.Logger {
+	if h.config.Logger != nil {
+		return h.config.Logger
+	}
+	return slog.Default()
+}
+
+// SetInitialInput stores the initial workflow input for use in traces.
+// TracedRunnable.Invoke and Stream set it from their input, so it is only needed
+// when the graph runs through langgraphgo's own tracer.
 func (h *Hook) SetInitialInput(input interface{}) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	h.initialInput = input
 }
 
+// SetGraphTopology stores the graph structure attached to root spans when
+// WithGraphTopology is enabled. It takes precedence over the topology of the
+// graphs run through TracedRunnable.
+func (h *Hook) SetGraphTopology(topology *GraphTopology) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.topology = topology
+	h.topologySet = topology != nil
+}
+
+// setRunnableTopology stores the topology of the graph about to run, unless
+// one was supplied with SetGraphTopology
+func (h *Hook) setRunnableTopology(topology *GraphTopology) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.topologySet {
+		h.topology = topology
+	}
+}
+
+// graphTopology returns the topology attached to the root span: the known
+// topology with the branch mappings observed in this run, or else the edges
+// observed. h.mu must be held.
+func (h *Hook) graphTopology() *GraphTopology {
+	if h.topology == nil {
+		if h.observed == nil {
+			return nil
+		}
+		return h.observed.clone()
+	}
+	topology := h.topology.clone()
+	if h.observed != nil {
+		for from, targets := range h.observed.ConditionalEdges {
+			topology.AddConditionalEdge(from, targets...)
+		}
+	}
+	return topology
+}
+
 // OnEvent handles trace events and sends them to Langfuse
 func (h *Hook) OnEvent(ctx context.Context, span *graph.TraceSpan) {
-	if !h.enabled {
+	if !h.enabled || h.suppressed(span) {
 		return
 	}
 
+	// Tracing must never take down the workflow it observes
+	defer func() {
+		if r := recover(); r != nil {
+			event := graph.TraceEvent("unknown")
+			if span != nil {
+				event = span.Event
+			}
+			log.Printf("Failed to handle %s event: recovered from panic: %v", event, r)
+		}
+	}()
+
 	switch span.Event {
 	case graph.TraceEventGraphStart:
 		h.handleGraphStart(ctx, span)
@@ -176,8 +710,7 @@ func (h *Hook) OnEvent(ctx context.Context, span *graph.TraceSpan) {
 	case graph.TraceEventNodeEnd, graph.TraceEventNodeError:
 		h.handleNodeEnd(ctx, span)
 	case graph.TraceEventEdgeTraversal:
-		// Skip edge events for now
-		return
+		h.handleEdgeTraversal(span)
 	}
 }
 
@@ -186,24 +719,56 @@ func (h *Hook) handleGraphStart(ctx context.Context, span *graph.TraceSpan) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	now := h.timeOrNow(span.StartTime)
+
+	// A resumed run continues the trace of its interrupted run
+	runID, hasRunID := RunIDFromContext(ctx)
+	if hasRunID {
+		if run, paused := h.takeInterruptedRun(runID); paused {
+			h.resumeGraph(span, runID, run, now)
+			return
+		}
+	}
+
+	h.checkInitialInput()
+	// Edges observed in an earlier run do not belong to this one
+	h.observed = nil
+
 	traceID := uuid.New().String()
-	now := span.StartTime
 
 	// Merge metadata
 	metadata := make(map[string]interface{})
 	for k, v := range h.config.DefaultMetadata {
 		metadata[k] = v
 	}
+	for _, path := range h.config.MetadataFromInput {
+		if value, found := resolvePath(h.initialInput, path); found {
+			metadata[inputMetadataKey(path)] = value
+		}
+	}
 	for k, v := range span.Metadata {
 		metadata[k] = v
 	}
 	metadata["graph_span_id"] = span.ID
 	metadata["sdk"] = "langfuse-go/langgraph"
-	metadata["sdk_version"] = "1.0.0"
+	metadata["sdk_version"] = langfuse.Version
+	if hasRunID {
+		metadata["graph_run_id"] = runID
+	}
 
-	// Use configuration or metadata values
+	// Use resolved, configuration or metadata values
 	userID := h.config.UserID
+	if h.config.UserIDFunc != nil {
+		if resolved := h.config.UserIDFunc(h.initialInput); resolved != "" {
+			userID = resolved
+		}
+	}
 	sessionID := h.config.SessionID
+	if h.config.SessionIDFunc != nil {
+		if resolved := h.config.SessionIDFunc(h.initialInput); resolved != "" {
+			sessionID = resolved
+		}
+	}
 	if sessionID == "" {
 		sessionID = fmt.Sprintf("graph_%s", traceID)
 	}
@@ -214,22 +779,27 @@ func (h *Hook) handleGraphStart(ctx context.Context, span *graph.TraceSpan) {
 		sessionID = sid
 	}
 
+	traceName := h.traceName()
+	traceInput, inputDigest := h.referencePayload(traceID, h.graphIO(h.initialInput))
 	trace := &model.Trace{
 		ID:        traceID,
 		Timestamp: &now,
-		Name:      h.config.TraceName,
+		Name:      traceName,
 		UserID:    userID,
 		SessionID: sessionID,
-		Input:     h.initialInput,
-		Metadata:  metadata,
+		Input:     traceInput,
+		Metadata:  h.limitMetadata(metadata),
 		Tags:      h.config.Tags,
+		Public:    h.config.Public,
+		Version:   h.config.Version,
 	}
 
-	// Send trace to Langfuse
-	_, err := h.client.Trace(trace)
-	if err != nil {
+	// Send trace to Langfuse. Ingestion is an upsert, so on failure keep the
+	// local ID and let later events for this trace complete it.
+	if _, err := h.client.Trace(trace, h.callOptions()...); err != nil {
 		log.Printf("Failed to create Langfuse trace: %v", err)
-		return
+	} else {
+		h.rememberPayload(traceID, inputDigest, "", "input")
 	}
 
 	// Store trace for later reference
@@ -237,31 +807,64 @@ func (h *Hook) handleGraphStart(ctx context.Context, span *graph.TraceSpan) {
 
 	// Create workflow root span
 	rootSpanID := uuid.New().String()
+	rootMetadata := map[string]interface{}{
+		"graph_span_id": span.ID,
+		"sdk":           "langfuse-go/langgraph",
+		"sdk_version":   langfuse.Version,
+	}
+	if h.config.GraphTopology && h.topology != nil {
+		rootMetadata["graph_topology"] = h.topology.clone()
+	}
+
+	rootInput, rootInputDigest := h.referencePayload(traceID, h.graphIO(h.initialInput))
 	rootSpan := &model.Span{
 		ID:        rootSpanID,
 		TraceID:   traceID,
-		Name:      h.config.TraceName,
+		Name:      traceName,
 		StartTime: &now,
-		Input:     h.initialInput,
-		Metadata: map[string]interface{}{
-			"graph_span_id": span.ID,
-			"sdk":           "langfuse-go/langgraph",
-			"sdk_version":   "1.0.0",
-		},
+		Version:   h.config.Version,
+		Input:     rootInput,
+		Metadata:  h.limitMetadata(rootMetadata),
 	}
 
-	createdRootSpan, spanErr := h.client.Span(rootSpan, nil)
+	// On failure keep the locally generated ID so children still nest under it;
+	// the root span is upserted again when the graph ends
+	createdRootSpan, spanErr := h.client.Span(rootSpan, nil, h.callOptions()...)
 	if spanErr != nil {
 		log.Printf("Failed to create root span: %v", spanErr)
-	} else if createdRootSpan.ID != "" {
-		rootSpanID = createdRootSpan.ID
+		h.pendingRoots[span.ID] = rootSpan
+	} else {
+		if createdRootSpan != nil && createdRootSpan.ID != "" {
+			rootSpanID = createdRootSpan.ID
+		}
+		h.rememberPayload(traceID, rootInputDigest, rootSpanID, "input")
 	}
 
 	// Store as parent for all top-level operations
 	h.observations["langgraph_wrapper"] = rootSpanID
 	h.observations["default_parent"] = rootSpanID
 	h.observations[span.ID] = rootSpanID
-	h.parents[rootSpanID] = ""
+	if hasRunID {
+		h.activeRuns[span.ID] = activeRun{id: runID, run: &graphRun{trace: trace, rootSpanID: rootSpanID}}
+	}
+}
+
+// traceName resolves the name of a new trace: the configured name, then the
+// name derived from the initial input, then the entry node, then
+// DefaultTraceName. h.mu must be held.
+func (h *Hook) traceName() string {
+	if name := h.config.TraceName; name != "" && name != DefaultTraceName {
+		return name
+	}
+	if h.config.TraceNameFunc != nil {
+		if resolved := h.config.TraceNameFunc(h.initialInput); resolved != "" {
+			return resolved
+		}
+	}
+	if h.config.TraceNameFromEntryPoint && h.topology != nil && h.topology.EntryPoint != "" {
+		return h.topology.EntryPoint
+	}
+	return DefaultTraceName
 }
 
 // handleGraphEnd updates the trace with final information
@@ -275,28 +878,64 @@ func (h *Hook) handleGraphEnd(ctx context.Context, span *graph.TraceSpan) {
 	}
 
 	// Update trace with end time and duration
-	endTime := span.EndTime
+	endTime := h.timeOrNow(span.EndTime)
+
+	// An interrupted run with a run ID stays open until it is resumed
+	interrupted := errors.Is(span.Error, ErrInterrupted)
+	active, hasRun := h.activeRuns[span.ID]
+	delete(h.activeRuns, span.ID)
+	paused := interrupted && hasRun
 
 	// Update metadata
 	if traceMetadata, isMap := trace.Metadata.(map[string]interface{}); isMap {
 		traceMetadata["duration_ms"] = span.Duration.Milliseconds()
 		traceMetadata["status"] = "completed"
-		if span.Error != nil {
+		delete(traceMetadata, "interrupt")
+		if interrupted {
+			traceMetadata["interrupt"] = span.Error.Error()
+			traceMetadata["status"] = "interrupted"
+		} else if span.Error != nil {
 			traceMetadata["error"] = span.Error.Error()
 			traceMetadata["status"] = "error"
 		}
-		trace.Metadata = traceMetadata
+		delete(traceMetadata, LatencyBreakdownKey)
+		if timings, timed := h.timings[span.ID]; timed {
+			traceMetadata[LatencyBreakdownKey] = NewLatencyBreakdown(span.Duration, timings)
+		}
+		trace.Metadata = h.limitMetadata(traceMetadata)
+	}
+	delete(h.timings, span.ID)
+
+	// A failed run leads its outputs with the error so it stands out in the trace list
+	failed := span.Error != nil && !interrupted
+	traceOutput := h.graphIO(h.traceOutput(span.State))
+	var outputDigest string
+	if failed {
+		traceOutput = h.errorOutput(span, traceOutput)
+	} else {
+		traceOutput, outputDigest = h.referencePayload(trace.ID, traceOutput)
 	}
 
 	// Update the trace
 	_, err := h.client.Trace(&model.Trace{
 		ID:        trace.ID,
 		Timestamp: &endTime,
-		Output:    span.State,
+		Output:    traceOutput,
 		Metadata:  trace.Metadata,
-	})
+		Version:   trace.Version,
+	}, h.callOptions()...)
 	if err != nil {
 		log.Printf("Failed to update Langfuse trace: %v", err)
+	} else {
+		h.rememberPayload(trace.ID, outputDigest, "", "output")
+	}
+
+	// Retry a root span whose creation failed; the ID is unchanged so this is idempotent
+	if pending, isPending := h.pendingRoots[span.ID]; isPending {
+		if _, retryErr := h.client.Span(pending, nil, h.callOptions()...); retryErr != nil {
+			log.Printf("Failed to create root span on retry: %v", retryErr)
+		}
+		delete(h.pendingRoots, span.ID)
 	}
 
 	// Update root span
@@ -304,13 +943,71 @@ func (h *Hook) handleGraphEnd(ctx context.Context, span *graph.TraceSpan) {
 		rootSpan := &model.Span{
 			ID:      rootSpanID,
 			TraceID: trace.ID,
-			Name:    h.config.TraceName,
-			EndTime: &endTime,
-			Output:  span.State,
+			Name:    trace.Name,
+			Version: h.config.Version,
+			Output:  h.graphIO(flattenState(span.State, h.config.PromotedStateFields)),
+		}
+		if !paused {
+			rootSpan.EndTime = &endTime
+		}
+		// Traces have no level, so the root span marks the run as failed
+		var rootOutputDigest string
+		if failed {
+			rootSpan.Output = h.errorOutput(span, rootSpan.Output)
+			rootSpan.Level = model.ObservationLevelError
+			rootSpan.StatusMessage = span.Error.Error()
+		} else {
+			rootSpan.Output, rootOutputDigest = h.referencePayload(trace.ID, rootSpan.Output)
+		}
+		rootMetadata := make(map[string]interface{})
+		// Attach the edges and branch mappings observed during execution
+		if h.config.GraphTopology {
+			if topology := h.graphTopology(); topology != nil {
+				rootMetadata["graph_topology"] = topology
+			}
+		}
+		if decisions, decided := h.branches[span.ID]; decided {
+			rootMetadata[BranchDecisionsKey] = decisions
+			delete(h.branches, span.ID)
 		}
-		if _, rootErr := h.client.Span(rootSpan, nil); rootErr != nil {
+		if len(rootMetadata) > 0 {
+			rootSpan.Metadata = h.limitMetadata(rootMetadata)
+		}
+		if _, rootErr := h.client.Span(rootSpan, nil, h.callOptions()...); rootErr != nil {
 			log.Printf("Failed to update root span: %v", rootErr)
+		} else {
+			h.rememberPayload(trace.ID, rootOutputDigest, rootSpanID, "output")
+		}
+
+		if interrupted {
+			run := active.run
+			if !hasRun {
+				run = &graphRun{trace: trace, rootSpanID: rootSpanID}
+			}
+			metadata := map[string]interface{}{"graph_span_id": span.ID}
+			if hasRun {
+				metadata["graph_run_id"] = active.id
+			}
+			h.recordRunEvent(run, "graph_interrupted", endTime, nil, span.Error, metadata)
+		}
+	}
+
+	if paused {
+		h.storeInterruptedRun(active.id, active.run)
+		if h.config.AutoFlush {
+			h.client.Flush(h.ctx)
 		}
+		return
+	}
+
+	h.stepsMu.Lock()
+	delete(h.steps, trace.ID)
+	h.stepsMu.Unlock()
+	h.forgetPayloads(trace.ID)
+
+	// Release the buffered trace to the sampling decision
+	if h.config.TailSampler != nil {
+		h.client.EndTrace(trace.ID)
 	}
 
 	// Auto-flush if configured
@@ -319,60 +1016,106 @@ func (h *Hook) handleGraphEnd(ctx context.Context, span *graph.TraceSpan) {
 	}
 }
 
-// handleNodeStart creates a span for node execution
-func (h *Hook) handleNodeStart(ctx context.Context, span *graph.TraceSpan) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+// nodeParent is the trace and parent observation of a starting node
+type nodeParent struct {
+	traceID   string
+	traceTags []string
+	parentID  *string
+	step      int
+}
 
-	// Find parent trace
-	var traceID string
+// findNodeParent resolves the trace and parent observation of a starting node
+// and numbers its step. It only reads the hook's graph state, so nodes running
+// in parallel share h.mu; all other node work happens after it is released.
+func (h *Hook) findNodeParent(span *graph.TraceSpan) (nodeParent, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var trace *model.Trace
 	if span.ParentID != "" {
-		if parentTrace, traceExists := h.traces[span.ParentID]; traceExists {
-			traceID = parentTrace.ID
-		}
+		trace = h.traces[span.ParentID]
 	} else {
 		// Find the current trace
 		for _, currentTrace := range h.traces {
-			traceID = currentTrace.ID
+			trace = currentTrace
 			break
 		}
 	}
+	if trace == nil || trace.ID == "" {
+		return nodeParent{}, false
+	}
 
-	if traceID == "" {
+	parent := nodeParent{traceID: trace.ID, traceTags: trace.Tags}
+	if defaultParent, hasDefaultParent := h.observations["default_parent"]; hasDefaultParent {
+		parent.parentID = &defaultParent
+	}
+
+	// Steps number node executions in order, which timestamps cannot guarantee in fast loops
+	h.stepsMu.Lock()
+	defer h.stepsMu.Unlock()
+	h.steps[trace.ID]++
+	parent.step = h.steps[trace.ID]
+	return parent, true
+}
+
+// handleNodeStart creates a span for node execution. The hook's lock is held
+// only to look up the node's trace; the clock, the node's classification and
+// the observation are handled without it, and the running node is stored
+// under its own shard lock.
+func (h *Hook) handleNodeStart(ctx context.Context, span *graph.TraceSpan) {
+	parent, found := h.findNodeParent(span)
+	if !found {
 		return
 	}
+	traceID, parentObsID := parent.traceID, parent.parentID
 
-	spanID := uuid.New().String()
-	startTime := span.StartTime
+	nodeMetadata := map[string]interface{}{
+		"node_name":     span.NodeName,
+		"graph_span_id": span.ID,
+		"step":          parent.step,
+	}
+	if h.config.TagInheritance {
+		if tags := inheritTags(h.inheritedTags(parent.traceTags), span.Metadata); len(tags) > 0 {
+			nodeMetadata["tags"] = tags
+		}
+	}
 
-	// Check if this is an AI operation
-	isAINode := h.isAIOperation(span.NodeName)
+	spanID := uuid.New().String()
+	startTime := h.timeOrNow(span.StartTime)
 
-	// Find parent observation (used in both branches)
-	var parentObsID *string
-	var hasParent bool
-	if defaultParent, hasDefaultParent := h.observations["default_parent"]; hasDefaultParent {
-		parentObsID = &defaultParent
-		hasParent = true
+	// AI and embedding nodes are recorded as generations so they carry model and usage
+	var obsType langfuse.ObservationType
+	if h.config.NodeTypeClassifier != nil {
+		obsType = h.nodeType(span)
+	}
+	isAINode := h.isGenerationNode(span.NodeName, obsType)
+	var provider string
+	if isAINode {
+		provider = h.extractProvider(span)
 	}
+	// AI nodes are generations whatever else the classifier says, so only
+	// generation and embedding types are recorded on them
+	if obsType != "" && (!isAINode || isGenerationType(obsType)) {
+		nodeMetadata["observation_type"] = obsType
+	}
+	input, inputDigest := h.referencePayload(traceID, h.nodeIO(span.State))
 
 	if isAINode {
 		// Create generation for AI operations
 		generation := &model.Generation{
-			ID:        spanID,
-			TraceID:   traceID,
-			Name:      fmt.Sprintf("%s_generation", span.NodeName),
-			StartTime: &startTime,
-			Model:     h.extractModel(span),
-			Input:     span.State,
-			Metadata: map[string]interface{}{
-				"node_name":     span.NodeName,
-				"graph_span_id": span.ID,
-			},
+			ID:              spanID,
+			TraceID:         traceID,
+			Name:            h.generationName(span.NodeName, obsType),
+			StartTime:       &startTime,
+			Model:           h.extractModel(span),
+			Provider:        provider,
+			Input:           input,
+			Metadata:        h.limitMetadata(nodeMetadata),
 			ModelParameters: h.extractModelParams(span),
+			Version:         h.nodeVersion(span.NodeName),
 		}
 
-		createdGen, genErr := h.client.Generation(generation, parentObsID)
+		createdGen, genErr := h.client.Generation(generation, parentObsID, h.callOptions()...)
 		if genErr != nil {
 			log.Printf("Failed to create generation: %v", genErr)
 			return
@@ -380,24 +1123,19 @@ func (h *Hook) handleNodeStart(ctx context.Context, span *graph.TraceSpan) {
 		if createdGen.ID != "" {
 			spanID = createdGen.ID
 		}
-		if hasParent {
-			h.parents[spanID] = *parentObsID
-		}
 	} else {
 		// Create span for non-AI operations
 		langfuseSpan := &model.Span{
 			ID:        spanID,
 			TraceID:   traceID,
-			Name:      span.NodeName,
+			Name:      h.observationName(span.NodeName),
 			StartTime: &startTime,
-			Input:     span.State,
-			Metadata: map[string]interface{}{
-				"node_name":     span.NodeName,
-				"graph_span_id": span.ID,
-			},
+			Version:   h.nodeVersion(span.NodeName),
+			Input:     input,
+			Metadata:  h.limitMetadata(nodeMetadata),
 		}
 
-		createdSpan, spanErr := h.client.Span(langfuseSpan, parentObsID)
+		createdSpan, spanErr := h.client.Span(langfuseSpan, parentObsID, h.callOptions()...)
 		if spanErr != nil {
 			log.Printf("Failed to create span: %v", spanErr)
 			return
@@ -405,91 +1143,216 @@ func (h *Hook) handleNodeStart(ctx context.Context, span *graph.TraceSpan) {
 		if createdSpan.ID != "" {
 			spanID = createdSpan.ID
 		}
-		if hasParent {
-			h.parents[spanID] = *parentObsID
-		}
 	}
 
-	// Store observation ID
-	h.observations[span.ID] = spanID
+	h.rememberPayload(traceID, inputDigest, spanID, "input")
+
+	// Store the observation and the metadata to merge at node end
+	h.nodes.put(span.ID, &nodeRun{
+		traceID:       traceID,
+		observationID: spanID,
+		parentID:      parentObsID,
+		nodeName:      span.NodeName,
+		provider:      provider,
+		obsType:       obsType,
+		metadata:      nodeMetadata,
+		lastChunk:     startTime,
+	})
 }
 
-// handleNodeEnd updates the span/generation with completion information
+// handleNodeEnd updates the span/generation with completion information. Like
+// handleNodeStart, it runs without the hook's lock: the node is taken from its
+// shard of the running nodes.
 func (h *Hook) handleNodeEnd(ctx context.Context, span *graph.TraceSpan) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	obsID, obsExists := h.observations[span.ID]
-	if !obsExists {
+	run, running := h.nodes.take(span.ID)
+	if !running {
 		return
 	}
+	obsID, traceID, parentObsID, provider := run.observationID, run.traceID, run.parentID, run.provider
 
-	// Find parent trace
-	var traceID string
-	if span.ParentID != "" {
-		if parentTrace, traceExists := h.traces[span.ParentID]; traceExists {
-			traceID = parentTrace.ID
-		}
+	endTime := h.timeOrNow(span.EndTime)
+	if h.config.LatencyBreakdown {
+		h.recordNodeTiming(span, endTime)
 	}
 
-	if traceID == "" {
-		return
-	}
-
-	endTime := span.EndTime
-	metadata := map[string]interface{}{
-		"duration_ms": span.Duration.Milliseconds(),
-		"node_name":   span.NodeName,
+	// Ingestion replaces metadata on upsert, so resend the start metadata with the end keys.
+	// Generation updates also resend the provider, as it is stored in the replaced metadata.
+	metadata := make(map[string]interface{}, len(run.metadata)+4)
+	for k, v := range run.metadata {
+		metadata[k] = v
 	}
+	metadata["duration_ms"] = span.Duration.Milliseconds()
+	metadata["node_name"] = span.NodeName
 
 	if span.Error != nil {
 		metadata["error"] = span.Error.Error()
+		metadata["error_type"] = errorTypeName(span.Error)
+		if code, hasCode := errorCode(span.Error); hasCode {
+			metadata["error_code"] = code
+		}
 		metadata["status"] = "error"
+		if h.config.CaptureStackTrace {
+			// Skip handleNodeEnd and OnEvent
+			metadata["stack_trace"] = stack.Capture(2)
+		}
 	} else {
 		metadata["status"] = "completed"
 	}
+	cacheHit := isCacheHit(span)
+	if cacheHit {
+		metadata[CacheHitKey] = true
+	}
+	retries := retryCount(span)
+	if retries > 0 {
+		metadata[RetryCountKey] = retries
+	}
 
-	// Check if this is an AI operation
-	isAINode := h.isAIOperation(span.NodeName)
-
-	// Get parent observation ID for both cases
-	var parentObsID *string
-	if parentID, hasParentID := h.parents[obsID]; hasParentID && parentID != "" {
-		parentObsID = &parentID
+	// Errored nodes lead their output with the error so it is visible in the output pane
+	output := h.nodeIO(flattenState(span.State, h.config.PromotedStateFields))
+	var outputDigest string
+	var level model.ObservationLevel
+	var statusMessage string
+	if span.Error != nil {
+		output = h.errorOutput(span, output)
+		level = model.ObservationLevelError
+		statusMessage = span.Error.Error()
+	} else if retries > 0 {
+		// Nodes that only succeeded after retrying are flagged so flaky steps stand out
+		level = model.ObservationLevelWarning
+		statusMessage = fmt.Sprintf("succeeded after %d retries", retries)
+	}
+	if span.Error == nil {
+		output, outputDigest = h.referencePayload(traceID, output)
 	}
 
+	// The node keeps the observation type it started with
+	obsType := run.obsType
+	isAINode := h.isGenerationNode(span.NodeName, obsType)
+
 	if isAINode {
 		// Update generation
 		generation := &model.Generation{
-			ID:       obsID,
-			TraceID:  traceID,
-			Name:     fmt.Sprintf("%s_generation", span.NodeName),
-			EndTime:  &endTime,
-			Output:   span.State,
-			Metadata: metadata,
-			Usage:    h.extractUsage(span),
+			ID:            obsID,
+			TraceID:       traceID,
+			Name:          h.generationName(span.NodeName, obsType),
+			EndTime:       &endTime,
+			Output:        output,
+			Metadata:      h.limitMetadata(metadata),
+			Level:         level,
+			StatusMessage: statusMessage,
+			Version:       h.nodeVersion(span.NodeName),
+			Provider:      provider,
+		}
+		if !cacheHit || !h.config.SkipCachedUsage {
+			generation.Usage = h.extractUsage(span)
 		}
 
-		if _, genErr := h.client.Generation(generation, parentObsID); genErr != nil {
+		if _, genErr := h.client.Generation(generation, parentObsID, h.callOptions()...); genErr != nil {
 			log.Printf("Failed to update generation: %v", genErr)
+		} else {
+			h.rememberPayload(traceID, outputDigest, obsID, "output")
 		}
 	} else {
 		// Update span
 		langfuseSpan := &model.Span{
-			ID:       obsID,
-			TraceID:  traceID,
-			Name:     span.NodeName,
-			EndTime:  &endTime,
-			Output:   span.State,
-			Metadata: metadata,
+			ID:            obsID,
+			TraceID:       traceID,
+			Name:          h.observationName(span.NodeName),
+			EndTime:       &endTime,
+			Output:        output,
+			Metadata:      h.limitMetadata(metadata),
+			Level:         level,
+			StatusMessage: statusMessage,
+			Version:       h.nodeVersion(span.NodeName),
 		}
 
-		if _, spanErr := h.client.Span(langfuseSpan, parentObsID); spanErr != nil {
+		if _, spanErr := h.client.Span(langfuseSpan, parentObsID, h.callOptions()...); spanErr != nil {
 			log.Printf("Failed to update span: %v", spanErr)
+		} else {
+			h.rememberPayload(traceID, outputDigest, obsID, "output")
 		}
 	}
 }
 
+// traceOutput returns the trace output for the final state
+func (h *Hook) traceOutput(state interface{}) interface{} {
+	if h.config.OutputExtractor != nil {
+		return h.config.OutputExtractor(state)
+	}
+	return flattenState(state, h.config.PromotedStateFields)
+}
+
+// errorOutput builds the output of an errored node or graph run: the error
+// first, followed by the state when the I/O scope records it
+func (h *Hook) errorOutput(span *graph.TraceSpan, state interface{}) interface{} {
+	if h.config.IOScope == IOScopeNone {
+		return nil
+	}
+
+	errInfo := map[string]interface{}{
+		"message": span.Error.Error(),
+		"type":    errorTypeName(span.Error),
+	}
+	if code, hasCode := errorCode(span.Error); hasCode {
+		errInfo["code"] = code
+	}
+
+	output := map[string]interface{}{"error": errInfo}
+	if state != nil {
+		output["state"] = state
+	}
+	return output
+}
+
+// graphIO returns payload when the I/O scope records graph input and output
+func (h *Hook) graphIO(payload interface{}) interface{} {
+	if h.config.IOScope == IOScopeNone {
+		return nil
+	}
+	return payload
+}
+
+// nodeIO returns payload when the I/O scope records node input and output
+func (h *Hook) nodeIO(payload interface{}) interface{} {
+	if h.config.IOScope != IOScopeAllNodes {
+		return nil
+	}
+	return payload
+}
+
+// observationName applies the configured name sanitizer
+func (h *Hook) observationName(name string) string {
+	if h.config.NameSanitizer == nil {
+		return name
+	}
+	return h.config.NameSanitizer(name)
+}
+
+// handleEdgeTraversal accumulates traversed edges for the graph topology
+func (h *Hook) handleEdgeTraversal(span *graph.TraceSpan) {
+	if (!h.config.GraphTopology && !h.config.EdgeTracing) || span.FromNode == "" || span.ToNode == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.config.EdgeTracing && h.isConditionalEdge(span) {
+		h.recordBranch(span)
+	}
+	if !h.config.GraphTopology {
+		return
+	}
+
+	if h.observed == nil {
+		h.observed = NewGraphTopology()
+	}
+	h.observed.AddEdge(span.FromNode, span.ToNode)
+	if h.isConditionalEdge(span) {
+		h.observed.AddConditionalEdge(span.FromNode, span.ToNode)
+	}
+}
+
 // Flush ensures all pending events are sent
 func (h *Hook) Flush() {
 	if !h.enabled {
@@ -500,6 +1363,17 @@ func (h *Hook) Flush() {
 
 // Helper methods
 
+// timeOrNow returns t, or the current time from the configured clock when t is unset
+func (h *Hook) timeOrNow(t time.Time) time.Time {
+	if !t.IsZero() {
+		return t
+	}
+	if h.config.Clock != nil {
+		return h.config.Clock.Now()
+	}
+	return time.Now()
+}
+
 func (h *Hook) isAIOperation(nodeName string) bool {
 	// Detect AI operations based on node name patterns
 	aiPatterns := []string{
@@ -515,7 +1389,44 @@ func (h *Hook) isAIOperation(nodeName string) bool {
 	return false
 }
 
+// callOptions returns the per-call settings the hook applies to the events it
+// sends, so that a client passed to NewHookWithClient keeps its own settings
+func (h *Hook) callOptions() []langfuse.CallOption {
+	if h.config.MaxInlineMediaSize > 0 {
+		return []langfuse.CallOption{langfuse.WithCallMaxInlineSize(h.config.MaxInlineMediaSize)}
+	}
+	return nil
+}
+
 func (h *Hook) extractModel(span *graph.TraceSpan) string {
+	name := h.rawModel(span)
+	if h.config.ModelNormalizer != nil {
+		return h.config.ModelNormalizer.Normalize(name)
+	}
+	return name
+}
+
+// extractProvider returns the provider reported in the node's metadata, or the
+// one resolved from its raw model name
+func (h *Hook) extractProvider(span *graph.TraceSpan) string {
+	if provider, reported := span.Metadata[langfuse.MetadataKeyProvider].(string); reported && provider != "" {
+		return provider
+	}
+	if h.config.ProviderResolver == nil {
+		return ""
+	}
+	return h.config.ProviderResolver.Resolve(h.rawModel(span))
+}
+
+// rawModel returns the model name reported by the node or guessed from its name
+func (h *Hook) rawModel(span *graph.TraceSpan) string {
+	if h.config.ModelPath != "" {
+		if modelStr, found := resolveSpanPath(span, h.config.ModelPath); found {
+			if s, isString := modelStr.(string); isString && s != "" {
+				return s
+			}
+		}
+	}
 	// Extract model from metadata if available
 	if span.Metadata != nil {
 		if modelStr, exists := span.Metadata["model"].(string); exists {
@@ -556,6 +1467,14 @@ func (h *Hook) extractModelParams(span *graph.TraceSpan) map[string]interface{}
 }
 
 func (h *Hook) extractUsage(span *graph.TraceSpan) model.Usage {
+	if h.config.UsagePath != "" {
+		if usage, found := resolveSpanPath(span, h.config.UsagePath); found {
+			if resolved, isUsage := usageFromValue(usage); isUsage {
+				return resolved
+			}
+		}
+	}
+
 	// Extract usage from metadata if available
 	if span.Metadata != nil {
 		if usage, hasUsage := span.Metadata["usage"].(map[string]interface{}); hasUsage {
@@ -567,20 +1486,164 @@ func (h *Hook) extractUsage(span *graph.TraceSpan) model.Usage {
 			if !outputOk {
 				output = 0
 			}
-			return model.Usage{
-				Input:  input,
-				Output: output,
-				Total:  input + output,
-			}
+			return model.NewUsage(input, output)
 		}
 	}
 
 	// Return estimated usage
-	return model.Usage{
-		Input:  100,
-		Output: 200,
-		Total:  300,
+	return model.NewUsage(100, 200)
+}
+
+// isCacheHit reports whether a node signalled a cache hit through a true
+// cache_hit key in its output state or metadata
+func isCacheHit(span *graph.TraceSpan) bool {
+	value, found := resolveSpanPath(span, CacheHitKey)
+	hit, isBool := value.(bool)
+	return found && isBool && hit
+}
+
+// retryCount returns the retry count a node reported under RetryCountKey
+func retryCount(span *graph.TraceSpan) int {
+	value, found := resolveSpanPath(span, RetryCountKey)
+	if !found {
+		return 0
+	}
+	retries, isInt := toInt(value)
+	if !isInt || retries < 0 {
+		return 0
 	}
+	return retries
+}
+
+// isGenerationNode reports whether a node is recorded as a generation: AI
+// nodes, and nodes classified as generations or embeddings
+func (h *Hook) isGenerationNode(nodeName string, obsType langfuse.ObservationType) bool {
+	return isGenerationType(obsType) || h.isAIOperation(nodeName)
+}
+
+// isGenerationType reports whether nodes of obsType are recorded as generations
+func isGenerationType(obsType langfuse.ObservationType) bool {
+	return obsType == langfuse.ObservationTypeGeneration || obsType == langfuse.ObservationTypeEmbedding
+}
+
+// generationName names the generation of a node after its observation type
+func (h *Hook) generationName(nodeName string, obsType langfuse.ObservationType) string {
+	if obsType == langfuse.ObservationTypeEmbedding {
+		return h.observationName(fmt.Sprintf("%s_embedding", nodeName))
+	}
+	return h.observationName(fmt.Sprintf("%s_generation", nodeName))
+}
+
+// nodeType classifies a node, defaulting to a span
+func (h *Hook) nodeType(span *graph.TraceSpan) langfuse.ObservationType {
+	if obsType := h.config.NodeTypeClassifier(span.NodeName, span.Metadata); obsType != "" {
+		return obsType
+	}
+	return langfuse.ObservationTypeSpan
+}
+
+// usageFromValue reads input and output token counts from a map or struct,
+// accepting Langfuse, OpenAI and Anthropic field names
+func usageFromValue(value interface{}) (model.Usage, bool) {
+	count := func(keys ...string) (int, bool) {
+		for _, key := range keys {
+			if raw, found := resolvePath(value, key); found {
+				if n, isNumber := toInt(raw); isNumber {
+					return n, true
+				}
+			}
+		}
+		return 0, false
+	}
+
+	input, hasInput := count("input", "prompt_tokens", "input_tokens", "promptTokens")
+	output, hasOutput := count("output", "completion_tokens", "output_tokens", "completionTokens")
+	if !hasInput && !hasOutput {
+		return model.Usage{}, false
+	}
+	usage := model.NewUsage(input, output)
+	if total, hasTotal := count("total", "total_tokens", "totalTokens"); hasTotal {
+		usage.Total = total
+	}
+	return usage, true
+}
+
+// inheritedTags returns the trace tags node observations inherit
+func (h *Hook) inheritedTags(traceTags []string) []string {
+	if len(h.config.InheritedTags) == 0 {
+		return traceTags
+	}
+	var inherited []string
+	for _, tag := range traceTags {
+		if slices.Contains(h.config.InheritedTags, tag) {
+			inherited = append(inherited, tag)
+		}
+	}
+	return inherited
+}
+
+// inheritTags merges trace tags with the node's own "tags" metadata,
+// keeping the node's tags first and dropping duplicates
+func inheritTags(traceTags []string, nodeMetadata map[string]interface{}) []string {
+	var own []string
+	switch tags := nodeMetadata["tags"].(type) {
+	case []string:
+		own = tags
+	case []interface{}:
+		for _, tag := range tags {
+			if s, isString := tag.(string); isString {
+				own = append(own, s)
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(own)+len(traceTags))
+	merged := make([]string, 0, len(own)+len(traceTags))
+	for _, tags := range [][]string{own, traceTags} {
+		for _, tag := range tags {
+			if !seen[tag] {
+				seen[tag] = true
+				merged = append(merged, tag)
+			}
+		}
+	}
+	return merged
+}
+
+// ErrorCoder is implemented by errors that expose a machine-readable code
+type ErrorCoder interface {
+	Code() string
+}
+
+// errorTypeName returns the Go type of the most specific error in the chain,
+// skipping generic wrappers such as those created by fmt.Errorf("%w")
+func errorTypeName(err error) string {
+	var typeName string
+	for current := err; current != nil; current = errors.Unwrap(current) {
+		typeName = fmt.Sprintf("%T", current)
+		if !isGenericErrorType(typeName) {
+			return typeName
+		}
+	}
+	return typeName
+}
+
+func isGenericErrorType(typeName string) bool {
+	switch typeName {
+	case "*fmt.wrapError", "*fmt.wrapErrors", "*errors.joinError":
+		return true
+	default:
+		return false
+	}
+}
+
+// errorCode returns the code of the first error in the chain implementing ErrorCoder
+func errorCode(err error) (string, bool) {
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		return coder.Code(), true
+	}
+	return "", false
 }
 
 func containsIgnoreCase(s, substr string) bool {
@@ -613,3 +1676,34 @@ func toLowerCase(s string) string {
 	}
 	return string(result)
 }
+
+// nodeVersion returns the version recorded on a node's observations
+func (h *Hook) nodeVersion(node string) string {
+	if version, set := h.config.NodeVersions[node]; set {
+		return version
+	}
+	return h.config.Version
+}
+
+// checkInitialInput warns once when a graph starts without an initial input,
+// which leaves the trace and root span without input. h.mu must be held.
+func (h *Hook) checkInitialInput() {
+	if h.initialInput != nil || h.missingInput {
+		return
+	}
+	h.missingInput = true
+	h.logger().Warn("Graph started without an initial input; invoke it through langgraph.TracedRunnable or call Hook.SetInitialInput")
+}
+
+// suppressed reports whether span's event type is configured to be ignored
+func (h *Hook) suppressed(span *graph.TraceSpan) bool {
+	if span == nil {
+		return false
+	}
+	for _, event := range h.config.SuppressedEvents {
+		if span.Event == event {
+			return true
+		}
+	}
+	return false
+}
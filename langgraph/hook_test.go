@@ -1,11 +1,22 @@
 package langgraph
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	langfuse "github.com/paulnegz/langfuse-go"
+	"github.com/paulnegz/langfuse-go/model"
 	"github.com/tmc/langgraphgo/graph"
 )
 
@@ -33,6 +44,92 @@ func (m *MockLangfuseClient) Flush() {
 	m.flushed = true
 }
 
+// fakeClient records events sent by the hook and can fail span calls
+type fakeClient struct {
+	mu          sync.Mutex
+	beforeSpan  func() // called outside mu at the start of each Span call
+	traces      []*model.Trace
+	spans       []*model.Span
+	generations []*model.Generation
+	events      []*model.Event
+	parents     map[string]string
+	failSpans   int
+	endedTraces []string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{parents: make(map[string]string)}
+}
+
+func (f *fakeClient) Trace(t *model.Trace, _ ...langfuse.CallOption) (*model.Trace, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.traces = append(f.traces, t)
+	return t, nil
+}
+
+func (f *fakeClient) Span(s *model.Span, parentID *string, _ ...langfuse.CallOption) (*model.Span, error) {
+	if f.beforeSpan != nil {
+		f.beforeSpan()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failSpans > 0 {
+		f.failSpans--
+		return nil, errors.New("ingestion unavailable")
+	}
+	f.spans = append(f.spans, s)
+	if parentID != nil {
+		f.parents[s.ID] = *parentID
+	}
+	return s, nil
+}
+
+func (f *fakeClient) Generation(g *model.Generation, parentID *string, _ ...langfuse.CallOption) (*model.Generation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.generations = append(f.generations, g)
+	if parentID != nil {
+		f.parents[g.ID] = *parentID
+	}
+	return g, nil
+}
+
+func (f *fakeClient) Event(e *model.Event, parentID *string) (*model.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, e)
+	if parentID != nil {
+		f.parents[e.ID] = *parentID
+	}
+	return e, nil
+}
+
+func (f *fakeClient) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeClient) EndTrace(traceID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.endedTraces = append(f.endedTraces, traceID)
+	return true
+}
+
+// newTestHook creates an enabled hook backed by a fake client
+func newTestHook(opts ...Option) (*Hook, *fakeClient) {
+	config := &Config{
+		DefaultMetadata: make(map[string]interface{}),
+		TraceName:       "test_workflow",
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	fake := newFakeClient()
+	return newEnabledHook(context.Background(), fake, config), fake
+}
+
 // Test hook creation
 func TestNewHook(t *testing.T) {
 	tests := []struct {
@@ -117,6 +214,34 @@ func TestTraceHookBuilder(t *testing.T) {
 	}
 }
 
+// Test that merged tags and metadata add to the defaults
+func TestTagsAndMetadataMerge(t *testing.T) {
+	hook := NewBuilder().
+		WithTagsMerge("production", "langgraph").
+		WithMetadataMerge(map[string]interface{}{"team": "search"}).
+		WithMetadataMerge(map[string]interface{}{"tier": "gold"}).
+		Build()
+
+	wantTags := []string{"golang", "langgraph", "production"}
+	if len(hook.config.Tags) != len(wantTags) {
+		t.Fatalf("Tags: got %v, want %v", hook.config.Tags, wantTags)
+	}
+	for i, tag := range wantTags {
+		if hook.config.Tags[i] != tag {
+			t.Errorf("Tags: got %v, want %v", hook.config.Tags, wantTags)
+			break
+		}
+	}
+	if hook.config.DefaultMetadata["team"] != "search" || hook.config.DefaultMetadata["tier"] != "gold" {
+		t.Errorf("Expected merged metadata, got %v", hook.config.DefaultMetadata)
+	}
+
+	replaced := NewHook(WithTags([]string{"custom"}))
+	if len(replaced.config.Tags) != 1 || replaced.config.Tags[0] != "custom" {
+		t.Errorf("Expected WithTags to replace the defaults, got %v", replaced.config.Tags)
+	}
+}
+
 // Test SetInitialInput
 func TestSetInitialInput(t *testing.T) {
 	hook := NewHook()
@@ -234,6 +359,341 @@ func TestExtractModel(t *testing.T) {
 	}
 }
 
+// fixedClock always returns the same time
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// Test clock injection
+func TestWithClock(t *testing.T) {
+	fixed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	hook := NewHook(WithClock(fixedClock{now: fixed}))
+
+	if got := hook.timeOrNow(time.Time{}); !got.Equal(fixed) {
+		t.Errorf("timeOrNow(zero): got %v, want %v", got, fixed)
+	}
+
+	spanTime := fixed.Add(time.Hour)
+	if got := hook.timeOrNow(spanTime); !got.Equal(spanTime) {
+		t.Errorf("timeOrNow(set): got %v, want %v", got, spanTime)
+	}
+
+	built := NewBuilder().WithClock(fixedClock{now: fixed}).Build()
+	if got := built.timeOrNow(time.Time{}); !got.Equal(fixed) {
+		t.Errorf("builder timeOrNow(zero): got %v, want %v", got, fixed)
+	}
+}
+
+// codedError is an error exposing a code
+type codedError struct {
+	code string
+}
+
+func (e *codedError) Error() string {
+	return "coded failure"
+}
+
+func (e *codedError) Code() string {
+	return e.code
+}
+
+// Test error categorization
+func TestErrorCategorization(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedType string
+		expectedCode string
+		hasCode      bool
+	}{
+		{
+			name:         "Plain error",
+			err:          errors.New("boom"),
+			expectedType: "*errors.errorString",
+		},
+		{
+			name:         "Coded error",
+			err:          &codedError{code: "RATE_LIMITED"},
+			expectedType: "*langgraph.codedError",
+			expectedCode: "RATE_LIMITED",
+			hasCode:      true,
+		},
+		{
+			name:         "Wrapped coded error",
+			err:          fmt.Errorf("node failed: %w", &codedError{code: "TIMEOUT"}),
+			expectedType: "*langgraph.codedError",
+			expectedCode: "TIMEOUT",
+			hasCode:      true,
+		},
+		{
+			name:         "Wrapped context error",
+			err:          fmt.Errorf("node failed: %w", context.DeadlineExceeded),
+			expectedType: "context.deadlineExceededError",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorTypeName(tt.err); got != tt.expectedType {
+				t.Errorf("errorTypeName: got %v, want %v", got, tt.expectedType)
+			}
+			code, hasCode := errorCode(tt.err)
+			if hasCode != tt.hasCode || code != tt.expectedCode {
+				t.Errorf("errorCode: got (%v, %v), want (%v, %v)", code, hasCode, tt.expectedCode, tt.hasCode)
+			}
+		})
+	}
+}
+
+// Test tag inheritance merging
+func TestInheritTags(t *testing.T) {
+	traceTags := []string{"golang", "langgraph"}
+
+	got := inheritTags(traceTags, nil)
+	if len(got) != 2 || got[0] != "golang" || got[1] != "langgraph" {
+		t.Errorf("inheritTags(nil): got %v, want %v", got, traceTags)
+	}
+
+	got = inheritTags(traceTags, map[string]interface{}{
+		"tags": []interface{}{"retrieval", "golang"},
+	})
+	want := []string{"retrieval", "golang", "langgraph"}
+	if len(got) != len(want) {
+		t.Fatalf("inheritTags(own): got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("inheritTags(own)[%d]: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// Test that node observations carry the inherited trace tags
+func TestTagInheritance(t *testing.T) {
+	ctx := context.Background()
+	nodeTags := func(t *testing.T, opts ...Option) interface{} {
+		t.Helper()
+		hook, client := newTestHook(append([]Option{WithTags([]string{"team-a", "prod", "beta"})}, opts...)...)
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+		hook.OnEvent(ctx, &graph.TraceSpan{
+			ID:       "node-1",
+			ParentID: "graph-1",
+			Event:    graph.TraceEventNodeStart,
+			NodeName: "fetch",
+			Metadata: map[string]interface{}{"tags": []interface{}{"retrieval", "prod"}},
+		})
+		for _, span := range client.spans {
+			if span.Name == "fetch" {
+				metadata, _ := span.Metadata.(map[string]interface{})
+				return metadata["tags"]
+			}
+		}
+		t.Fatal("Expected a node span")
+		return nil
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want interface{}
+	}{
+		{"Disabled", nil, nil},
+		{"All trace tags", []Option{WithTagInheritance(true)}, []string{"retrieval", "prod", "team-a", "beta"}},
+		{"Subset", []Option{WithTagInheritance(true, "team-a")}, []string{"retrieval", "prod", "team-a"}},
+		{"Subset of absent tags", []Option{WithTagInheritance(true, "staging")}, []string{"retrieval", "prod"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeTags(t, tt.opts...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected node tags %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// Test children nest under the root span even when its creation fails
+func TestRootSpanCreationFailure(t *testing.T) {
+	hook, fake := newTestHook()
+	fake.failSpans = 1
+	ctx := context.Background()
+
+	graphSpan := &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()}
+	hook.OnEvent(ctx, graphSpan)
+
+	rootSpanID := hook.observations["default_parent"]
+	if rootSpanID == "" {
+		t.Fatal("Root span ID should be kept after a failed create")
+	}
+
+	nodeSpan := &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "process", StartTime: time.Now()}
+	hook.OnEvent(ctx, nodeSpan)
+
+	if len(fake.spans) != 1 {
+		t.Fatalf("Expected one node span, got %d", len(fake.spans))
+	}
+	if got := fake.parents[fake.spans[0].ID]; got != rootSpanID {
+		t.Errorf("Node parent: got %v, want %v", got, rootSpanID)
+	}
+
+	graphSpan.Event = graph.TraceEventGraphEnd
+	graphSpan.EndTime = time.Now()
+	hook.OnEvent(ctx, graphSpan)
+
+	retried := false
+	for _, s := range fake.spans {
+		if s.ID == rootSpanID && s.StartTime != nil {
+			retried = true
+		}
+	}
+	if !retried {
+		t.Error("Root span should be re-sent with its start time at graph end")
+	}
+	if len(hook.pendingRoots) != 0 {
+		t.Error("Pending root spans should be cleared after retry")
+	}
+}
+
+// Test node observation names pass through the sanitizer
+func TestNameSanitizer(t *testing.T) {
+	hook, fake := newTestHook(WithNameSanitizer(langfuse.NewNameSanitizer("_generation")))
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "llm", StartTime: time.Now()})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-2", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "tools/search", StartTime: time.Now()})
+
+	if len(fake.generations) != 1 || fake.generations[0].Name != "llm" {
+		t.Errorf("Generation name: got %+v, want llm", fake.generations)
+	}
+
+	run, _ := hook.nodes.get("node-2")
+	var nodeSpan *model.Span
+	for _, s := range fake.spans {
+		if run != nil && s.ID == run.observationID {
+			nodeSpan = s
+		}
+	}
+	if nodeSpan == nil || nodeSpan.Name != "search" {
+		t.Errorf("Span name: got %+v, want search", nodeSpan)
+	}
+
+	if got := langfuse.DefaultNameSanitizer("github.com/org/repo/pkg.Handler"); got != "pkg.Handler" {
+		t.Errorf("DefaultNameSanitizer: got %v, want pkg.Handler", got)
+	}
+}
+
+// Test I/O payloads are recorded according to the scope
+func TestIOScope(t *testing.T) {
+	tests := []struct {
+		scope     IOScope
+		wantGraph bool
+		wantNode  bool
+	}{
+		{IOScopeAllNodes, true, true},
+		{IOScopeGraphOnly, true, false},
+		{IOScopeNone, false, false},
+	}
+
+	for _, tt := range tests {
+		hook, fake := newTestHook(WithIOScope(tt.scope))
+		hook.SetInitialInput("question")
+		ctx := context.Background()
+		state := map[string]interface{}{"answer": "42"}
+
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "process", State: state})
+
+		if got := fake.traces[0].Input != nil; got != tt.wantGraph {
+			t.Errorf("scope %d: trace input recorded = %v, want %v", tt.scope, got, tt.wantGraph)
+		}
+		if got := fake.spans[0].Input != nil; got != tt.wantGraph {
+			t.Errorf("scope %d: root span input recorded = %v, want %v", tt.scope, got, tt.wantGraph)
+		}
+		if got := fake.spans[1].Input != nil; got != tt.wantNode {
+			t.Errorf("scope %d: node input recorded = %v, want %v", tt.scope, got, tt.wantNode)
+		}
+	}
+}
+
+// Test the buffered trace is released to the sampler at graph end
+func TestTailSampling(t *testing.T) {
+	keepErrors := func(trace *langfuse.BufferedTrace) bool { return trace.Errored }
+	ctx := context.Background()
+
+	hook, fake := newTestHook(WithTailSampling(keepErrors, 10))
+	graphSpan := &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()}
+	hook.OnEvent(ctx, graphSpan)
+	graphSpan.Event = graph.TraceEventGraphEnd
+	hook.OnEvent(ctx, graphSpan)
+
+	if len(fake.endedTraces) != 1 || fake.endedTraces[0] != fake.traces[0].ID {
+		t.Errorf("EndTrace calls: got %v, want [%s]", fake.endedTraces, fake.traces[0].ID)
+	}
+
+	untouched, untouchedFake := newTestHook()
+	untouched.OnEvent(ctx, &graph.TraceSpan{ID: "graph-2", Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+	untouched.OnEvent(ctx, &graph.TraceSpan{ID: "graph-2", Event: graph.TraceEventGraphEnd})
+	if len(untouchedFake.endedTraces) != 0 {
+		t.Error("EndTrace should not be called without tail sampling")
+	}
+}
+
+// Test that a client passed to the hook keeps its own buffering settings
+func TestTailSamplingSharedClient(t *testing.T) {
+	recorder := langfuse.NewObserverRecorder()
+	dropAll := func(*langfuse.BufferedTrace) bool { return false }
+	hook := NewHookWithClient(recorder.Client(), WithTailSampling(dropAll, 10))
+
+	ctx := context.Background()
+	graphSpan := &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()}
+	hook.OnEvent(ctx, graphSpan)
+	graphSpan.Event = graph.TraceEventGraphEnd
+	hook.OnEvent(ctx, graphSpan)
+
+	if traces := recorder.Traces(); len(traces) == 0 {
+		t.Error("Expected the shared client to send the trace unbuffered")
+	}
+}
+
+// Test errored nodes surface the error in their output
+func TestNodeErrorOutput(t *testing.T) {
+	hook, fake := newTestHook()
+	ctx := context.Background()
+	state := map[string]interface{}{"step": 2}
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "process"})
+	hook.OnEvent(ctx, &graph.TraceSpan{
+		ID:       "node-1",
+		ParentID: "graph-1",
+		Event:    graph.TraceEventNodeError,
+		NodeName: "process",
+		State:    state,
+		Error:    &codedError{code: "TIMEOUT"},
+	})
+
+	ended := fake.spans[len(fake.spans)-1]
+	if ended.Level != model.ObservationLevelError || ended.StatusMessage != "coded failure" {
+		t.Errorf("Level/status: got (%v, %v), want (ERROR, coded failure)", ended.Level, ended.StatusMessage)
+	}
+
+	output, isMap := ended.Output.(map[string]interface{})
+	if !isMap {
+		t.Fatalf("Output should be a map, got %T", ended.Output)
+	}
+	errInfo, _ := output["error"].(map[string]interface{})
+	if errInfo["message"] != "coded failure" || errInfo["code"] != "TIMEOUT" {
+		t.Errorf("Output error: got %v", output["error"])
+	}
+	if output["state"] == nil {
+		t.Error("Output should keep the node state")
+	}
+}
+
 // Test event filter
 func TestFilteredHook(t *testing.T) {
 	baseHook := &MockTraceHook{
@@ -414,93 +874,1460 @@ func TestTracedRunnable(t *testing.T) {
 	}
 }
 
-// MockRunnable for testing
-type MockRunnable struct {
-	result interface{}
-	err    error
-}
+// Test that plain functions are traced as a single-node graph
+func TestRunnableFunc(t *testing.T) {
+	t.Run("Synthesized events", func(t *testing.T) {
+		mockHook := &MockTraceHook{events: []graph.TraceEvent{}}
+		fn := RunnableFunc(func(ctx context.Context, input interface{}) (interface{}, error) {
+			return fmt.Sprintf("%v!", input), nil
+		})
 
-func (m *MockRunnable) Invoke(ctx context.Context, input interface{}) (interface{}, error) {
-	return m.result, m.err
-}
+		result, err := NewTracedRunnable(fn, mockHook).Invoke(context.Background(), "hi")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result != "hi!" {
+			t.Errorf("Result: got %v, want hi!", result)
+		}
 
-func (m *MockRunnable) Stream(ctx context.Context, input interface{}) (<-chan interface{}, <-chan error) {
-	outputChan := make(chan interface{}, 1)
-	errorChan := make(chan error, 1)
+		want := []graph.TraceEvent{
+			graph.TraceEventGraphStart,
+			graph.TraceEventNodeStart,
+			graph.TraceEventNodeEnd,
+			graph.TraceEventGraphEnd,
+		}
+		if fmt.Sprint(mockHook.events) != fmt.Sprint(want) {
+			t.Errorf("Events: got %v, want %v", mockHook.events, want)
+		}
+	})
 
-	if m.err != nil {
-		errorChan <- m.err
-	} else {
-		outputChan <- m.result
-	}
+	t.Run("Langfuse trace", func(t *testing.T) {
+		hook, client := newTestHook()
+		fn := RunnableFunc(func(ctx context.Context, input interface{}) (interface{}, error) {
+			return nil, errors.New("boom")
+		})
 
-	close(outputChan)
-	close(errorChan)
+		if _, err := NewTracedRunnable(fn, hook).Invoke(context.Background(), "hi"); err == nil {
+			t.Fatal("Expected the function's error")
+		}
 
-	return outputChan, errorChan
+		if len(client.traces) == 0 {
+			t.Fatal("Expected a trace")
+		}
+		var node *model.Span
+		for _, span := range client.spans {
+			if span.Name == defaultRunnableNodeName && span.Level == model.ObservationLevelError {
+				node = span
+			}
+		}
+		if node == nil {
+			t.Error("Expected an errored span for the synthesized node")
+		}
+	})
 }
 
-// Benchmark tests
-func BenchmarkHookOnEvent(b *testing.B) {
-	hook := NewHook()
-	ctx := context.Background()
-
-	span := &graph.TraceSpan{
-		ID:        uuid.New().String(),
-		Event:     graph.TraceEventNodeStart,
-		NodeName:  "benchmark_node",
-		StartTime: time.Now(),
-		State:     map[string]interface{}{"key": "value"},
-	}
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		hook.OnEvent(ctx, span)
-	}
+// panicClient panics when the hook creates spans
+type panicClient struct {
+	*fakeClient
 }
 
-func BenchmarkFilteredHook(b *testing.B) {
-	baseHook := NewHook()
-	filter := EventFilter{
-		IncludeEvents: []graph.TraceEvent{
-			graph.TraceEventNodeStart,
-			graph.TraceEventNodeEnd,
-		},
-		MinDuration: 10 * time.Millisecond,
-	}
+func (p *panicClient) Span(s *model.Span, parentID *string, _ ...langfuse.CallOption) (*model.Span, error) {
+	panic("unexpected span")
+}
 
-	filteredHook := NewFilteredHook(baseHook, filter)
+// Test that panics in handlers do not escape OnEvent
+func TestHookRecoversFromPanics(t *testing.T) {
 	ctx := context.Background()
 
-	span := &graph.TraceSpan{
-		Event:     graph.TraceEventNodeEnd,
-		Duration:  100 * time.Millisecond,
-		NodeName:  "benchmark_node",
-		StartTime: time.Now(),
-	}
+	t.Run("Nil span", func(t *testing.T) {
+		hook, _ := newTestHook()
+		hook.OnEvent(ctx, nil)
+	})
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		filteredHook.OnEvent(ctx, span)
-	}
-}
+	t.Run("Malformed spans", func(t *testing.T) {
+		hook, _ := newTestHook()
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "orphan", Event: graph.TraceEventGraphEnd, State: func() {}})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "orphan-node", Event: graph.TraceEventNodeEnd, State: (*struct{ A int })(nil)})
+		hook.OnEvent(ctx, &graph.TraceSpan{Event: graph.TraceEventEdgeTraversal})
+	})
 
-func BenchmarkMultiHook(b *testing.B) {
-	hook1 := NewHook()
-	hook2 := NewHook()
-	hook3 := NewHook()
+	t.Run("Panicking client", func(t *testing.T) {
+		config := &Config{DefaultMetadata: make(map[string]interface{}), TraceName: "test_workflow"}
+		hook := newEnabledHook(ctx, &panicClient{fakeClient: newFakeClient()}, config)
 
-	multiHook := NewMultiHook(hook1, hook2, hook3)
+		start := time.Now()
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: start})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "fetch", StartTime: start})
+
+		// The hook must remain usable after a recovered panic
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "fetch", StartTime: start, EndTime: start})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd, StartTime: start, EndTime: start})
+	})
+}
+
+// Test that metadata is capped before sending
+func TestMetadataLimits(t *testing.T) {
 	ctx := context.Background()
 
-	span := &graph.TraceSpan{
-		Event:     graph.TraceEventNodeStart,
-		NodeName:  "benchmark_node",
-		StartTime: time.Now(),
-	}
+	t.Run("Key limit keeps SDK and configured keys", func(t *testing.T) {
+		hook, client := newTestHook(
+			WithMetadata(map[string]interface{}{"team": "search"}),
+			WithMetadataLimits(10, 0),
+		)
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
+		runaway := make(map[string]interface{})
+		for i := 0; i < 500; i++ {
+			runaway[fmt.Sprintf("iteration_%03d", i)] = i
+		}
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, Metadata: runaway})
+
+		metadata := client.traces[0].Metadata.(map[string]interface{})
+		if len(metadata) > 10 {
+			t.Errorf("Expected at most 10 keys, got %d", len(metadata))
+		}
+		for _, key := range []string{"team", "sdk", "graph_span_id"} {
+			if _, kept := metadata[key]; !kept {
+				t.Errorf("Expected priority key %q to be kept", key)
+			}
+		}
+		marker, marked := metadata[MetadataTruncatedKey].(map[string]int)
+		if !marked || marker["dropped_keys"] == 0 {
+			t.Errorf("Expected a truncation marker, got %v", metadata[MetadataTruncatedKey])
+		}
+	})
+
+	t.Run("Size limit truncates strings", func(t *testing.T) {
+		hook, _ := newTestHook(WithMetadataLimits(0, 1024))
+
+		limited := hook.limitMetadata(map[string]interface{}{
+			"sdk":    "langfuse-go/langgraph",
+			"prompt": strings.Repeat("x", 10000),
+		})
+
+		encoded, err := json.Marshal(limited)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if len(encoded) > 1024 {
+			t.Errorf("Expected at most 1024 bytes, got %d", len(encoded))
+		}
+		if prompt, _ := limited["prompt"].(string); !strings.HasSuffix(prompt, "[truncated]") {
+			t.Errorf("Expected a truncated prompt, got %d bytes", len(prompt))
+		}
+	})
+
+	t.Run("Unencodable values are dropped", func(t *testing.T) {
+		hook, _ := newTestHook(WithMetadataLimits(0, 1024))
+
+		limited := hook.limitMetadata(map[string]interface{}{
+			"sdk":      "langfuse-go/langgraph",
+			"channel":  make(chan int),
+			"callback": func() {},
+		})
+
+		if _, err := json.Marshal(limited); err != nil {
+			t.Fatalf("Expected the limited metadata to encode, got %v", err)
+		}
+		if _, kept := limited["channel"]; kept {
+			t.Error("Expected the channel value to be dropped")
+		}
+		if _, kept := limited["callback"]; kept {
+			t.Error("Expected the func value to be dropped")
+		}
+		if limited["sdk"] != "langfuse-go/langgraph" {
+			t.Errorf("Expected encodable values to be kept, got %v", limited)
+		}
+		if marker, _ := limited[MetadataTruncatedKey].(map[string]int); marker["dropped_keys"] != 2 {
+			t.Errorf("Expected 2 dropped keys, got %v", limited[MetadataTruncatedKey])
+		}
+	})
+
+	t.Run("Root span topology", func(t *testing.T) {
+		hook, client := newTestHook(WithGraphTopology(true), WithMetadataLimits(0, 1024))
+		topology := NewGraphTopology()
+		for i := 0; i < 100; i++ {
+			topology.AddEdge(fmt.Sprintf("node_%03d", i), fmt.Sprintf("node_%03d", i+1))
+		}
+		hook.SetGraphTopology(topology)
+
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+
+		if len(client.spans) == 0 {
+			t.Fatal("Expected a root span")
+		}
+		encoded, err := json.Marshal(client.spans[0].Metadata)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if len(encoded) > 1024 {
+			t.Errorf("Expected the root span metadata capped at 1024 bytes, got %d", len(encoded))
+		}
+	})
+
+	t.Run("Within limits", func(t *testing.T) {
+		hook, _ := newTestHook()
+		metadata := map[string]interface{}{"a": 1, "b": "two"}
+
+		limited := hook.limitMetadata(metadata)
+		if len(limited) != 2 {
+			t.Errorf("Expected metadata unchanged, got %v", limited)
+		}
+	})
+}
+
+// Test that user and session IDs are resolved from the initial input
+func TestIDResolvers(t *testing.T) {
+	ctx := context.Background()
+	fromInput := func(key string) func(state interface{}) string {
+		return func(state interface{}) string {
+			if m, isMap := state.(map[string]interface{}); isMap {
+				id, _ := m[key].(string)
+				return id
+			}
+			return ""
+		}
+	}
+
+	hook, client := newTestHook(
+		WithUserID("static-user"),
+		WithSessionID("static-session"),
+		WithUserIDFunc(fromInput("user")),
+		WithSessionIDFunc(fromInput("session")),
+	)
+
+	hook.SetInitialInput(map[string]interface{}{"user": "alice", "session": "s-1"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+
+	hook.SetInitialInput(map[string]interface{}{"user": "bob"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-2", Event: graph.TraceEventGraphStart})
+
+	if got := client.traces[0]; got.UserID != "alice" || got.SessionID != "s-1" {
+		t.Errorf("First trace: got user %q session %q, want alice s-1", got.UserID, got.SessionID)
+	}
+	if got := client.traces[1]; got.UserID != "bob" || got.SessionID != "static-session" {
+		t.Errorf("Second trace: got user %q session %q, want bob static-session", got.UserID, got.SessionID)
+	}
+}
+
+// Test that the trace output can be projected from the final state
+func TestOutputExtractor(t *testing.T) {
+	type chatState struct {
+		History  []string
+		Response string
+	}
+	ctx := context.Background()
+	final := chatState{History: []string{"hi", "hello"}, Response: "hello"}
+
+	hook, client := newTestHook(WithOutputExtractor(func(state interface{}) interface{} {
+		return state.(chatState).Response
+	}))
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd, State: final})
+
+	update := client.traces[len(client.traces)-1]
+	if update.Output != "hello" {
+		t.Errorf("Trace output: got %v, want hello", update.Output)
+	}
+
+	// The root span still records the full state
+	root := client.spans[len(client.spans)-1]
+	if _, isState := root.Output.(chatState); !isState {
+		t.Errorf("Root span output: got %T, want chatState", root.Output)
+	}
+}
+
+// Test model name normalization
+func TestModelNormalizer(t *testing.T) {
+	normalizer := langfuse.NewModelNormalizer().Add("my-gpt4-deployment", "gpt-4")
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"gpt-4", "gpt-4"},
+		{"gpt-4-0613", "gpt-4"},
+		{"openai/gpt-4", "gpt-4"},
+		{"OpenAI/GPT-4-0613", "gpt-4"},
+		{"gpt-4o-2024-08-06", "gpt-4o"},
+		{"claude-3-5-sonnet-20241022", "claude-3-5-sonnet"},
+		{"anthropic/claude-3-5-sonnet@20240620", "claude-3-5-sonnet"},
+		{"models/gemini-1.5-pro-002", "gemini-1.5-pro"},
+		{"my-gpt4-deployment", "gpt-4"},
+		{"some-local-model", "some-local-model"},
+		{"Local/My-Model-20240101", "Local/My-Model-20240101"},
+		{"openai/ft:gpt-4o-mini:acme", "openai/ft:gpt-4o-mini:acme"},
+	}
+	for _, tt := range tests {
+		if got := normalizer.Normalize(tt.raw); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+
+	t.Run("Hook", func(t *testing.T) {
+		hook, client := newTestHook(WithModelNormalizer(normalizer))
+		ctx := context.Background()
+
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+		hook.OnEvent(ctx, &graph.TraceSpan{
+			ID:       "node-1",
+			ParentID: "graph-1",
+			Event:    graph.TraceEventNodeStart,
+			NodeName: "llm_call",
+			Metadata: map[string]interface{}{"model": "openai/gpt-4-0613"},
+		})
+
+		if len(client.generations) != 1 || client.generations[0].Model != "gpt-4" {
+			t.Errorf("Expected a gpt-4 generation, got %+v", client.generations)
+		}
+	})
+
+	t.Run("SharedClient", func(t *testing.T) {
+		shared := langfuse.NewObserverRecorder().Client()
+		NewHookWithClient(shared, WithModelNormalizer(normalizer))
+		if got := shared.NormalizeModel("openai/gpt-4-0613"); got != "openai/gpt-4-0613" {
+			t.Errorf("Expected the shared client to keep its model names, got %q", got)
+		}
+	})
+}
+
+// Test that the inline media limit applies to the hook's events only
+func TestMaxInlineMediaSize(t *testing.T) {
+	recorder := langfuse.NewObserverRecorder()
+	shared := recorder.Client()
+	hook := NewHookWithClient(shared, WithMaxInlineMediaSize(16))
+	ctx := context.Background()
+	document := "a document much longer than sixteen bytes"
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "ocr", State: document, StartTime: time.Now()})
+
+	nodes := recorder.ObservationsNamed("ocr")
+	if len(nodes) == 0 {
+		t.Fatal("Expected the node span to be recorded")
+	}
+	if ref, _ := nodes[0].Input.(string); !langfuse.IsMediaReference(ref) {
+		t.Errorf("Expected the node input to be offloaded, got %v", nodes[0].Input)
+	}
+
+	direct, _ := shared.Span(&model.Span{TraceID: "trace-1", Name: "direct", Input: document}, nil)
+	if direct.Input != document {
+		t.Errorf("Expected the shared client to keep payloads inline, got %v", direct.Input)
+	}
+}
+
+// Test that AI nodes record their provider at start and end
+func TestProviderResolver(t *testing.T) {
+	hook, client := newTestHook(WithProviderResolver(langfuse.NewProviderResolver()))
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	nodes := []*graph.TraceSpan{
+		{ID: "node-1", NodeName: "llm_call", Metadata: map[string]interface{}{"model": "anthropic/claude-3-5-sonnet"}},
+		{ID: "node-2", NodeName: "llm_call", Metadata: map[string]interface{}{"model": "llama-3-70b", "provider": "self-hosted"}},
+	}
+	for _, node := range nodes {
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: node.ID, ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: node.NodeName, Metadata: node.Metadata})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: node.ID, ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: node.NodeName})
+	}
+
+	if len(client.generations) != 4 {
+		t.Fatalf("Expected 4 generation events, got %d", len(client.generations))
+	}
+	for i, want := range []string{"anthropic", "anthropic", "self-hosted", "self-hosted"} {
+		if got := client.generations[i].Provider; got != want {
+			t.Errorf("Generation event %d: expected provider %q, got %q", i, want, got)
+		}
+	}
+}
+
+// Test that trace metadata is copied from the initial input
+func TestMetadataFromInput(t *testing.T) {
+	type request struct {
+		TenantID string `json:"tenant_id"`
+	}
+	hook, client := newTestHook(WithMetadataFromInput("request.tenant_id", "$.messages[0].model", "missing.path"))
+	ctx := context.Background()
+	hook.SetInitialInput(map[string]interface{}{
+		"request":  &request{TenantID: "acme"},
+		"messages": []map[string]interface{}{{"model": "gpt-4o"}},
+	})
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+
+	metadata, _ := client.traces[0].Metadata.(map[string]interface{})
+	if metadata["tenant_id"] != "acme" || metadata["model"] != "gpt-4o" {
+		t.Errorf("Expected tenant_id and model from the input, got %v", metadata)
+	}
+	if _, found := metadata["path"]; found {
+		t.Errorf("Expected missing paths to be skipped, got %v", metadata)
+	}
+}
+
+// Test that node end metadata is merged with the metadata sent at node start
+func TestNodeMetadataMerge(t *testing.T) {
+	hook, client := newTestHook(WithTags([]string{"team-a"}), WithTagInheritance(true))
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "fetch"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "fetch", Duration: time.Second})
+
+	end := client.spans[len(client.spans)-1]
+	metadata, isMap := end.Metadata.(map[string]interface{})
+	if !isMap {
+		t.Fatalf("Expected map metadata, got %T", end.Metadata)
+	}
+	if metadata["graph_span_id"] != "node-1" {
+		t.Errorf("graph_span_id: got %v, want node-1", metadata["graph_span_id"])
+	}
+	if _, hasTags := metadata["tags"]; !hasTags {
+		t.Error("Expected start tags to survive node end")
+	}
+	if metadata["status"] != "completed" || metadata["duration_ms"] != int64(1000) {
+		t.Errorf("Expected end keys, got %v", metadata)
+	}
+	if running := hook.nodes.len(); running != 0 {
+		t.Errorf("Expected start metadata to be released, got %d running nodes", running)
+	}
+}
+
+// Test that traces can be made public
+func TestPublicTraces(t *testing.T) {
+	ctx := context.Background()
+
+	hook, client := newTestHook()
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	if client.traces[0].Public {
+		t.Error("Traces should not be public by default")
+	}
+
+	hook, client = newTestHook(WithPublic(true))
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	if !client.traces[0].Public {
+		t.Error("Expected a public trace")
+	}
+}
+
+// Test that node observations are numbered in execution order per trace
+func TestStepNumbers(t *testing.T) {
+	hook, client := newTestHook()
+	ctx := context.Background()
+
+	for _, graphID := range []string{"graph-1", "graph-2"} {
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: graphID, Event: graph.TraceEventGraphStart})
+		for i := 0; i < 3; i++ {
+			nodeID := fmt.Sprintf("%s-node-%d", graphID, i)
+			hook.OnEvent(ctx, &graph.TraceSpan{ID: nodeID, ParentID: graphID, Event: graph.TraceEventNodeStart, NodeName: "loop"})
+			hook.OnEvent(ctx, &graph.TraceSpan{ID: nodeID, ParentID: graphID, Event: graph.TraceEventNodeEnd, NodeName: "loop"})
+		}
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: graphID, Event: graph.TraceEventGraphEnd})
+	}
+
+	steps := make(map[string][]interface{})
+	for _, span := range client.spans {
+		metadata, _ := span.Metadata.(map[string]interface{})
+		if span.Name == "loop" && span.StartTime != nil {
+			steps[span.TraceID] = append(steps[span.TraceID], metadata["step"])
+		}
+	}
+	if len(steps) != 2 {
+		t.Fatalf("Expected node spans in 2 traces, got %d", len(steps))
+	}
+	for traceID, got := range steps {
+		if fmt.Sprint(got) != "[1 2 3]" {
+			t.Errorf("Trace %s: got steps %v, want [1 2 3]", traceID, got)
+		}
+	}
+	if len(hook.steps) != 0 {
+		t.Errorf("Expected step counters to be released, got %v", hook.steps)
+	}
+}
+
+// Test model and usage extraction from configured state paths
+func TestModelAndUsagePaths(t *testing.T) {
+	type llmResult struct {
+		Model string
+		Usage map[string]interface{} `json:"usage"`
+	}
+
+	t.Run("Resolver", func(t *testing.T) {
+		state := map[string]interface{}{
+			"calls": []interface{}{&llmResult{Model: "gpt-4"}},
+		}
+		if got, found := resolvePath(state, "$.calls[0].model"); !found || got != "gpt-4" {
+			t.Errorf("Expected gpt-4, got %v (found %v)", got, found)
+		}
+		if _, found := resolvePath(state, "calls.1.model"); found {
+			t.Error("Out-of-range index should not resolve")
+		}
+	})
+
+	t.Run("Hook", func(t *testing.T) {
+		hook, client := newTestHook(WithModelPath("llm.model"), WithUsagePath("llm.usage"))
+		ctx := context.Background()
+
+		state := map[string]interface{}{
+			"llm": llmResult{
+				Model: "claude-3-opus",
+				Usage: map[string]interface{}{"prompt_tokens": 12.0, "completion_tokens": 30},
+			},
+		}
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "chat", State: state})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "chat", State: state})
+
+		if len(client.generations) != 2 {
+			t.Fatalf("Expected generation start and end, got %d", len(client.generations))
+		}
+		if client.generations[0].Model != "claude-3-opus" {
+			t.Errorf("Model: got %q, want claude-3-opus", client.generations[0].Model)
+		}
+		usage := client.generations[1].Usage
+		if usage.Input != 12 || usage.Output != 30 || usage.Total != 42 {
+			t.Errorf("Usage: got %+v, want 12/30/42", usage)
+		}
+	})
+}
+
+// Test that non-AI nodes record the type chosen by the classifier
+func TestNodeTypeClassifier(t *testing.T) {
+	hook, client := newTestHook(WithNodeTypeClassifier(func(nodeName string, metadata map[string]interface{}) langfuse.ObservationType {
+		if nodeName == "search" {
+			return langfuse.ObservationTypeRetriever
+		}
+		return ""
+	}))
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	for _, name := range []string{"search", "format"} {
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: name, ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: name})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: name, ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: name})
+	}
+
+	want := map[string]langfuse.ObservationType{
+		"search": langfuse.ObservationTypeRetriever,
+		"format": langfuse.ObservationTypeSpan,
+	}
+	for _, span := range client.spans {
+		expected, isNode := want[span.Name]
+		if !isNode {
+			continue
+		}
+		metadata, _ := span.Metadata.(map[string]interface{})
+		if metadata["observation_type"] != expected {
+			t.Errorf("%s: got type %v, want %s", span.Name, metadata["observation_type"], expected)
+		}
+	}
+}
+
+// Test that embedding nodes are recorded as generations carrying usage
+func TestEmbeddingNodeUsage(t *testing.T) {
+	hook, client := newTestHook(WithNodeTypeClassifier(func(nodeName string, metadata map[string]interface{}) langfuse.ObservationType {
+		if nodeName == "embed_query" {
+			return langfuse.ObservationTypeEmbedding
+		}
+		return ""
+	}))
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "embed_query",
+		Metadata: map[string]interface{}{"model": "text-embedding-3-small"}})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "embed_query",
+		Metadata: map[string]interface{}{"usage": map[string]interface{}{"input": 12}}})
+
+	if len(client.generations) != 2 {
+		t.Fatalf("Expected the embedding node to be recorded as a generation, got %d generations", len(client.generations))
+	}
+	start, end := client.generations[0], client.generations[1]
+	if start.Name != "embed_query_embedding" || start.Model != "text-embedding-3-small" {
+		t.Errorf("Unexpected embedding generation: name %q, model %q", start.Name, start.Model)
+	}
+	if end.Name != start.Name || end.Usage.Input != 12 {
+		t.Errorf("Expected usage on the embedding, got name %q and usage %+v", end.Name, end.Usage)
+	}
+	metadata, _ := end.Metadata.(map[string]interface{})
+	if metadata["observation_type"] != langfuse.ObservationTypeEmbedding {
+		t.Errorf("Expected observation_type embedding, got %v", metadata["observation_type"])
+	}
+}
+
+// Test that an AI-named node classified as an embedding keeps its name and
+// type from start to end
+func TestAINamedEmbeddingNode(t *testing.T) {
+	hook, client := newTestHook(WithNodeTypeClassifier(func(nodeName string, metadata map[string]interface{}) langfuse.ObservationType {
+		if nodeName == "openai_embed" {
+			return langfuse.ObservationTypeEmbedding
+		}
+		return ""
+	}))
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "openai_embed"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "openai_embed"})
+
+	if len(client.generations) != 2 {
+		t.Fatalf("Expected generation start and end, got %d", len(client.generations))
+	}
+	for i, gen := range client.generations {
+		if gen.Name != "openai_embed_embedding" {
+			t.Errorf("Generation %d: got name %q, want openai_embed_embedding", i, gen.Name)
+		}
+		metadata, _ := gen.Metadata.(map[string]interface{})
+		if metadata["observation_type"] != langfuse.ObservationTypeEmbedding {
+			t.Errorf("Generation %d: got type %v, want embedding", i, metadata["observation_type"])
+		}
+	}
+}
+
+// Test that cache hits are recorded and optionally carry no usage
+func TestCacheHits(t *testing.T) {
+	hook, client := newTestHook(WithSkipCachedUsage(true))
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	for _, hit := range []bool{true, false} {
+		nodeID := fmt.Sprintf("llm-%v", hit)
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: nodeID, ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "llm"})
+		hook.OnEvent(ctx, &graph.TraceSpan{
+			ID:       nodeID,
+			ParentID: "graph-1",
+			Event:    graph.TraceEventNodeEnd,
+			NodeName: "llm",
+			State:    map[string]interface{}{"response": "cached", CacheHitKey: hit},
+		})
+	}
+
+	ends := make([]*model.Generation, 0, 2)
+	for _, generation := range client.generations {
+		if generation.EndTime != nil {
+			ends = append(ends, generation)
+		}
+	}
+	if len(ends) != 2 {
+		t.Fatalf("Expected 2 generation ends, got %d", len(ends))
+	}
+
+	hitMetadata, _ := ends[0].Metadata.(map[string]interface{})
+	if hitMetadata[CacheHitKey] != true {
+		t.Errorf("Expected cache_hit metadata, got %v", hitMetadata)
+	}
+	if ends[0].Usage.Total != 0 {
+		t.Errorf("Cache hit should carry no usage, got %+v", ends[0].Usage)
+	}
+
+	missMetadata, _ := ends[1].Metadata.(map[string]interface{})
+	if _, marked := missMetadata[CacheHitKey]; marked {
+		t.Error("Cache miss should not be marked")
+	}
+	if ends[1].Usage.Total == 0 {
+		t.Error("Cache miss should keep its usage")
+	}
+}
+
+// Test that errored nodes carry a stack trace only when enabled
+func TestCaptureStackTrace(t *testing.T) {
+	for _, capture := range []bool{false, true} {
+		hook, client := newTestHook(WithCaptureStackTrace(capture))
+		ctx := context.Background()
+
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "fetch"})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeError, NodeName: "fetch", Error: errors.New("boom")})
+
+		end := client.spans[len(client.spans)-1]
+		metadata, _ := end.Metadata.(map[string]interface{})
+		trace, hasTrace := metadata["stack_trace"].(string)
+		if hasTrace != capture {
+			t.Errorf("capture=%v: stack trace present=%v", capture, hasTrace)
+		}
+		if capture && !strings.Contains(trace, "TestCaptureStackTrace") {
+			t.Errorf("Expected the stack to reach the caller, got %q", trace)
+		}
+	}
+}
+
+// Test that a resumed run continues the trace of the interrupted one
+func TestInterruptAndResume(t *testing.T) {
+	hook, client := newTestHook()
+	ctx := ContextWithRunID(context.Background(), "approval-42")
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{
+		ID:    "graph-1",
+		Event: graph.TraceEventGraphEnd,
+		Error: fmt.Errorf("awaiting approval: %w", ErrInterrupted),
+	})
+
+	traceID := client.traces[0].ID
+	metadata, _ := client.traces[len(client.traces)-1].Metadata.(map[string]interface{})
+	if metadata["status"] != "interrupted" {
+		t.Errorf("Expected interrupted status, got %v", metadata["status"])
+	}
+	if root := client.spans[len(client.spans)-1]; root.EndTime != nil {
+		t.Error("The root span of an interrupted run should stay open")
+	}
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-2", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-2", Event: graph.TraceEventNodeStart, NodeName: "apply"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-2", Event: graph.TraceEventNodeEnd, NodeName: "apply"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-2", Event: graph.TraceEventGraphEnd})
+
+	for _, trace := range client.traces {
+		if trace.ID != traceID {
+			t.Errorf("Resumed run should continue trace %s, got %s", traceID, trace.ID)
+		}
+	}
+	for _, span := range client.spans {
+		if span.TraceID != traceID {
+			t.Errorf("Span %s recorded in trace %s", span.Name, span.TraceID)
+		}
+	}
+	if len(client.events) != 2 || client.events[0].Name != "graph_interrupted" || client.events[1].Name != "graph_resumed" {
+		t.Fatalf("Expected interrupt and resume events, got %+v", client.events)
+	}
+	if client.events[0].StatusMessage != "awaiting approval: graph interrupted" {
+		t.Errorf("Unexpected interrupt message %q", client.events[0].StatusMessage)
+	}
+	if root := client.spans[len(client.spans)-1]; root.EndTime == nil {
+		t.Error("The root span should end when the resumed run completes")
+	}
+	metadata, _ = client.traces[len(client.traces)-1].Metadata.(map[string]interface{})
+	if metadata["status"] != "completed" {
+		t.Errorf("Expected completed status, got %v", metadata["status"])
+	}
+}
+
+// Test that conditional edge decisions are recorded on the root span
+func TestEdgeTracing(t *testing.T) {
+	hook, client := newTestHook(WithEdgeTracing(true))
+	topology := NewGraphTopology()
+	topology.AddEdge("classify", "check_cache")
+	topology.AddEdge("check_cache", "simple")
+	topology.AddEdge("check_cache", "complex")
+	hook.SetGraphTopology(topology)
+
+	graphSpan := &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart}
+	ctx := graph.ContextWithSpan(context.Background(), graphSpan)
+	hook.OnEvent(ctx, graphSpan)
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "edge-1", ParentID: "graph-1", Event: graph.TraceEventEdgeTraversal, FromNode: "classify", ToNode: "check_cache"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "edge-2", ParentID: "graph-1", Event: graph.TraceEventEdgeTraversal, FromNode: "check_cache", ToNode: "complex"})
+
+	nodeSpan := &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "review"}
+	route := TracedCondition(hook, "review", func(ctx context.Context, state interface{}) string { return "approve" })
+	if got := route(graph.ContextWithSpan(ctx, nodeSpan), nil); got != "approve" {
+		t.Errorf("TracedCondition changed the decision to %q", got)
+	}
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd})
+
+	root := client.spans[len(client.spans)-1]
+	metadata, _ := root.Metadata.(map[string]interface{})
+	decisions, _ := metadata[BranchDecisionsKey].([]BranchDecision)
+	want := []string{"check_cache -> complex", "review -> approve"}
+	if len(decisions) != len(want) {
+		t.Fatalf("Expected %d decisions, got %+v", len(want), metadata[BranchDecisionsKey])
+	}
+	for i, decision := range decisions {
+		if decision.Condition != want[i] {
+			t.Errorf("Decision %d: got %q, want %q", i, decision.Condition, want[i])
+		}
+	}
+}
+
+// Test that trace names are derived when no trace name is set
+func TestTraceNameDerivation(t *testing.T) {
+	topology := NewGraphTopology()
+	topology.EntryPoint = "classify"
+	nameFunc := func(state interface{}) string {
+		input, _ := state.(map[string]interface{})
+		name, _ := input["workflow"].(string)
+		return name
+	}
+
+	tests := []struct {
+		name  string
+		opts  []Option
+		input interface{}
+		want  string
+	}{
+		{"explicit name wins", []Option{WithTraceName("support"), WithTraceNameFunc(nameFunc), WithEntryPointTraceName(true)}, map[string]interface{}{"workflow": "refund"}, "support"},
+		{"derived from input", []Option{WithTraceName(""), WithTraceNameFunc(nameFunc), WithEntryPointTraceName(true)}, map[string]interface{}{"workflow": "refund"}, "refund"},
+		{"entry point when func is empty", []Option{WithTraceName(""), WithTraceNameFunc(nameFunc), WithEntryPointTraceName(true)}, map[string]interface{}{}, "classify"},
+		{"default name", []Option{WithTraceName(DefaultTraceName)}, nil, DefaultTraceName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook, client := newTestHook(tt.opts...)
+			hook.SetGraphTopology(topology)
+			hook.SetInitialInput(tt.input)
+
+			hook.OnEvent(context.Background(), &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+			hook.OnEvent(context.Background(), &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd})
+
+			if got := client.traces[0].Name; got != tt.want {
+				t.Errorf("Trace name: got %q, want %q", got, tt.want)
+			}
+			for _, span := range client.spans {
+				if span.Name != tt.want {
+					t.Errorf("Root span name: got %q, want %q", span.Name, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Test that a graph run ending in an unhandled error is marked failed
+func TestGraphEndError(t *testing.T) {
+	hook, client := newTestHook()
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd, State: map[string]interface{}{"query": "hi"}, Error: errors.New("node fetch: timeout")})
+
+	final := client.traces[len(client.traces)-1]
+	output, _ := final.Output.(map[string]interface{})
+	errInfo, _ := output["error"].(map[string]interface{})
+	if errInfo["message"] != "node fetch: timeout" || output["state"] == nil {
+		t.Errorf("Expected the trace output to lead with the error, got %+v", final.Output)
+	}
+
+	root := client.spans[len(client.spans)-1]
+	if root.Level != model.ObservationLevelError || root.StatusMessage != "node fetch: timeout" {
+		t.Errorf("Expected an ERROR root span, got level %q and status %q", root.Level, root.StatusMessage)
+	}
+	if rootOutput, _ := root.Output.(map[string]interface{}); rootOutput["error"] == nil {
+		t.Errorf("Expected the root span output to lead with the error, got %+v", root.Output)
+	}
+}
+
+// Test the per-node latency breakdown recorded at graph end
+func TestLatencyBreakdown(t *testing.T) {
+	hook, client := newTestHook(WithLatencyBreakdown(true))
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: start})
+	// search and rank run in parallel from 100ms to 400ms
+	nodes := []struct {
+		id, name   string
+		start, end int
+	}{
+		{"node-1", "plan", 0, 100},
+		{"node-2", "search", 100, 400},
+		{"node-3", "rank", 200, 300},
+		{"node-4", "plan", 400, 500},
+	}
+	for _, n := range nodes {
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: n.id, ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: n.name, StartTime: at(n.start)})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: n.id, ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: n.name, StartTime: at(n.start), EndTime: at(n.end)})
+	}
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd, StartTime: start, EndTime: at(1000), Duration: time.Second})
+
+	metadata, _ := client.traces[len(client.traces)-1].Metadata.(map[string]interface{})
+	breakdown, _ := metadata[LatencyBreakdownKey].(LatencyBreakdown)
+	want := LatencyBreakdown{
+		TotalMs:    1000,
+		NodeMs:     500,
+		OverheadMs: 500,
+		Nodes: []NodeLatency{
+			{Node: "search", Calls: 1, DurationMs: 300, Percent: 30},
+			{Node: "plan", Calls: 2, DurationMs: 200, Percent: 20},
+			{Node: "rank", Calls: 1, DurationMs: 100, Percent: 10},
+		},
+	}
+	if fmt.Sprint(breakdown) != fmt.Sprint(want) {
+		t.Errorf("Expected breakdown %+v, got %+v", want, breakdown)
+	}
+	if len(hook.timings) != 0 {
+		t.Errorf("Expected timings to be released at graph end, got %v", hook.timings)
+	}
+}
+
+// Test that versions are recorded on traces and observations, with per-node overrides
+func TestVersions(t *testing.T) {
+	hook, client := newTestHook(WithVersion("v1"), WithNodeVersions(map[string]string{"summarize": "v2"}))
+	ctx := context.Background()
+	now := time.Now()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: now})
+	for i, node := range []string{"plan", "summarize"} {
+		id := fmt.Sprintf("node-%d", i)
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: id, ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: node, StartTime: now})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: id, ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: node, StartTime: now, EndTime: now})
+	}
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd, StartTime: now, EndTime: now})
+
+	for _, trace := range client.traces {
+		if trace.Version != "v1" {
+			t.Errorf("Expected trace version v1, got %q", trace.Version)
+		}
+	}
+	for _, span := range client.spans {
+		want := "v1"
+		if span.Name == "summarize" {
+			want = "v2"
+		}
+		if span.Version != want {
+			t.Errorf("Expected span %s to have version %s, got %q", span.Name, want, span.Version)
+		}
+	}
+}
+
+// Test the notice and enabled state of hooks without credentials
+func TestDisabledHookLogging(t *testing.T) {
+	t.Setenv("LANGFUSE_PUBLIC_KEY", "")
+	t.Setenv("LANGFUSE_SECRET_KEY", "")
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	hook := NewHook(WithLogger(logger))
+	if hook.Enabled() {
+		t.Error("Expected hook without credentials to be disabled")
+	}
+	if !strings.Contains(buf.String(), "Langfuse not configured, tracing disabled") {
+		t.Errorf("Expected disabled notice on the configured logger, got %q", buf.String())
+	}
+
+	buf.Reset()
+	NewHook(WithLogger(logger), WithSuppressDisabledLog(true))
+	NewBuilder().WithLogger(logger).WithSuppressDisabledLog(true).Build()
+	if buf.Len() != 0 {
+		t.Errorf("Expected no notice when suppressed, got %q", buf.String())
+	}
+
+	NewBuilder().WithLogger(logger).Build()
+	if strings.Count(buf.String(), "tracing disabled") != 1 {
+		t.Errorf("Expected the builder to log the notice once on Build, got %q", buf.String())
+	}
+
+	if enabled, _ := newTestHook(); !enabled.Enabled() {
+		t.Error("Expected hook with a client to be enabled")
+	}
+}
+
+// Test topology extraction from a compiled graph
+func TestTopologyFromRunnable(t *testing.T) {
+	workflow := graph.NewMessageGraph()
+	noop := func(ctx context.Context, state interface{}) (interface{}, error) {
+		return state, nil
+	}
+	workflow.AddNode("plan", noop)
+	workflow.AddNode("act", noop)
+	workflow.SetEntryPoint("plan")
+	workflow.AddEdge("plan", "act")
+	workflow.AddEdge("act", graph.END)
+
+	compiled, err := workflow.Compile()
+	if err != nil {
+		t.Fatalf("Failed to compile workflow: %v", err)
+	}
+
+	topology := TopologyFromRunnable(compiled)
+	if topology == nil {
+		t.Fatal("Expected topology, got nil")
+	}
+	if topology.EntryPoint != "plan" {
+		t.Errorf("EntryPoint: got %v, want plan", topology.EntryPoint)
+	}
+	if len(topology.Nodes) != 2 {
+		t.Errorf("Nodes: got %v, want [plan act]", topology.Nodes)
+	}
+	if got := topology.Edges["plan"]; len(got) != 1 || got[0] != "act" {
+		t.Errorf("Edges[plan]: got %v, want [act]", got)
+	}
+	if got := topology.Edges["act"]; len(got) != 1 || got[0] != graph.END {
+		t.Errorf("Edges[act]: got %v, want [END]", got)
+	}
+}
+
+// Test topology accumulation from edge events
+func TestEdgeTraversalTopology(t *testing.T) {
+	hook := NewHook(WithGraphTopology(true))
+
+	hook.handleEdgeTraversal(&graph.TraceSpan{FromNode: "a", ToNode: "b"})
+	hook.handleEdgeTraversal(&graph.TraceSpan{FromNode: "a", ToNode: "b"})
+	hook.handleEdgeTraversal(&graph.TraceSpan{FromNode: "b", ToNode: graph.END})
+
+	if hook.observed == nil {
+		t.Fatal("Expected observed topology")
+	}
+	if got := hook.observed.Edges["a"]; len(got) != 1 {
+		t.Errorf("Edges[a]: got %v, want one deduplicated edge", got)
+	}
+	if len(hook.observed.Nodes) != 2 {
+		t.Errorf("Nodes: got %v, want [a b]", hook.observed.Nodes)
+	}
+
+	disabled := NewHook()
+	disabled.handleEdgeTraversal(&graph.TraceSpan{FromNode: "a", ToNode: "b"})
+	if disabled.observed != nil {
+		t.Error("Edges should not be recorded when topology is disabled")
+	}
+}
+
+// Test that a reused hook attaches the topology of each run only
+func TestGraphTopologyReusedHook(t *testing.T) {
+	hook, client := newTestHook(WithGraphTopology(true))
+	ctx := context.Background()
+	conditional := map[string]interface{}{ConditionalKey: true}
+
+	run := func(graphID string, edges ...[2]string) map[string]interface{} {
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: graphID, Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+		for _, edge := range edges {
+			hook.OnEvent(ctx, &graph.TraceSpan{ID: graphID + edge[0], ParentID: graphID, Event: graph.TraceEventEdgeTraversal, FromNode: edge[0], ToNode: edge[1], Metadata: conditional})
+		}
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: graphID, Event: graph.TraceEventGraphEnd, EndTime: time.Now()})
+		root := client.spans[len(client.spans)-1]
+		metadata, _ := root.Metadata.(map[string]interface{})
+		return metadata
+	}
+
+	run("graph-1", [2]string{"a", "b"})
+	metadata := run("graph-2", [2]string{"route", "c"}, [2]string{"route", "c"})
+	topology, _ := metadata["graph_topology"].(*GraphTopology)
+	if topology == nil {
+		t.Fatalf("Expected a topology on the second run, got %v", metadata)
+	}
+	if len(topology.Nodes) != 2 || topology.Edges["a"] != nil {
+		t.Errorf("Expected only the second run's edges, got %+v", topology)
+	}
+	if got := topology.ConditionalEdges["route"]; len(got) != 1 || got[0] != "c" {
+		t.Errorf("Expected the branch mapping route -> c, got %v", topology.ConditionalEdges)
+	}
+
+	// A topology known up front gains the observed branch mappings
+	hook.SetGraphTopology(&GraphTopology{Nodes: []string{"route", "c", "d"}, Edges: map[string][]string{"c": {graph.END}}})
+	metadata = run("graph-3", [2]string{"route", "d"})
+	topology, _ = metadata["graph_topology"].(*GraphTopology)
+	if topology == nil || len(topology.Nodes) != 3 {
+		t.Fatalf("Expected the supplied topology, got %v", metadata)
+	}
+	if got := topology.ConditionalEdges["route"]; len(got) != 1 || got[0] != "d" {
+		t.Errorf("Expected the branch mapping route -> d, got %v", topology.ConditionalEdges)
+	}
+
+	// The topology of each compiled graph replaces the previous one
+	noop := func(ctx context.Context, state interface{}) (interface{}, error) { return state, nil }
+	compile := func(nodes ...string) *graph.Runnable {
+		workflow := graph.NewMessageGraph()
+		for i, node := range nodes {
+			workflow.AddNode(node, noop)
+			if i > 0 {
+				workflow.AddEdge(nodes[i-1], node)
+			}
+		}
+		workflow.AddEdge(nodes[len(nodes)-1], graph.END)
+		workflow.SetEntryPoint(nodes[0])
+		compiled, err := workflow.Compile()
+		if err != nil {
+			t.Fatalf("Failed to compile workflow: %v", err)
+		}
+		return compiled
+	}
+	reused := NewHook(WithGraphTopology(true))
+	for _, compiled := range []*graph.Runnable{compile("plan", "act"), compile("search")} {
+		NewTracedRunnable(compiled, reused).attachTopology(compiled)
+	}
+	if reused.topology == nil || reused.topology.EntryPoint != "search" || len(reused.topology.Nodes) != 1 {
+		t.Errorf("Expected the second graph's topology, got %+v", reused.topology)
+	}
+}
+
+// MockRunnable for testing
+type MockRunnable struct {
+	result interface{}
+	err    error
+}
+
+func (m *MockRunnable) Invoke(ctx context.Context, input interface{}) (interface{}, error) {
+	return m.result, m.err
+}
+
+func (m *MockRunnable) Stream(ctx context.Context, input interface{}) (<-chan interface{}, <-chan error) {
+	outputChan := make(chan interface{}, 1)
+	errorChan := make(chan error, 1)
+
+	if m.err != nil {
+		errorChan <- m.err
+	} else {
+		outputChan <- m.result
+	}
+
+	close(outputChan)
+	close(errorChan)
+
+	return outputChan, errorChan
+}
+
+// Benchmark tests
+func BenchmarkHookOnEvent(b *testing.B) {
+	hook := NewHook()
+	ctx := context.Background()
+
+	span := &graph.TraceSpan{
+		ID:        uuid.New().String(),
+		Event:     graph.TraceEventNodeStart,
+		NodeName:  "benchmark_node",
+		StartTime: time.Now(),
+		State:     map[string]interface{}{"key": "value"},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hook.OnEvent(ctx, span)
+	}
+}
+
+func BenchmarkFilteredHook(b *testing.B) {
+	baseHook := NewHook()
+	filter := EventFilter{
+		IncludeEvents: []graph.TraceEvent{
+			graph.TraceEventNodeStart,
+			graph.TraceEventNodeEnd,
+		},
+		MinDuration: 10 * time.Millisecond,
+	}
+
+	filteredHook := NewFilteredHook(baseHook, filter)
+	ctx := context.Background()
+
+	span := &graph.TraceSpan{
+		Event:     graph.TraceEventNodeEnd,
+		Duration:  100 * time.Millisecond,
+		NodeName:  "benchmark_node",
+		StartTime: time.Now(),
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filteredHook.OnEvent(ctx, span)
+	}
+}
+
+func BenchmarkMultiHook(b *testing.B) {
+	hook1 := NewHook()
+	hook2 := NewHook()
+	hook3 := NewHook()
+
+	multiHook := NewMultiHook(hook1, hook2, hook3)
+	ctx := context.Background()
+
+	span := &graph.TraceSpan{
+		Event:     graph.TraceEventNodeStart,
+		NodeName:  "benchmark_node",
+		StartTime: time.Now(),
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
 		multiHook.OnEvent(ctx, span)
 	}
 }
+
+// Test that a graph started without an initial input is reported once, and that
+// TracedRunnable records its input without SetInitialInput
+func TestMissingInitialInput(t *testing.T) {
+	var buf bytes.Buffer
+	hook, _ := newTestHook(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+
+	for i := 0; i < 2; i++ {
+		hook.OnEvent(context.Background(), &graph.TraceSpan{ID: fmt.Sprintf("graph-%d", i), Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+	}
+	if strings.Count(buf.String(), "without an initial input") != 1 {
+		t.Errorf("Expected one warning, got %q", buf.String())
+	}
+
+	buf.Reset()
+	fresh, freshFake := newTestHook(WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	runnable := RunnableFunc(func(ctx context.Context, input interface{}) (interface{}, error) {
+		return input, nil
+	})
+	if _, err := NewTracedRunnable(runnable, fresh).Invoke(context.Background(), "question"); err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if len(freshFake.traces) == 0 || freshFake.traces[0].Input != "question" {
+		t.Errorf("Expected the invoke input on the trace, got %+v", freshFake.traces)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no warning for a traced runnable, got %q", buf.String())
+	}
+}
+
+// Test that suppressed event types are ignored
+func TestWithoutEvents(t *testing.T) {
+	hook, fake := newTestHook(WithoutEvents(graph.TraceEventNodeStart))
+	hook.SetInitialInput("question")
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph", Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+	rootSpans := len(fake.spans)
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node", ParentID: "graph", Event: graph.TraceEventNodeStart, NodeName: "agent", StartTime: time.Now()})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node", ParentID: "graph", Event: graph.TraceEventNodeEnd, NodeName: "agent", EndTime: time.Now()})
+
+	if len(fake.traces) == 0 {
+		t.Fatal("Expected the graph start to create a trace")
+	}
+	if len(fake.spans) != rootSpans || len(fake.generations) != 0 {
+		t.Errorf("Expected no node observations, got %d spans and %d generations", len(fake.spans)-rootSpans, len(fake.generations))
+	}
+}
+
+// Test that retry counts reported by nodes are recorded on their observations
+func TestRetryCount(t *testing.T) {
+	hook, client := newTestHook()
+	ctx := context.Background()
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	for i, retries := range []interface{}{2, 0, "1"} {
+		nodeID := fmt.Sprintf("node-%d", i)
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: nodeID, ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "fetch"})
+		hook.OnEvent(ctx, &graph.TraceSpan{
+			ID:       nodeID,
+			ParentID: "graph-1",
+			Event:    graph.TraceEventNodeEnd,
+			NodeName: "fetch",
+			Metadata: map[string]interface{}{RetryCountKey: retries},
+		})
+	}
+
+	ends := make([]*model.Span, 0, 3)
+	for _, span := range client.spans {
+		if span.EndTime != nil && span.Name == "fetch" {
+			ends = append(ends, span)
+		}
+	}
+	if len(ends) != 3 {
+		t.Fatalf("Expected 3 node ends, got %d", len(ends))
+	}
+
+	retried, _ := ends[0].Metadata.(map[string]interface{})
+	if retried[RetryCountKey] != 2 || ends[0].Level != model.ObservationLevelWarning {
+		t.Errorf("Expected 2 retries at warning level, got %v at %q", retried, ends[0].Level)
+	}
+	clean, _ := ends[1].Metadata.(map[string]interface{})
+	if _, marked := clean[RetryCountKey]; marked || ends[1].Level != "" {
+		t.Errorf("Expected no retries recorded, got %v at %q", clean, ends[1].Level)
+	}
+	parsed, _ := ends[2].Metadata.(map[string]interface{})
+	if parsed[RetryCountKey] != 1 {
+		t.Errorf("Expected a numeric string retry count, got %v", parsed)
+	}
+}
+
+// Test that parallel nodes are recorded without waiting on each other. Run
+// with -race to check the hook's shared state.
+func TestParallelNodes(t *testing.T) {
+	const nodes = 32
+	hook, client := newTestHook()
+	ctx := context.Background()
+	hook.SetInitialInput("fan-out")
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	rootSpans := len(client.spans)
+
+	// Every node start waits until all of them are sending, which only
+	// completes when the hook does not serialize the calls
+	var arrived sync.WaitGroup
+	arrived.Add(nodes)
+	allArrived := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(allArrived)
+	}()
+	var starts atomic.Int32
+	client.beforeSpan = func() {
+		if starts.Add(1) > nodes {
+			return
+		}
+		arrived.Done()
+		select {
+		case <-allArrived:
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < nodes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			nodeID := fmt.Sprintf("node-%d", i)
+			hook.OnEvent(ctx, &graph.TraceSpan{ID: nodeID, ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: fmt.Sprintf("worker_%d", i)})
+			hook.OnEvent(ctx, &graph.TraceSpan{ID: nodeID, ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: fmt.Sprintf("worker_%d", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case <-allArrived:
+	default:
+		t.Fatal("Node starts were serialized")
+	}
+	if got := len(client.spans) - rootSpans; got != 2*nodes {
+		t.Errorf("Expected %d node spans, got %d", 2*nodes, got)
+	}
+
+	steps := make(map[interface{}]bool)
+	for _, span := range client.spans[rootSpans:] {
+		metadata, _ := span.Metadata.(map[string]interface{})
+		if span.EndTime == nil {
+			steps[metadata["step"]] = true
+		}
+	}
+	if len(steps) != nodes {
+		t.Errorf("Expected %d distinct steps, got %d", nodes, len(steps))
+	}
+}
+
+// panickingClock panics on its first call
+type panickingClock struct {
+	calls atomic.Int32
+}
+
+func (c *panickingClock) Now() time.Time {
+	if c.calls.Add(1) == 1 {
+		panic("clock failed")
+	}
+	return time.Now()
+}
+
+// Test that a clock panicking during a node event does not leave the hook locked
+func TestClockPanicReleasesLock(t *testing.T) {
+	hook, client := newTestHook(WithClock(&panickingClock{}))
+	ctx := context.Background()
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart, StartTime: time.Now()})
+
+	// The node start has no time, so the first clock call panics
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "first"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-2", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "second"})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-2", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "second"})
+		hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd, EndTime: time.Now()})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Hook deadlocked after the clock panicked")
+	}
+
+	var ended bool
+	for _, span := range client.spans {
+		if span.Name == "second" && span.EndTime != nil {
+			ended = true
+		}
+	}
+	if !ended {
+		t.Error("Expected the later node to be recorded")
+	}
+}
+
+// advancingClock returns a time the test moves forward
+type advancingClock struct {
+	now time.Time
+}
+
+func (c *advancingClock) Now() time.Time {
+	return c.now
+}
+
+// Test that chunks are recorded as events nested under the running node
+func TestRecordChunk(t *testing.T) {
+	clock := &advancingClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	hook, client := newTestHook(WithClock(clock))
+	ctx := context.Background()
+	hook.SetInitialInput("text")
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	nodeSpan := &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "process_chunk"}
+	hook.OnEvent(ctx, nodeSpan)
+	nodeObservation := client.spans[len(client.spans)-1].ID
+
+	clock.now = clock.now.Add(150 * time.Millisecond)
+	hook.RecordChunk(graph.ContextWithSpan(ctx, nodeSpan), "process_chunk", 0, "first")
+	clock.now = clock.now.Add(50 * time.Millisecond)
+	hook.RecordChunk(ctx, "process_chunk", 1, "second")
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "process_chunk"})
+	hook.RecordChunk(ctx, "process_chunk", 2, "late")
+
+	if len(client.events) != 2 {
+		t.Fatalf("Expected 2 chunk events, got %d", len(client.events))
+	}
+	for i, latency := range []int64{150, 50} {
+		event := client.events[i]
+		metadata, _ := event.Metadata.(map[string]interface{})
+		if client.parents[event.ID] != nodeObservation {
+			t.Errorf("Chunk %d is not nested under the node observation", i)
+		}
+		if metadata[ChunkIndexKey] != i || metadata[ChunkLatencyKey] != latency {
+			t.Errorf("Chunk %d: expected index %d and latency %d, got %v", i, i, latency, metadata)
+		}
+	}
+	if client.events[0].Output != "first" {
+		t.Errorf("Expected the chunk content as output, got %v", client.events[0].Output)
+	}
+}
+
+// Test that repeated payloads within a trace reference their first copy
+func TestPayloadReferences(t *testing.T) {
+	hook, client := newTestHook(WithPayloadReferences(true))
+	ctx := context.Background()
+	input := map[string]interface{}{"document": strings.Repeat("input ", 40)}
+	state := map[string]interface{}{"document": strings.Repeat("state ", 40)}
+	hook.SetInitialInput(input)
+
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "load", State: input})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-1", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "load", State: state})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-2", ParentID: "graph-1", Event: graph.TraceEventNodeStart, NodeName: "check", State: state})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "node-2", ParentID: "graph-1", Event: graph.TraceEventNodeEnd, NodeName: "check", State: "ok"})
+	hook.OnEvent(ctx, &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphEnd, State: state})
+
+	if _, inlined := client.traces[0].Input.(map[string]interface{}); !inlined {
+		t.Fatalf("Expected the trace input inlined, got %v", client.traces[0].Input)
+	}
+	rootStart, loadStart, loadEnd, checkStart, checkEnd := client.spans[0], client.spans[1], client.spans[2], client.spans[3], client.spans[4]
+	inputRef, _ := loadStart.Input.(PayloadReference)
+	traceInput := PayloadReference{Ref: inputRef.Ref, Field: "input"}
+	if inputRef.Ref == "" || rootStart.Input != traceInput || loadStart.Input != traceInput {
+		t.Errorf("Expected the root span and first node inputs to reference the trace input, got %v and %v", rootStart.Input, loadStart.Input)
+	}
+	if _, inlined := loadEnd.Output.(map[string]interface{}); !inlined {
+		t.Errorf("Expected the first copy of the state inlined, got %v", loadEnd.Output)
+	}
+	checkRef, _ := checkStart.Input.(PayloadReference)
+	stateRef := PayloadReference{Ref: checkRef.Ref, ObservationID: loadEnd.ID, Field: "output"}
+	if checkRef.Ref == "" || checkStart.Input != stateRef {
+		t.Errorf("Expected the second node input to reference the first node output, got %v", checkStart.Input)
+	}
+	if checkEnd.Output != "ok" {
+		t.Errorf("Expected small payloads inlined, got %v", checkEnd.Output)
+	}
+	if output := client.traces[len(client.traces)-1].Output; output != stateRef {
+		t.Errorf("Expected the trace output to reference the first node output, got %v", output)
+	}
+}
+
+// Test that the trace and root span report the SDK version of the client
+func TestSDKVersionMetadata(t *testing.T) {
+	hook, client := newTestHook()
+	hook.OnEvent(context.Background(), &graph.TraceSpan{ID: "graph-1", Event: graph.TraceEventGraphStart})
+
+	if len(client.traces) == 0 || len(client.spans) == 0 {
+		t.Fatalf("Expected a trace and a root span, got %d and %d", len(client.traces), len(client.spans))
+	}
+	for name, metadata := range map[string]interface{}{"trace": client.traces[0].Metadata, "root span": client.spans[0].Metadata} {
+		if m, _ := metadata.(map[string]interface{}); m["sdk_version"] != langfuse.Version {
+			t.Errorf("%s: got sdk_version %v, want %s", name, m["sdk_version"], langfuse.Version)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package langgraph
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/internal/pkg/ctxkey"
+	"github.com/paulnegz/langfuse-go/model"
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// ErrInterrupted marks a graph run that paused, e.g. to wait for human approval.
+// Return an error wrapping it from a node to end the run as interrupted rather
+// than failed; invoking the graph again with the same run ID resumes the trace.
+var ErrInterrupted = errors.New("graph interrupted")
+
+// maxInterruptedRuns bounds the paused runs remembered for resumption
+const maxInterruptedRuns = 1000
+
+// runIDKey stores the stable ID of a graph run in a context
+var runIDKey = ctxkey.New[string]("langgraph_run_id")
+
+// ContextWithRunID returns a context that identifies a graph run across
+// interruptions. Invoke the graph with it each time the run starts or resumes,
+// so a resumed run continues the trace of the interrupted one.
+func ContextWithRunID(ctx context.Context, runID string) context.Context {
+	return runIDKey.With(ctx, runID)
+}
+
+// RunIDFromContext returns the graph run ID set with ContextWithRunID
+func RunIDFromContext(ctx context.Context) (string, bool) {
+	runID, found := runIDKey.Value(ctx)
+	return runID, found && runID != ""
+}
+
+// graphRun is an interrupted run waiting to be resumed
+type graphRun struct {
+	trace      *model.Trace
+	rootSpanID string
+	resumes    int
+}
+
+// takeInterruptedRun removes and returns the paused run with runID. h.mu must be held.
+func (h *Hook) takeInterruptedRun(runID string) (*graphRun, bool) {
+	run, found := h.interrupted[runID]
+	if !found {
+		return nil, false
+	}
+	delete(h.interrupted, runID)
+	for i, id := range h.interruptedOrder {
+		if id == runID {
+			h.interruptedOrder = append(h.interruptedOrder[:i], h.interruptedOrder[i+1:]...)
+			break
+		}
+	}
+	return run, true
+}
+
+// storeInterruptedRun remembers a paused run, forgetting the oldest beyond
+// maxInterruptedRuns. h.mu must be held.
+func (h *Hook) storeInterruptedRun(runID string, run *graphRun) {
+	h.interrupted[runID] = run
+	h.interruptedOrder = append(h.interruptedOrder, runID)
+	for len(h.interruptedOrder) > maxInterruptedRuns {
+		delete(h.interrupted, h.interruptedOrder[0])
+		h.interruptedOrder = h.interruptedOrder[1:]
+	}
+}
+
+// resumeGraph continues the trace of an interrupted run. h.mu must be held.
+func (h *Hook) resumeGraph(span *graph.TraceSpan, runID string, run *graphRun, now time.Time) {
+	run.resumes++
+
+	h.traces[span.ID] = run.trace
+	h.observations["langgraph_wrapper"] = run.rootSpanID
+	h.observations["default_parent"] = run.rootSpanID
+	h.observations[span.ID] = run.rootSpanID
+	h.activeRuns[span.ID] = activeRun{id: runID, run: run}
+
+	h.recordRunEvent(run, "graph_resumed", now, h.graphIO(h.initialInput), nil, map[string]interface{}{
+		"graph_span_id": span.ID,
+		"graph_run_id":  runID,
+		"resume_count":  run.resumes,
+	})
+}
+
+// recordRunEvent records an interrupt or resume as an event under the root span
+func (h *Hook) recordRunEvent(run *graphRun, name string, at time.Time, input interface{}, err error, metadata map[string]interface{}) {
+	event := &model.Event{
+		TraceID:   run.trace.ID,
+		Name:      name,
+		StartTime: &at,
+		Input:     input,
+		Metadata:  metadata,
+	}
+	if err != nil {
+		event.StatusMessage = err.Error()
+	}
+
+	parentID := run.rootSpanID
+	if _, eventErr := h.client.Event(event, &parentID); eventErr != nil {
+		log.Printf("Failed to record %s event: %v", name, eventErr)
+	}
+}
+
+// activeRun links a graph span to the run it belongs to
+type activeRun struct {
+	id  string
+	run *graphRun
+}
@@ -0,0 +1,116 @@
+package langgraph
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// NodeTiming is one execution of a node
+type NodeTiming struct {
+	Node  string
+	Start time.Time
+	End   time.Time
+}
+
+// NodeLatency is the time a node took during a run
+type NodeLatency struct {
+	Node       string `json:"node"`
+	Calls      int    `json:"calls"`
+	DurationMs int64  `json:"duration_ms"`
+	// Percent is the node's share of the run's total duration
+	Percent float64 `json:"percent"`
+}
+
+// LatencyBreakdown shows where the time of a graph run went, slowest node first.
+// Nodes that ran in parallel overlap, so their percentages can add up to more
+// than 100; NodeMs counts overlapping time once and OverheadMs is the time no
+// node was running.
+type LatencyBreakdown struct {
+	TotalMs    int64         `json:"total_ms"`
+	NodeMs     int64         `json:"node_ms"`
+	OverheadMs int64         `json:"overhead_ms"`
+	Nodes      []NodeLatency `json:"nodes"`
+}
+
+// NewLatencyBreakdown sums the timings of each node over a run that took total,
+// e.g. from the node observations of a completed trace
+func NewLatencyBreakdown(total time.Duration, timings []NodeTiming) LatencyBreakdown {
+	byNode := make(map[string]*NodeLatency)
+	durations := make(map[string]time.Duration)
+	for _, timing := range timings {
+		latency, seen := byNode[timing.Node]
+		if !seen {
+			latency = &NodeLatency{Node: timing.Node}
+			byNode[timing.Node] = latency
+		}
+		latency.Calls++
+		durations[timing.Node] += timing.End.Sub(timing.Start)
+	}
+
+	busy := busyTime(timings)
+	if total < busy {
+		total = busy
+	}
+
+	breakdown := LatencyBreakdown{
+		TotalMs:    total.Milliseconds(),
+		NodeMs:     busy.Milliseconds(),
+		OverheadMs: (total - busy).Milliseconds(),
+		Nodes:      make([]NodeLatency, 0, len(byNode)),
+	}
+	for node, latency := range byNode {
+		latency.DurationMs = durations[node].Milliseconds()
+		if total > 0 {
+			latency.Percent = math.Round(float64(durations[node])/float64(total)*1000) / 10
+		}
+		breakdown.Nodes = append(breakdown.Nodes, *latency)
+	}
+	sort.Slice(breakdown.Nodes, func(i, j int) bool {
+		if breakdown.Nodes[i].DurationMs != breakdown.Nodes[j].DurationMs {
+			return breakdown.Nodes[i].DurationMs > breakdown.Nodes[j].DurationMs
+		}
+		return breakdown.Nodes[i].Node < breakdown.Nodes[j].Node
+	})
+	return breakdown
+}
+
+// busyTime returns the time covered by at least one timing
+func busyTime(timings []NodeTiming) time.Duration {
+	sorted := append([]NodeTiming(nil), timings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	var busy time.Duration
+	var end time.Time
+	for _, timing := range sorted {
+		if timing.End.Before(end) || timing.End.Equal(end) {
+			continue
+		}
+		start := timing.Start
+		if start.Before(end) {
+			start = end
+		}
+		busy += timing.End.Sub(start)
+		end = timing.End
+	}
+	return busy
+}
+
+// recordNodeTiming adds a finished node to the run of the graph span that
+// contains it. h.mu must not be held.
+func (h *Hook) recordNodeTiming(span *graph.TraceSpan, end time.Time) {
+	start := span.StartTime
+	if start.IsZero() {
+		start = end.Add(-span.Duration)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.timings[span.ParentID] = append(h.timings[span.ParentID], NodeTiming{
+		Node:  span.NodeName,
+		Start: start,
+		End:   end,
+	})
+}
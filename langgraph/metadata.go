@@ -0,0 +1,181 @@
+package langgraph
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+const (
+	// DefaultMaxMetadataKeys bounds the keys of observation and trace metadata
+	DefaultMaxMetadataKeys = 100
+	// DefaultMaxMetadataBytes bounds the JSON size of observation and trace metadata
+	DefaultMaxMetadataBytes = 64 * 1024
+
+	// MetadataTruncatedKey marks metadata that lost keys or values to the limits
+	MetadataTruncatedKey = "_metadata_truncated"
+	// CacheHitKey marks a node that served its result from a cache; nodes set it to
+	// true in their output state or metadata and the hook records it on the observation
+	CacheHitKey = "cache_hit"
+	// RetryCountKey holds how many times a node retried its work before finishing;
+	// nodes set it in their output state or metadata and the hook records it on the observation
+	RetryCountKey = "retry_count"
+	// ConditionalKey marks an edge traversal span as the decision of a conditional edge
+	ConditionalKey = "conditional"
+	// BranchDecisionsKey holds the conditional edge decisions recorded on the root span
+	BranchDecisionsKey = "branch_decisions"
+	// LatencyBreakdownKey holds the per-node latency summary recorded on the trace
+	LatencyBreakdownKey = "latency_breakdown"
+
+	// truncatedMarkerBytes is reserved in the size budget for the marker
+	truncatedMarkerBytes = 96
+	// minTruncatedStringBytes is the smallest remainder worth keeping of a long string
+	minTruncatedStringBytes = 64
+)
+
+// sdkMetadataKeys are recorded by the hook itself and kept before incidental keys
+var sdkMetadataKeys = map[string]bool{
+	"graph_span_id":     true,
+	"graph_run_id":      true,
+	"interrupt":         true,
+	"sdk":               true,
+	"sdk_version":       true,
+	"node_name":         true,
+	"step":              true,
+	"observation_type":  true,
+	CacheHitKey:         true,
+	RetryCountKey:       true,
+	BranchDecisionsKey:  true,
+	LatencyBreakdownKey: true,
+	"duration_ms":       true,
+	"status":            true,
+	"error":             true,
+	"error_type":        true,
+	"error_code":        true,
+	"tags":              true,
+	"user_id":           true,
+	"session_id":        true,
+}
+
+// limitMetadata returns metadata within the configured key and size limits.
+// SDK keys and keys of the configured default metadata are kept first, then
+// the remaining keys in sorted order. Strings that do not fit are shortened
+// and other values that do not fit or cannot be encoded as JSON are dropped;
+// either way the result records what was lost under MetadataTruncatedKey.
+func (h *Hook) limitMetadata(metadata map[string]interface{}) map[string]interface{} {
+	maxKeys := h.config.MaxMetadataKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxMetadataKeys
+	}
+	maxBytes := h.config.MaxMetadataBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxMetadataBytes
+	}
+
+	if len(metadata) <= maxKeys {
+		if encoded, err := json.Marshal(metadata); err == nil && len(encoded) <= maxBytes {
+			return metadata
+		}
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		if k != MetadataTruncatedKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		pi, pj := h.isPriorityMetadataKey(keys[i]), h.isPriorityMetadataKey(keys[j])
+		if pi != pj {
+			return pi
+		}
+		return keys[i] < keys[j]
+	})
+
+	limited := make(map[string]interface{}, min(len(keys), maxKeys))
+	remaining := maxBytes - truncatedMarkerBytes
+	dropped, truncated := 0, 0
+
+	// Metadata limited before, e.g. a trace updated at graph end, keeps its counts
+	if previous, wasLimited := metadata[MetadataTruncatedKey].(map[string]int); wasLimited {
+		dropped, truncated = previous["dropped_keys"], previous["truncated_values"]
+	}
+	for _, k := range keys {
+		if len(limited) >= maxKeys-1 {
+			dropped++
+			continue
+		}
+
+		keySize := len(k) + 4 // quotes, colon and separator
+		valueSize, err := jsonSize(metadata[k])
+		if err != nil {
+			// A value that cannot be encoded would fail the whole ingestion batch
+			dropped++
+			continue
+		}
+		if keySize+valueSize <= remaining {
+			limited[k] = metadata[k]
+			remaining -= keySize + valueSize
+			continue
+		}
+
+		if s, isString := metadata[k].(string); isString && remaining-keySize >= minTruncatedStringBytes {
+			shortened := truncateString(s, remaining-keySize)
+			limited[k] = shortened
+			remaining -= keySize + stringSize(shortened)
+			truncated++
+			continue
+		}
+		dropped++
+	}
+
+	limited[MetadataTruncatedKey] = map[string]int{
+		"dropped_keys":     dropped,
+		"truncated_values": truncated,
+	}
+	return limited
+}
+
+// isPriorityMetadataKey reports whether key was set by the SDK or the user's configuration
+func (h *Hook) isPriorityMetadataKey(key string) bool {
+	if sdkMetadataKeys[key] {
+		return true
+	}
+	if _, configured := h.config.DefaultMetadata[key]; configured {
+		return true
+	}
+	for _, path := range h.config.MetadataFromInput {
+		if inputMetadataKey(path) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonSize returns the encoded size of v
+func jsonSize(v interface{}) (int, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return len(encoded), nil
+}
+
+// stringSize returns the encoded size of s, which always encodes
+func stringSize(s string) int {
+	size, _ := jsonSize(s)
+	return size
+}
+
+// truncateString shortens s so that its JSON encoding fits in maxBytes
+func truncateString(s string, maxBytes int) string {
+	const marker = "...[truncated]"
+	for len(s) > 0 && stringSize(s+marker) > maxBytes {
+		cut := max(0, len(s)-max(1, stringSize(s+marker)-maxBytes))
+		// Avoid splitting a multi-byte rune
+		for cut > 0 && cut < len(s) && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		s = s[:cut]
+	}
+	return s + marker
+}
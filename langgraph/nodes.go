@@ -0,0 +1,132 @@
+package langgraph
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	langfuse "github.com/paulnegz/langfuse-go"
+)
+
+// nodeShardCount is the number of locks the state of running nodes is spread over
+const nodeShardCount = 16
+
+// nodeRun is a node execution in progress, keyed by node span ID
+type nodeRun struct {
+	traceID       string
+	observationID string
+	parentID      *string
+	nodeName      string
+	provider      string
+	// obsType is the node's classified observation type, kept for the node end update
+	obsType langfuse.ObservationType
+	// metadata is the metadata sent at node start, merged into the node end update
+	metadata  map[string]interface{}
+	lastChunk time.Time
+}
+
+// nodeStore holds the running nodes behind per-shard locks keyed by node span
+// ID, so nodes running in parallel do not contend on the hook's lock
+type nodeStore struct {
+	shards [nodeShardCount]nodeShard
+}
+
+type nodeShard struct {
+	mu   sync.Mutex
+	runs map[string]*nodeRun
+}
+
+// shard returns the shard holding the node with spanID
+func (s *nodeStore) shard(spanID string) *nodeShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(spanID))
+	return &s.shards[h.Sum32()%nodeShardCount]
+}
+
+// put records a started node
+func (s *nodeStore) put(spanID string, run *nodeRun) {
+	shard := s.shard(spanID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.runs == nil {
+		shard.runs = make(map[string]*nodeRun)
+	}
+	shard.runs[spanID] = run
+}
+
+// take removes and returns the node with spanID when it is running
+func (s *nodeStore) take(spanID string) (*nodeRun, bool) {
+	shard := s.shard(spanID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	run, running := shard.runs[spanID]
+	delete(shard.runs, spanID)
+	return run, running
+}
+
+// get returns the node with spanID when it is running
+func (s *nodeStore) get(spanID string) (*nodeRun, bool) {
+	shard := s.shard(spanID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	run, running := shard.runs[spanID]
+	return run, running
+}
+
+// len returns the number of running nodes
+func (s *nodeStore) len() int {
+	n := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		n += len(shard.runs)
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+// markChunk finds the running node a chunk belongs to, the node with spanID
+// or else the most recently active node named nodeName, and records now as
+// its latest chunk. It returns the node's span ID, the run and the time since
+// the node's previous chunk.
+func (s *nodeStore) markChunk(spanID string, nodeName string, now time.Time) (string, *nodeRun, time.Duration, bool) {
+	if spanID != "" {
+		shard := s.shard(spanID)
+		shard.mu.Lock()
+		run, running := shard.runs[spanID]
+		if running {
+			latency := now.Sub(run.lastChunk)
+			run.lastChunk = now
+			shard.mu.Unlock()
+			return spanID, run, latency, true
+		}
+		shard.mu.Unlock()
+	}
+
+	var latestID string
+	var latest *nodeRun
+	var latestTime time.Time
+	var latestShard *nodeShard
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.Lock()
+		for id, run := range shard.runs {
+			if run.nodeName != nodeName {
+				continue
+			}
+			if latest == nil || run.lastChunk.After(latestTime) {
+				latestID, latest, latestTime, latestShard = id, run, run.lastChunk, shard
+			}
+		}
+		shard.mu.Unlock()
+	}
+	if latest == nil {
+		return "", nil, 0, false
+	}
+
+	latestShard.mu.Lock()
+	defer latestShard.mu.Unlock()
+	latency := now.Sub(latest.lastChunk)
+	latest.lastChunk = now
+	return latestID, latest, latency, true
+}
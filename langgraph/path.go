@@ -0,0 +1,151 @@
+package langgraph
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// resolvePath looks up a dotted path such as "llm.usage.input" or
+// "$.messages[0].model" in maps, structs, slices and pointers. Struct fields
+// match by Go name (case-insensitive) or by their json tag.
+func resolvePath(root interface{}, path string) (interface{}, bool) {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return nil, false
+	}
+
+	current := reflect.ValueOf(root)
+	for _, segment := range segments {
+		current = indirect(current)
+		if !current.IsValid() {
+			return nil, false
+		}
+
+		switch current.Kind() {
+		case reflect.Map:
+			if current.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			value := current.MapIndex(reflect.ValueOf(segment).Convert(current.Type().Key()))
+			if !value.IsValid() {
+				return nil, false
+			}
+			current = value
+		case reflect.Struct:
+			field, found := structField(current, segment)
+			if !found {
+				return nil, false
+			}
+			current = field
+		case reflect.Slice, reflect.Array:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= current.Len() {
+				return nil, false
+			}
+			current = current.Index(index)
+		default:
+			return nil, false
+		}
+	}
+
+	current = indirect(current)
+	if !current.IsValid() || !current.CanInterface() {
+		return nil, false
+	}
+	return current.Interface(), true
+}
+
+// splitPath splits a path into its segments, treating "[n]" as ".n" and
+// ignoring a leading "$"
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// inputMetadataKey returns the metadata key of a WithMetadataFromInput path:
+// its last segment
+func inputMetadataKey(path string) string {
+	segments := splitPath(path)
+	if len(segments) == 0 {
+		return path
+	}
+	return segments[len(segments)-1]
+}
+
+// indirect dereferences pointers and interfaces
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// structField finds an exported field by json tag or case-insensitive name
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); tag == name {
+			return v.Field(i), true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.IsExported() && strings.EqualFold(field.Name, name) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// resolveSpanPath resolves a path against the span's state and then its
+// metadata. A "state." or "metadata." prefix restricts the lookup to one of them.
+func resolveSpanPath(span *graph.TraceSpan, path string) (interface{}, bool) {
+	if rest, found := strings.CutPrefix(path, "state."); found {
+		return resolvePath(span.State, rest)
+	}
+	if rest, found := strings.CutPrefix(path, "metadata."); found {
+		return resolvePath(span.Metadata, rest)
+	}
+	if value, found := resolvePath(span.State, path); found {
+		return value, true
+	}
+	return resolvePath(span.Metadata, path)
+}
+
+// toInt converts a resolved numeric value to an int
+func toInt(value interface{}) (int, bool) {
+	v := indirect(reflect.ValueOf(value))
+	if !v.IsValid() {
+		return 0, false
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return int(v.Float()), true
+	case reflect.String:
+		n, err := strconv.Atoi(v.String())
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,92 @@
+package langgraph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// minReferencedPayloadBytes is the JSON size below which payloads are always
+// inlined, as a reference would be about as large
+const minReferencedPayloadBytes = 128
+
+// PayloadReference replaces an input or output identical to one already
+// recorded in the same trace when WithPayloadReferences is enabled. The full
+// payload is on the trace when ObservationID is empty, and otherwise on that
+// observation, in the input or output named by Field. Ref is "sha256:" followed
+// by the hex SHA-256 of the payload's JSON, so a reader can check a resolved
+// payload is the one referenced.
+type PayloadReference struct {
+	Ref           string `json:"$ref"`
+	ObservationID string `json:"observation_id,omitempty"`
+	Field         string `json:"field"`
+}
+
+// payloadLocation is where the first copy of a payload was recorded
+type payloadLocation struct {
+	observationID string
+	field         string
+}
+
+// payloadIndex remembers the payloads recorded in each trace by digest. It has
+// its own lock as payloads are recorded both with and without h.mu held.
+type payloadIndex struct {
+	mu     sync.Mutex
+	traces map[string]map[string]payloadLocation
+}
+
+// referencePayload returns a reference to payload when an identical payload
+// was already recorded in the trace. Otherwise it returns payload with the
+// digest to pass to rememberPayload once it is recorded; the digest is empty
+// for payloads that are always inlined: small ones and ones that cannot be
+// serialized.
+func (h *Hook) referencePayload(traceID string, payload interface{}) (interface{}, string) {
+	if !h.config.PayloadReferences || payload == nil {
+		return payload, ""
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil || len(encoded) < minReferencedPayloadBytes {
+		return payload, ""
+	}
+	sum := sha256.Sum256(encoded)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	h.payloads.mu.Lock()
+	defer h.payloads.mu.Unlock()
+	if location, recorded := h.payloads.traces[traceID][digest]; recorded {
+		return PayloadReference{Ref: digest, ObservationID: location.observationID, Field: location.field}, ""
+	}
+	return payload, digest
+}
+
+// rememberPayload records where the payload with digest was sent in full, so
+// later copies reference it. Payloads whose observation failed to send are
+// not remembered and stay inlined.
+func (h *Hook) rememberPayload(traceID string, digest string, observationID string, field string) {
+	if digest == "" {
+		return
+	}
+
+	h.payloads.mu.Lock()
+	defer h.payloads.mu.Unlock()
+	if h.payloads.traces == nil {
+		h.payloads.traces = make(map[string]map[string]payloadLocation)
+	}
+	recorded := h.payloads.traces[traceID]
+	if recorded == nil {
+		recorded = make(map[string]payloadLocation)
+		h.payloads.traces[traceID] = recorded
+	}
+	if _, exists := recorded[digest]; !exists {
+		recorded[digest] = payloadLocation{observationID: observationID, field: field}
+	}
+}
+
+// forgetPayloads drops the payloads remembered for a finished trace
+func (h *Hook) forgetPayloads(traceID string) {
+	h.payloads.mu.Lock()
+	defer h.payloads.mu.Unlock()
+	delete(h.payloads.traces, traceID)
+}
@@ -0,0 +1,214 @@
+package langgraph
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// Test that Stream forwards each node's output as it completes
+func TestTracedRunnableStream(t *testing.T) {
+	var secondRuns atomic.Int32
+	newRunnable := func(t *testing.T, secondErr error) *graph.Runnable {
+		g := graph.NewMessageGraph()
+		g.AddNode("first", func(ctx context.Context, state interface{}) (interface{}, error) {
+			return state.(int) + 1, nil
+		})
+		g.AddNode("second", func(ctx context.Context, state interface{}) (interface{}, error) {
+			secondRuns.Add(1)
+			return state.(int) * 10, secondErr
+		})
+		g.SetEntryPoint("first")
+		g.AddEdge("first", "second")
+		g.AddEdge("second", graph.END)
+
+		runnable, err := g.Compile()
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		return runnable
+	}
+
+	t.Run("Intermediate outputs", func(t *testing.T) {
+		hook, client := newTestHook()
+		out, errCh := NewTracedRunnable(newRunnable(t, nil), hook).Stream(context.Background(), 1)
+
+		var got []interface{}
+		for v := range out {
+			got = append(got, v)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(got) != 2 || got[0] != 2 || got[1] != 20 {
+			t.Errorf("Expected outputs [2 20], got %v", got)
+		}
+		if len(client.spans) == 0 {
+			t.Error("Expected node spans to be traced")
+		}
+	})
+
+	t.Run("Back-pressure", func(t *testing.T) {
+		started := make(chan struct{})
+		g := graph.NewMessageGraph()
+		g.AddNode("first", func(ctx context.Context, state interface{}) (interface{}, error) {
+			return state.(int) + 1, nil
+		})
+		g.AddNode("second", func(ctx context.Context, state interface{}) (interface{}, error) {
+			started <- struct{}{}
+			return state.(int) * 10, nil
+		})
+		g.SetEntryPoint("first")
+		g.AddEdge("first", "second")
+		g.AddEdge("second", graph.END)
+		runnable, err := g.Compile()
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+
+		hook, _ := newTestHook()
+		out, _ := NewTracedRunnable(runnable, hook).Stream(context.Background(), 1)
+
+		// The graph waits for the first output to be read before the second node starts
+		select {
+		case <-started:
+			t.Fatal("Second node started before the first output was read")
+		case v := <-out:
+			if v != 2 {
+				t.Errorf("Expected 2, got %v", v)
+			}
+		}
+		<-started
+		if v := <-out; v != 20 {
+			t.Errorf("Expected 20, got %v", v)
+		}
+	})
+
+	t.Run("State graph", func(t *testing.T) {
+		started := make(chan struct{})
+		g := graph.NewStateGraph()
+		g.AddNode("first", StreamNode(func(ctx context.Context, state interface{}) (interface{}, error) {
+			return state.(int) + 1, nil
+		}))
+		g.AddNode("second", StreamNode(func(ctx context.Context, state interface{}) (interface{}, error) {
+			started <- struct{}{}
+			return state.(int) * 10, nil
+		}))
+		g.SetEntryPoint("first")
+		g.AddEdge("first", "second")
+		g.AddEdge("second", graph.END)
+		runnable, err := g.Compile()
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+
+		hook, client := newTestHook()
+		out, errCh := NewTracedRunnable(runnable, hook).Stream(context.Background(), 1)
+
+		select {
+		case <-started:
+			t.Fatal("Second node started before the first output was read")
+		case v := <-out:
+			if v != 2 {
+				t.Errorf("Expected 2, got %v", v)
+			}
+		}
+		<-started
+		var rest []interface{}
+		for v := range out {
+			rest = append(rest, v)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// The final state is not sent again after the last node streamed it
+		if len(rest) != 1 || rest[0] != 20 {
+			t.Errorf("Expected the remaining outputs [20], got %v", rest)
+		}
+		if len(client.spans) == 0 {
+			t.Error("Expected the run to be traced")
+		}
+
+		// Outside Stream the wrapped nodes run as usual
+		go func() { <-started }()
+		result, err := NewTracedRunnable(runnable).Invoke(context.Background(), 1)
+		if err != nil || result != 20 {
+			t.Errorf("Expected 20, got %v and %v", result, err)
+		}
+	})
+
+	t.Run("Node error", func(t *testing.T) {
+		boom := errors.New("boom")
+		hook, _ := newTestHook()
+		out, errCh := NewTracedRunnable(newRunnable(t, boom), hook).Stream(context.Background(), 1)
+
+		for range out {
+		}
+		if err := <-errCh; !errors.Is(err, boom) {
+			t.Errorf("Expected boom, got %v", err)
+		}
+	})
+
+	t.Run("Cancelled mid-stream", func(t *testing.T) {
+		g := graph.NewMessageGraph()
+		g.AddNode("fast", func(ctx context.Context, state interface{}) (interface{}, error) {
+			return "partial", nil
+		})
+		g.AddNode("slow", func(ctx context.Context, state interface{}) (interface{}, error) {
+			// Ignores ctx, so only Stream itself can stop waiting
+			time.Sleep(2 * time.Second)
+			return "final", nil
+		})
+		g.SetEntryPoint("fast")
+		g.AddEdge("fast", "slow")
+		g.AddEdge("slow", graph.END)
+		runnable, err := g.Compile()
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+
+		hook, _ := newTestHook()
+		ctx, cancel := context.WithCancel(context.Background())
+		out, errCh := NewTracedRunnable(runnable, hook).Stream(ctx, nil)
+
+		if v := <-out; v != "partial" {
+			t.Fatalf("Expected the partial result, got %v", v)
+		}
+		cancel()
+
+		select {
+		case v, open := <-out:
+			if open {
+				t.Errorf("Expected the output channel to close, got %v", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Stream did not stop after cancellation")
+		}
+		if err := <-errCh; !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("Deadline on a non-streaming runnable", func(t *testing.T) {
+		slow := RunnableFunc(func(ctx context.Context, input interface{}) (interface{}, error) {
+			time.Sleep(2 * time.Second)
+			return input, nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		out, errCh := NewTracedRunnable(slow).Stream(ctx, "input")
+
+		for range out {
+			t.Error("Expected no output after the deadline")
+		}
+		if err := <-errCh; !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package langgraph
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// GraphTopology describes the nodes and edges of a workflow
+type GraphTopology struct {
+	// EntryPoint is the first node executed
+	EntryPoint string `json:"entry_point,omitempty"`
+	// Nodes lists the node names in the graph
+	Nodes []string `json:"nodes"`
+	// Edges is an adjacency list from a node to its successors
+	Edges map[string][]string `json:"edges"`
+	// ConditionalEdges maps a node to the branch targets its condition may select
+	ConditionalEdges map[string][]string `json:"conditional_edges,omitempty"`
+}
+
+// NewGraphTopology creates an empty topology
+func NewGraphTopology() *GraphTopology {
+	return &GraphTopology{
+		Nodes: make([]string, 0),
+		Edges: make(map[string][]string),
+	}
+}
+
+// AddNode records a node if it is not already present
+func (t *GraphTopology) AddNode(name string) {
+	for _, existing := range t.Nodes {
+		if existing == name {
+			return
+		}
+	}
+	t.Nodes = append(t.Nodes, name)
+}
+
+// AddEdge records an edge if it is not already present
+func (t *GraphTopology) AddEdge(from, to string) {
+	if from != graph.END {
+		t.AddNode(from)
+	}
+	if to != graph.END {
+		t.AddNode(to)
+	}
+
+	for _, existing := range t.Edges[from] {
+		if existing == to {
+			return
+		}
+	}
+	t.Edges[from] = append(t.Edges[from], to)
+}
+
+// AddConditionalEdge records the possible branch targets of a conditional node
+// if they are not already present
+func (t *GraphTopology) AddConditionalEdge(from string, targets ...string) {
+	if t.ConditionalEdges == nil {
+		t.ConditionalEdges = make(map[string][]string)
+	}
+	t.AddNode(from)
+	for _, target := range targets {
+		if !slices.Contains(t.ConditionalEdges[from], target) {
+			t.ConditionalEdges[from] = append(t.ConditionalEdges[from], target)
+		}
+	}
+}
+
+// clone returns a deep copy safe to attach to observation metadata
+func (t *GraphTopology) clone() *GraphTopology {
+	c := &GraphTopology{
+		EntryPoint: t.EntryPoint,
+		Nodes:      append([]string(nil), t.Nodes...),
+		Edges:      make(map[string][]string, len(t.Edges)),
+	}
+	for from, to := range t.Edges {
+		c.Edges[from] = append([]string(nil), to...)
+	}
+	if t.ConditionalEdges != nil {
+		c.ConditionalEdges = make(map[string][]string, len(t.ConditionalEdges))
+		for from, to := range t.ConditionalEdges {
+			c.ConditionalEdges[from] = append([]string(nil), to...)
+		}
+	}
+	sort.Strings(c.Nodes)
+	return c
+}
+
+// TopologyFromRunnable extracts the topology of a compiled message graph.
+// langgraphgo does not expose its nodes and edges directly, so the Mermaid
+// export is parsed instead. Conditional edges are not included in that export.
+func TopologyFromRunnable(runnable *graph.Runnable) *GraphTopology {
+	if runnable == nil {
+		return nil
+	}
+
+	topology := NewGraphTopology()
+	for _, line := range strings.Split(runnable.GetGraph().DrawMermaid(), "\n") {
+		line = strings.TrimSpace(line)
+
+		if from, to, isEdge := strings.Cut(line, " --> "); isEdge {
+			if from == "START" {
+				topology.EntryPoint = to
+				topology.AddNode(to)
+				continue
+			}
+			topology.AddEdge(from, to)
+			continue
+		}
+
+		// Node declarations look like name["name"] or name[["name"]];
+		// START and END are declared as name(["name"])
+		if idx := strings.IndexAny(line, "[("); idx > 0 {
+			name := line[:idx]
+			if name != "START" && name != graph.END {
+				topology.AddNode(name)
+			}
+		}
+	}
+
+	if len(topology.Nodes) == 0 {
+		return nil
+	}
+	return topology
+}
@@ -2,8 +2,11 @@ package langgraph
 
 import (
 	"context"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
+	langfuse "github.com/paulnegz/langfuse-go"
 	"github.com/tmc/langgraphgo/graph"
 )
 
@@ -71,7 +74,7 @@ type TraceHookBuilder struct {
 // NewBuilder creates a new hook builder
 func NewBuilder() *TraceHookBuilder {
 	return &TraceHookBuilder{
-		hook: NewHook(),
+		hook: newHook(defaultConfig()),
 	}
 }
 
@@ -81,12 +84,24 @@ func (b *TraceHookBuilder) WithAutoFlush(enabled bool) *TraceHookBuilder {
 	return b
 }
 
-// WithMetadata adds metadata
+// WithMetadata replaces the default metadata
 func (b *TraceHookBuilder) WithMetadata(metadata map[string]interface{}) *TraceHookBuilder {
 	b.hook.config.DefaultMetadata = metadata
 	return b
 }
 
+// WithMetadataFromInput copies values at dotted paths of the initial input into trace metadata
+func (b *TraceHookBuilder) WithMetadataFromInput(paths ...string) *TraceHookBuilder {
+	WithMetadataFromInput(paths...)(b.hook.config)
+	return b
+}
+
+// WithMetadataMerge adds to the default metadata
+func (b *TraceHookBuilder) WithMetadataMerge(metadata map[string]interface{}) *TraceHookBuilder {
+	WithMetadataMerge(metadata)(b.hook.config)
+	return b
+}
+
 // WithTraceName sets the trace name
 func (b *TraceHookBuilder) WithTraceName(name string) *TraceHookBuilder {
 	b.hook.config.TraceName = name
@@ -105,14 +120,217 @@ func (b *TraceHookBuilder) WithUserID(id string) *TraceHookBuilder {
 	return b
 }
 
-// WithTags adds tags
+// WithTraceNameFunc derives the trace name from the initial input when no name is set
+func (b *TraceHookBuilder) WithTraceNameFunc(fn func(state interface{}) string) *TraceHookBuilder {
+	b.hook.config.TraceNameFunc = fn
+	return b
+}
+
+// WithEntryPointTraceName names traces after the entry node when no name is set
+func (b *TraceHookBuilder) WithEntryPointTraceName(enabled bool) *TraceHookBuilder {
+	b.hook.config.TraceNameFromEntryPoint = enabled
+	return b
+}
+
+// WithUserIDFunc derives the user ID from the initial input
+func (b *TraceHookBuilder) WithUserIDFunc(fn func(state interface{}) string) *TraceHookBuilder {
+	b.hook.config.UserIDFunc = fn
+	return b
+}
+
+// WithSessionIDFunc derives the session ID from the initial input
+func (b *TraceHookBuilder) WithSessionIDFunc(fn func(state interface{}) string) *TraceHookBuilder {
+	b.hook.config.SessionIDFunc = fn
+	return b
+}
+
+// WithPublic makes traces shareable via their link
+func (b *TraceHookBuilder) WithPublic(public bool) *TraceHookBuilder {
+	b.hook.config.Public = public
+	return b
+}
+
+// WithTags replaces the trace tags, including the defaults
 func (b *TraceHookBuilder) WithTags(tags ...string) *TraceHookBuilder {
 	b.hook.config.Tags = tags
 	return b
 }
 
+// WithTagsMerge adds trace tags, keeping the defaults
+func (b *TraceHookBuilder) WithTagsMerge(tags ...string) *TraceHookBuilder {
+	WithTagsMerge(tags)(b.hook.config)
+	return b
+}
+
+// WithEdgeTracing records conditional edge decisions in the root span metadata
+func (b *TraceHookBuilder) WithEdgeTracing(enabled bool) *TraceHookBuilder {
+	b.hook.config.EdgeTracing = enabled
+	return b
+}
+
+// WithVersion records the version of the graph's code on traces and observations
+func (b *TraceHookBuilder) WithVersion(version string) *TraceHookBuilder {
+	b.hook.config.Version = version
+	return b
+}
+
+// WithNodeVersions overrides the version recorded on individual nodes
+func (b *TraceHookBuilder) WithNodeVersions(versions map[string]string) *TraceHookBuilder {
+	b.hook.config.NodeVersions = versions
+	return b
+}
+
+// WithoutEvents makes the hook ignore events of the given types
+func (b *TraceHookBuilder) WithoutEvents(events ...graph.TraceEvent) *TraceHookBuilder {
+	b.hook.config.SuppressedEvents = append(b.hook.config.SuppressedEvents, events...)
+	return b
+}
+
+// WithLatencyBreakdown records the time spent in each node in the trace metadata
+func (b *TraceHookBuilder) WithLatencyBreakdown(enabled bool) *TraceHookBuilder {
+	b.hook.config.LatencyBreakdown = enabled
+	return b
+}
+
+// WithGraphTopology records the graph structure in the root span metadata
+func (b *TraceHookBuilder) WithGraphTopology(enabled bool) *TraceHookBuilder {
+	b.hook.config.GraphTopology = enabled
+	return b
+}
+
+// WithTagInheritance copies trace tags, or only the given ones, onto node observations
+func (b *TraceHookBuilder) WithTagInheritance(enabled bool, tags ...string) *TraceHookBuilder {
+	WithTagInheritance(enabled, tags...)(b.hook.config)
+	return b
+}
+
+// WithNameSanitizer sets the sanitizer applied to node observation names
+func (b *TraceHookBuilder) WithNameSanitizer(sanitizer langfuse.NameSanitizer) *TraceHookBuilder {
+	b.hook.config.NameSanitizer = sanitizer
+	return b
+}
+
+// WithIOScope sets where input and output payloads are recorded
+func (b *TraceHookBuilder) WithIOScope(scope IOScope) *TraceHookBuilder {
+	b.hook.config.IOScope = scope
+	return b
+}
+
+// WithTailSampling buffers each graph's events and sends them at graph end only if keep returns true
+func (b *TraceHookBuilder) WithTailSampling(keep func(*langfuse.BufferedTrace) bool, maxTraces int) *TraceHookBuilder {
+	b.hook.config.TailSampler = keep
+	b.hook.config.MaxBufferedTraces = maxTraces
+	if lf, isLangfuse := b.hook.client.(*langfuse.Langfuse); isLangfuse && lf != nil {
+		lf.WithTraceBuffering(keep, maxTraces)
+	}
+	return b
+}
+
+// WithStateFlattening lifts the named state fields to the top of recorded outputs
+func (b *TraceHookBuilder) WithStateFlattening(promoted ...string) *TraceHookBuilder {
+	WithStateFlattening(promoted...)(b.hook.config)
+	return b
+}
+
+// WithOutputExtractor projects the final state onto the trace output
+func (b *TraceHookBuilder) WithOutputExtractor(fn func(finalState interface{}) interface{}) *TraceHookBuilder {
+	b.hook.config.OutputExtractor = fn
+	return b
+}
+
+// WithNodeTypeClassifier records observation types such as tool or retriever on non-AI nodes
+func (b *TraceHookBuilder) WithNodeTypeClassifier(classify func(nodeName string, metadata map[string]interface{}) langfuse.ObservationType) *TraceHookBuilder {
+	b.hook.config.NodeTypeClassifier = classify
+	return b
+}
+
+// WithCaptureStackTrace records a stack trace on errored nodes
+func (b *TraceHookBuilder) WithCaptureStackTrace(capture bool) *TraceHookBuilder {
+	b.hook.config.CaptureStackTrace = capture
+	return b
+}
+
+// WithPayloadReferences records repeats of an input or output within a trace as references
+func (b *TraceHookBuilder) WithPayloadReferences(enabled bool) *TraceHookBuilder {
+	b.hook.config.PayloadReferences = enabled
+	return b
+}
+
+// WithSkipCachedUsage records no token usage on AI nodes that report a cache hit
+func (b *TraceHookBuilder) WithSkipCachedUsage(skip bool) *TraceHookBuilder {
+	b.hook.config.SkipCachedUsage = skip
+	return b
+}
+
+// WithModelPath reads the model name from a dotted path in node state or metadata
+func (b *TraceHookBuilder) WithModelPath(path string) *TraceHookBuilder {
+	b.hook.config.ModelPath = path
+	return b
+}
+
+// WithUsagePath reads token usage from a dotted path in node state or metadata
+func (b *TraceHookBuilder) WithUsagePath(path string) *TraceHookBuilder {
+	b.hook.config.UsagePath = path
+	return b
+}
+
+// WithProviderResolver records the provider of AI nodes derived from their model name
+func (b *TraceHookBuilder) WithProviderResolver(r *langfuse.ProviderResolver) *TraceHookBuilder {
+	b.hook.config.ProviderResolver = r
+	return b
+}
+
+// WithModelNormalizer maps model names to canonical names
+func (b *TraceHookBuilder) WithModelNormalizer(n *langfuse.ModelNormalizer) *TraceHookBuilder {
+	b.hook.config.ModelNormalizer = n
+	return b
+}
+
+// WithCanonicalJSON records payloads in canonical JSON form
+func (b *TraceHookBuilder) WithCanonicalJSON(enabled bool) *TraceHookBuilder {
+	b.hook.config.CanonicalJSON = enabled
+	if lf, isLangfuse := b.hook.client.(*langfuse.Langfuse); isLangfuse && lf != nil {
+		lf.WithCanonicalJSON(enabled)
+	}
+	return b
+}
+
+// WithMaxInlineMediaSize uploads larger string and byte payloads as media
+func (b *TraceHookBuilder) WithMaxInlineMediaSize(size int) *TraceHookBuilder {
+	b.hook.config.MaxInlineMediaSize = size
+	return b
+}
+
+// WithMetadataLimits bounds the number of keys and the size of event metadata
+func (b *TraceHookBuilder) WithMetadataLimits(maxKeys, maxBytes int) *TraceHookBuilder {
+	WithMetadataLimits(maxKeys, maxBytes)(b.hook.config)
+	return b
+}
+
+// WithLogger routes the hook's notices to logger
+func (b *TraceHookBuilder) WithLogger(logger *slog.Logger) *TraceHookBuilder {
+	b.hook.config.Logger = logger
+	return b
+}
+
+// WithSuppressDisabledLog skips the notice logged when Langfuse is not configured
+func (b *TraceHookBuilder) WithSuppressDisabledLog(suppress bool) *TraceHookBuilder {
+	b.hook.config.SuppressDisabledLog = suppress
+	return b
+}
+
+// WithClock sets the clock used for timestamps
+func (b *TraceHookBuilder) WithClock(clock langfuse.Clock) *TraceHookBuilder {
+	b.hook.config.Clock = clock
+	if lf, isLangfuse := b.hook.client.(*langfuse.Langfuse); isLangfuse && lf != nil {
+		lf.WithClock(clock)
+	}
+	return b
+}
+
 // Build returns the configured hook
 func (b *TraceHookBuilder) Build() *Hook {
+	b.hook.logDisabled()
 	return b.hook
 }
 
@@ -148,21 +366,73 @@ func (t *TracedRunnable) Invoke(ctx context.Context, input interface{}) (interfa
 
 	// For graph.Runnable, use the traced version from langgraphgo
 	if graphRunnable, isGraphRunnable := t.runnable.(*graph.Runnable); isGraphRunnable {
+		t.attachTopology(graphRunnable)
 		traced := graph.NewTracedRunnable(graphRunnable, t.tracer)
 		return traced.Invoke(ctx, input)
 	}
 
-	// For graph.StateRunnable, execute directly (no traced version available)
-	if stateRunnable, isStateRunnable := t.runnable.(*graph.StateRunnable); isStateRunnable {
-		return stateRunnable.Invoke(ctx, input)
+	// Other runnables, including *graph.StateRunnable, cannot report their nodes
+	return t.invokeSynthesized(ctx, input)
+}
+
+// invokeSynthesized runs a runnable that has no langgraphgo tracing as a graph
+// with a single node, so hooks receive graph_start, node_start, node_end (or
+// node_error) and graph_end events around it
+func (t *TracedRunnable) invokeSynthesized(ctx context.Context, input interface{}) (interface{}, error) {
+	graphSpan := t.tracer.StartSpan(ctx, graph.TraceEventGraphStart, "")
+	ctx = graph.ContextWithSpan(ctx, graphSpan)
+
+	nodeSpan := t.tracer.StartSpan(ctx, graph.TraceEventNodeStart, runnableName(t.runnable))
+	nodeCtx := graph.ContextWithSpan(ctx, nodeSpan)
+
+	result, err := t.runnable.Invoke(nodeCtx, input)
+
+	t.tracer.EndSpan(nodeCtx, nodeSpan, result, err)
+	t.tracer.EndSpan(ctx, graphSpan, result, err)
+
+	return result, err
+}
+
+// RunnableFunc adapts a function to Runnable so it can be traced with
+// NewTracedRunnable like a graph
+type RunnableFunc func(ctx context.Context, input interface{}) (interface{}, error)
+
+// Invoke calls f
+func (f RunnableFunc) Invoke(ctx context.Context, input interface{}) (interface{}, error) {
+	return f(ctx, input)
+}
+
+// defaultRunnableNodeName names the synthesized node of runnables without a Name method
+const defaultRunnableNodeName = "run"
+
+// runnableName returns the node name used when tracing runnable
+func runnableName(runnable Runnable) string {
+	if named, isNamed := runnable.(interface{ Name() string }); isNamed && named.Name() != "" {
+		return named.Name()
 	}
+	return defaultRunnableNodeName
+}
 
-	// For other runnables, execute directly without langgraphgo tracing
-	// (the hooks will still receive events via other mechanisms if implemented)
-	return t.runnable.Invoke(ctx, input)
+// attachTopology supplies the compiled graph's structure to hooks that record it
+func (t *TracedRunnable) attachTopology(runnable *graph.Runnable) {
+	for _, hook := range t.hooks {
+		if h, isHook := hook.(*Hook); isHook && h.config.GraphTopology {
+			h.setRunnableTopology(TopologyFromRunnable(runnable))
+		}
+	}
 }
 
-// Stream executes the runnable with streaming and tracing
+// Stream executes the runnable with streaming and tracing. For a *graph.Runnable
+// the state produced by each node is sent as soon as the node completes; sends
+// block until the consumer reads them, so a slow consumer pauses the graph rather
+// than buffering its output. Other runnables, such as a *graph.StateRunnable,
+// cannot report their nodes: they stream the outputs of node functions wrapped
+// with StreamNode in the same way, and otherwise send their final result once.
+// Both channels are closed when execution ends.
+//
+// When ctx is done the stream stops immediately, even while a node is running:
+// outputs already produced have been delivered, ctx.Err() is sent on the error
+// channel and both channels are closed.
 func (t *TracedRunnable) Stream(ctx context.Context, input interface{}) (<-chan interface{}, <-chan error) {
 	// Set initial input for hooks that support it
 	for _, hook := range t.hooks {
@@ -171,24 +441,157 @@ func (t *TracedRunnable) Stream(ctx context.Context, input interface{}) (<-chan
 		}
 	}
 
-	// Execute with tracing - use the same logic as Invoke
-	result, err := t.Invoke(ctx, input)
-	ch := make(chan interface{}, 1)
+	graphRunnable, isGraphRunnable := t.runnable.(*graph.Runnable)
+	if !isGraphRunnable {
+		// Nodes wrapped with StreamNode find the stream in their context
+		chunks := make(chan streamChunk)
+		stream := &streamHook{ctx: ctx, out: chunks}
+		nodeCtx := context.WithValue(ctx, streamContextKey{}, stream)
+		return t.stream(ctx, chunks, func() (interface{}, error) {
+			result, err := t.Invoke(nodeCtx, input)
+			// The final state was already streamed by the last wrapped node
+			if stream.sent.Load() {
+				return nil, err
+			}
+			return result, err
+		})
+	}
+
+	t.attachTopology(graphRunnable)
+
+	// A dedicated tracer keeps the forwarding hook out of Invoke
+	chunks := make(chan streamChunk)
+	tracer := graph.NewTracer()
+	for _, hook := range t.hooks {
+		tracer.AddHook(hook)
+	}
+	tracer.AddHook(&streamHook{ctx: ctx, out: chunks})
+
+	return t.stream(ctx, chunks, func() (interface{}, error) {
+		// The final state was already streamed by the last node
+		_, err := graph.NewTracedRunnable(graphRunnable, tracer).Invoke(ctx, input)
+		return nil, err
+	})
+}
+
+// streamResult is the outcome of the execution behind a stream
+type streamResult struct {
+	value interface{}
+	err   error
+}
+
+// stream runs execute in the background, forwarding chunks and then its non-nil
+// result until it returns or ctx is done
+func (t *TracedRunnable) stream(ctx context.Context, chunks <-chan streamChunk, execute func() (interface{}, error)) (<-chan interface{}, <-chan error) {
+	ch := make(chan interface{})
 	errCh := make(chan error, 1)
 
+	done := make(chan streamResult, 1)
 	go func() {
-		if err != nil {
-			errCh <- err
-		} else {
-			ch <- result
+		value, err := execute()
+		done <- streamResult{value: value, err: err}
+	}()
+
+	go func() {
+		defer close(errCh)
+		defer close(ch)
+
+		send := func(value interface{}) bool {
+			select {
+			case ch <- value:
+				return true
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return false
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case chunk := <-chunks:
+				if !send(chunk.value) {
+					return
+				}
+				close(chunk.delivered)
+			case result := <-done:
+				if result.err != nil {
+					errCh <- result.err
+					return
+				}
+				if result.value != nil {
+					send(result.value)
+				}
+				return
+			}
 		}
-		close(ch)
-		close(errCh)
 	}()
 
 	return ch, errCh
 }
 
+// streamChunk is a node output on its way to the stream consumer
+type streamChunk struct {
+	value     interface{}
+	delivered chan struct{}
+}
+
+// streamHook forwards node outputs to a stream as nodes complete
+type streamHook struct {
+	ctx  context.Context
+	out  chan<- streamChunk
+	sent atomic.Bool
+}
+
+// OnEvent sends the state of completed nodes
+func (s *streamHook) OnEvent(_ context.Context, span *graph.TraceSpan) {
+	if span.Event == graph.TraceEventNodeEnd {
+		s.send(span.State)
+	}
+}
+
+// send forwards a node output and waits until the consumer has received it,
+// which holds the graph back from running the next node
+func (s *streamHook) send(value interface{}) {
+	s.sent.Store(true)
+	chunk := streamChunk{value: value, delivered: make(chan struct{})}
+	select {
+	case s.out <- chunk:
+	case <-s.ctx.Done():
+		return
+	}
+
+	select {
+	case <-chunk.delivered:
+	case <-s.ctx.Done():
+	}
+}
+
+// streamContextKey is the context key of the stream StreamNode sends to
+type streamContextKey struct{}
+
+// StreamNode wraps a node function so that TracedRunnable.Stream sends its
+// output as soon as it returns, for runnables that cannot report their nodes,
+// such as a *graph.StateRunnable:
+//
+//	g.AddNode("classify", langgraph.StreamNode(classify))
+//
+// Like the nodes of a *graph.Runnable, the node returns once the consumer has
+// read its output. Once a node has streamed, the final result is not sent
+// again, so wrap the last node too. Outside Stream, and when fn fails, the
+// wrapped function behaves like fn.
+func StreamNode(fn func(ctx context.Context, state interface{}) (interface{}, error)) func(ctx context.Context, state interface{}) (interface{}, error) {
+	return func(ctx context.Context, state interface{}) (interface{}, error) {
+		result, err := fn(ctx, state)
+		if stream, streaming := ctx.Value(streamContextKey{}).(*streamHook); streaming && err == nil {
+			stream.send(result)
+		}
+		return result, err
+	}
+}
+
 // EventFilter allows filtering of trace events
 type EventFilter struct {
 	// IncludeEvents specifies which events to include
@@ -0,0 +1,168 @@
+package langgraph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// OutputFormat selects how the WriterHook renders events
+type OutputFormat int
+
+const (
+	// OutputFormatText writes one human-readable line per event, aligned in a
+	// table with the other lines of the run. Lines are written when the graph
+	// ends or a node fails, or on Flush.
+	OutputFormatText OutputFormat = iota
+	// OutputFormatJSON writes one compact JSON object per line, suitable for grep and jq
+	OutputFormatJSON
+	// OutputFormatPrettyJSON writes indented JSON objects
+	OutputFormatPrettyJSON
+)
+
+// WriterHook implements graph.TraceHook by writing events to an io.Writer.
+// It is intended as a local debug sink that works without Langfuse credentials.
+type WriterHook struct {
+	w            io.Writer
+	format       OutputFormat
+	includeState bool
+	mu           sync.Mutex
+	// table aligns the lines of the text format across events
+	table *tabwriter.Writer
+}
+
+// WriterOption configures a WriterHook
+type WriterOption func(*WriterHook)
+
+// WithOutputFormat sets the output format
+func WithOutputFormat(format OutputFormat) WriterOption {
+	return func(h *WriterHook) {
+		h.format = format
+	}
+}
+
+// WithStateInOutput includes node state payloads in the output
+func WithStateInOutput(include bool) WriterOption {
+	return func(h *WriterHook) {
+		h.includeState = include
+	}
+}
+
+// NewWriterHook creates a hook writing events to w (os.Stdout if nil).
+// State payloads are omitted unless WithStateInOutput(true) is given.
+func NewWriterHook(w io.Writer, opts ...WriterOption) *WriterHook {
+	if w == nil {
+		w = os.Stdout
+	}
+
+	h := &WriterHook{
+		w:      w,
+		format: OutputFormatText,
+		table:  tabwriter.NewWriter(w, 16, 8, 2, ' ', 0),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// writerRecord is the serialized form of a trace event
+type writerRecord struct {
+	Event      graph.TraceEvent       `json:"event"`
+	SpanID     string                 `json:"span_id,omitempty"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Node       string                 `json:"node,omitempty"`
+	From       string                 `json:"from,omitempty"`
+	To         string                 `json:"to,omitempty"`
+	StartTime  time.Time              `json:"start_time"`
+	DurationMs int64                  `json:"duration_ms,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	State      interface{}            `json:"state,omitempty"`
+}
+
+// OnEvent writes the event in the configured format
+func (h *WriterHook) OnEvent(ctx context.Context, span *graph.TraceSpan) {
+	record := writerRecord{
+		Event:      span.Event,
+		SpanID:     span.ID,
+		ParentID:   span.ParentID,
+		Node:       span.NodeName,
+		From:       span.FromNode,
+		To:         span.ToNode,
+		StartTime:  span.StartTime,
+		DurationMs: span.Duration.Milliseconds(),
+		Metadata:   span.Metadata,
+	}
+	if span.Error != nil {
+		record.Error = span.Error.Error()
+	}
+	if h.includeState {
+		record.State = span.State
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var err error
+	switch h.format {
+	case OutputFormatJSON:
+		err = json.NewEncoder(h.w).Encode(record)
+	case OutputFormatPrettyJSON:
+		encoder := json.NewEncoder(h.w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(record)
+	default:
+		err = h.writeText(record)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "langgraph: failed to write trace event: %v\n", err)
+	}
+}
+
+// Flush writes the text lines buffered for alignment
+func (h *WriterHook) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.table.Flush()
+}
+
+// writeText adds a line for the record to the table, writing the table out
+// when the graph ends or a node fails
+func (h *WriterHook) writeText(record writerRecord) error {
+	name := record.Node
+	if record.Event == graph.TraceEventEdgeTraversal {
+		name = fmt.Sprintf("%s -> %s", record.From, record.To)
+	}
+
+	line := fmt.Sprintf("%s\t%s\t%s\t%dms",
+		record.StartTime.Format("15:04:05.000"),
+		record.Event,
+		name,
+		record.DurationMs,
+	)
+	if record.Error != "" {
+		line += "\terror=" + record.Error
+	}
+	if record.State != nil {
+		line += fmt.Sprintf("\tstate=%v", record.State)
+	}
+
+	if _, err := fmt.Fprintln(h.table, line); err != nil {
+		return err
+	}
+	if record.Event == graph.TraceEventGraphEnd || record.Error != "" {
+		return h.table.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package langgraph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/langgraphgo/graph"
+)
+
+// Test writer hook output formats
+func TestWriterHook(t *testing.T) {
+	span := &graph.TraceSpan{
+		ID:        "span-1",
+		Event:     graph.TraceEventNodeError,
+		NodeName:  "fetch",
+		StartTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Duration:  150 * time.Millisecond,
+		State:     map[string]interface{}{"payload": "large"},
+		Error:     errors.New("boom"),
+	}
+	ctx := context.Background()
+
+	t.Run("Compact JSON without state", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewWriterHook(&buf, WithOutputFormat(OutputFormatJSON)).OnEvent(ctx, span)
+
+		if strings.Count(buf.String(), "\n") != 1 {
+			t.Errorf("Expected a single line, got %q", buf.String())
+		}
+
+		var record map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		if record["node"] != "fetch" {
+			t.Errorf("node: got %v, want fetch", record["node"])
+		}
+		if record["error"] != "boom" {
+			t.Errorf("error: got %v, want boom", record["error"])
+		}
+		if _, hasState := record["state"]; hasState {
+			t.Error("State should be omitted by default")
+		}
+	})
+
+	t.Run("Pretty JSON with state", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewWriterHook(&buf,
+			WithOutputFormat(OutputFormatPrettyJSON),
+			WithStateInOutput(true),
+		).OnEvent(ctx, span)
+
+		var record map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid JSON: %v", err)
+		}
+		if _, hasState := record["state"]; !hasState {
+			t.Error("State should be included")
+		}
+		if !strings.Contains(buf.String(), "\n  ") {
+			t.Error("Expected indented output")
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewWriterHook(&buf).OnEvent(ctx, span)
+
+		out := buf.String()
+		for _, want := range []string{"03:04:05.000", "node_error", "fetch", "150ms", "error=boom"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("Output %q missing %q", out, want)
+			}
+		}
+		if strings.Contains(out, "state=") {
+			t.Error("State should be omitted by default")
+		}
+	})
+}
+
+// Test that the text format aligns the lines of a run in one table
+func TestWriterHookTable(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var buf bytes.Buffer
+	hook := NewWriterHook(&buf)
+
+	hook.OnEvent(ctx, &graph.TraceSpan{Event: graph.TraceEventGraphStart, StartTime: start})
+	hook.OnEvent(ctx, &graph.TraceSpan{Event: graph.TraceEventNodeEnd, NodeName: "summarize_documents_with_context", StartTime: start, Duration: 20 * time.Millisecond})
+	if buf.Len() != 0 {
+		t.Fatalf("Expected lines to wait for the end of the graph, got %q", buf.String())
+	}
+	hook.OnEvent(ctx, &graph.TraceSpan{Event: graph.TraceEventGraphEnd, StartTime: start, Duration: 1500 * time.Millisecond})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", lines)
+	}
+	column := strings.Index(lines[1], "20ms")
+	for _, line := range []string{lines[0], lines[2]} {
+		if got := strings.LastIndex(line, "  ") + 2; got != column {
+			t.Errorf("Expected the durations aligned at column %d, got %d in %q", column, got, line)
+		}
+	}
+
+	buf.Reset()
+	hook.OnEvent(ctx, &graph.TraceSpan{Event: graph.TraceEventNodeStart, NodeName: "fetch", StartTime: start})
+	if err := hook.Flush(); err != nil || !strings.Contains(buf.String(), "fetch") {
+		t.Errorf("Expected Flush to write the buffered line, got %q and %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,72 @@
+package langfuse
+
+import (
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// levelRanks orders observation levels from least to most severe
+var levelRanks = map[model.ObservationLevel]int{
+	model.ObservationLevelDebug:   0,
+	model.ObservationLevelDefault: 1,
+	model.ObservationLevelWarning: 2,
+	model.ObservationLevelError:   3,
+}
+
+// WithMinLevel drops spans, generations and events below level before they are
+// queued, like a log level, e.g. model.ObservationLevelDefault in production to
+// leave DEBUG instrumentation in the code at no cost. Observations without a
+// level count as DEFAULT. Later updates of a dropped observation are dropped
+// too, even at a higher level, so it is never recorded in part; its children
+// are still recorded. Traces and scores are not filtered. Dropped events count
+// in Stats.EventsFiltered. An empty level disables filtering.
+func (l *Langfuse) WithMinLevel(level model.ObservationLevel) *Langfuse {
+	l.minLevel = level
+	return l
+}
+
+// belowMinLevel reports whether body is an observation dropped by WithMinLevel.
+// Dropped IDs are remembered so the observation's updates are dropped too.
+func (l *Langfuse) belowMinLevel(body any) bool {
+	if l.minLevel == "" {
+		return false
+	}
+
+	var id string
+	var level model.ObservationLevel
+	switch b := body.(type) {
+	case *model.Span:
+		id, level = b.ID, b.Level
+	case *model.Generation:
+		id, level = b.ID, b.Level
+	case *model.Event:
+		id, level = b.ID, b.Level
+	default:
+		return false
+	}
+
+	if id != "" {
+		if _, dropped := l.levelDropped.get(id); dropped {
+			return true
+		}
+	}
+	if !l.filtersLevel(level) {
+		return false
+	}
+	if id != "" {
+		l.levelDropped.store(id, true)
+	}
+	return true
+}
+
+// filtersLevel reports whether observations at level are dropped
+func (l *Langfuse) filtersLevel(level model.ObservationLevel) bool {
+	return l != nil && l.minLevel != "" && levelRank(level) < levelRank(l.minLevel)
+}
+
+// levelRank returns the severity of level; an unset or unknown level is DEFAULT
+func levelRank(level model.ObservationLevel) int {
+	if rank, known := levelRanks[level]; known {
+		return rank
+	}
+	return levelRanks[model.ObservationLevelDefault]
+}
@@ -0,0 +1,87 @@
+package langfuse
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// requestLimiter bounds the number of concurrent ingestion requests.
+// Callers beyond the limit block until a slot is released.
+type requestLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	active  int
+	pending int // requests waiting for or holding a slot
+}
+
+func newRequestLimiter(limit int) *requestLimiter {
+	r := &requestLimiter{limit: limit}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// acquire blocks until a request slot is available. It fails with
+// ErrIngestionCanceled when ctx is done first, without taking a slot.
+func (r *requestLimiter) acquire(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Wake the wait below when ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.cond.Broadcast()
+	})
+	defer stop()
+
+	r.pending++
+	for r.active >= r.limit {
+		if ctx.Err() != nil {
+			r.pending--
+			r.cond.Broadcast()
+			return fmt.Errorf("%w: %w", ErrIngestionCanceled, ctx.Err())
+		}
+		r.cond.Wait()
+	}
+	r.active++
+	return nil
+}
+
+// release frees a request slot
+func (r *requestLimiter) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.active--
+	r.pending--
+	r.cond.Broadcast()
+}
+
+// setLimit changes the maximum number of concurrent requests
+func (r *requestLimiter) setLimit(limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limit = limit
+	r.cond.Broadcast()
+}
+
+// waitIdle blocks until no request is waiting for or holding a slot
+func (r *requestLimiter) waitIdle() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.pending > 0 {
+		r.cond.Wait()
+	}
+}
+
+// inFlight returns the number of requests currently holding a slot
+func (r *requestLimiter) inFlight() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.active
+}
@@ -0,0 +1,60 @@
+package langfuse
+
+import (
+	"fmt"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+const (
+	// TraceIDHeader is the HTTP header used to propagate a trace ID between services
+	TraceIDHeader = "X-Langfuse-Trace-Id"
+
+	// MetadataKeyParentTraceID is the trace metadata key referencing the parent trace
+	MetadataKeyParentTraceID = "parent_trace_id"
+
+	// MetadataKeyLinks holds the links of a span or generation, which the
+	// ingestion API does not support natively
+	MetadataKeyLinks = "links"
+)
+
+// LinkTraces records that the trace childID was caused by the trace parentID.
+// The calling service should propagate its trace ID (e.g. via TraceIDHeader)
+// so the receiving service can link its own trace to it.
+func (l *Langfuse) LinkTraces(childID, parentID string) error {
+	if childID == "" {
+		return fmt.Errorf("child trace ID is required")
+	}
+
+	if parentID == "" {
+		return fmt.Errorf("parent trace ID is required")
+	}
+
+	if childID == parentID {
+		return fmt.Errorf("a trace cannot be linked to itself")
+	}
+
+	_, err := l.Trace(&model.Trace{
+		ID: childID,
+		Metadata: map[string]interface{}{
+			MetadataKeyParentTraceID: parentID,
+		},
+	})
+	return err
+}
+
+// withObservationLinks returns metadata with links stored under its "links"
+// key, replacing any links stored there
+func withObservationLinks(metadata any, links []model.ObservationLink) any {
+	if len(links) == 0 {
+		return metadata
+	}
+
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+	extended[MetadataKeyLinks] = links
+	return extended
+}
@@ -0,0 +1,217 @@
+package langfuse
+
+import (
+	"context"
+	"log"
+	"log/slog"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+const (
+	// LogKeyTraceID is the log attribute holding the active Langfuse trace ID
+	LogKeyTraceID = "langfuse_trace_id"
+	// LogKeyObservationID is the log attribute holding the active Langfuse observation ID
+	LogKeyObservationID = "langfuse_observation_id"
+)
+
+// WithLogger sets the base logger returned by Logger (slog.Default() if unset).
+// The client also logs its validation warnings to it.
+func (l *Langfuse) WithLogger(logger *slog.Logger) *Langfuse {
+	l.loggerMu.Lock()
+	defer l.loggerMu.Unlock()
+
+	l.logger = logger
+	return l
+}
+
+// Logger returns a logger annotated with the trace and observation IDs of the
+// observer in ctx. Inside functions wrapped by Observe that take a
+// context.Context, these are the IDs of the enclosing observation.
+func (l *Langfuse) Logger(ctx context.Context) *slog.Logger {
+	l.loggerMu.RLock()
+	logger := l.logger
+	l.loggerMu.RUnlock()
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	observer := ObserverFromContext(ctx)
+	if observer == nil {
+		return logger
+	}
+
+	if traceID := observer.TraceID(); traceID != "" {
+		logger = logger.With(LogKeyTraceID, traceID)
+	}
+	if observer.parentID != nil && *observer.parentID != "" {
+		logger = logger.With(LogKeyObservationID, *observer.parentID)
+	}
+
+	return logger
+}
+
+// SlogHandler is a slog.Handler that records log records as events of the
+// observation active in the record's context, bridging structured logging into
+// traces. Create it with NewSlogHandler.
+type SlogHandler struct {
+	client *Langfuse
+	// scopes holds the attributes and groups added with WithAttrs and WithGroup, in order
+	scopes []slogScope
+}
+
+// slogScope is either a group opened with WithGroup or attributes added with WithAttrs
+type slogScope struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewSlogHandler creates a handler recording each log record as an event
+// nested under the observation in its context: the observation of a function
+// wrapped by Observe that takes the context, or else the trace set with
+// ContextWithTraceID. The event is named after the message, carries the
+// record's attributes as metadata and its level mapped to the observation
+// level: DEBUG below slog.LevelInfo, DEFAULT below slog.LevelWarn, WARNING
+// below slog.LevelError and ERROR above. Records below the client's
+// WithMinLevel are dropped, and records logged without an active trace are
+// ignored, so the handler is safe to use everywhere. To also write logs
+// elsewhere, fan records out to it and another handler.
+//
+//	logger := slog.New(langfuse.NewSlogHandler(client))
+//	logger.InfoContext(ctx, "fetched documents", "count", len(docs))
+func NewSlogHandler(client *Langfuse) *SlogHandler {
+	return &SlogHandler{client: client}
+}
+
+// Enabled reports whether a record at level logged with ctx is recorded
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if ctx == nil || h.client.filtersLevel(observationLevel(level)) {
+		return false
+	}
+	_, active := TraceIDFromContext(ctx)
+	return active
+}
+
+// Handle records r as an event of the observation active in ctx
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil {
+		return nil
+	}
+	traceID, active := TraceIDFromContext(ctx)
+	if !active {
+		return nil
+	}
+	var parentID *string
+	if observer := ObserverFromContext(ctx); observer != nil && observer.TraceID() == traceID {
+		parentID = observer.parentID
+	}
+
+	metadata := make(map[string]interface{})
+	var groups []string
+	for _, scope := range h.scopes {
+		if scope.group != "" {
+			groups = append(groups, scope.group)
+			continue
+		}
+		for _, attr := range scope.attrs {
+			addSlogAttr(metadata, groups, attr)
+		}
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		addSlogAttr(metadata, groups, attr)
+		return true
+	})
+
+	startTime := r.Time
+	if startTime.IsZero() {
+		startTime = h.client.Now()
+	}
+	event := &model.Event{
+		TraceID:   traceID,
+		Name:      r.Message,
+		StartTime: &startTime,
+		Level:     observationLevel(r.Level),
+	}
+	if len(metadata) > 0 {
+		event.Metadata = metadata
+	}
+	if _, err := h.client.Event(event, parentID); err != nil {
+		log.Printf("Failed to record log: %v", err)
+	}
+	return nil
+}
+
+// WithAttrs returns a handler adding attrs to the metadata of every record
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.withScope(slogScope{attrs: attrs})
+}
+
+// WithGroup returns a handler nesting the attributes added later under name
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.withScope(slogScope{group: name})
+}
+
+func (h *SlogHandler) withScope(scope slogScope) *SlogHandler {
+	scopes := make([]slogScope, len(h.scopes), len(h.scopes)+1)
+	copy(scopes, h.scopes)
+	return &SlogHandler{client: h.client, scopes: append(scopes, scope)}
+}
+
+// observationLevel maps a slog level to the observation level of its events
+func observationLevel(level slog.Level) model.ObservationLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return model.ObservationLevelDebug
+	case level < slog.LevelWarn:
+		return model.ObservationLevelDefault
+	case level < slog.LevelError:
+		return model.ObservationLevelWarning
+	default:
+		return model.ObservationLevelError
+	}
+}
+
+// addSlogAttr stores attr in metadata under groups, creating group maps only
+// for groups that end up holding attributes
+func addSlogAttr(metadata map[string]interface{}, groups []string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		members := attr.Value.Group()
+		if len(members) == 0 {
+			return
+		}
+		if attr.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], attr.Key)
+		}
+		for _, member := range members {
+			addSlogAttr(metadata, groups, member)
+		}
+		return
+	}
+
+	target := metadata
+	for _, group := range groups {
+		nested, isMap := target[group].(map[string]interface{})
+		if !isMap {
+			nested = make(map[string]interface{})
+			target[group] = nested
+		}
+		target = nested
+	}
+
+	value := attr.Value.Any()
+	if err, isErr := value.(error); isErr {
+		value = err.Error()
+	}
+	target[attr.Key] = value
+}
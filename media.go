@@ -1,10 +1,14 @@
 package langfuse
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"mime"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,8 +16,26 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
 )
 
+// Media fields tell Langfuse where in the trace view to render an attachment
+const (
+	MediaFieldInput    = "input"
+	MediaFieldOutput   = "output"
+	MediaFieldMetadata = "metadata"
+)
+
+// isValidMediaField reports whether field is a known media field
+func isValidMediaField(field string) bool {
+	switch field {
+	case MediaFieldInput, MediaFieldOutput, MediaFieldMetadata:
+		return true
+	default:
+		return false
+	}
+}
+
 // MediaContent represents a media file or data
 type MediaContent struct {
 	ID          string     `json:"id"`
@@ -25,6 +47,8 @@ type MediaContent struct {
 	Hash        string     `json:"hash"`
 	UploadedAt  *time.Time `json:"uploaded_at,omitempty"`
 	ReferenceID string     `json:"reference_id,omitempty"`
+	// URL locates media hosted elsewhere, which is referenced instead of uploaded
+	URL string `json:"url,omitempty"`
 }
 
 // NewMediaFromFile creates media content from a file path
@@ -78,6 +102,34 @@ func NewMediaFromBytes(data []byte, contentType string, fileName string) *MediaC
 	}
 }
 
+// NewMediaFromURL references media already hosted at an http or https URL.
+// The media is not downloaded or uploaded; traces show the URL instead.
+func NewMediaFromURL(rawURL string, contentType string) (*MediaContent, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("media URL %q must use http or https", rawURL)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("media URL %q has no host", rawURL)
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(parsed.Path))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &MediaContent{
+		ID:          uuid.New().String(),
+		ContentType: contentType,
+		FileName:    filepath.Base(parsed.Path),
+		URL:         parsed.String(),
+	}, nil
+}
+
 // NewMediaFromDataURI creates media content from a data URI
 func NewMediaFromDataURI(dataURI string) (*MediaContent, error) {
 	// Parse data URI format: data:[<mediatype>][;base64],<data>
@@ -131,8 +183,12 @@ func NewMediaFromDataURI(dataURI string) (*MediaContent, error) {
 	}, nil
 }
 
-// ToReferenceString returns a reference string for this media
+// ToReferenceString returns a reference string for this media, or the URL of
+// media hosted elsewhere
 func (m *MediaContent) ToReferenceString() string {
+	if m.URL != "" {
+		return m.URL
+	}
 	if m.ReferenceID != "" {
 		return fmt.Sprintf("@media/%s", m.ReferenceID)
 	}
@@ -148,13 +204,21 @@ type MediaUploader struct {
 	mu         sync.RWMutex
 	uploads    map[string]*MediaUploadStatus
 	dedupCache map[string]string // hash -> reference_id
+	associated map[string]bool   // hash, trace, observation and field of recorded associations
+
+	// pending counts uploads queued or in flight; idle is closed when it drops to zero
+	pendingMu sync.Mutex
+	pending   int
+	idle      chan struct{}
 }
 
 // MediaUploadTask represents a media upload task
 type MediaUploadTask struct {
-	Media    *MediaContent
-	TraceID  string
-	SpanID   string
+	Media   *MediaContent
+	TraceID string
+	SpanID  string
+	// Field is where the media is rendered: MediaFieldInput, MediaFieldOutput or MediaFieldMetadata
+	Field    string
 	Callback func(referenceID string, err error)
 }
 
@@ -180,6 +244,7 @@ func NewMediaUploader(client *Langfuse, workers int) *MediaUploader {
 		workers:    workers,
 		uploads:    make(map[string]*MediaUploadStatus),
 		dedupCache: make(map[string]string),
+		associated: make(map[string]bool),
 	}
 
 	// Start workers
@@ -191,16 +256,32 @@ func NewMediaUploader(client *Langfuse, workers int) *MediaUploader {
 	return uploader
 }
 
-// Upload queues a media upload task
+// Upload queues a media upload task attached to the input field
 func (mu *MediaUploader) Upload(media *MediaContent, traceID string, spanID string) (string, error) {
-	// Check dedup cache
+	return mu.UploadToField(media, traceID, spanID, MediaFieldInput)
+}
+
+// UploadToField queues a media upload task attached to the given field
+func (mu *MediaUploader) UploadToField(media *MediaContent, traceID string, spanID string, field string) (string, error) {
+	if !isValidMediaField(field) {
+		return "", fmt.Errorf("invalid media field %q", field)
+	}
+	if media.URL != "" {
+		return "", fmt.Errorf("media at %s is hosted elsewhere and is not uploaded", media.URL)
+	}
+
+	// Content uploaded before is not uploaded again, but each trace field
+	// showing it still needs its own association
 	mu.mu.RLock()
-	if refID, exists := mu.dedupCache[media.Hash]; exists {
-		mu.mu.RUnlock()
+	refID, cached := mu.dedupCache[media.Hash]
+	associated := mu.associated[associationKey(media.Hash, traceID, spanID, field)]
+	mu.mu.RUnlock()
+	if cached {
 		media.ReferenceID = refID
-		return refID, nil
+		if associated {
+			return refID, nil
+		}
 	}
-	mu.mu.RUnlock()
 
 	// Create upload status
 	status := &MediaUploadStatus{
@@ -218,12 +299,18 @@ func (mu *MediaUploader) Upload(media *MediaContent, traceID string, spanID stri
 		Media:   media,
 		TraceID: traceID,
 		SpanID:  spanID,
+		Field:   field,
 	}
 
+	mu.trackUpload(1)
 	select {
 	case mu.queue <- task:
+		if cached {
+			return refID, nil
+		}
 		return media.ID, nil
 	default:
+		mu.trackUpload(-1)
 		return "", fmt.Errorf("upload queue is full")
 	}
 }
@@ -234,12 +321,57 @@ func (mu *MediaUploader) UploadWithCallback(media *MediaContent, traceID string,
 		Media:    media,
 		TraceID:  traceID,
 		SpanID:   spanID,
+		Field:    MediaFieldInput,
 		Callback: callback,
 	}
 
+	mu.trackUpload(1)
 	mu.queue <- task
 }
 
+// associationKey identifies the association of media content with a trace field
+func associationKey(hash string, traceID string, spanID string, field string) string {
+	return hash + "|" + traceID + "|" + spanID + "|" + field
+}
+
+// trackUpload adjusts the count of uploads queued or in flight
+func (mu *MediaUploader) trackUpload(delta int64) {
+	if mu.client != nil {
+		mu.client.metrics.uploadsInFlight.Add(delta)
+	}
+
+	mu.pendingMu.Lock()
+	defer mu.pendingMu.Unlock()
+
+	if mu.pending == 0 && delta > 0 {
+		mu.idle = make(chan struct{})
+	}
+	mu.pending += int(delta)
+	if mu.pending == 0 && mu.idle != nil {
+		close(mu.idle)
+		mu.idle = nil
+	}
+}
+
+// Drain blocks until every queued upload has completed or failed, or ctx is
+// done. Unlike Shutdown it leaves the uploader running, e.g. to settle the
+// media of a trace before flushing the events referencing it.
+func (mu *MediaUploader) Drain(ctx context.Context) error {
+	mu.pendingMu.Lock()
+	idle := mu.idle
+	mu.pendingMu.Unlock()
+
+	if idle == nil {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // worker processes upload tasks
 func (mu *MediaUploader) worker() {
 	defer mu.wg.Done()
@@ -251,6 +383,8 @@ func (mu *MediaUploader) worker() {
 
 // processUpload handles a single upload task
 func (mu *MediaUploader) processUpload(task *MediaUploadTask) {
+	defer mu.trackUpload(-1)
+
 	// Update status
 	mu.mu.Lock()
 	if status, exists := mu.uploads[task.Media.ID]; exists {
@@ -258,16 +392,25 @@ func (mu *MediaUploader) processUpload(task *MediaUploadTask) {
 	}
 	mu.mu.Unlock()
 
-	// Simulate upload to Langfuse API
-	// In real implementation, this would POST to the media endpoint
-	time.Sleep(100 * time.Millisecond)
+	referenceID, err := mu.send(task)
+	if err != nil {
+		mu.mu.Lock()
+		if status, exists := mu.uploads[task.Media.ID]; exists {
+			status.Status = "failed"
+			status.Error = err
+		}
+		mu.mu.Unlock()
 
-	// Generate reference ID (in real implementation, this comes from API)
-	referenceID := fmt.Sprintf("media_%s", uuid.New().String())
+		if task.Callback != nil {
+			task.Callback("", err)
+		}
+		return
+	}
 
 	// Update dedup cache
 	mu.mu.Lock()
 	mu.dedupCache[task.Media.Hash] = referenceID
+	mu.associated[associationKey(task.Media.Hash, task.TraceID, task.SpanID, task.Field)] = true
 	if status, exists := mu.uploads[task.Media.ID]; exists {
 		status.Status = "completed"
 		status.ReferenceID = referenceID
@@ -287,6 +430,74 @@ func (mu *MediaUploader) processUpload(task *MediaUploadTask) {
 	}
 }
 
+// send associates the media with its trace field and uploads the content.
+// It returns the Langfuse media ID.
+func (mu *MediaUploader) send(task *MediaUploadTask) (string, error) {
+	if mu.client == nil || mu.client.client == nil {
+		return "", fmt.Errorf("media uploader has no Langfuse client")
+	}
+
+	field := task.Field
+	if field == "" {
+		field = MediaFieldInput
+	}
+
+	ctx := context.Background()
+	checksum := mediaChecksum(task.Media)
+
+	res := &api.MediaUploadResponse{}
+	if err := mu.client.client.GetMediaUploadURL(ctx, &api.MediaUploadRequest{
+		TraceID:       task.TraceID,
+		ObservationID: task.SpanID,
+		ContentType:   task.Media.ContentType,
+		ContentLength: len(task.Media.Data),
+		SHA256Hash:    checksum,
+		Field:         field,
+	}, res); err != nil {
+		return "", fmt.Errorf("failed to get media upload URL: %w", err)
+	}
+
+	// No upload URL means the same content was uploaded before
+	if res.UploadURL == nil {
+		return res.MediaID, nil
+	}
+
+	status, uploadErr := mu.client.client.UploadMedia(ctx, *res.UploadURL, task.Media.ContentType, checksum, task.Media.Data)
+	uploaded := &api.MediaUploadedRequest{
+		UploadedAt:       time.Now().UTC(),
+		UploadHTTPStatus: status,
+	}
+	if uploadErr != nil {
+		uploaded.UploadHTTPError = uploadErr.Error()
+	}
+	if patchErr := mu.client.client.PatchMedia(ctx, res.MediaID, uploaded); patchErr != nil && uploadErr == nil {
+		return "", fmt.Errorf("failed to record media upload: %w", patchErr)
+	}
+	if uploadErr != nil {
+		return "", fmt.Errorf("failed to upload media: %w", uploadErr)
+	}
+
+	return res.MediaID, nil
+}
+
+// mediaChecksum returns the base64 SHA-256 of the media content, reusing its
+// hex Hash when set
+func mediaChecksum(media *MediaContent) string {
+	if sum, err := hex.DecodeString(media.Hash); err == nil && len(sum) == sha256.Size {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	sum := sha256.Sum256(media.Data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WithMediaChecksumHeader sets the header carrying the SHA-256 checksum of media
+// uploads, x-amz-checksum-sha256 by default, for object stores that expect
+// another name. An empty name omits the header.
+func (l *Langfuse) WithMediaChecksumHeader(name string) *Langfuse {
+	l.client.WithChecksumHeader(name)
+	return l
+}
+
 // GetStatus returns the upload status for a media ID
 func (mu *MediaUploader) GetStatus(mediaID string) *MediaUploadStatus {
 	mu.mu.RLock()
@@ -328,7 +539,8 @@ func (mu *MediaUploader) Shutdown() {
 
 // MediaProcessor provides utilities for processing media in traces
 type MediaProcessor struct {
-	uploader *MediaUploader
+	uploader      *MediaUploader
+	maxInlineSize int // strings and byte slices above this size are uploaded (zero disables)
 }
 
 // NewMediaProcessor creates a new media processor
@@ -340,30 +552,54 @@ func NewMediaProcessor(uploader *MediaUploader) *MediaProcessor {
 
 // ProcessInput processes media in input data
 func (mp *MediaProcessor) ProcessInput(input interface{}, traceID string) interface{} {
-	return mp.processValue(input, traceID, "")
+	return mp.processValue(input, traceID, "", MediaFieldInput)
 }
 
 // ProcessOutput processes media in output data
 func (mp *MediaProcessor) ProcessOutput(output interface{}, traceID string, spanID string) interface{} {
-	return mp.processValue(output, traceID, spanID)
+	return mp.processValue(output, traceID, spanID, MediaFieldOutput)
 }
 
 // processValue recursively processes media in values
-func (mp *MediaProcessor) processValue(value interface{}, traceID string, spanID string) interface{} {
+func (mp *MediaProcessor) processValue(value interface{}, traceID string, spanID string, field string) interface{} {
 	switch v := value.(type) {
 	case *MediaContent:
+		// Media hosted elsewhere is referenced by its URL
+		if v.URL != "" {
+			return v.URL
+		}
 		// Upload media and return reference
-		refID, err := mp.uploader.Upload(v, traceID, spanID)
+		refID, err := mp.uploader.UploadToField(v, traceID, spanID, field)
 		if err != nil {
 			return v.ToReferenceString()
 		}
 		return fmt.Sprintf("@media/%s", refID)
 
+	case string:
+		// Upload inline base64 data URIs (e.g. images in vision messages)
+		if !isBase64DataURI(v) {
+			if mp.oversized(len(v)) && !IsMediaReference(v) {
+				return mp.offload(value, []byte(v), "text/plain; charset=utf-8", traceID, spanID, field)
+			}
+			return value
+		}
+		media, err := NewMediaFromDataURI(v)
+		if err != nil {
+			return value
+		}
+		return mp.processValue(media, traceID, spanID, field)
+
+	case []byte:
+		if mp.oversized(len(v)) {
+			return mp.offload(value, v, http.DetectContentType(v), traceID, spanID, field)
+		}
+		return value
+
 	case map[string]interface{}:
 		// Process map values
 		result := make(map[string]interface{})
 		for key, val := range v {
-			result[key] = mp.processValue(val, traceID, spanID)
+			result[key] = mp.processValue(val, traceID, spanID, field)
 		}
 		return result
 
@@ -371,7 +607,15 @@ func (mp *MediaProcessor) processValue(value interface{}, traceID string, spanID
 		// Process array values
 		result := make([]interface{}, len(v))
 		for i, val := range v {
-			result[i] = mp.processValue(val, traceID, spanID)
+			result[i] = mp.processValue(val, traceID, spanID, field)
+		}
+		return result
+
+	case []map[string]interface{}:
+		// Process message lists
+		result := make([]interface{}, len(v))
+		for i, val := range v {
+			result[i] = mp.processValue(val, traceID, spanID, field)
 		}
 		return result
 
@@ -380,16 +624,30 @@ func (mp *MediaProcessor) processValue(value interface{}, traceID string, spanID
 	}
 }
 
-// Global media uploader instance (optional, for convenience)
-var globalUploader *MediaUploader
-var globalUploaderOnce sync.Once
+const defaultMediaUploadWorkers = 4
 
-// GetGlobalUploader returns the global media uploader instance
-func GetGlobalUploader(client *Langfuse) *MediaUploader {
-	globalUploaderOnce.Do(func() {
-		globalUploader = NewMediaUploader(client, 4)
+// MediaUploader returns the client's media uploader, creating it on first use.
+// Each client has its own uploader, so uploads use that client's credentials.
+func (l *Langfuse) MediaUploader() *MediaUploader {
+	l.uploaderOnce.Do(func() {
+		l.uploader = NewMediaUploader(l, defaultMediaUploadWorkers)
 	})
-	return globalUploader
+	return l.uploader
+}
+
+// GetGlobalUploader returns the media uploader of client.
+//
+// Deprecated: uploaders are per client; use client.MediaUploader.
+func GetGlobalUploader(client *Langfuse) *MediaUploader {
+	return uploaderFor(client)
+}
+
+// uploaderFor returns the client's uploader, or a detached one for a nil client
+func uploaderFor(client *Langfuse) *MediaUploader {
+	if client == nil {
+		return NewMediaUploader(nil, defaultMediaUploadWorkers)
+	}
+	return client.MediaUploader()
 }
 
 // Helper functions for media handling
@@ -399,6 +657,15 @@ func IsMediaReference(s string) bool {
 	return strings.HasPrefix(s, "@media/")
 }
 
+// isBase64DataURI checks if a string is a base64-encoded data URI
+func isBase64DataURI(s string) bool {
+	if !strings.HasPrefix(s, "data:") {
+		return false
+	}
+	header, _, hasData := strings.Cut(s, ",")
+	return hasData && strings.HasSuffix(header, ";base64")
+}
+
 // ParseMediaReference extracts the reference ID from a media reference string
 func ParseMediaReference(s string) (string, bool) {
 	if !IsMediaReference(s) {
@@ -417,22 +684,22 @@ type MediaHelper struct {
 func NewMediaHelper(client *Langfuse) *MediaHelper {
 	return &MediaHelper{
 		client:   client,
-		uploader: GetGlobalUploader(client),
+		uploader: uploaderFor(client),
 	}
 }
 
-// AttachImage attaches an image to a trace or span
-func (mh *MediaHelper) AttachImage(filePath string, traceID string, spanID string) (string, error) {
+// AttachImage attaches an image to the given field ("input", "output" or "metadata") of a trace or span
+func (mh *MediaHelper) AttachImage(filePath string, traceID string, spanID string, field string) (string, error) {
 	media, err := NewMediaFromFile(filePath)
 	if err != nil {
 		return "", err
 	}
 
-	return mh.uploader.Upload(media, traceID, spanID)
+	return mh.uploader.UploadToField(media, traceID, spanID, field)
 }
 
-// AttachData attaches raw data as media
-func (mh *MediaHelper) AttachData(data []byte, contentType string, name string, traceID string, spanID string) (string, error) {
+// AttachData attaches raw data as media to the given field of a trace or span
+func (mh *MediaHelper) AttachData(data []byte, contentType string, name string, traceID string, spanID string, field string) (string, error) {
 	media := NewMediaFromBytes(data, contentType, name)
-	return mh.uploader.Upload(media, traceID, spanID)
+	return mh.uploader.UploadToField(media, traceID, spanID, field)
 }
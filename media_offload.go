@@ -0,0 +1,64 @@
+package langfuse
+
+// WithMaxInlineSize makes the processor upload strings and byte slices larger
+// than size bytes as media and replace them with @media references. Smaller
+// payloads stay inline. A size of zero or less disables offloading.
+func (mp *MediaProcessor) WithMaxInlineSize(size int) *MediaProcessor {
+	mp.maxInlineSize = size
+	return mp
+}
+
+// oversized reports whether a payload of n bytes should be uploaded as media
+func (mp *MediaProcessor) oversized(n int) bool {
+	return mp.maxInlineSize > 0 && n > mp.maxInlineSize
+}
+
+// offload uploads an oversized payload and returns its reference. The payload
+// is kept inline if the upload cannot be queued.
+func (mp *MediaProcessor) offload(value interface{}, data []byte, contentType string, traceID string, spanID string, field string) interface{} {
+	media := NewMediaFromBytes(data, contentType, "")
+	refID, err := mp.uploader.UploadToField(media, traceID, spanID, field)
+	if err != nil {
+		return value
+	}
+	return "@media/" + refID
+}
+
+// WithMaxInlineSize uploads string and byte slice payloads larger than size bytes
+// in the input and output of traces and observations as media attachments, and
+// records @media references in their place. Payloads nested in maps and slices
+// are included, and inline base64 data URIs are uploaded too. A size of zero or
+// less disables offloading.
+func (l *Langfuse) WithMaxInlineSize(size int) *Langfuse {
+	if size <= 0 {
+		l.offloader.Store(nil)
+		return l
+	}
+	l.offloader.Store(NewMediaProcessor(l.MediaUploader()).WithMaxInlineSize(size))
+	return l
+}
+
+// offloadIO replaces oversized input and output payloads with media
+// references, using the threshold of the call options when they set one
+func (l *Langfuse) offloadIO(traceID string, observationID string, input *any, output *any, opts []CallOption) {
+	mp := l.offloader.Load()
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.inlineSized {
+		mp = nil
+		if o.maxInlineSize > 0 {
+			mp = NewMediaProcessor(l.MediaUploader()).WithMaxInlineSize(o.maxInlineSize)
+		}
+	}
+	if mp == nil {
+		return
+	}
+	if *input != nil {
+		*input = mp.processValue(*input, traceID, observationID, MediaFieldInput)
+	}
+	if *output != nil {
+		*output = mp.processValue(*output, traceID, observationID, MediaFieldOutput)
+	}
+}
@@ -0,0 +1,197 @@
+package langfuse
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// MiddlewareOption configures HTTPMiddleware
+type MiddlewareOption func(*middlewareConfig)
+
+type middlewareConfig struct {
+	name        func(r *http.Request) string
+	skip        func(r *http.Request) bool
+	traceHeader bool
+}
+
+// WithMiddlewareName names request traces with name instead of "METHOD /path",
+// e.g. to use route patterns and keep the number of trace names bounded
+func WithMiddlewareName(name func(r *http.Request) string) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.name = name
+	}
+}
+
+// WithMiddlewareSkip leaves requests for which skip returns true untraced,
+// e.g. health checks
+func WithMiddlewareSkip(skip func(r *http.Request) bool) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.skip = skip
+	}
+}
+
+// WithMiddlewareTraceHeader sets whether responses carry the request's trace
+// ID in TraceIDHeader, so callers can look the trace up (default true)
+func WithMiddlewareTraceHeader(enabled bool) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.traceHeader = enabled
+	}
+}
+
+// HTTPMiddleware returns middleware that traces each request. A request span
+// named "METHOD /path" records the method, path and response status; responses
+// with a 5xx status, or a panicking handler, mark it as an error. Observed
+// calls that take the request context nest under the span, and
+// TraceIDFromContext and Logger report its trace.
+//
+// A request carrying TraceContextHeader continues the caller's trace; one
+// carrying a W3C TraceparentHeader records into the trace mapped from it, see
+// ParseTraceparent; one carrying TraceIDHeader gets its own trace linked to the
+// caller's; any other request starts a new trace.
+func HTTPMiddleware(client *Langfuse, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	config := middlewareConfig{
+		name:        func(r *http.Request) string { return r.Method + " " + r.URL.Path },
+		traceHeader: true,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config.skip != nil && config.skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			startTime := client.Now()
+			name := config.name(r)
+			request := map[string]interface{}{"method": r.Method, "path": r.URL.Path}
+
+			traceID, parentID, ownTrace := client.requestTrace(r, name, startTime, request)
+			span, err := client.Span(&model.Span{
+				TraceID:             traceID,
+				ParentObservationID: parentID,
+				Name:                name,
+				StartTime:           &startTime,
+				Input:               request,
+			}, nil)
+			if err != nil {
+				log.Printf("Failed to create request span: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if config.traceHeader {
+				w.Header().Set(TraceIDHeader, span.TraceID)
+			}
+			scope := observationScope{client: client, traceID: span.TraceID}
+			ctx := WithObserver(r.Context(), NewObserver(client).child(scope, span.ID))
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			defer func() {
+				recovered := recover()
+				status := recorder.status
+				if recovered != nil {
+					status = http.StatusInternalServerError
+				}
+				client.endRequest(span, ownTrace, status, recovered)
+				if recovered != nil {
+					panic(recovered)
+				}
+			}()
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestTrace returns the trace and parent observation of a request's span,
+// creating a trace unless the request continues one. The boolean reports
+// whether the trace was created for the request.
+func (l *Langfuse) requestTrace(r *http.Request, name string, startTime time.Time, input interface{}) (string, string, bool) {
+	if tc, err := ParseTraceContext(r.Header.Get(TraceContextHeader)); err == nil {
+		return tc.TraceID, tc.ParentObservationID, false
+	}
+
+	trace := &model.Trace{Name: name, Timestamp: &startTime, Input: input}
+	if header := r.Header.Get(TraceparentHeader); header != "" {
+		// Join the distributed trace, creating it in Langfuse
+		if tc, err := ParseTraceparent(header); err == nil {
+			trace.ID = tc.TraceID
+			trace.Metadata = map[string]interface{}{MetadataKeyTraceparent: header}
+		}
+	}
+	if parentTraceID := r.Header.Get(TraceIDHeader); parentTraceID != "" && trace.ID == "" {
+		trace.Metadata = map[string]interface{}{MetadataKeyParentTraceID: parentTraceID}
+	}
+	if _, err := l.Trace(trace); err != nil {
+		log.Printf("Failed to create request trace: %v", err)
+		return "", "", false
+	}
+	return trace.ID, "", true
+}
+
+// endRequest ends a request span with the response status, updating the
+// request's own trace
+func (l *Langfuse) endRequest(span *model.Span, ownTrace bool, status int, recovered interface{}) {
+	endTime := l.Now()
+	output := map[string]interface{}{"status_code": status}
+
+	ended := &model.Span{
+		ID:        span.ID,
+		TraceID:   span.TraceID,
+		Name:      span.Name,
+		StartTime: span.StartTime,
+		EndTime:   &endTime,
+		Output:    output,
+	}
+	switch {
+	case recovered != nil:
+		ended.Level = model.ObservationLevelError
+		ended.StatusMessage = fmt.Sprintf("panic: %v", recovered)
+	case status >= http.StatusInternalServerError:
+		ended.Level = model.ObservationLevelError
+		ended.StatusMessage = http.StatusText(status)
+	case status >= http.StatusBadRequest:
+		ended.Level = model.ObservationLevelWarning
+		ended.StatusMessage = http.StatusText(status)
+	}
+	if _, err := l.SpanEnd(ended); err != nil {
+		log.Printf("Failed to end request span: %v", err)
+	}
+
+	if ownTrace {
+		if _, err := l.Trace(&model.Trace{ID: span.TraceID, Output: output}); err != nil {
+			log.Printf("Failed to update request trace: %v", err)
+		}
+	}
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
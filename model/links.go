@@ -0,0 +1,23 @@
+package model
+
+// ObservationLink relates an observation to another one besides its parent,
+// e.g. each of the chunk spans an aggregation consumed
+type ObservationLink struct {
+	ObservationID string `json:"observationId"`
+	// TraceID is set when the linked observation belongs to another trace
+	TraceID string `json:"traceId,omitempty"`
+	// Relationship labels the link, e.g. "consumed"
+	Relationship string `json:"relationship,omitempty"`
+}
+
+// AddLinks appends links to the span
+func (s *Span) AddLinks(links ...ObservationLink) *Span {
+	s.Links = append(s.Links, links...)
+	return s
+}
+
+// AddLinks appends links to the generation
+func (g *Generation) AddLinks(links ...ObservationLink) *Generation {
+	g.Links = append(g.Links, links...)
+	return g
+}
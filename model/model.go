@@ -23,18 +23,19 @@ type IngestionEvent struct {
 }
 
 type Trace struct {
-	ID        string     `json:"id,omitempty"`
-	Timestamp *time.Time `json:"timestamp,omitempty"`
-	Name      string     `json:"name,omitempty"`
-	UserID    string     `json:"userId,omitempty"`
-	Input     any        `json:"input,omitempty"`
-	Output    any        `json:"output,omitempty"`
-	SessionID string     `json:"sessionId,omitempty"`
-	Release   string     `json:"release,omitempty"`
-	Version   string     `json:"version,omitempty"`
-	Metadata  any        `json:"metadata,omitempty"`
-	Tags      []string   `json:"tags,omitempty"`
-	Public    bool       `json:"public,omitempty"`
+	ID          string     `json:"id,omitempty"`
+	Timestamp   *time.Time `json:"timestamp,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	UserID      string     `json:"userId,omitempty"`
+	Input       any        `json:"input,omitempty"`
+	Output      any        `json:"output,omitempty"`
+	SessionID   string     `json:"sessionId,omitempty"`
+	Release     string     `json:"release,omitempty"`
+	Version     string     `json:"version,omitempty"`
+	Metadata    any        `json:"metadata,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Public      bool       `json:"public,omitempty"`
+	Environment string     `json:"environment,omitempty"`
 }
 
 type ObservationLevel string
@@ -47,26 +48,62 @@ const (
 )
 
 type Generation struct {
-	TraceID             string           `json:"traceId,omitempty"`
-	Name                string           `json:"name,omitempty"`
-	StartTime           *time.Time       `json:"startTime,omitempty"`
-	Metadata            any              `json:"metadata,omitempty"`
-	Input               any              `json:"input,omitempty"`
-	Output              any              `json:"output,omitempty"`
-	Level               ObservationLevel `json:"level,omitempty"`
-	StatusMessage       string           `json:"statusMessage,omitempty"`
-	ParentObservationID string           `json:"parentObservationId,omitempty"`
-	Version             string           `json:"version,omitempty"`
-	ID                  string           `json:"id,omitempty"`
-	EndTime             *time.Time       `json:"endTime,omitempty"`
-	CompletionStartTime *time.Time       `json:"completionStartTime,omitempty"`
-	Model               string           `json:"model,omitempty"`
-	ModelParameters     any              `json:"modelParameters,omitempty"`
-	Usage               Usage            `json:"usage,omitempty"`
-	PromptName          string           `json:"promptName,omitempty"`
-	PromptVersion       int              `json:"promptVersion,omitempty"`
+	TraceID             string             `json:"traceId,omitempty"`
+	Name                string             `json:"name,omitempty"`
+	StartTime           *time.Time         `json:"startTime,omitempty"`
+	Metadata            any                `json:"metadata,omitempty"`
+	Input               any                `json:"input,omitempty"`
+	Output              any                `json:"output,omitempty"`
+	Level               ObservationLevel   `json:"level,omitempty"`
+	StatusMessage       string             `json:"statusMessage,omitempty"`
+	ParentObservationID string             `json:"parentObservationId,omitempty"`
+	Version             string             `json:"version,omitempty"`
+	ID                  string             `json:"id,omitempty"`
+	EndTime             *time.Time         `json:"endTime,omitempty"`
+	CompletionStartTime *time.Time         `json:"completionStartTime,omitempty"`
+	Model               string             `json:"model,omitempty"`
+	ModelParameters     any                `json:"modelParameters,omitempty"`
+	Usage               Usage              `json:"usage,omitempty"`
+	UsageDetails        map[string]int     `json:"usageDetails,omitempty"`
+	CostDetails         map[string]float64 `json:"costDetails,omitempty"`
+	PromptName          string             `json:"promptName,omitempty"`
+	PromptVersion       int                `json:"promptVersion,omitempty"`
+	Environment         string             `json:"environment,omitempty"`
+
+	// Observation tags are not part of the ingestion schema and are sent as
+	// metadata under the "tags" key; traces keep their own Tags field
+	Tags []string `json:"-"`
+	// Comment is a human-readable note such as "fell back to secondary model",
+	// kept apart from StatusMessage, which describes errors. It is not part of the
+	// ingestion schema and is sent as metadata under the "comment" key.
+	Comment string `json:"-"`
+	// Tools are the tools or functions the model could call. They are not part
+	// of the ingestion schema and are sent as metadata under the "tools" key.
+	Tools []ToolDefinition `json:"-"`
+	// Links relate the generation to observations other than its parent. They
+	// are not part of the ingestion schema and are sent as metadata under the
+	// "links" key.
+	Links []ObservationLink `json:"-"`
+	// Provider is the provider serving the model, e.g. "openai" or "anthropic",
+	// for per-provider cost and latency analytics. It is not part of the
+	// ingestion schema and is sent as metadata under the "provider" key.
+	Provider string `json:"-"`
+	// Reasoning is the thinking content of reasoning models, kept apart from
+	// the final answer in Output. It is not part of the ingestion schema and is
+	// sent as metadata under the "reasoning" key.
+	Reasoning string `json:"-"`
+	// ReasoningTokens counts the thinking tokens included in the output usage.
+	// They are split out of the output tokens into the "output_reasoning"
+	// usage details entry.
+	ReasoningTokens int `json:"-"`
+
+	// Streaming metrics are not part of the ingestion schema and are sent as metadata
+	TimeToFirstToken          time.Duration `json:"-"`
+	CompletionTokensPerSecond float64       `json:"-"`
 }
 
+// Usage reports the units consumed by a generation. Input, Output and Total are
+// the canonical fields; use NewUsage to compute the total.
 type Usage struct {
 	Input      int       `json:"input,omitempty"`
 	Output     int       `json:"output,omitempty"`
@@ -76,6 +113,8 @@ type Usage struct {
 	OutputCost float64   `json:"outputCost,omitempty"`
 	TotalCost  float64   `json:"totalCost,omitempty"`
 
+	// OpenAI-style aliases of Input, Output and Total. The client moves them
+	// into the canonical fields before sending (see Normalize).
 	PromptTokens     int `json:"promptTokens,omitempty"`
 	CompletionTokens int `json:"completionTokens,omitempty"`
 	TotalTokens      int `json:"totalTokens,omitempty"`
@@ -91,13 +130,22 @@ const (
 	ModelUsageUnitImages       UsageUnit = "IMAGES"
 )
 
+// Score rates a trace, or one of its observations when ObservationID is set
 type Score struct {
-	ID            string  `json:"id,omitempty"`
-	TraceID       string  `json:"traceId,omitempty"`
-	Name          string  `json:"name,omitempty"`
-	Value         float64 `json:"value,omitempty"`
-	ObservationID string  `json:"observationId,omitempty"`
-	Comment       string  `json:"comment,omitempty"`
+	ID            string                 `json:"id,omitempty"`
+	TraceID       string                 `json:"traceId,omitempty"`
+	Name          string                 `json:"name,omitempty"`
+	Value         float64                `json:"value"`
+	ObservationID string                 `json:"observationId,omitempty"`
+	Comment       string                 `json:"comment,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	Environment   string                 `json:"environment,omitempty"`
+	ConfigID      string                 `json:"configId,omitempty"`
+
+	// DataType defaults to NUMERIC; boolean scores have the value 0 or 1
+	DataType ScoreDataType `json:"dataType,omitempty"`
+	// StringValue is the value of a categorical score
+	StringValue string `json:"-"`
 }
 
 type Span struct {
@@ -113,6 +161,19 @@ type Span struct {
 	Version             string           `json:"version,omitempty"`
 	ID                  string           `json:"id,omitempty"`
 	EndTime             *time.Time       `json:"endTime,omitempty"`
+	Environment         string           `json:"environment,omitempty"`
+
+	// Observation tags are not part of the ingestion schema and are sent as
+	// metadata under the "tags" key; traces keep their own Tags field
+	Tags []string `json:"-"`
+	// Comment is a human-readable note such as "fell back to secondary model",
+	// kept apart from StatusMessage, which describes errors. It is not part of the
+	// ingestion schema and is sent as metadata under the "comment" key.
+	Comment string `json:"-"`
+	// Links relate the span to observations other than its parent, e.g. the
+	// branches a fan-in step consumed. They are not part of the ingestion schema
+	// and are sent as metadata under the "links" key.
+	Links []ObservationLink `json:"-"`
 }
 
 type Event struct {
@@ -127,6 +188,7 @@ type Event struct {
 	ParentObservationID string           `json:"parentObservationId,omitempty"`
 	Version             string           `json:"version,omitempty"`
 	ID                  string           `json:"id,omitempty"`
+	Environment         string           `json:"environment,omitempty"`
 }
 
 type M map[string]interface{}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MetadataKeyFinishReason is the generation metadata key holding the finish reason
+const MetadataKeyFinishReason = "finish_reason"
+
+// OpenAIResponse is the common shape of OpenAI chat completion and completion responses
+type OpenAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage"`
+}
+
+// OpenAIChoice is a single completion choice; chat completions set Message, completions set Text
+type OpenAIChoice struct {
+	Index        int            `json:"index"`
+	Message      *OpenAIMessage `json:"message"`
+	Text         string         `json:"text"`
+	FinishReason string         `json:"finish_reason"`
+}
+
+// OpenAIMessage is a chat message
+type OpenAIMessage struct {
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	ToolCalls any    `json:"tool_calls,omitempty"`
+}
+
+// OpenAIUsage is the token usage of a response
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// SetOpenAIResponse populates the output, usage, model and finish reason of gen from
+// an OpenAI-style response. resp may be an *OpenAIResponse, a map, or any SDK type
+// that encodes to the OpenAI JSON shape.
+func SetOpenAIResponse(gen *Generation, resp any) error {
+	if gen == nil {
+		return fmt.Errorf("generation is nil")
+	}
+
+	parsed, err := toOpenAIResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Model != "" {
+		gen.Model = parsed.Model
+	}
+
+	if parsed.Usage != nil {
+		gen.Usage.Input = parsed.Usage.PromptTokens
+		gen.Usage.Output = parsed.Usage.CompletionTokens
+		gen.Usage.Total = parsed.Usage.TotalTokens
+		gen.Usage.Unit = ModelUsageUnitTokens
+	}
+
+	if len(parsed.Choices) == 0 {
+		return nil
+	}
+
+	choice := parsed.Choices[0]
+	if choice.Message != nil {
+		gen.Output = choice.Message
+	} else {
+		gen.Output = choice.Text
+	}
+
+	if choice.FinishReason != "" {
+		gen.Metadata = withMetadata(gen.Metadata, MetadataKeyFinishReason, choice.FinishReason)
+	}
+
+	return nil
+}
+
+// toOpenAIResponse converts resp to an OpenAIResponse through its JSON encoding
+func toOpenAIResponse(resp any) (*OpenAIResponse, error) {
+	switch r := resp.(type) {
+	case nil:
+		return nil, fmt.Errorf("response is nil")
+	case *OpenAIResponse:
+		return r, nil
+	case OpenAIResponse:
+		return &r, nil
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	parsed := &OpenAIResponse{}
+	if err := json.Unmarshal(data, parsed); err != nil {
+		return nil, fmt.Errorf("response is not OpenAI-shaped: %w", err)
+	}
+	return parsed, nil
+}
+
+// withMetadata returns metadata with key set, copying map metadata so callers'
+// maps are not modified. Metadata of another type is returned unchanged.
+func withMetadata(metadata any, key string, value any) any {
+	result := make(map[string]any)
+	switch m := metadata.(type) {
+	case nil:
+	case map[string]any:
+		for k, v := range m {
+			result[k] = v
+		}
+	case M:
+		for k, v := range m {
+			result[k] = v
+		}
+	default:
+		return metadata
+	}
+	result[key] = value
+	return result
+}
@@ -0,0 +1,80 @@
+package model
+
+import "testing"
+
+// sdkResponse stands in for an SDK type that encodes to the OpenAI JSON shape
+type sdkResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Text         string `json:"text"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+func TestSetOpenAIResponse(t *testing.T) {
+	t.Run("Chat completion", func(t *testing.T) {
+		metadata := map[string]any{"team": "search"}
+		gen := &Generation{Model: "gpt-4o", Metadata: metadata}
+		resp := map[string]any{
+			"model": "gpt-4o-2024-08-06",
+			"choices": []any{
+				map[string]any{"message": map[string]any{"role": "assistant", "content": "Paris"}, "finish_reason": "stop"},
+				map[string]any{"message": map[string]any{"role": "assistant", "content": "Lyon"}},
+			},
+			"usage": map[string]any{"prompt_tokens": 12, "completion_tokens": 3, "total_tokens": 15},
+		}
+		if err := SetOpenAIResponse(gen, resp); err != nil {
+			t.Fatalf("SetOpenAIResponse: %v", err)
+		}
+
+		if message, _ := gen.Output.(*OpenAIMessage); message == nil || message.Content != "Paris" || message.Role != "assistant" {
+			t.Errorf("Expected the first choice's message as output, got %v", gen.Output)
+		}
+		if gen.Model != "gpt-4o-2024-08-06" {
+			t.Errorf("Expected the response model, got %q", gen.Model)
+		}
+		if want := (Usage{Input: 12, Output: 3, Total: 15, Unit: ModelUsageUnitTokens}); gen.Usage != want {
+			t.Errorf("Expected usage %+v, got %+v", want, gen.Usage)
+		}
+		if got := gen.Metadata.(map[string]any); got[MetadataKeyFinishReason] != "stop" || got["team"] != "search" {
+			t.Errorf("Expected the finish reason added to the metadata, got %v", got)
+		}
+		if _, modified := metadata[MetadataKeyFinishReason]; modified {
+			t.Error("Expected the caller's metadata map to be left unchanged")
+		}
+	})
+
+	t.Run("Completion", func(t *testing.T) {
+		resp := sdkResponse{Model: "gpt-3.5-turbo-instruct"}
+		resp.Choices = append(resp.Choices, struct {
+			Text         string `json:"text"`
+			FinishReason string `json:"finish_reason"`
+		}{Text: "Paris", FinishReason: "length"})
+
+		gen := &Generation{Usage: Usage{Input: 4}}
+		if err := SetOpenAIResponse(gen, resp); err != nil {
+			t.Fatalf("SetOpenAIResponse: %v", err)
+		}
+		if gen.Output != "Paris" || gen.Model != "gpt-3.5-turbo-instruct" {
+			t.Errorf("Expected the completion text and model, got %v and %q", gen.Output, gen.Model)
+		}
+		if gen.Usage.Input != 4 {
+			t.Errorf("Expected usage to be kept without usage in the response, got %+v", gen.Usage)
+		}
+		if got := gen.Metadata.(map[string]any); got[MetadataKeyFinishReason] != "length" {
+			t.Errorf("Expected the finish reason, got %v", got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if err := SetOpenAIResponse(nil, &OpenAIResponse{}); err == nil {
+			t.Error("Expected an error for a nil generation")
+		}
+		if err := SetOpenAIResponse(&Generation{}, nil); err == nil {
+			t.Error("Expected an error for a nil response")
+		}
+		if err := SetOpenAIResponse(&Generation{}, "not a response"); err == nil {
+			t.Error("Expected an error for a response of another shape")
+		}
+	})
+}
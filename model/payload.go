@@ -0,0 +1,82 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Observable is an event body carrying an input and an output
+type Observable interface {
+	*Trace | *Span | *Generation | *Event
+}
+
+// SetInput sets the input of obs after checking that v encodes to JSON, so a
+// value that cannot be sent, such as a channel or a NaN, fails where it is set
+// rather than when the batch is flushed. Assign Input directly for values
+// whose type is only known at run time.
+func SetInput[O Observable, T any](obs O, v T) error {
+	if err := CheckSerializable(v); err != nil {
+		return fmt.Errorf("input: %w", err)
+	}
+	switch o := any(obs).(type) {
+	case *Trace:
+		if o != nil {
+			o.Input = v
+			return nil
+		}
+	case *Span:
+		if o != nil {
+			o.Input = v
+			return nil
+		}
+	case *Generation:
+		if o != nil {
+			o.Input = v
+			return nil
+		}
+	case *Event:
+		if o != nil {
+			o.Input = v
+			return nil
+		}
+	}
+	return fmt.Errorf("observation is nil")
+}
+
+// SetOutput sets the output of obs after checking that v encodes to JSON
+func SetOutput[O Observable, T any](obs O, v T) error {
+	if err := CheckSerializable(v); err != nil {
+		return fmt.Errorf("output: %w", err)
+	}
+	switch o := any(obs).(type) {
+	case *Trace:
+		if o != nil {
+			o.Output = v
+			return nil
+		}
+	case *Span:
+		if o != nil {
+			o.Output = v
+			return nil
+		}
+	case *Generation:
+		if o != nil {
+			o.Output = v
+			return nil
+		}
+	case *Event:
+		if o != nil {
+			o.Output = v
+			return nil
+		}
+	}
+	return fmt.Errorf("observation is nil")
+}
+
+// CheckSerializable reports an error when v cannot be encoded as an event payload
+func CheckSerializable(v any) error {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Errorf("value of type %T is not serializable: %w", v, err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package model
+
+import "encoding/json"
+
+// ScoreDataType is the type of a score's value
+type ScoreDataType string
+
+const (
+	ScoreDataTypeNumeric     ScoreDataType = "NUMERIC"
+	ScoreDataTypeCategorical ScoreDataType = "CATEGORICAL"
+	ScoreDataTypeBoolean     ScoreDataType = "BOOLEAN"
+)
+
+// MarshalJSON sends the StringValue of categorical scores as their value
+func (s Score) MarshalJSON() ([]byte, error) {
+	type plain Score
+	if s.DataType != ScoreDataTypeCategorical {
+		return json.Marshal(plain(s))
+	}
+	return json.Marshal(struct {
+		plain
+		Value string `json:"value"`
+	}{plain(s), s.StringValue})
+}
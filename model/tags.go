@@ -0,0 +1,33 @@
+package model
+
+// AddTags adds tags to the span, skipping tags it already has
+func (s *Span) AddTags(tags ...string) *Span {
+	s.Tags = AppendTags(s.Tags, tags...)
+	return s
+}
+
+// AddTags adds tags to the generation, skipping tags it already has
+func (g *Generation) AddTags(tags ...string) *Generation {
+	g.Tags = AppendTags(g.Tags, tags...)
+	return g
+}
+
+// AppendTags appends the non-empty tags not yet in existing
+func AppendTags(existing []string, tags ...string) []string {
+	for _, tag := range tags {
+		if tag == "" || containsTag(existing, tag) {
+			continue
+		}
+		existing = append(existing, tag)
+	}
+	return existing
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
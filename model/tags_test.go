@@ -0,0 +1,33 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		tags     []string
+		want     []string
+	}{
+		{name: "New tags", tags: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "Duplicates", existing: []string{"a"}, tags: []string{"b", "a", "b"}, want: []string{"a", "b"}},
+		{name: "Empty tags", existing: []string{"a"}, tags: []string{"", "b"}, want: []string{"a", "b"}},
+		{name: "Nothing to add", existing: []string{"a"}, want: []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := (&Span{Tags: tt.existing}).AddTags(tt.tags...)
+			if !reflect.DeepEqual(span.Tags, tt.want) {
+				t.Errorf("Span tags: got %v, want %v", span.Tags, tt.want)
+			}
+			generation := (&Generation{Tags: tt.existing}).AddTags(tt.tags...)
+			if !reflect.DeepEqual(generation.Tags, tt.want) {
+				t.Errorf("Generation tags: got %v, want %v", generation.Tags, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package model
+
+// ToolDefinition describes a tool or function the model could call
+type ToolDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters is the JSON schema of the tool's arguments
+	Parameters any `json:"parameters,omitempty"`
+}
+
+// SetTools sets the tools available to the generation, replacing any set before
+func (g *Generation) SetTools(tools ...ToolDefinition) *Generation {
+	g.Tools = tools
+	return g
+}
@@ -0,0 +1,49 @@
+package model
+
+// NewUsage returns token usage with its total computed from input and output
+func NewUsage(input, output int) Usage {
+	return Usage{
+		Input:  input,
+		Output: output,
+		Total:  input + output,
+		Unit:   ModelUsageUnitTokens,
+	}
+}
+
+// InputTokens returns Input, falling back to the PromptTokens alias
+func (u Usage) InputTokens() int {
+	if u.Input != 0 {
+		return u.Input
+	}
+	return u.PromptTokens
+}
+
+// OutputTokens returns Output, falling back to the CompletionTokens alias
+func (u Usage) OutputTokens() int {
+	if u.Output != 0 {
+		return u.Output
+	}
+	return u.CompletionTokens
+}
+
+// TotalCount returns Total, falling back to the TotalTokens alias and then
+// to the sum of input and output tokens
+func (u Usage) TotalCount() int {
+	if u.Total != 0 {
+		return u.Total
+	}
+	if u.TotalTokens != 0 {
+		return u.TotalTokens
+	}
+	return u.InputTokens() + u.OutputTokens()
+}
+
+// Normalize moves the PromptTokens, CompletionTokens and TotalTokens aliases
+// into Input, Output and Total and fills in a missing total, so only one
+// shape is sent. An explicit total is kept even if it differs from the sum,
+// since some providers count tokens outside input and output.
+func (u Usage) Normalize() Usage {
+	u.Input, u.Output, u.Total = u.InputTokens(), u.OutputTokens(), u.TotalCount()
+	u.PromptTokens, u.CompletionTokens, u.TotalTokens = 0, 0, 0
+	return u
+}
@@ -0,0 +1,49 @@
+package model
+
+import "testing"
+
+func TestUsageTotals(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage Usage
+		want  Usage
+	}{
+		{
+			name:  "Computed total",
+			usage: NewUsage(10, 5),
+			want:  Usage{Input: 10, Output: 5, Total: 15, Unit: ModelUsageUnitTokens},
+		},
+		{
+			name:  "Aliases",
+			usage: Usage{PromptTokens: 7, CompletionTokens: 3},
+			want:  Usage{Input: 7, Output: 3, Total: 10},
+		},
+		{
+			name:  "Alias total",
+			usage: Usage{TotalTokens: 42},
+			want:  Usage{Total: 42},
+		},
+		{
+			name:  "Explicit total kept",
+			usage: Usage{Input: 10, Output: 5, Total: 20},
+			want:  Usage{Input: 10, Output: 5, Total: 20},
+		},
+		{
+			name:  "Canonical fields win",
+			usage: Usage{Input: 1, PromptTokens: 2, Output: 3, CompletionTokens: 4},
+			want:  Usage{Input: 1, Output: 3, Total: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.usage.Normalize()
+			if got != tt.want {
+				t.Errorf("Normalize: got %+v, want %+v", got, tt.want)
+			}
+			if got.TotalCount() != tt.usage.TotalCount() {
+				t.Errorf("TotalCount changed by Normalize: %d != %d", got.TotalCount(), tt.usage.TotalCount())
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package langfuse
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// defaultModelAliases maps snapshot and provider-specific model names to the
+// canonical names used for cost and usage analytics
+var defaultModelAliases = map[string]string{
+	// OpenAI
+	"gpt-4-0314":             "gpt-4",
+	"gpt-4-0613":             "gpt-4",
+	"gpt-4-32k-0314":         "gpt-4-32k",
+	"gpt-4-32k-0613":         "gpt-4-32k",
+	"gpt-4-turbo-preview":    "gpt-4-turbo",
+	"gpt-4-1106-preview":     "gpt-4-turbo",
+	"gpt-4-0125-preview":     "gpt-4-turbo",
+	"gpt-3.5-turbo-0301":     "gpt-3.5-turbo",
+	"gpt-3.5-turbo-0613":     "gpt-3.5-turbo",
+	"gpt-3.5-turbo-1106":     "gpt-3.5-turbo",
+	"gpt-3.5-turbo-0125":     "gpt-3.5-turbo",
+	"gpt-3.5-turbo-16k-0613": "gpt-3.5-turbo-16k",
+	"chatgpt-4o-latest":      "gpt-4o",
+
+	// Anthropic
+	"claude-3-opus-latest":     "claude-3-opus",
+	"claude-3-5-sonnet-latest": "claude-3-5-sonnet",
+	"claude-3-5-haiku-latest":  "claude-3-5-haiku",
+	"claude-3-7-sonnet-latest": "claude-3-7-sonnet",
+	"claude-instant-1.2":       "claude-instant-1",
+
+	// Google
+	"gemini-pro":            "gemini-1.0-pro",
+	"gemini-1.0-pro-001":    "gemini-1.0-pro",
+	"gemini-1.0-pro-002":    "gemini-1.0-pro",
+	"gemini-1.5-pro-latest": "gemini-1.5-pro",
+	"gemini-1.5-pro-001":    "gemini-1.5-pro",
+	"gemini-1.5-pro-002":    "gemini-1.5-pro",
+	"gemini-1.5-flash-001":  "gemini-1.5-flash",
+	"gemini-1.5-flash-002":  "gemini-1.5-flash",
+}
+
+// modelProviderPrefixes are stripped from names such as "openai/gpt-4"
+var modelProviderPrefixes = []string{"openai/", "anthropic/", "google/", "models/", "azure/", "bedrock/", "vertex_ai/"}
+
+// modelDateSuffix matches dated snapshots such as "-20241022", "-2024-08-06" or "@20240620"
+var modelDateSuffix = regexp.MustCompile(`[-@](\d{8}|\d{4}-\d{2}-\d{2})$`)
+
+// ModelNormalizer maps raw model names reported by providers and nodes to canonical
+// names, so that usage and cost of the same model are not split across variants
+type ModelNormalizer struct {
+	mu        sync.RWMutex
+	aliases   map[string]string
+	canonical map[string]bool // canonical names, matched case-insensitively
+}
+
+// NewModelNormalizer creates a normalizer with the built-in table of common
+// OpenAI, Anthropic and Google model names
+func NewModelNormalizer() *ModelNormalizer {
+	n := &ModelNormalizer{
+		aliases:   make(map[string]string, len(defaultModelAliases)),
+		canonical: make(map[string]bool),
+	}
+	for raw, canonical := range defaultModelAliases {
+		n.aliases[raw] = canonical
+		n.canonical[strings.ToLower(canonical)] = true
+	}
+	return n
+}
+
+// Add maps raw to canonical, overriding the built-in table. Matching is case-insensitive.
+func (n *ModelNormalizer) Add(raw string, canonical string) *ModelNormalizer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.aliases[strings.ToLower(strings.TrimSpace(raw))] = canonical
+	n.canonical[strings.ToLower(canonical)] = true
+	return n
+}
+
+// Normalize returns the canonical name of a model. Provider prefixes and dated
+// snapshot suffixes are removed before the table and its canonical names are
+// consulted; unknown names are returned unchanged.
+func (n *ModelNormalizer) Normalize(name string) string {
+	cleaned := strings.ToLower(strings.TrimSpace(name))
+	if cleaned == "" {
+		return name
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	// User mappings may target the raw form, e.g. a deployment name
+	if canonical, known := n.aliases[cleaned]; known {
+		return canonical
+	}
+
+	for _, prefix := range modelProviderPrefixes {
+		cleaned = strings.TrimPrefix(cleaned, prefix)
+	}
+	cleaned = modelDateSuffix.ReplaceAllString(cleaned, "")
+
+	if canonical, known := n.aliases[cleaned]; known {
+		return canonical
+	}
+	if n.canonical[cleaned] {
+		return cleaned
+	}
+	return name
+}
+
+// WithModelNormalizer normalizes the model name of every generation before it is
+// sent. Use NewModelNormalizer for the built-in table; nil disables normalization.
+func (l *Langfuse) WithModelNormalizer(n *ModelNormalizer) *Langfuse {
+	l.modelNormalizer = n
+	return l
+}
+
+// NormalizeModel returns name as it will be recorded by this client
+func (l *Langfuse) NormalizeModel(name string) string {
+	if l.modelNormalizer == nil {
+		return name
+	}
+	return l.modelNormalizer.Normalize(name)
+}
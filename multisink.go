@@ -0,0 +1,144 @@
+package langfuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// Project identifies a Langfuse project that events are mirrored to
+type Project struct {
+	// Host of the project, e.g. "https://cloud.langfuse.com"
+	Host      string
+	PublicKey string
+	SecretKey string
+}
+
+// MultiSink sends every ingestion batch of a client to its own project and to
+// mirrored projects. Create it with WithMultiSink.
+type MultiSink struct {
+	clients []*api.Client
+	// dropped is called with the number of events too large for some project
+	dropped func(int)
+}
+
+// WithMultiSink mirrors every event the client sends to the given projects as
+// well as to its own, e.g. to an org-wide project and a team project. Events
+// are sent unchanged, so traces and observations have the same IDs in every
+// project. Each batch is sent to all projects concurrently and costs bandwidth
+// and request quota once per project. Errors from any project are reported
+// through the error handler and Flush, naming the project host; a batch
+// counts as failed if any project failed it. A project that rejects a batch as
+// too large gets it again in smaller pieces, without resending it to the other
+// projects. Prompts, datasets and media are read from and uploaded to the
+// client's own project only.
+//
+// Mirrors use the client's settings at the time of the call, such as
+// compression and the User-Agent, so call WithMultiSink after them. Calling it
+// without projects stops mirroring.
+func (l *Langfuse) WithMultiSink(mirrors ...Project) *Langfuse {
+	if len(mirrors) == 0 {
+		l.sink = nil
+		return l
+	}
+
+	sink := &MultiSink{
+		clients: []*api.Client{l.client},
+		dropped: func(n int) { l.metrics.eventsDropped.Add(int64(n)) },
+	}
+	for _, project := range mirrors {
+		sink.clients = append(sink.clients, l.client.Clone(strings.TrimRight(project.Host, "/"), project.PublicKey, project.SecretKey))
+	}
+	l.sink = sink
+	return l
+}
+
+// ingest sends events to every project concurrently and merges the responses.
+// Per-event errors of the same event are merged into one, so each event counts
+// once in the client's stats.
+func (s *MultiSink) ingest(ctx context.Context, events []model.IngestionEvent) (*api.IngestionResponse, error) {
+	responses := make([]*api.IngestionResponse, len(s.clients))
+	errs := make([]error, len(s.clients))
+
+	var wg sync.WaitGroup
+	for i, client := range s.clients {
+		wg.Add(1)
+		go func(i int, client *api.Client) {
+			defer wg.Done()
+			responses[i], errs[i] = ingestSplit(ctx, client, events)
+		}(i, client)
+	}
+	wg.Wait()
+
+	merged := &api.IngestionResponse{}
+	failed := make(map[string]int)
+	tooLarge := make(map[string]bool)
+	var requestErrs []error
+	for i, res := range responses {
+		host := s.clients[i].BaseURL()
+		if errs[i] != nil {
+			requestErrs = append(requestErrs, fmt.Errorf("project %s: %w", host, errs[i]))
+			continue
+		}
+		if i == 0 {
+			merged.Successes = res.Successes
+		}
+		for _, eventErr := range res.Errors {
+			if eventErr.Status == http.StatusRequestEntityTooLarge {
+				tooLarge[eventErr.ID] = true
+			}
+			eventErr.Message = fmt.Sprintf("project %s: %s", host, eventErr.Message)
+			if at, seen := failed[eventErr.ID]; seen {
+				merged.Errors[at].Message += "; " + eventErr.Message
+				continue
+			}
+			failed[eventErr.ID] = len(merged.Errors)
+			merged.Errors = append(merged.Errors, eventErr)
+		}
+	}
+	if len(tooLarge) > 0 {
+		s.dropped(len(tooLarge))
+	}
+	if len(requestErrs) > 0 {
+		return nil, errors.Join(requestErrs...)
+	}
+	return merged, nil
+}
+
+// ingestSplit sends events to the project of client, resending the halves of
+// a batch it rejects as too large until each piece fits. An event too large to
+// send alone is reported as a failed event of the response.
+func ingestSplit(ctx context.Context, client *api.Client, events []model.IngestionEvent) (*api.IngestionResponse, error) {
+	res, err := ingestTo(ctx, client, events)
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		return res, err
+	}
+	if len(events) == 1 {
+		res = &api.IngestionResponse{}
+		res.Errors = []api.Error{{
+			ID:      events[0].ID,
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("dropped %s event: %v", events[0].Type, err),
+		}}
+		return res, nil
+	}
+
+	half := len(events) / 2
+	first, err := ingestSplit(ctx, client, events[:half])
+	if err != nil {
+		return nil, err
+	}
+	second, err := ingestSplit(ctx, client, events[half:])
+	if err != nil {
+		return nil, err
+	}
+	first.Successes = append(first.Successes, second.Successes...)
+	first.Errors = append(first.Errors, second.Errors...)
+	return first, nil
+}
@@ -0,0 +1,29 @@
+package langfuse
+
+import "log"
+
+// Must returns v, panicking if err is non-nil. It shortens one-shot scripts
+// and examples where any failure should stop the program:
+//
+//	trace := langfuse.Must(l.Trace(&model.Trace{Name: "backfill"}))
+//
+// Services should handle the error returned by the call instead.
+func Must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// LogError returns v, logging err if it is non-nil, so a script carries on past
+// a call whose failure is not fatal, such as recording an event or a score:
+//
+//	langfuse.LogError(l.Score(&model.Score{TraceID: trace.ID, Name: "quality", Value: 1}))
+//
+// Calls that fail return a nil result, so check it before use.
+func LogError[T any](v T, err error) T {
+	if err != nil {
+		log.Printf("Langfuse call failed: %v", err)
+	}
+	return v
+}
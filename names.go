@@ -0,0 +1,29 @@
+package langfuse
+
+import "strings"
+
+// NameSanitizer rewrites an observation name before it is submitted
+type NameSanitizer func(name string) string
+
+// DefaultNameSanitizer keeps the last path segment of a name, so a function
+// name such as "github.com/org/repo/pkg.Handler" becomes "pkg.Handler"
+func DefaultNameSanitizer(name string) string {
+	if idx := strings.LastIndex(name, "/"); idx >= 0 && idx < len(name)-1 {
+		return name[idx+1:]
+	}
+	return name
+}
+
+// NewNameSanitizer returns a sanitizer that applies DefaultNameSanitizer and
+// then strips the first matching suffix, e.g. NewNameSanitizer("_generation")
+func NewNameSanitizer(suffixes ...string) NameSanitizer {
+	return func(name string) string {
+		name = DefaultNameSanitizer(name)
+		for _, suffix := range suffixes {
+			if trimmed := strings.TrimSuffix(name, suffix); trimmed != name && trimmed != "" {
+				return trimmed
+			}
+		}
+		return name
+	}
+}
@@ -8,19 +8,23 @@ import (
 	"log"
 	"reflect"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/paulnegz/langfuse-go/internal/pkg/ctxkey"
+	"github.com/paulnegz/langfuse-go/internal/pkg/stack"
 	"github.com/paulnegz/langfuse-go/model"
 )
 
-// Context key types to avoid collisions
-type contextKey string
+// metadataKeyStackTrace holds the stack captured for errored observations
+const metadataKeyStackTrace = "stack_trace"
 
-const (
-	contextKeyObserver contextKey = "langfuse_observer"
-	contextKeyParentID contextKey = "langfuse_parent_id"
-)
+// observerKey stores the current observer in a context
+var observerKey = ctxkey.New[*Observer]("observer")
+
+// traceIDKey stores a trace ID for observed calls to join
+var traceIDKey = ctxkey.New[string]("trace_id")
 
 // ObservationType represents the type of observation
 type ObservationType string
@@ -37,18 +41,39 @@ const (
 	ObservationTypeGuardrail  ObservationType = "guardrail"
 )
 
-// Observer provides function observation capabilities similar to Python's @observe decorator
+// Observer provides function observation capabilities similar to Python's @observe decorator.
+// Its configuration is fixed at construction, so one observer can be shared by
+// concurrent calls; they join the trace created by the first call.
 type Observer struct {
 	client     *Langfuse
+	traceMu    sync.RWMutex
 	traceID    string
+	refMu      sync.Mutex
 	parentID   *string
 	sessionID  string
 	userID     string
+	version    string
 	name       string
 	obsType    ObservationType
 	metadata   map[string]interface{}
 	captureIO  bool
 	sampleRate float64
+	clock      Clock
+	ctx        context.Context
+
+	nameSanitizer NameSanitizer
+	captureStack  bool
+	ref           *ObservationRef
+	limits        captureLimits
+
+	embeddingVectors bool
+	level            model.ObservationLevel
+}
+
+// ObservationRef identifies the trace and observation recorded for an observed call
+type ObservationRef struct {
+	TraceID       string
+	ObservationID string
 }
 
 // ObserveOption configures the observer
@@ -89,6 +114,15 @@ func WithObserveUser(userID string) ObserveOption {
 	}
 }
 
+// WithObserveVersion sets the version of the trace and observations, e.g. the
+// release of the code being observed. Unset, it is inherited from an observer
+// in the context, then from the client's WithVersion.
+func WithObserveVersion(version string) ObserveOption {
+	return func(o *Observer) {
+		o.version = version
+	}
+}
+
 // WithCaptureIO enables/disables input/output capture
 func WithCaptureIO(capture bool) ObserveOption {
 	return func(o *Observer) {
@@ -96,13 +130,118 @@ func WithCaptureIO(capture bool) ObserveOption {
 	}
 }
 
-// WithSampleRate sets the sampling rate (0.0 to 1.0)
+// WithSampleRate sets the sampling rate (0.0 to 1.0) of traces the observer
+// creates. Calls joining an existing trace follow its sampling decision, see
+// Langfuse.IsSampled.
 func WithSampleRate(rate float64) ObserveOption {
 	return func(o *Observer) {
 		o.sampleRate = rate
 	}
 }
 
+// WithMaxCaptureDepth bounds how deeply captured arguments and results are
+// copied; values nested deeper are replaced by a description of their type.
+// Defaults to 32.
+func WithMaxCaptureDepth(depth int) ObserveOption {
+	return func(o *Observer) {
+		o.limits.maxDepth = depth
+	}
+}
+
+// WithMaxCaptureElements caps the elements captured of each slice, array and
+// map in arguments and results, so tracing a huge collection cannot produce an
+// enormous payload. Elements beyond n are replaced by a marker counting them;
+// map entries are kept in key order. Zero, the default, captures all elements.
+func WithMaxCaptureElements(n int) ObserveOption {
+	return func(o *Observer) {
+		o.limits.maxElements = n
+	}
+}
+
+// WithObserveLevel sets the level of the observations created, e.g.
+// model.ObservationLevelDebug for verbose instrumentation that a client
+// configured with WithMinLevel drops. Their arguments are then not captured.
+func WithObserveLevel(level model.ObservationLevel) ObserveOption {
+	return func(o *Observer) {
+		o.level = level
+	}
+}
+
+// WithObserveContext sets the context searched for an ambient observer when the
+// observed function does not take a context.Context as its first argument
+func WithObserveContext(ctx context.Context) ObserveOption {
+	return func(o *Observer) {
+		o.ctx = ctx
+	}
+}
+
+// WithObserveNameSanitizer sets the sanitizer applied to observation names.
+// Defaults to DefaultNameSanitizer; nil submits names unchanged.
+func WithObserveNameSanitizer(sanitizer NameSanitizer) ObserveOption {
+	return func(o *Observer) {
+		o.nameSanitizer = sanitizer
+	}
+}
+
+// WithObserveClock sets the clock used for observation timing
+func WithObserveClock(c Clock) ObserveOption {
+	return func(o *Observer) {
+		o.clock = c
+	}
+}
+
+// WithCaptureStackTrace records a stack trace under the stack_trace metadata key
+// when an observed function returns an error or panics. For panics the stack is
+// taken at the recover site, so it includes the panicking frames. A returned
+// error has left the frames that created it, so its stack is the one it
+// carries, e.g. from ErrorWithStack or github.com/pkg/errors, and otherwise that
+// of the call to the observed function. Defaults to false, as stacks cost time
+// to capture and reveal internal code paths.
+func WithCaptureStackTrace(capture bool) ObserveOption {
+	return func(o *Observer) {
+		o.captureStack = capture
+	}
+}
+
+// ErrorWithStack returns err annotated with the stack of its caller, so an
+// observer capturing stack traces records where the error occurred rather than
+// where the observed function returned. It returns nil for a nil err.
+func ErrorWithStack(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &stackError{err: err, pcs: stack.Callers(1)}
+}
+
+// stackError is an error annotated with the stack it was created on
+type stackError struct {
+	err error
+	pcs []uintptr
+}
+
+func (e *stackError) Error() string      { return e.err.Error() }
+func (e *stackError) Unwrap() error      { return e.err }
+func (e *stackError) Callers() []uintptr { return e.pcs }
+
+// errorStack returns the stack recorded by err, or else the current stack
+// skipping skip frames above the caller of errorStack
+func errorStack(err error, skip int) string {
+	if trace := stack.FromError(err); trace != "" {
+		return trace
+	}
+	return stack.Capture(skip + 1)
+}
+
+// WithObservationRef stores the trace and observation IDs of each observed call
+// in ref before the function runs, so callers of ObserveFunc and ObserveWithResult
+// can log or link the resulting trace. With concurrent calls ref holds the IDs of
+// the call that started last.
+func WithObservationRef(ref *ObservationRef) ObserveOption {
+	return func(o *Observer) {
+		o.ref = ref
+	}
+}
+
 // NewObserver creates a new observer instance
 func NewObserver(client *Langfuse, opts ...ObserveOption) *Observer {
 	o := &Observer{
@@ -111,17 +250,85 @@ func NewObserver(client *Langfuse, opts ...ObserveOption) *Observer {
 		metadata:   make(map[string]interface{}),
 		captureIO:  true,
 		sampleRate: 1.0,
+
+		nameSanitizer: DefaultNameSanitizer,
+		limits:        defaultCaptureLimits,
 	}
 
 	for _, opt := range opts {
 		opt(o)
 	}
 
+	// Fall back to the client's clock so timestamps stay consistent
+	if o.clock == nil {
+		if client != nil && client.clock != nil {
+			o.clock = client.clock
+		} else {
+			o.clock = realClock{}
+		}
+	}
+
 	return o
 }
 
+// TraceID returns the ID of the trace the observer records into. It is empty
+// until the first observed call or Start creates or joins a trace.
+func (o *Observer) TraceID() string {
+	o.traceMu.RLock()
+	defer o.traceMu.RUnlock()
+
+	return o.traceID
+}
+
+// joinOrCreateTrace returns the observer's trace, calling create to start one
+// when it has none. Concurrent first calls share the trace created by one of them.
+func (o *Observer) joinOrCreateTrace(create func() (string, bool)) string {
+	o.traceMu.Lock()
+	defer o.traceMu.Unlock()
+
+	if o.traceID == "" {
+		if traceID, created := create(); created {
+			o.traceID = traceID
+		}
+	}
+	return o.traceID
+}
+
+// ContextWithTraceID returns a context whose observed calls record into traceID
+// instead of creating a new trace, e.g. a trace started by an HTTP middleware.
+// An observer in the context takes precedence.
+func ContextWithTraceID(ctx context.Context, traceID string) context.Context {
+	return traceIDKey.With(ctx, traceID)
+}
+
+// TraceIDFromContext returns the trace ID of the observer in ctx, or else the
+// one set with ContextWithTraceID. Inside functions wrapped by Observe that take
+// a context.Context, this is the trace of the enclosing observation.
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	if observer := ObserverFromContext(ctx); observer != nil {
+		if traceID := observer.TraceID(); traceID != "" {
+			return traceID, true
+		}
+	}
+	traceID, found := traceIDKey.Value(ctx)
+	return traceID, found && traceID != ""
+}
+
+// sanitizeName applies the configured name sanitizer
+func (o *Observer) sanitizeName(name string) string {
+	if o.nameSanitizer == nil {
+		return name
+	}
+	return o.nameSanitizer(name)
+}
+
+// contextType is the reflect type of context.Context
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 // Observe wraps a function with observation capabilities
-// This is the Go equivalent of Python's @observe decorator
+// This is the Go equivalent of Python's @observe decorator.
+// If the function takes a context.Context first argument carrying an observer
+// (see WithObserver), the trace, parent, session and user are inherited from it.
 func (o *Observer) Observe(fn interface{}) interface{} {
 	fnValue := reflect.ValueOf(fn)
 	fnType := fnValue.Type()
@@ -130,42 +337,55 @@ func (o *Observer) Observe(fn interface{}) interface{} {
 		panic("Observe: argument must be a function")
 	}
 
-	// Get function name if not specified
-	if o.name == "" {
-		o.name = runtime.FuncForPC(fnValue.Pointer()).Name()
+	// Get function name if not specified; the observer itself is left unchanged
+	name := o.name
+	if name == "" {
+		name = runtime.FuncForPC(fnValue.Pointer()).Name()
 	}
+	name = o.sanitizeName(name)
 
 	// Create wrapped function
 	wrappedFn := reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
-		// Check sampling
-		if !o.shouldSample() {
+		// Resolve trace attributes, inheriting from an ambient observer if present
+		ctx, ctxArg := o.callContext(args)
+		scope := o.scopeFor(ctx)
+
+		// A call joining a trace follows the trace's sampling decision, so the
+		// trace is never partially recorded; the observer's rate applies to
+		// traces it creates
+		if scope.traceID != "" && scope.client != nil {
+			if !scope.client.IsSampled(scope.traceID) {
+				return fnValue.Call(args)
+			}
+		} else if !o.shouldSample() {
 			return fnValue.Call(args)
 		}
 
 		// Start observation
-		ctx := context.Background()
-		startTime := time.Now()
+		startTime := o.clock.Now()
 
 		// Create trace if needed
-		if o.traceID == "" {
-			trace := &model.Trace{
-				ID:        uuid.New().String(),
-				Name:      o.name,
-				Timestamp: &startTime,
-				SessionID: o.sessionID,
-				UserID:    o.userID,
-				Metadata:  o.metadata,
-			}
-
-			createdTrace, err := o.client.Trace(trace)
-			if err == nil {
-				o.traceID = createdTrace.ID
-			}
+		if scope.traceID == "" {
+			scope.traceID = o.joinOrCreateTrace(func() (string, bool) {
+				createdTrace, err := scope.client.Trace(&model.Trace{
+					ID:        uuid.New().String(),
+					Name:      name,
+					Timestamp: &startTime,
+					SessionID: scope.sessionID,
+					UserID:    scope.userID,
+					Version:   scope.version,
+					Metadata:  o.metadata,
+				})
+				if err != nil {
+					return "", false
+				}
+				return createdTrace.ID, true
+			})
 		}
 
-		// Capture input if enabled
+		// Capture input if enabled and the observation is not dropped by level
 		var input interface{}
-		if o.captureIO && len(args) > 0 {
+		if o.captureIO && len(args) > 0 && !scope.client.filtersLevel(o.level) {
 			input = o.captureArgs(args)
 		}
 
@@ -175,14 +395,16 @@ func (o *Observer) Observe(fn interface{}) interface{} {
 		case ObservationTypeGeneration:
 			gen := &model.Generation{
 				ID:        uuid.New().String(),
-				TraceID:   o.traceID,
-				Name:      o.name,
+				TraceID:   scope.traceID,
+				Version:   scope.version,
+				Name:      name,
 				StartTime: &startTime,
 				Input:     input,
 				Metadata:  o.metadata,
+				Level:     o.level,
 			}
 
-			createdGen, err := o.client.Generation(gen, o.parentID)
+			createdGen, err := scope.client.Generation(gen, scope.parentID)
 			if err == nil {
 				observationID = createdGen.ID
 			}
@@ -190,19 +412,51 @@ func (o *Observer) Observe(fn interface{}) interface{} {
 		default: // Span or other types
 			span := &model.Span{
 				ID:        uuid.New().String(),
-				TraceID:   o.traceID,
-				Name:      o.name,
+				TraceID:   scope.traceID,
+				Version:   scope.version,
+				Name:      name,
 				StartTime: &startTime,
 				Input:     input,
 				Metadata:  o.metadata,
+				Level:     o.level,
 			}
 
-			createdSpan, err := o.client.Span(span, o.parentID)
+			createdSpan, err := scope.client.Span(span, scope.parentID)
 			if err == nil {
 				observationID = createdSpan.ID
 			}
 		}
 
+		if o.ref != nil {
+			o.refMu.Lock()
+			*o.ref = ObservationRef{TraceID: scope.traceID, ObservationID: observationID}
+			o.refMu.Unlock()
+		}
+
+		// Expose this observation to nested observed calls through the context argument
+		if ctxArg && observationID != "" {
+			args[0] = reflect.ValueOf(WithObserver(ctx, o.child(scope, observationID)))
+		}
+
+		// A panic ends the observation as an error before it propagates
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				endTime := o.clock.Now()
+				message := fmt.Sprintf("panic: %v", recovered)
+				metadata := map[string]interface{}{
+					"duration_ms": endTime.Sub(startTime).Milliseconds(),
+					"error":       true,
+					"panic":       fmt.Sprint(recovered),
+				}
+				if o.captureStack {
+					// Deferred calls run on top of the panicking frames
+					metadata[metadataKeyStackTrace] = stack.Capture(0)
+				}
+				o.endCall(scope, observationID, endTime, nil, metadata, model.ObservationLevelError, message)
+				panic(recovered)
+			}
+		}()
+
 		// Execute the function
 		results := fnValue.Call(args)
 
@@ -214,45 +468,19 @@ func (o *Observer) Observe(fn interface{}) interface{} {
 		}
 
 		// End observation
-		endTime := time.Now()
+		endTime := o.clock.Now()
 		duration := endTime.Sub(startTime)
 
 		// Update observation with results
-		switch o.obsType {
-		case ObservationTypeGeneration:
-			if _, err := o.client.GenerationEnd(&model.Generation{
-				ID:      observationID,
-				TraceID: o.traceID,
-				EndTime: &endTime,
-				Output:  output,
-				Metadata: map[string]interface{}{
-					"duration_ms": duration.Milliseconds(),
-					"error":       fnErr != nil,
-				},
-			}); err != nil {
-				log.Printf("Failed to end generation: %v", err)
-			}
-
-		default:
-			if _, err := o.client.SpanEnd(&model.Span{
-				ID:      observationID,
-				TraceID: o.traceID,
-				EndTime: &endTime,
-				Output:  output,
-				Metadata: map[string]interface{}{
-					"duration_ms": duration.Milliseconds(),
-					"error":       fnErr != nil,
-				},
-			}); err != nil {
-				log.Printf("Failed to end span: %v", err)
-			}
+		metadata := map[string]interface{}{
+			"duration_ms": duration.Milliseconds(),
+			"error":       fnErr != nil,
 		}
-
-		// Create child observer for nested calls
-		if observationID != "" {
-			// Store parent ID in context for nested observations
-			_ = context.WithValue(ctx, contextKeyParentID, observationID)
+		if fnErr != nil && o.captureStack {
+			// Skip this wrapper so the stack starts at the observed call
+			metadata[metadataKeyStackTrace] = errorStack(fnErr, 1)
 		}
+		o.endCall(scope, observationID, endTime, output, metadata, "", "")
 
 		return results
 	})
@@ -260,6 +488,165 @@ func (o *Observer) Observe(fn interface{}) interface{} {
 	return wrappedFn.Interface()
 }
 
+// endCall ends the observation of an observed call
+func (o *Observer) endCall(scope observationScope, observationID string, endTime time.Time, output interface{}, metadata map[string]interface{}, level model.ObservationLevel, statusMessage string) {
+	switch o.obsType {
+	case ObservationTypeGeneration:
+		if _, err := scope.client.GenerationEnd(&model.Generation{
+			ID:            observationID,
+			TraceID:       scope.traceID,
+			Version:       scope.version,
+			EndTime:       &endTime,
+			Output:        output,
+			Metadata:      metadata,
+			Level:         level,
+			StatusMessage: statusMessage,
+		}); err != nil {
+			log.Printf("Failed to end generation: %v", err)
+		}
+
+	default:
+		if _, err := scope.client.SpanEnd(&model.Span{
+			ID:            observationID,
+			TraceID:       scope.traceID,
+			Version:       scope.version,
+			EndTime:       &endTime,
+			Output:        output,
+			Metadata:      metadata,
+			Level:         level,
+			StatusMessage: statusMessage,
+		}); err != nil {
+			log.Printf("Failed to end span: %v", err)
+		}
+	}
+}
+
+// observationScope holds the trace attributes used by a single observed call
+type observationScope struct {
+	client    *Langfuse
+	traceID   string
+	parentID  *string
+	sessionID string
+	userID    string
+	version   string
+}
+
+// callContext returns the context for an observed call: the function's first
+// argument when it is a context.Context, otherwise the WithObserveContext value.
+// The boolean reports whether the context came from the arguments.
+func (o *Observer) callContext(args []reflect.Value) (context.Context, bool) {
+	if len(args) > 0 && args[0].Type() == contextType {
+		if ctx, isCtx := args[0].Interface().(context.Context); isCtx && ctx != nil {
+			return ctx, true
+		}
+	}
+	if o.ctx != nil {
+		return o.ctx, false
+	}
+	return context.Background(), false
+}
+
+// scopeFor resolves the scope of an observed call. Values set on this observer
+// take precedence; unset values are inherited from an observer found in ctx.
+// Without either, the call joins a trace set with ContextWithTraceID.
+func (o *Observer) scopeFor(ctx context.Context) observationScope {
+	scope := observationScope{
+		client:    o.client,
+		traceID:   o.TraceID(),
+		parentID:  o.parentID,
+		sessionID: o.sessionID,
+		userID:    o.userID,
+		version:   o.version,
+	}
+
+	if ambient := ObserverFromContext(ctx); ambient != nil && ambient != o {
+		if scope.client == nil {
+			scope.client = ambient.client
+		}
+		if scope.traceID == "" {
+			scope.traceID = ambient.TraceID()
+			if scope.parentID == nil {
+				scope.parentID = ambient.parentID
+			}
+		}
+		if scope.sessionID == "" {
+			scope.sessionID = ambient.sessionID
+		}
+		if scope.userID == "" {
+			scope.userID = ambient.userID
+		}
+		if scope.version == "" {
+			scope.version = ambient.version
+		}
+	}
+
+	if scope.traceID == "" {
+		scope.traceID, _ = traceIDKey.Value(ctx)
+	}
+
+	return scope
+}
+
+// child returns an observer that nests further observations under observationID
+func (o *Observer) child(scope observationScope, observationID string) *Observer {
+	return &Observer{
+		client:     scope.client,
+		traceID:    scope.traceID,
+		parentID:   &observationID,
+		sessionID:  scope.sessionID,
+		userID:     scope.userID,
+		version:    scope.version,
+		obsType:    ObservationTypeSpan,
+		metadata:   make(map[string]interface{}),
+		captureIO:  o.captureIO,
+		sampleRate: 1.0,
+		clock:      o.clock,
+
+		captureStack: o.captureStack,
+		limits:       o.limits,
+	}
+}
+
+// With returns a copy of the observer with opts applied on top of its
+// configuration, e.g. to observe a nested step under another name or type. The
+// copy records into the same trace, session and user, nested under the same
+// parent; for the observer of an observed call, found with ObserverFromContext,
+// that is the call's observation. The metadata map is copied, not shared.
+func (o *Observer) With(opts ...ObserveOption) *Observer {
+	metadata := make(map[string]interface{}, len(o.metadata))
+	for key, value := range o.metadata {
+		metadata[key] = value
+	}
+
+	derived := &Observer{
+		client:     o.client,
+		traceID:    o.TraceID(),
+		parentID:   o.parentID,
+		sessionID:  o.sessionID,
+		userID:     o.userID,
+		version:    o.version,
+		name:       o.name,
+		obsType:    o.obsType,
+		metadata:   metadata,
+		captureIO:  o.captureIO,
+		sampleRate: o.sampleRate,
+		clock:      o.clock,
+		ctx:        o.ctx,
+
+		nameSanitizer: o.nameSanitizer,
+		captureStack:  o.captureStack,
+		ref:           o.ref,
+		limits:        o.limits,
+
+		embeddingVectors: o.embeddingVectors,
+		level:            o.level,
+	}
+	for _, opt := range opts {
+		opt(derived)
+	}
+	return derived
+}
+
 // ObserveFunc is a convenience function to wrap and execute a function with observation
 func ObserveFunc(client *Langfuse, fn func() error, opts ...ObserveOption) error {
 	observer := NewObserver(client, opts...)
@@ -290,7 +677,7 @@ func (o *Observer) shouldSample() bool {
 		return false
 	}
 	// Simple random sampling
-	return float64(time.Now().UnixNano()%100)/100.0 < o.sampleRate
+	return float64(o.clock.Now().UnixNano()%100)/100.0 < o.sampleRate
 }
 
 // captureArgs converts function arguments to a capturable format
@@ -351,88 +738,117 @@ func (o *Observer) captureResults(results []reflect.Value) (interface{}, error)
 	return captured, err
 }
 
-// reflectValueToInterface safely converts a reflect.Value to interface{}
+// reflectValueToInterface converts a reflect.Value to a JSON-serializable copy,
+// so arguments with unexported or unsupported fields cannot fail the batch
 func (o *Observer) reflectValueToInterface(v reflect.Value) interface{} {
-	if !v.IsValid() {
-		return nil
-	}
-
-	if v.Kind() == reflect.Ptr && v.IsNil() {
-		return nil
-	}
-
-	// Handle special types
-	switch v.Kind() {
-	case reflect.Func:
-		return fmt.Sprintf("function<%s>", v.Type().String())
-	case reflect.Chan:
-		return fmt.Sprintf("channel<%s>", v.Type().String())
-	case reflect.Interface:
-		if v.IsNil() {
-			return nil
-		}
-		return v.Interface()
-	default:
-		if v.CanInterface() {
-			return v.Interface()
-		}
-		return fmt.Sprintf("<%s>", v.Type().String())
-	}
+	return o.limits.captureValue(v, 0)
 }
 
 // ObserveContext creates an observation context for manual span management
 type ObserveContext struct {
 	observer      *Observer
 	observationID string
+	name          string
 	startTime     time.Time
 	obsType       ObservationType
+
+	parent       *ObserveContext
+	openChildren int
+	ended        bool
+
+	mu             sync.Mutex
+	firstTokenTime *time.Time
+	streamedTokens int
+	checkpoints    int
+	input          interface{}
+	output         interface{}
+	comment        string
+	attributes     map[string]interface{}
 }
 
 // Start begins a new observation
 func (o *Observer) Start(name string) *ObserveContext {
-	startTime := time.Now()
+	return o.StartAt(name, o.clock.Now())
+}
 
-	// Create trace if needed
-	if o.traceID == "" {
-		trace := &model.Trace{
+// StartAt begins a new observation that started at startTime, e.g. to backfill
+// events imported from another tracing system. End it with EndAt.
+func (o *Observer) StartAt(name string, startTime time.Time) *ObserveContext {
+	name = o.sanitizeName(name)
+
+	// Join a trace from the WithObserveContext context, or create one
+	var ctxTraceID string
+	if o.ctx != nil && o.TraceID() == "" {
+		ctxTraceID, _ = TraceIDFromContext(o.ctx)
+	}
+	o.joinOrCreateTrace(func() (string, bool) {
+		if ctxTraceID != "" {
+			return ctxTraceID, true
+		}
+
+		createdTrace, err := o.client.Trace(&model.Trace{
 			ID:        uuid.New().String(),
 			Name:      name,
 			Timestamp: &startTime,
 			SessionID: o.sessionID,
 			UserID:    o.userID,
+			Version:   o.version,
 			Metadata:  o.metadata,
+		})
+		if err != nil {
+			return "", false
 		}
+		return createdTrace.ID, true
+	})
 
-		createdTrace, err := o.client.Trace(trace)
-		if err == nil {
-			o.traceID = createdTrace.ID
-		}
-	}
+	return o.startObservation(name, o.obsType, o.parentID, nil, startTime)
+}
+
+// Child opens a nested observation parented to this one, for manually
+// instrumenting the internal steps of a single function
+func (oc *ObserveContext) Child(name string, obsType ObservationType) *ObserveContext {
+	return oc.ChildAt(name, obsType, oc.observer.clock.Now())
+}
+
+// ChildAt opens a nested observation that started at startTime, for backfilling
+func (oc *ObserveContext) ChildAt(name string, obsType ObservationType, startTime time.Time) *ObserveContext {
+	oc.mu.Lock()
+	oc.openChildren++
+	oc.mu.Unlock()
+
+	parentID := oc.observationID
+	return oc.observer.startObservation(oc.observer.sanitizeName(name), obsType, &parentID, oc, startTime)
+}
 
-	// Create observation
+// startObservation creates a span or generation in the observer's trace
+func (o *Observer) startObservation(name string, obsType ObservationType, parentID *string, parent *ObserveContext, startTime time.Time) *ObserveContext {
 	observationID := uuid.New().String()
-	switch o.obsType {
+	switch obsType {
 	case ObservationTypeGeneration:
 		gen := &model.Generation{
 			ID:        observationID,
-			TraceID:   o.traceID,
+			TraceID:   o.TraceID(),
+			Version:   o.version,
 			Name:      name,
 			StartTime: &startTime,
 			Metadata:  o.metadata,
+			Level:     o.level,
 		}
-		if _, err := o.client.Generation(gen, o.parentID); err != nil {
+		if _, err := o.client.Generation(gen, parentID); err != nil {
 			log.Printf("Failed to create generation: %v", err)
 		}
 
 	default:
 		span := &model.Span{
 			ID:        observationID,
-			TraceID:   o.traceID,
+			TraceID:   o.TraceID(),
+			Version:   o.version,
 			Name:      name,
 			StartTime: &startTime,
 			Metadata:  o.metadata,
+			Level:     o.level,
 		}
-		if _, err := o.client.Span(span, o.parentID); err != nil {
+		if _, err := o.client.Span(span, parentID); err != nil {
 			log.Printf("Failed to create span: %v", err)
 		}
 	}
@@ -440,42 +856,235 @@ func (o *Observer) Start(name string) *ObserveContext {
 	return &ObserveContext{
 		observer:      o,
 		observationID: observationID,
+		name:          name,
 		startTime:     startTime,
-		obsType:       o.obsType,
+		obsType:       obsType,
+		parent:        parent,
+	}
+}
+
+// RecordToken records n tokens streamed by a generation. The first call marks the
+// completion start, from which time-to-first-token and throughput are derived on End.
+func (oc *ObserveContext) RecordToken(n int) {
+	now := oc.observer.clock.Now()
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	if oc.firstTokenTime == nil {
+		oc.firstTokenTime = &now
+	}
+	oc.streamedTokens += n
+}
+
+// SetInput attaches input to an observation created with Start or Child. It is
+// sent as an update of the existing observation, so it can be called at any time
+// before or after End.
+func (oc *ObserveContext) SetInput(input interface{}) {
+	oc.mu.Lock()
+	oc.input = input
+	oc.mu.Unlock()
+
+	var err error
+	switch oc.obsType {
+	case ObservationTypeGeneration:
+		_, err = oc.observer.client.GenerationEnd(&model.Generation{
+			ID:      oc.observationID,
+			TraceID: oc.observer.TraceID(),
+			Version: oc.observer.version,
+			Input:   input,
+		})
+	default:
+		_, err = oc.observer.client.SpanEnd(&model.Span{
+			ID:      oc.observationID,
+			TraceID: oc.observer.TraceID(),
+			Version: oc.observer.version,
+			Input:   input,
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to set observation input: %v", err)
+	}
+}
+
+// SetOutput sets the output recorded by End when it is called with a nil output
+func (oc *ObserveContext) SetOutput(output interface{}) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	oc.output = output
+}
+
+// SetObservationInput is SetInput for a statically typed input. It returns an
+// error, and sends nothing, when input cannot be encoded as JSON.
+func SetObservationInput[T any](oc *ObserveContext, input T) error {
+	if err := model.CheckSerializable(input); err != nil {
+		return fmt.Errorf("input: %w", err)
+	}
+	oc.SetInput(input)
+	return nil
+}
+
+// SetObservationOutput is SetOutput for a statically typed output. It returns
+// an error, and keeps the previous output, when output cannot be encoded as JSON.
+func SetObservationOutput[T any](oc *ObserveContext, output T) error {
+	if err := model.CheckSerializable(output); err != nil {
+		return fmt.Errorf("output: %w", err)
+	}
+	oc.SetOutput(output)
+	return nil
+}
+
+// SetComment records a human-readable note on the observation when it ends,
+// e.g. "fell back to secondary model", separate from any error
+func (oc *ObserveContext) SetComment(comment string) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	oc.comment = comment
+}
+
+// SetAttribute records a key-value attribute discovered while the observation
+// runs, such as the model chosen or a retry count, like an OpenTelemetry span
+// attribute. Attributes are merged into the observation's metadata at End; a
+// later value for the same key replaces an earlier one. It is safe to call from
+// concurrent goroutines.
+func (oc *ObserveContext) SetAttribute(key string, value interface{}) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if oc.attributes == nil {
+		oc.attributes = make(map[string]interface{})
+	}
+	oc.attributes[key] = value
+}
+
+// Checkpoint records progress of a long-running observation as an event nested
+// under it, so the observation shows a timeline instead of a single block.
+// The event carries data as its output and the time elapsed since the start.
+func (oc *ObserveContext) Checkpoint(name string, data interface{}) {
+	now := oc.observer.clock.Now()
+
+	oc.mu.Lock()
+	oc.checkpoints++
+	index := oc.checkpoints
+	oc.mu.Unlock()
+
+	parentID := oc.observationID
+	if _, err := oc.observer.client.Event(&model.Event{
+		TraceID:   oc.observer.TraceID(),
+		Version:   oc.observer.version,
+		Name:      oc.observer.sanitizeName(name),
+		StartTime: &now,
+		Output:    data,
+		Metadata: map[string]interface{}{
+			"checkpoint": index,
+			"elapsed_ms": now.Sub(oc.startTime).Milliseconds(),
+		},
+	}, &parentID); err != nil {
+		log.Printf("Failed to record checkpoint: %v", err)
 	}
 }
 
-// End completes an observation
+// Score adds a score to the observation
+func (oc *ObserveContext) Score(name string, value float64, comment string) error {
+	return oc.ScoreWithMetadata(name, value, comment, nil)
+}
+
+// ScoreWithMetadata adds a score carrying metadata such as the annotator or rubric version
+func (oc *ObserveContext) ScoreWithMetadata(name string, value float64, comment string, metadata map[string]interface{}) error {
+	_, err := oc.observer.client.Score(&model.Score{
+		TraceID:       oc.observer.TraceID(),
+		Name:          name,
+		Value:         value,
+		Comment:       comment,
+		ObservationID: oc.observationID,
+		Metadata:      metadata,
+	})
+	return err
+}
+
+// End completes an observation with output, or with the output given to SetOutput
+// if output is nil. Ending an observation whose children are still open logs a
+// warning; the children can still be ended afterwards.
 func (oc *ObserveContext) End(output interface{}, err error) {
-	endTime := time.Now()
-	duration := endTime.Sub(oc.startTime)
+	oc.EndAt(output, err, oc.observer.clock.Now())
+}
 
-	metadata := map[string]interface{}{
-		"duration_ms": duration.Milliseconds(),
+// EndAt completes an observation like End, at endTime instead of now. An end
+// time before the start is rejected under strict validation and otherwise
+// clamped to the start.
+func (oc *ObserveContext) EndAt(output interface{}, err error, endTime time.Time) {
+	oc.mu.Lock()
+	openChildren := oc.openChildren
+	firstEnd := !oc.ended
+	oc.ended = true
+	if output == nil {
+		output = oc.output
 	}
+	oc.output = output
+	comment := oc.comment
+	metadata := make(map[string]interface{}, len(oc.attributes)+1)
+	for key, value := range oc.attributes {
+		metadata[key] = value
+	}
+	oc.mu.Unlock()
+
+	if openChildren > 0 {
+		log.Printf("Ending observation %s with %d open child observations", oc.observationID, openChildren)
+	}
+	if firstEnd && oc.parent != nil {
+		oc.parent.mu.Lock()
+		oc.parent.openChildren--
+		oc.parent.mu.Unlock()
+	}
+	duration := endTime.Sub(oc.startTime)
+
+	metadata["duration_ms"] = duration.Milliseconds()
 	if err != nil {
 		metadata["error"] = err.Error()
+		if oc.observer.captureStack {
+			metadata[metadataKeyStackTrace] = errorStack(err, 1)
+		}
 	}
 
 	switch oc.obsType {
 	case ObservationTypeGeneration:
-		if _, genErr := oc.observer.client.GenerationEnd(&model.Generation{
-			ID:       oc.observationID,
-			TraceID:  oc.observer.traceID,
-			EndTime:  &endTime,
-			Output:   output,
-			Metadata: metadata,
-		}); genErr != nil {
+		generation := &model.Generation{
+			ID:        oc.observationID,
+			TraceID:   oc.observer.TraceID(),
+			Version:   oc.observer.version,
+			Name:      oc.name,
+			StartTime: &oc.startTime,
+			EndTime:   &endTime,
+			Output:    output,
+			Metadata:  metadata,
+			Comment:   comment,
+		}
+
+		oc.mu.Lock()
+		if oc.firstTokenTime != nil {
+			generation.CompletionStartTime = oc.firstTokenTime
+			generation.TimeToFirstToken = oc.firstTokenTime.Sub(oc.startTime)
+			if elapsed := endTime.Sub(*oc.firstTokenTime).Seconds(); elapsed > 0 {
+				generation.CompletionTokensPerSecond = float64(oc.streamedTokens) / elapsed
+			}
+		}
+		oc.mu.Unlock()
+
+		if _, genErr := oc.observer.client.GenerationEnd(generation); genErr != nil {
 			log.Printf("Failed to end generation: %v", genErr)
 		}
 
 	default:
 		if _, spanErr := oc.observer.client.SpanEnd(&model.Span{
-			ID:       oc.observationID,
-			TraceID:  oc.observer.traceID,
-			EndTime:  &endTime,
-			Output:   output,
-			Metadata: metadata,
+			ID:        oc.observationID,
+			TraceID:   oc.observer.TraceID(),
+			Version:   oc.observer.version,
+			Name:      oc.name,
+			StartTime: &oc.startTime,
+			EndTime:   &endTime,
+			Output:    output,
+			Metadata:  metadata,
+			Comment:   comment,
 		}); spanErr != nil {
 			log.Printf("Failed to end span: %v", spanErr)
 		}
@@ -484,13 +1093,11 @@ func (oc *ObserveContext) End(output interface{}, err error) {
 
 // WithObserver adds an observer to the context
 func WithObserver(ctx context.Context, observer *Observer) context.Context {
-	return context.WithValue(ctx, contextKeyObserver, observer)
+	return observerKey.With(ctx, observer)
 }
 
 // ObserverFromContext retrieves an observer from context
 func ObserverFromContext(ctx context.Context) *Observer {
-	if observer, ok := ctx.Value(contextKeyObserver).(*Observer); ok {
-		return observer
-	}
-	return nil
+	observer, _ := observerKey.Value(ctx)
+	return observer
 }
@@ -0,0 +1,83 @@
+package langfuse
+
+import (
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// OpenInference semantic attribute names added to observation metadata by WithOpenInference
+const (
+	openInferenceSpanKind         = "openinference.span.kind"
+	openInferenceModelName        = "llm.model_name"
+	openInferenceInvocationParams = "llm.invocation_parameters"
+	openInferencePromptTokens     = "llm.token_count.prompt"
+	openInferenceCompletionTokens = "llm.token_count.completion"
+	openInferenceTotalTokens      = "llm.token_count.total"
+	openInferenceInputValue       = "input.value"
+	openInferenceOutputValue      = "output.value"
+)
+
+// WithOpenInference adds OpenInference semantic attributes, such as
+// llm.model_name and llm.token_count.prompt, to the metadata of spans and
+// generations, so traces can be read by tools following those conventions.
+// The attributes are added next to the existing metadata; input and output
+// are copied after masking and offloading.
+func (l *Langfuse) WithOpenInference(enabled bool) *Langfuse {
+	l.openInference = enabled
+	return l
+}
+
+// applyOpenInferenceSpan adds the OpenInference attributes of a span to its metadata
+func (l *Langfuse) applyOpenInferenceSpan(s *model.Span) {
+	if !l.openInference {
+		return
+	}
+	s.Metadata = withOpenInference(s.Metadata, "CHAIN", s.Input, s.Output, nil)
+}
+
+// applyOpenInferenceGeneration adds the OpenInference attributes of a
+// generation to its metadata
+func (l *Langfuse) applyOpenInferenceGeneration(g *model.Generation) {
+	if !l.openInference {
+		return
+	}
+
+	attributes := make(map[string]interface{})
+	if g.Model != "" {
+		attributes[openInferenceModelName] = g.Model
+	}
+	if g.ModelParameters != nil {
+		attributes[openInferenceInvocationParams] = g.ModelParameters
+	}
+	if g.Usage.Input > 0 {
+		attributes[openInferencePromptTokens] = g.Usage.Input
+	}
+	if g.Usage.Output > 0 {
+		attributes[openInferenceCompletionTokens] = g.Usage.Output
+	}
+	if g.Usage.Total > 0 {
+		attributes[openInferenceTotalTokens] = g.Usage.Total
+	}
+	g.Metadata = withOpenInference(g.Metadata, "LLM", g.Input, g.Output, attributes)
+}
+
+// withOpenInference returns metadata extended with the span kind, input,
+// output and attributes
+func withOpenInference(metadata any, kind string, input any, output any, attributes map[string]interface{}) any {
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+
+	extended[openInferenceSpanKind] = kind
+	if input != nil {
+		extended[openInferenceInputValue] = input
+	}
+	if output != nil {
+		extended[openInferenceOutputValue] = output
+	}
+	for key, value := range attributes {
+		extended[key] = value
+	}
+	return extended
+}
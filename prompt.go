@@ -1,12 +1,24 @@
 package langfuse
 
 import (
+	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+var (
+	// ErrPromptFetch matches errors of GetAndCompile fetching the prompt
+	ErrPromptFetch = errors.New("prompt fetch failed")
+	// ErrPromptCompile matches errors of GetAndCompile compiling a fetched prompt
+	ErrPromptCompile = errors.New("prompt compile failed")
 )
 
 // PromptType represents the type of prompt
@@ -35,26 +47,86 @@ type ChatMessage struct {
 
 // CompiledPrompt represents a prompt with variables replaced
 type CompiledPrompt struct {
-	Type   PromptType
-	Text   string        // For text prompts
-	Chat   []ChatMessage // For chat prompts
-	Config map[string]interface{}
+	Name    string
+	Version int
+	Type    PromptType
+	Text    string        // For text prompts
+	Chat    []ChatMessage // For chat prompts
+	Config  map[string]interface{}
+}
+
+// MetadataKeyCompiledPrompt holds the exact prompt sent to the model, recorded by
+// AttachTo when the generation already has an input
+const MetadataKeyCompiledPrompt = "compiled_prompt"
+
+// Input returns the compiled messages for chat prompts and the compiled text otherwise
+func (c *CompiledPrompt) Input() interface{} {
+	if c.Type == PromptTypeChat {
+		return c.Chat
+	}
+	return c.Text
+}
+
+// AttachTo records the compiled prompt on a generation for auditing. It becomes the
+// generation input, or, when an input is already set, is stored with the prompt
+// name, version and type under the compiled_prompt metadata key instead. Metadata
+// that is not map-shaped is left unchanged.
+func (c *CompiledPrompt) AttachTo(g *model.Generation) {
+	if g.Input == nil {
+		g.Input = c.Input()
+		return
+	}
+
+	metadata, ok := copyMetadata(g.Metadata)
+	if !ok {
+		return
+	}
+	record := map[string]interface{}{
+		"name":    c.Name,
+		"version": c.Version,
+		"type":    c.Type,
+	}
+	if c.Type == PromptTypeChat {
+		record["messages"] = c.Chat
+	} else {
+		record["text"] = c.Text
+	}
+	metadata[MetadataKeyCompiledPrompt] = record
+	g.Metadata = metadata
 }
 
 // PromptClient provides prompt management functionality
 type PromptClient struct {
-	langfuse *Langfuse
-	cache    *PromptCache
+	langfuse     *Langfuse
+	cache        *PromptCache
+	compileCache *compileCache
 }
 
 // NewPromptClient creates a new prompt client
 func (l *Langfuse) NewPromptClient() *PromptClient {
+	cache := NewPromptCache(60 * time.Second).WithMaxSize(DefaultPromptCacheSize) // 60s TTL like Python
+	cache.shared = &l.metrics.promptCache
 	return &PromptClient{
 		langfuse: l,
-		cache:    NewPromptCache(60 * time.Second), // 60s TTL like Python
+		cache:    cache,
 	}
 }
 
+// CacheStats returns the number of GetPrompt calls answered from the cache and
+// the number that fetched the prompt or waited for a fetch in progress. The
+// client's Stats sums them over all its prompt clients.
+func (pc *PromptClient) CacheStats() (hits uint64, misses uint64) {
+	return pc.cache.Stats()
+}
+
+// WithPromptCacheSize bounds the number of fetched prompts the client caches
+// (DefaultPromptCacheSize by default), evicting the least recently used.
+// A size of zero or less leaves the cache unbounded.
+func (pc *PromptClient) WithPromptCacheSize(size int) *PromptClient {
+	pc.cache.WithMaxSize(size)
+	return pc
+}
+
 // GetPrompt retrieves a prompt by name and optional version or label
 func (pc *PromptClient) GetPrompt(ctx context.Context, name string, opts ...PromptOption) (*Prompt, error) {
 	options := &promptOptions{
@@ -65,22 +137,29 @@ func (pc *PromptClient) GetPrompt(ctx context.Context, name string, opts ...Prom
 		opt(options)
 	}
 
-	// Check cache first
+	// Concurrent misses for the same key share a single fetch
 	cacheKey := pc.buildCacheKey(name, options)
-	if cached := pc.cache.Get(cacheKey); cached != nil {
-		return cached, nil
-	}
+	return pc.cache.GetOrLoad(ctx, cacheKey, func(ctx context.Context) (*Prompt, error) {
+		return pc.fetchPrompt(ctx, name, options)
+	})
+}
 
-	// Fetch from API
-	prompt, err := pc.fetchPrompt(ctx, name, options)
+// GetAndCompile fetches a prompt through the cache, like GetPrompt, and
+// compiles it with variables, like Compile. Errors match ErrPromptFetch when
+// the prompt could not be fetched and ErrPromptCompile when it could not be
+// compiled, including include directives that cannot be resolved, so serving
+// code can tell an unavailable prompt from a broken one.
+func (pc *PromptClient) GetAndCompile(ctx context.Context, name string, variables map[string]interface{}, opts ...PromptOption) (*CompiledPrompt, error) {
+	prompt, err := pc.GetPrompt(ctx, name, opts...)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s: %w", ErrPromptFetch, name, err)
 	}
 
-	// Cache the result
-	pc.cache.Set(cacheKey, prompt)
-
-	return prompt, nil
+	compiled, err := pc.Compile(ctx, prompt, variables)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s version %d: %w", ErrPromptCompile, name, prompt.Version, err)
+	}
+	return compiled, nil
 }
 
 // CreatePrompt creates a new prompt or version
@@ -99,6 +178,9 @@ func (pc *PromptClient) CreatePrompt(ctx context.Context, prompt *Prompt) (*Prom
 
 	// Invalidate cache for this prompt name
 	pc.cache.InvalidatePrefix(prompt.Name)
+	if pc.compileCache != nil {
+		pc.compileCache.invalidate(prompt.Name)
+	}
 
 	return prompt, nil
 }
@@ -106,8 +188,10 @@ func (pc *PromptClient) CreatePrompt(ctx context.Context, prompt *Prompt) (*Prom
 // Compile replaces variables in the prompt template
 func (p *Prompt) Compile(variables map[string]interface{}) (*CompiledPrompt, error) {
 	compiled := &CompiledPrompt{
-		Type:   p.Type,
-		Config: p.Config,
+		Name:    p.Name,
+		Version: p.Version,
+		Type:    p.Type,
+		Config:  p.Config,
 	}
 
 	switch p.Type {
@@ -119,22 +203,9 @@ func (p *Prompt) Compile(variables map[string]interface{}) (*CompiledPrompt, err
 		compiled.Text = replaceVariables(text, variables)
 
 	case PromptTypeChat:
-		messages, isMessages := p.Prompt.([]ChatMessage)
-		if !isMessages {
-			// Try to convert from []interface{}
-			if msgs, isMsgSlice := p.Prompt.([]interface{}); isMsgSlice {
-				messages = make([]ChatMessage, 0, len(msgs))
-				for _, msg := range msgs {
-					if m, isMap := msg.(map[string]interface{}); isMap {
-						messages = append(messages, ChatMessage{
-							Role:    getString(m, "role"),
-							Content: getString(m, "content"),
-						})
-					}
-				}
-			} else {
-				return nil, fmt.Errorf("invalid chat prompt format")
-			}
+		messages, err := chatMessages(p.Prompt)
+		if err != nil {
+			return nil, err
 		}
 
 		compiled.Chat = make([]ChatMessage, len(messages))
@@ -152,12 +223,36 @@ func (p *Prompt) Compile(variables map[string]interface{}) (*CompiledPrompt, err
 	return compiled, nil
 }
 
+// chatMessages returns the messages of a chat prompt template, converting
+// them from []interface{} when the prompt was decoded from JSON
+func chatMessages(template interface{}) ([]ChatMessage, error) {
+	if messages, isMessages := template.([]ChatMessage); isMessages {
+		return messages, nil
+	}
+
+	msgs, isMsgSlice := template.([]interface{})
+	if !isMsgSlice {
+		return nil, fmt.Errorf("invalid chat prompt format")
+	}
+	messages := make([]ChatMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if m, isMap := msg.(map[string]interface{}); isMap {
+			messages = append(messages, ChatMessage{
+				Role:    getString(m, "role"),
+				Content: getString(m, "content"),
+			})
+		}
+	}
+	return messages, nil
+}
+
+// templateVariable matches {{variable}} placeholders
+var templateVariable = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
 // replaceVariables replaces {{variable}} placeholders with values
 func replaceVariables(template string, variables map[string]interface{}) string {
-	re := regexp.MustCompile(`\{\{(\w+)\}\}`)
-
-	return re.ReplaceAllStringFunc(template, func(match string) string {
-		varName := re.FindStringSubmatch(match)[1]
+	return templateVariable.ReplaceAllStringFunc(template, func(match string) string {
+		varName := templateVariable.FindStringSubmatch(match)[1]
 		if value, ok := variables[varName]; ok {
 			return fmt.Sprintf("%v", value)
 		}
@@ -211,23 +306,57 @@ func (pc *PromptClient) buildCacheKey(name string, opts *promptOptions) string {
 	return fmt.Sprintf("%s:latest", name)
 }
 
-// PromptCache implements a simple TTL cache for prompts
+// DefaultPromptCacheSize is the number of prompts a PromptClient caches
+const DefaultPromptCacheSize = 1000
+
+// PromptCache implements a TTL cache for prompts, optionally bounded in size
+// with least-recently-used eviction
 type PromptCache struct {
-	mu    sync.RWMutex
-	items map[string]*cacheItem
-	ttl   time.Duration
+	mu       sync.RWMutex
+	items    map[string]*cacheItem
+	lru      *list.List // keys, most recently used first
+	maxSize  int
+	inflight map[string]*promptLoad
+	ttl      time.Duration
+
+	counters cacheCounters
+	shared   *cacheCounters // the client's counters, when created by a PromptClient
+}
+
+// cacheCounters counts prompt cache lookups
+type cacheCounters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (c *cacheCounters) record(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
 }
 
 type cacheItem struct {
 	prompt    *Prompt
 	expiresAt time.Time
+	element   *list.Element
+}
+
+// promptLoad is a fetch in progress shared by concurrent callers
+type promptLoad struct {
+	done   chan struct{}
+	prompt *Prompt
+	err    error
 }
 
 // NewPromptCache creates a new prompt cache
 func NewPromptCache(ttl time.Duration) *PromptCache {
 	cache := &PromptCache{
-		items: make(map[string]*cacheItem),
-		ttl:   ttl,
+		items:    make(map[string]*cacheItem),
+		lru:      list.New(),
+		inflight: make(map[string]*promptLoad),
+		ttl:      ttl,
 	}
 
 	// Start cleanup goroutine
@@ -236,20 +365,53 @@ func NewPromptCache(ttl time.Duration) *PromptCache {
 	return cache
 }
 
-// Get retrieves a prompt from cache
-func (c *PromptCache) Get(key string) *Prompt {
+// WithMaxSize bounds the cache to size prompts. When it is full, storing a
+// prompt evicts the least recently used one. Entries still expire after the
+// TTL. A size of zero or less leaves the cache unbounded.
+func (c *PromptCache) WithMaxSize(size int) *PromptCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSize = size
+	c.evict()
+	return c
+}
+
+// Len returns the number of cached prompts, including expired ones not yet removed
+func (c *PromptCache) Len() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	item, ok := c.items[key]
-	if !ok {
-		return nil
+	return len(c.items)
+}
+
+// Stats returns the number of lookups answered from the cache and the number
+// that missed, including lookups of expired entries
+func (c *PromptCache) Stats() (hits uint64, misses uint64) {
+	return c.counters.hits.Load(), c.counters.misses.Load()
+}
+
+// recordLookup counts a lookup in the cache's and the client's counters
+func (c *PromptCache) recordLookup(hit bool) {
+	c.counters.record(hit)
+	if c.shared != nil {
+		c.shared.record(hit)
 	}
+}
 
-	if time.Now().After(item.expiresAt) {
-		return nil // Expired
+// Get retrieves a prompt from cache
+func (c *PromptCache) Get(key string) *Prompt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || time.Now().After(item.expiresAt) {
+		c.recordLookup(false)
+		return nil
 	}
 
+	c.recordLookup(true)
+	c.lru.MoveToFront(item.element)
 	return item.prompt
 }
 
@@ -258,12 +420,85 @@ func (c *PromptCache) Set(key string, prompt *Prompt) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.store(key, prompt)
+}
+
+// store adds or replaces an entry as the most recently used, evicting the
+// least recently used entries beyond the size limit. c.mu must be held.
+func (c *PromptCache) store(key string, prompt *Prompt) {
+	if item, ok := c.items[key]; ok {
+		item.prompt = prompt
+		item.expiresAt = time.Now().Add(c.ttl)
+		c.lru.MoveToFront(item.element)
+		return
+	}
+
 	c.items[key] = &cacheItem{
 		prompt:    prompt,
 		expiresAt: time.Now().Add(c.ttl),
+		element:   c.lru.PushFront(key),
+	}
+	c.evict()
+}
+
+// evict removes least recently used entries beyond the size limit. c.mu must be held.
+func (c *PromptCache) evict() {
+	for c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back().Value.(string))
+	}
+}
+
+// remove deletes an entry. c.mu must be held.
+func (c *PromptCache) remove(key string) {
+	if item, ok := c.items[key]; ok {
+		c.lru.Remove(item.element)
+		delete(c.items, key)
+	}
+}
+
+// GetOrLoad returns the cached prompt for key, calling load on a miss. Concurrent
+// misses for the same key wait for a single load and share its result; only
+// successful loads are cached. The load runs with ctx's values but is not
+// canceled with it, so a caller giving up does not fail the load for the
+// others: each caller waits until the load is done or its own ctx is canceled.
+func (c *PromptCache) GetOrLoad(ctx context.Context, key string, load func(ctx context.Context) (*Prompt, error)) (*Prompt, error) {
+	c.mu.Lock()
+	if item, ok := c.items[key]; ok && time.Now().Before(item.expiresAt) {
+		c.recordLookup(true)
+		c.lru.MoveToFront(item.element)
+		c.mu.Unlock()
+		return item.prompt, nil
+	}
+	c.recordLookup(false)
+	call, loading := c.inflight[key]
+	if !loading {
+		call = &promptLoad{done: make(chan struct{})}
+		c.inflight[key] = call
+		go c.load(context.WithoutCancel(ctx), key, call, load)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.prompt, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
+// load runs a shared load for key and publishes its result to the waiters
+func (c *PromptCache) load(ctx context.Context, key string, call *promptLoad, load func(ctx context.Context) (*Prompt, error)) {
+	call.prompt, call.err = load(ctx)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		c.store(key, call.prompt)
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
 // InvalidatePrefix removes all cache entries with the given prefix
 func (c *PromptCache) InvalidatePrefix(prefix string) {
 	c.mu.Lock()
@@ -271,7 +506,7 @@ func (c *PromptCache) InvalidatePrefix(prefix string) {
 
 	for key := range c.items {
 		if strings.HasPrefix(key, prefix) {
-			delete(c.items, key)
+			c.remove(key)
 		}
 	}
 }
@@ -286,7 +521,7 @@ func (c *PromptCache) cleanup() {
 		now := time.Now()
 		for key, item := range c.items {
 			if now.After(item.expiresAt) {
-				delete(c.items, key)
+				c.remove(key)
 			}
 		}
 		c.mu.Unlock()
@@ -339,6 +574,51 @@ func (l *Langfuse) CreatePrompt(ctx context.Context, prompt *Prompt) (*Prompt, e
 	return pc.CreatePrompt(ctx, prompt)
 }
 
+// GenerationFromPrompt compiles the prompt with the given variables and records a
+// generation using the compiled text or messages as input. The generation is linked
+// to the prompt name and version, and the prompt config becomes its model parameters.
+// The returned generation can be completed later with GenerationEnd.
+func (l *Langfuse) GenerationFromPrompt(prompt *Prompt, variables map[string]interface{}, parentID *string) (*model.Generation, error) {
+	return l.generationFromPrompt(prompt, variables, "", "", parentID)
+}
+
+// generationFromPrompt records a prompt-linked generation in the trace traceID,
+// or in a new trace if it is empty
+func (l *Langfuse) generationFromPrompt(prompt *Prompt, variables map[string]interface{}, traceID string, environment string, parentID *string) (*model.Generation, error) {
+	if prompt == nil {
+		return nil, fmt.Errorf("prompt is required")
+	}
+
+	compiled, err := prompt.Compile(variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile prompt: %w", err)
+	}
+
+	modelParameters := make(map[string]interface{}, len(prompt.Config))
+	for k, v := range prompt.Config {
+		modelParameters[k] = v
+	}
+
+	startTime := l.Now()
+	generation := &model.Generation{
+		TraceID:       traceID,
+		Name:          prompt.Name,
+		StartTime:     &startTime,
+		PromptName:    prompt.Name,
+		PromptVersion: prompt.Version,
+		Environment:   environment,
+	}
+	compiled.AttachTo(generation)
+	if len(modelParameters) > 0 {
+		generation.ModelParameters = modelParameters
+	}
+	if modelName, hasModel := prompt.Config["model"].(string); hasModel {
+		generation.Model = modelName
+	}
+
+	return l.Generation(generation, parentID)
+}
+
 // PromptTemplate provides a builder interface for prompts
 type PromptTemplate struct {
 	prompt *Prompt
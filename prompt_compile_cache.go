@@ -0,0 +1,149 @@
+package langfuse
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// compileCache is an LRU cache of compiled prompts keyed by prompt name,
+// version and a hash of the template and variables
+type compileCache struct {
+	mu       sync.Mutex
+	size     int
+	entries  map[string]*list.Element
+	lru      *list.List
+	versions map[string]int
+}
+
+type compileEntry struct {
+	key      string
+	name     string
+	compiled *CompiledPrompt
+}
+
+func newCompileCache(size int) *compileCache {
+	return &compileCache{
+		size:     size,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		versions: make(map[string]int),
+	}
+}
+
+// compile returns the cached compilation of prompt with variables, compiling
+// and caching it on a miss. Variables that cannot be hashed bypass the cache.
+func (c *compileCache) compile(prompt *Prompt, variables map[string]interface{}) (*CompiledPrompt, error) {
+	key, hashable := compileCacheKey(prompt, variables)
+	if !hashable {
+		return prompt.Compile(variables)
+	}
+
+	c.mu.Lock()
+	c.trackVersion(prompt)
+	if element, cached := c.entries[key]; cached {
+		c.lru.MoveToFront(element)
+		compiled := element.Value.(*compileEntry).compiled
+		c.mu.Unlock()
+		return compiled.clone(), nil
+	}
+	c.mu.Unlock()
+
+	compiled, err := prompt.Compile(variables)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, cached := c.entries[key]; !cached {
+		c.entries[key] = c.lru.PushFront(&compileEntry{key: key, name: prompt.Name, compiled: compiled.clone()})
+		for c.lru.Len() > c.size {
+			c.removeElement(c.lru.Back())
+		}
+	}
+	return compiled, nil
+}
+
+// trackVersion drops the compilations of a prompt when a different version of it
+// is compiled, so caches do not hold on to superseded versions
+func (c *compileCache) trackVersion(prompt *Prompt) {
+	if version, seen := c.versions[prompt.Name]; seen && version != prompt.Version {
+		c.removeName(prompt.Name)
+	}
+	c.versions[prompt.Name] = prompt.Version
+}
+
+// invalidate drops all compilations of the named prompt
+func (c *compileCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeName(name)
+	delete(c.versions, name)
+}
+
+func (c *compileCache) removeName(name string) {
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*compileEntry).name == name {
+			c.removeElement(element)
+		}
+		element = next
+	}
+}
+
+func (c *compileCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*compileEntry).key)
+}
+
+// compileCacheKey hashes the template and variables, so prompts built locally
+// under the same name and version never share entries
+func compileCacheKey(prompt *Prompt, variables map[string]interface{}) (string, bool) {
+	payload, err := json.Marshal([]interface{}{prompt.Type, prompt.Prompt, variables})
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(payload)
+	return fmt.Sprintf("%s\x00%d\x00%s", prompt.Name, prompt.Version, hex.EncodeToString(sum[:])), true
+}
+
+// clone copies the compiled messages so callers cannot modify cached results
+func (c *CompiledPrompt) clone() *CompiledPrompt {
+	clone := *c
+	clone.Chat = append([]ChatMessage(nil), c.Chat...)
+	return &clone
+}
+
+// WithCompileCache caches up to size compiled prompts, keyed by prompt name,
+// version and variables, for use by Compile. This trades memory for the CPU
+// spent on substitution when the same variable sets recur. Entries for a
+// prompt are dropped when another version of it is compiled or created.
+// A size of zero or less disables the cache.
+func (pc *PromptClient) WithCompileCache(size int) *PromptClient {
+	if size <= 0 {
+		pc.compileCache = nil
+		return pc
+	}
+	pc.compileCache = newCompileCache(size)
+	return pc
+}
+
+// Compile compiles prompt with variables, using the compile cache if enabled.
+// {{@include:name}} directives are first replaced by the named text prompts,
+// fetched through the client's prompt cache.
+func (pc *PromptClient) Compile(ctx context.Context, prompt *Prompt, variables map[string]interface{}) (*CompiledPrompt, error) {
+	prompt, err := pc.expandIncludes(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	if pc.compileCache == nil {
+		return prompt.Compile(variables)
+	}
+	return pc.compileCache.compile(prompt, variables)
+}
@@ -0,0 +1,87 @@
+package langfuse
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// PromptRunner sends a compiled prompt to an LLM and returns its output
+type PromptRunner func(ctx context.Context, compiled *CompiledPrompt) (interface{}, error)
+
+// EvaluatePrompt runs every item of dataset through prompt and runner and records
+// the results as a dataset run named after the prompt version. Map inputs are used
+// as the prompt variables; other inputs are passed as the "input" variable. Each
+// LLM call is recorded as a generation linked to the prompt version, and outputs
+// are scored by evaluator, or by exact match against the expected output if nil.
+func (l *Langfuse) EvaluatePrompt(ctx context.Context, prompt *Prompt, dataset *Dataset, runner PromptRunner, evaluator func(input interface{}, expectedOutput interface{}, actualOutput interface{}) (float64, error)) (*EvaluationResult, error) {
+	if prompt == nil {
+		return nil, fmt.Errorf("prompt is required")
+	}
+	if dataset == nil {
+		return nil, fmt.Errorf("dataset is required")
+	}
+	if runner == nil {
+		return nil, fmt.Errorf("runner is required")
+	}
+	if evaluator == nil {
+		evaluator = exactMatch
+	}
+
+	runMetadata := map[string]interface{}{
+		"prompt_name":    prompt.Name,
+		"prompt_version": prompt.Version,
+	}
+
+	result, err := NewDatasetEvaluator(dataset, evaluator).evaluate(ctx,
+		fmt.Sprintf("%s-v%d", prompt.Name, prompt.Version),
+		fmt.Sprintf("Evaluation of prompt %s version %d", prompt.Name, prompt.Version),
+		runMetadata,
+		func(rc *RunContext, input interface{}) (interface{}, error) {
+			return l.runPrompt(ctx, rc, prompt, input, runner)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Metadata = runMetadata
+	return result, nil
+}
+
+// runPrompt compiles prompt for input and calls runner, recording the call as a
+// prompt-linked generation under the run's span
+func (l *Langfuse) runPrompt(ctx context.Context, rc *RunContext, prompt *Prompt, input interface{}, runner PromptRunner) (interface{}, error) {
+	variables, isMap := input.(map[string]interface{})
+	if !isMap {
+		variables = map[string]interface{}{"input": input}
+	}
+
+	compiled, err := prompt.Compile(variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile prompt: %w", err)
+	}
+
+	parentID := rc.run.SpanID
+	generation, err := l.generationFromPrompt(prompt, variables, rc.run.TraceID, rc.run.Environment, &parentID)
+	if err != nil {
+		return nil, err
+	}
+
+	output, runErr := runner(ctx, compiled)
+
+	endTime := l.Now()
+	generation.EndTime = &endTime
+	generation.Output = output
+	if runErr != nil {
+		generation.Level = model.ObservationLevelError
+		generation.StatusMessage = runErr.Error()
+	}
+	if _, endErr := l.GenerationEnd(generation); endErr != nil {
+		log.Printf("Failed to end generation: %v", endErr)
+	}
+
+	return output, runErr
+}
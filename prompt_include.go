@@ -0,0 +1,104 @@
+package langfuse
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxIncludeDepth limits how deeply {{@include:name}} directives may nest
+const MaxIncludeDepth = 8
+
+// includeDirective matches {{@include:promptName}} directives
+var includeDirective = regexp.MustCompile(`\{\{@include:([\w.\-/]+)\}\}`)
+
+// expandIncludes returns a copy of prompt with every {{@include:name}} directive
+// replaced by the text of the named prompt, fetched through the client's prompt
+// cache. Included prompts may include others, up to MaxIncludeDepth levels.
+// Prompts without directives are returned unchanged.
+func (pc *PromptClient) expandIncludes(ctx context.Context, prompt *Prompt) (*Prompt, error) {
+	stack := []string{prompt.Name}
+
+	switch prompt.Type {
+	case PromptTypeText:
+		text, ok := prompt.Prompt.(string)
+		if !ok || !includeDirective.MatchString(text) {
+			return prompt, nil
+		}
+		expanded, err := pc.resolveIncludes(ctx, text, stack)
+		if err != nil {
+			return nil, err
+		}
+		clone := *prompt
+		clone.Prompt = expanded
+		return &clone, nil
+
+	case PromptTypeChat:
+		messages, err := chatMessages(prompt.Prompt)
+		if err != nil {
+			return prompt, nil // Compile reports the invalid format
+		}
+		expanded := make([]ChatMessage, len(messages))
+		changed := false
+		for i, msg := range messages {
+			expanded[i] = msg
+			if !includeDirective.MatchString(msg.Content) {
+				continue
+			}
+			if expanded[i].Content, err = pc.resolveIncludes(ctx, msg.Content, stack); err != nil {
+				return nil, err
+			}
+			changed = true
+		}
+		if !changed {
+			return prompt, nil
+		}
+		clone := *prompt
+		clone.Prompt = expanded
+		return &clone, nil
+	}
+
+	return prompt, nil
+}
+
+// resolveIncludes replaces the directives in text. stack holds the names of the
+// prompts being expanded, outermost first, to detect cycles.
+func (pc *PromptClient) resolveIncludes(ctx context.Context, text string, stack []string) (string, error) {
+	var resolveErr error
+	expanded := includeDirective.ReplaceAllStringFunc(text, func(match string) string {
+		if resolveErr != nil {
+			return match
+		}
+		name := includeDirective.FindStringSubmatch(match)[1]
+
+		for _, including := range stack {
+			if including == name {
+				resolveErr = fmt.Errorf("prompt include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+				return match
+			}
+		}
+		if len(stack) > MaxIncludeDepth {
+			resolveErr = fmt.Errorf("prompt includes nested deeper than %d levels: %s", MaxIncludeDepth, strings.Join(stack, " -> "))
+			return match
+		}
+
+		fragment, err := pc.GetPrompt(ctx, name)
+		if err != nil {
+			resolveErr = fmt.Errorf("failed to include prompt %s: %w", name, err)
+			return match
+		}
+		fragmentText, ok := fragment.Prompt.(string)
+		if fragment.Type != PromptTypeText || !ok {
+			resolveErr = fmt.Errorf("included prompt %s is not a text prompt", name)
+			return match
+		}
+
+		fragmentText, resolveErr = pc.resolveIncludes(ctx, fragmentText, append(stack[:len(stack):len(stack)], name))
+		return fragmentText
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return expanded, nil
+}
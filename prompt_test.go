@@ -0,0 +1,363 @@
+package langfuse
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// Test that compiled prompts are cached per version and variable set
+func TestPromptCompileCache(t *testing.T) {
+	ctx := context.Background()
+	pc := NewWithConfig(ctx, Config{PublicKey: "pk", SecretKey: "sk"}).NewPromptClient().WithCompileCache(2)
+
+	v1 := ChatPrompt("greet", []ChatMessage{{Role: "user", Content: "Hi {{name}}"}})
+	v1.Version = 1
+
+	first, err := pc.Compile(ctx, v1, map[string]interface{}{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	first.Chat[0].Content = "modified"
+
+	second, _ := pc.Compile(ctx, v1, map[string]interface{}{"name": "Ada"})
+	if second.Chat[0].Content != "Hi Ada" {
+		t.Errorf("Cached result was modified by a caller: %q", second.Chat[0].Content)
+	}
+	if pc.compileCache.lru.Len() != 1 {
+		t.Errorf("Expected 1 cached compilation, got %d", pc.compileCache.lru.Len())
+	}
+
+	v2 := ChatPrompt("greet", []ChatMessage{{Role: "user", Content: "Hello {{name}}"}})
+	v2.Version = 2
+	third, _ := pc.Compile(ctx, v2, map[string]interface{}{"name": "Ada"})
+	if third.Chat[0].Content != "Hello Ada" {
+		t.Errorf("Expected the new version to be compiled, got %q", third.Chat[0].Content)
+	}
+	if pc.compileCache.lru.Len() != 1 {
+		t.Errorf("Entries of the previous version should be dropped, got %d", pc.compileCache.lru.Len())
+	}
+
+	for _, name := range []string{"Bob", "Cy", "Di"} {
+		if _, err := pc.Compile(ctx, v2, map[string]interface{}{"name": name}); err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+	}
+	if pc.compileCache.lru.Len() != 2 {
+		t.Errorf("Expected the cache to be bounded at 2, got %d", pc.compileCache.lru.Len())
+	}
+}
+
+// Test that include directives are expanded and cycles are rejected
+func TestPromptIncludes(t *testing.T) {
+	ctx := context.Background()
+	pc := NewWithConfig(ctx, Config{PublicKey: "pk", SecretKey: "sk"}).NewPromptClient()
+	latest := &promptOptions{version: -1}
+	for name, text := range map[string]string{
+		"tone":   "Be {{style}}. {{@include:policy}}",
+		"policy": "Never share secrets.",
+		"loop-a": "{{@include:loop-b}}",
+		"loop-b": "{{@include:loop-a}}",
+	} {
+		pc.cache.Set(pc.buildCacheKey(name, latest), TextPrompt(name, text))
+	}
+
+	prompt := ChatPrompt("support", []ChatMessage{
+		{Role: "system", Content: "{{@include:tone}}"},
+		{Role: "user", Content: "{{question}}"},
+	})
+	compiled, err := pc.Compile(ctx, prompt, map[string]interface{}{"style": "brief", "question": "Hi"})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got := compiled.Chat[0].Content; got != "Be brief. Never share secrets." {
+		t.Errorf("Expected includes to be expanded, got %q", got)
+	}
+	if prompt.Prompt.([]ChatMessage)[0].Content != "{{@include:tone}}" {
+		t.Error("The original prompt should not be modified")
+	}
+
+	_, err = pc.Compile(ctx, TextPrompt("entry", "{{@include:loop-a}}"), nil)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected a cycle error, got %v", err)
+	}
+}
+
+// Test that GetAndCompile compiles fetched prompts and reports compile errors distinctly
+func TestGetAndCompile(t *testing.T) {
+	ctx := context.Background()
+	pc := NewWithConfig(ctx, Config{PublicKey: "pk", SecretKey: "sk"}).NewPromptClient()
+
+	compiled, err := pc.GetAndCompile(ctx, "welcome", map[string]interface{}{"name": "Ada", "place": "Paris"})
+	if err != nil {
+		t.Fatalf("GetAndCompile: %v", err)
+	}
+	if compiled.Text != "Hello Ada, welcome to Paris!" || compiled.Name != "welcome" {
+		t.Errorf("Expected the compiled welcome prompt, got %+v", compiled)
+	}
+
+	broken := TextPrompt("broken", "{{@include:broken}}")
+	pc.cache.Set(pc.buildCacheKey("broken", &promptOptions{label: "staging"}), broken)
+	_, err = pc.GetAndCompile(ctx, "broken", nil, WithLabel("staging"))
+	if !errors.Is(err, ErrPromptCompile) || errors.Is(err, ErrPromptFetch) {
+		t.Errorf("Expected a compile error, got %v", err)
+	}
+}
+
+// Test that the prompt cache evicts the least recently used entry when full
+func TestPromptCacheLRU(t *testing.T) {
+	cache := NewPromptCache(time.Minute).WithMaxSize(2)
+	cache.Set("a", TextPrompt("a", "A"))
+	cache.Set("b", TextPrompt("b", "B"))
+
+	if cache.Get("a") == nil {
+		t.Fatal("Expected a to be cached")
+	}
+	cache.Set("c", TextPrompt("c", "C"))
+
+	if cache.Get("b") != nil {
+		t.Error("The least recently used entry should be evicted")
+	}
+	if cache.Get("a") == nil || cache.Get("c") == nil {
+		t.Error("Recently used entries should be kept")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", cache.Len())
+	}
+
+	cache.InvalidatePrefix("a")
+	cache.Set("d", TextPrompt("d", "D"))
+	if cache.Get("c") == nil || cache.Len() != 2 {
+		t.Errorf("Invalidated entries should free their slot, got %d entries", cache.Len())
+	}
+}
+
+// Test that prompt cache hits and misses are counted per prompt client and per client
+func TestPromptCacheStats(t *testing.T) {
+	ctx := context.Background()
+	l := NewWithConfig(ctx, Config{PublicKey: "pk", SecretKey: "sk", FlushInterval: time.Hour})
+	pc := l.NewPromptClient()
+	pc.cache.Set(pc.buildCacheKey("greeting", &promptOptions{version: -1}), TextPrompt("greeting", "Hello"))
+
+	for i := 0; i < 2; i++ {
+		if _, err := pc.GetPrompt(ctx, "greeting"); err != nil {
+			t.Fatalf("GetPrompt: %v", err)
+		}
+	}
+	load := func(context.Context) (*Prompt, error) { return TextPrompt("farewell", "Bye"), nil }
+	for i := 0; i < 2; i++ {
+		if _, err := pc.cache.GetOrLoad(ctx, "farewell", load); err != nil {
+			t.Fatalf("GetOrLoad: %v", err)
+		}
+	}
+
+	if hits, misses := pc.CacheStats(); hits != 3 || misses != 1 {
+		t.Errorf("Expected 3 hits and 1 miss, got %d and %d", hits, misses)
+	}
+	if stats := l.Stats(); stats.PromptCacheHits != 3 || stats.PromptCacheMisses != 1 {
+		t.Errorf("Expected the client stats to include the prompt cache, got %+v", stats)
+	}
+}
+
+// Test that concurrent misses share one load that outlives a canceled caller
+func TestPromptCacheGetOrLoad(t *testing.T) {
+	cache := NewPromptCache(time.Hour)
+	release := make(chan struct{})
+	loadCtx := make(chan context.Context, 1)
+	var loads atomic.Int32
+	load := func(ctx context.Context) (*Prompt, error) {
+		loads.Add(1)
+		loadCtx <- ctx
+		<-release
+		return TextPrompt("greeting", "Hello"), ctx.Err()
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.GetOrLoad(canceled, "greeting", load)
+		first <- err
+	}()
+	ctx := <-loadCtx
+
+	second := make(chan *Prompt, 1)
+	go func() {
+		prompt, err := cache.GetOrLoad(context.Background(), "greeting", load)
+		if err != nil {
+			t.Errorf("GetOrLoad: %v", err)
+		}
+		second <- prompt
+	}()
+	// The second caller has missed once it is counted
+	for _, misses := cache.Stats(); misses < 2; _, misses = cache.Stats() {
+		runtime.Gosched()
+	}
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the canceled caller to return its ctx error, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Expected the load to outlive the canceled caller, got %v", ctx.Err())
+	}
+	close(release)
+
+	if prompt := <-second; prompt == nil || prompt.Prompt != "Hello" {
+		t.Errorf("Expected the waiting caller to get the loaded prompt, got %+v", prompt)
+	}
+	if prompt, err := cache.GetOrLoad(context.Background(), "greeting", load); err != nil || prompt.Prompt != "Hello" {
+		t.Errorf("Expected the loaded prompt to be cached, got %+v and %v", prompt, err)
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("Expected one load, got %d", n)
+	}
+}
+
+// Test that GenerationFromPrompt records the compiled prompt as a linked generation
+func TestGenerationFromPrompt(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client()
+
+	text := TextPrompt("summarize", "Summarize {{topic}}")
+	text.Version = 3
+	text.Config = map[string]interface{}{"model": "gpt-4", "temperature": 0.2}
+	generation, err := l.GenerationFromPrompt(text, map[string]interface{}{"topic": "Go"}, nil)
+	if err != nil {
+		t.Fatalf("GenerationFromPrompt: %v", err)
+	}
+	if generation.TraceID == "" || len(recorder.Traces()) != 1 {
+		t.Errorf("Expected the generation in a new trace, got trace %q and %d traces", generation.TraceID, len(recorder.Traces()))
+	}
+
+	recorded := recorder.ObservationsNamed("summarize")
+	if len(recorded) != 1 {
+		t.Fatalf("Expected one generation, got %d", len(recorded))
+	}
+	body := recorded[0].Body.(*model.Generation)
+	if body.Input != "Summarize Go" || body.PromptName != "summarize" || body.PromptVersion != 3 || body.Model != "gpt-4" {
+		t.Errorf("Expected the compiled input linked to summarize v3 on gpt-4, got %+v", body)
+	}
+	if parameters, _ := body.ModelParameters.(map[string]interface{}); parameters["temperature"] != 0.2 {
+		t.Errorf("Expected the prompt config as model parameters, got %v", body.ModelParameters)
+	}
+
+	parentID := "span-1"
+	chat := ChatPrompt("greet", []ChatMessage{{Role: "user", Content: "Hi {{name}}"}})
+	generation, err = l.GenerationFromPrompt(chat, map[string]interface{}{"name": "Ada"}, &parentID)
+	if err != nil {
+		t.Fatalf("GenerationFromPrompt: %v", err)
+	}
+	messages, _ := generation.Input.([]ChatMessage)
+	if len(messages) != 1 || messages[0].Content != "Hi Ada" || generation.ModelParameters != nil {
+		t.Errorf("Expected the compiled messages without model parameters, got %v and %v", generation.Input, generation.ModelParameters)
+	}
+
+	if _, err := l.GenerationFromPrompt(nil, nil, nil); err == nil {
+		t.Error("Expected an error without a prompt")
+	}
+}
+
+// Test that EvaluatePrompt runs dataset items through the prompt in a run named
+// after its version, recording prompt-linked generations
+func TestEvaluatePrompt(t *testing.T) {
+	recorder := NewObserverRecorder()
+	l := recorder.Client()
+	dataset := &Dataset{ID: "dataset-1", Name: "topics", client: l}
+	_, _ = dataset.CreateItem(map[string]interface{}{"input": "Go"}, "Summary of Go", nil)
+	_, _ = dataset.CreateItem("Rust", "Summary of Rust", nil)
+	_, _ = dataset.CreateItem("Zig", nil, nil)
+
+	prompt := TextPrompt("summarize", "Summarize {{input}}")
+	prompt.Version = 3
+	runner := func(ctx context.Context, compiled *CompiledPrompt) (interface{}, error) {
+		if compiled.Text == "Summarize Zig" {
+			return nil, errors.New("rate limited")
+		}
+		return "Summary of " + strings.TrimPrefix(compiled.Text, "Summarize "), nil
+	}
+
+	result, err := l.EvaluatePrompt(context.Background(), prompt, dataset, runner, nil)
+	if err != nil {
+		t.Fatalf("EvaluatePrompt: %v", err)
+	}
+	if len(result.Items) != 3 || result.Items[0].Score != 1 || result.Items[1].Score != 1 || result.Items[2].Error == nil {
+		t.Fatalf("Expected two exact matches and a failed item, got %+v", result.Items)
+	}
+	if result.Metadata["prompt_name"] != "summarize" || result.Metadata["prompt_version"] != 3 {
+		t.Errorf("Expected the prompt version in the result metadata, got %v", result.Metadata)
+	}
+	for _, trace := range recorder.Traces() {
+		if trace.Name != "dataset-run-summarize-v3" {
+			t.Errorf("Expected traces of the run summarize-v3, got %q", trace.Name)
+		}
+	}
+
+	generations := recorder.ObservationsNamed("summarize")
+	if len(generations) != 3 {
+		t.Fatalf("Expected a generation per item, got %d", len(generations))
+	}
+	for i, recorded := range generations {
+		generation := recorded.Body.(*model.Generation)
+		if generation.PromptName != "summarize" || generation.PromptVersion != 3 || generation.ParentObservationID == "" {
+			t.Errorf("Expected generation %d linked to summarize v3 under the run span, got %+v", i, generation)
+		}
+	}
+	if failed := generations[2]; failed.Level != model.ObservationLevelError || failed.StatusMessage != "rate limited" {
+		t.Errorf("Expected the failed call recorded as an error, got level %v and status %q", failed.Level, failed.StatusMessage)
+	}
+
+	if _, err := l.EvaluatePrompt(context.Background(), prompt, dataset, nil, nil); err == nil {
+		t.Error("Expected an error without a runner")
+	}
+}
+
+// Test that AttachTo records the compiled prompt once, as input or else as metadata
+func TestCompiledPromptAttachTo(t *testing.T) {
+	chat := ChatPrompt("greet", []ChatMessage{{Role: "system", Content: "Be brief"}, {Role: "user", Content: "Hi {{name}}"}})
+	chat.Version = 2
+	compiled, err := chat.Compile(map[string]interface{}{"name": "Ada"})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	generation := &model.Generation{Metadata: map[string]interface{}{"team": "support"}}
+	compiled.AttachTo(generation)
+	messages, _ := generation.Input.([]ChatMessage)
+	if len(messages) != 2 || messages[1].Content != "Hi Ada" {
+		t.Errorf("Expected the compiled messages as input, got %v", generation.Input)
+	}
+	if metadata := generation.Metadata.(map[string]interface{}); len(metadata) != 1 {
+		t.Errorf("Expected the compiled prompt only as input, got metadata %v", metadata)
+	}
+
+	metadata := map[string]interface{}{"team": "support"}
+	generation = &model.Generation{Input: "raw question", Metadata: metadata}
+	compiled.AttachTo(generation)
+	if generation.Input != "raw question" {
+		t.Errorf("Expected the existing input to be kept, got %v", generation.Input)
+	}
+	record, _ := generation.Metadata.(map[string]interface{})[MetadataKeyCompiledPrompt].(map[string]interface{})
+	if record["name"] != "greet" || record["version"] != 2 || record["type"] != PromptTypeChat {
+		t.Errorf("Expected the prompt name, version and type in the metadata, got %v", record)
+	}
+	if recorded, _ := record["messages"].([]ChatMessage); len(recorded) != 2 || recorded[1].Content != "Hi Ada" {
+		t.Errorf("Expected the compiled messages in the metadata, got %v", record["messages"])
+	}
+	if len(metadata) != 1 {
+		t.Errorf("Expected the caller's metadata map to be left unchanged, got %v", metadata)
+	}
+
+	text := TextPrompt("summarize", "Summarize {{topic}}")
+	compiledText, _ := text.Compile(map[string]interface{}{"topic": "Go"})
+	generation = &model.Generation{Input: "Go", Metadata: "not a map"}
+	compiledText.AttachTo(generation)
+	if generation.Metadata != "not a map" {
+		t.Errorf("Expected metadata that is not map-shaped to be left unchanged, got %v", generation.Metadata)
+	}
+}
@@ -0,0 +1,136 @@
+package langfuse
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// MetadataKeyProvider holds the provider of a generation's model, which the
+// ingestion API does not support natively
+const MetadataKeyProvider = "provider"
+
+// providerPrefix maps model names starting with prefix to a provider
+type providerPrefix struct {
+	prefix   string
+	provider string
+}
+
+// defaultProviderPrefixes maps model families to their providers. Routing
+// prefixes such as "bedrock/" are checked first, so "bedrock/claude-3" is
+// served by bedrock rather than anthropic.
+var defaultProviderPrefixes = []providerPrefix{
+	{"openai/", "openai"},
+	{"azure/", "azure"},
+	{"anthropic/", "anthropic"},
+	{"bedrock/", "bedrock"},
+	{"google/", "google"},
+	{"vertex_ai/", "google"},
+	{"models/", "google"},
+	{"mistral/", "mistral"},
+	{"gpt-", "openai"},
+	{"chatgpt-", "openai"},
+	{"o1", "openai"},
+	{"o3", "openai"},
+	{"o4", "openai"},
+	{"text-embedding-", "openai"},
+	{"davinci", "openai"},
+	{"claude", "anthropic"},
+	{"gemini", "google"},
+	{"text-bison", "google"},
+	{"mistral", "mistral"},
+	{"mixtral", "mistral"},
+	{"codestral", "mistral"},
+	{"command", "cohere"},
+	{"embed-", "cohere"},
+	{"llama", "meta"},
+}
+
+// ProviderResolver derives the provider of a generation, such as "openai" or
+// "anthropic", from its model name, so generations can be grouped by provider
+// without parsing model names downstream
+type ProviderResolver struct {
+	mu       sync.RWMutex
+	prefixes []providerPrefix
+}
+
+// NewProviderResolver creates a resolver with the built-in table of OpenAI,
+// Anthropic, Google, Mistral, Cohere and Meta model names, and of routing
+// prefixes such as "azure/" and "bedrock/"
+func NewProviderResolver() *ProviderResolver {
+	return &ProviderResolver{prefixes: append([]providerPrefix(nil), defaultProviderPrefixes...)}
+}
+
+// Add maps model names starting with prefix to provider, e.g. the name of a
+// self-hosted deployment. Added prefixes take precedence over the built-in
+// table and earlier additions. Matching is case-insensitive.
+func (r *ProviderResolver) Add(prefix string, provider string) *ProviderResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added := providerPrefix{prefix: strings.ToLower(strings.TrimSpace(prefix)), provider: provider}
+	r.prefixes = append([]providerPrefix{added}, r.prefixes...)
+	return r
+}
+
+// Resolve returns the provider of a model, or "" for unknown models
+func (r *ProviderResolver) Resolve(modelName string) string {
+	name := strings.ToLower(strings.TrimSpace(modelName))
+	if name == "" {
+		return ""
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.prefixes {
+		if strings.HasPrefix(name, p.prefix) {
+			return p.provider
+		}
+	}
+	return ""
+}
+
+// WithProviderResolver records the provider resolved from the model name on
+// generations that do not set Provider. Use NewProviderResolver for the
+// built-in table; nil disables resolution. The provider is resolved before
+// WithModelNormalizer strips routing prefixes from the name.
+func (l *Langfuse) WithProviderResolver(r *ProviderResolver) *Langfuse {
+	l.providerResolver = r
+	return l
+}
+
+// ResolveProvider returns the provider this client records for a generation
+// of modelName, "" when no resolver is set or the model is unknown
+func (l *Langfuse) ResolveProvider(modelName string) string {
+	if l.providerResolver == nil {
+		return ""
+	}
+	return l.providerResolver.Resolve(modelName)
+}
+
+// withGenerationProvider returns metadata with provider stored under its
+// "provider" key, replacing any provider stored there
+func withGenerationProvider(metadata any, provider string) any {
+	if provider == "" {
+		return metadata
+	}
+
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+	extended[MetadataKeyProvider] = provider
+	return extended
+}
+
+// applyGenerationProvider resolves a missing provider from the raw model name
+// and stores it in the metadata. It must run before the model is normalized.
+func (l *Langfuse) applyGenerationProvider(g *model.Generation) {
+	if g.Provider == "" {
+		g.Provider = l.ResolveProvider(g.Model)
+	}
+	g.Metadata = withGenerationProvider(g.Metadata, g.Provider)
+}
@@ -0,0 +1,27 @@
+package langfuse
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// WithProxy sends requests to Langfuse through the HTTP proxy at proxyURL,
+// e.g. "http://proxy.corp.example:3128", without building a custom HTTP
+// client. An empty proxyURL uses the standard HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables, so hosts excluded by NO_PROXY are reached
+// directly. An invalid URL is logged and the proxy settings are left unchanged.
+func (l *Langfuse) WithProxy(proxyURL string) *Langfuse {
+	if proxyURL == "" {
+		l.client.WithProxy(http.ProxyFromEnvironment)
+		return l
+	}
+
+	parsed, err := url.Parse(proxyURL)
+	if err != nil || parsed.Host == "" {
+		log.Printf("Invalid proxy URL %q, keeping the current proxy settings", proxyURL)
+		return l
+	}
+	l.client.WithProxy(http.ProxyURL(parsed))
+	return l
+}
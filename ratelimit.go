@@ -0,0 +1,90 @@
+package langfuse
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket bounding the rate of ingestion requests.
+// Requests beyond the rate wait for a token instead of being rejected.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rps, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes a token, blocking until one is available or ctx is done
+func (r *rateLimiter) wait(ctx context.Context) error {
+	delay := r.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		return fmt.Errorf("%w: %w", ErrIngestionCanceled, ctx.Err())
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns how long to
+// wait until the token is due
+func (r *rateLimiter) reserve() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.tokens = min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// cancel returns a token reserved by a request that gave up waiting
+func (r *rateLimiter) cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens = min(r.burst, r.tokens+1)
+}
+
+// WithRateLimit caps ingestion requests at rps per second, allowing bursts of
+// up to burst requests. Requests over the limit wait for their turn, or fail
+// with ErrIngestionCanceled when their context is done first, rather than
+// being rejected by the server. The limit applies before the concurrency
+// limit of WithMaxConcurrentRequests, so waiting requests hold no slot. A
+// non-positive rps removes the limit.
+func (l *Langfuse) WithRateLimit(rps float64, burst int) *Langfuse {
+	if rps <= 0 {
+		l.rateLimit.Store(nil)
+		return l
+	}
+	l.rateLimit.Store(newRateLimiter(rps, max(burst, 1)))
+	return l
+}
+
+// waitRateLimit blocks until the rate limit, if any, allows another request
+func (l *Langfuse) waitRateLimit(ctx context.Context) error {
+	limiter := l.rateLimit.Load()
+	if limiter == nil {
+		return nil
+	}
+	return limiter.wait(ctx)
+}
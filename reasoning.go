@@ -0,0 +1,61 @@
+package langfuse
+
+import (
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+const (
+	// MetadataKeyReasoning holds the reasoning content of a generation, which
+	// the ingestion API does not support natively
+	MetadataKeyReasoning = "reasoning"
+	// usageDetailsReasoningKey is the usage details key holding reasoning tokens
+	usageDetailsReasoningKey = "output_reasoning"
+	// usageDetailsOutputKey is the usage details key holding output tokens
+	usageDetailsOutputKey = "output"
+	// usageDetailsInputKey is the usage details key holding input tokens
+	usageDetailsInputKey = "input"
+)
+
+// applyReasoning stores a generation's reasoning content in its metadata and
+// splits its reasoning tokens out of the output tokens into usage details, so
+// thinking and answer tokens are counted separately and the total is kept.
+// Usage details without an "output_reasoning" entry are built from Usage when
+// empty. The caller's maps are not modified.
+func applyReasoning(g *model.Generation) {
+	if g.Reasoning != "" {
+		if metadata, ok := copyMetadata(g.Metadata); ok {
+			metadata[MetadataKeyReasoning] = g.Reasoning
+			g.Metadata = metadata
+		}
+	}
+
+	if g.ReasoningTokens <= 0 {
+		return
+	}
+	if _, split := g.UsageDetails[usageDetailsReasoningKey]; split {
+		return
+	}
+
+	details := make(map[string]int, len(g.UsageDetails)+3)
+	for k, v := range g.UsageDetails {
+		details[k] = v
+	}
+	if len(details) == 0 {
+		if input := g.Usage.InputTokens(); input > 0 {
+			details[usageDetailsInputKey] = input
+		}
+		if output := g.Usage.OutputTokens(); output > 0 {
+			details[usageDetailsOutputKey] = output
+		}
+	}
+	// The total counts reasoning tokens once, as part of the output
+	if _, hasTotal := details[usageDetailsTotalKey]; !hasTotal {
+		details[usageDetailsTotalKey] = details[usageDetailsInputKey] + max(details[usageDetailsOutputKey], g.ReasoningTokens)
+	}
+	// Providers count reasoning tokens as output tokens
+	if output, hasOutput := details[usageDetailsOutputKey]; hasOutput {
+		details[usageDetailsOutputKey] = max(output-g.ReasoningTokens, 0)
+	}
+	details[usageDetailsReasoningKey] = g.ReasoningTokens
+	g.UsageDetails = details
+}
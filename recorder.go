@@ -0,0 +1,201 @@
+package langfuse
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// ObserverRecorder keeps the events of a client in memory instead of sending
+// them, so code instrumented with Observe can be unit tested by asserting on
+// what it recorded. Where a client that is not configured sends nothing, the
+// recorder keeps everything for inspection.
+type ObserverRecorder struct {
+	client *Langfuse
+
+	mu     sync.Mutex
+	events []model.IngestionEvent
+}
+
+// RecordedObservation is a span, generation or event with its create and
+// update events merged, as Langfuse would show it
+type RecordedObservation struct {
+	// Type is "span", "generation" or "event"
+	Type                string
+	ID                  string
+	TraceID             string
+	ParentObservationID string
+	Name                string
+	Input               any
+	Output              any
+	Metadata            any
+	Level               model.ObservationLevel
+	StatusMessage       string
+	StartTime           *time.Time
+	EndTime             *time.Time
+	// Body is the merged *model.Span, *model.Generation or *model.Event
+	Body any
+}
+
+// NewObserverRecorder creates a recorder. Pass Client() to the code under test
+// or use Observer to create observers recording into it. For deterministic
+// timestamps, set a fake clock with Client().WithClock.
+func NewObserverRecorder() *ObserverRecorder {
+	r := &ObserverRecorder{}
+	r.client = NewWithConfig(context.Background(), Config{
+		PublicKey:     "recorder",
+		SecretKey:     "recorder",
+		FlushInterval: time.Hour,
+	})
+	r.client.recorder = r
+	return r
+}
+
+// Client returns the client whose events are recorded
+func (r *ObserverRecorder) Client() *Langfuse {
+	return r.client
+}
+
+// Observer returns an observer recording into the recorder
+func (r *ObserverRecorder) Observer(opts ...ObserveOption) *Observer {
+	return NewObserver(r.client, opts...)
+}
+
+// record stores events queued by the client
+func (r *ObserverRecorder) record(events []model.IngestionEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, events...)
+}
+
+// Reset forgets the events recorded so far
+func (r *ObserverRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = nil
+}
+
+// Events returns the events recorded so far, in the order they were queued
+func (r *ObserverRecorder) Events() []model.IngestionEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]model.IngestionEvent(nil), r.events...)
+}
+
+// Traces returns the recorded traces with their upserts merged, in the order
+// they were created
+func (r *ObserverRecorder) Traces() []*model.Trace {
+	var traces []*model.Trace
+	byID := make(map[string]*model.Trace)
+	for _, event := range r.Events() {
+		trace, isTrace := event.Body.(*model.Trace)
+		if !isTrace {
+			continue
+		}
+		if merged, seen := byID[trace.ID]; seen {
+			mergeRecorded(merged, trace)
+			continue
+		}
+		copied := *trace
+		byID[trace.ID] = &copied
+		traces = append(traces, &copied)
+	}
+	return traces
+}
+
+// Observations returns the recorded spans, generations and events with their
+// updates merged, in the order they were created
+func (r *ObserverRecorder) Observations() []*RecordedObservation {
+	var observations []*RecordedObservation
+	byID := make(map[string]*RecordedObservation)
+	for _, event := range r.Events() {
+		var obsType string
+		var body any
+		switch b := event.Body.(type) {
+		case *model.Span:
+			copied := *b
+			obsType, body = "span", &copied
+		case *model.Generation:
+			copied := *b
+			obsType, body = "generation", &copied
+		case *model.Event:
+			copied := *b
+			obsType, body = "event", &copied
+		default:
+			continue
+		}
+
+		id := reflect.ValueOf(body).Elem().FieldByName("ID").String()
+		if obs, seen := byID[id]; seen {
+			mergeRecorded(obs.Body, body)
+			obs.fill()
+			continue
+		}
+		obs := &RecordedObservation{Type: obsType, Body: body}
+		obs.fill()
+		byID[id] = obs
+		observations = append(observations, obs)
+	}
+	return observations
+}
+
+// ObservationsNamed returns the recorded observations called name
+func (r *ObserverRecorder) ObservationsNamed(name string) []*RecordedObservation {
+	var named []*RecordedObservation
+	for _, obs := range r.Observations() {
+		if obs.Name == name {
+			named = append(named, obs)
+		}
+	}
+	return named
+}
+
+// Scores returns the recorded scores
+func (r *ObserverRecorder) Scores() []*model.Score {
+	var scores []*model.Score
+	for _, event := range r.Events() {
+		if score, isScore := event.Body.(*model.Score); isScore {
+			scores = append(scores, score)
+		}
+	}
+	return scores
+}
+
+// fill copies the common fields of the merged body
+func (obs *RecordedObservation) fill() {
+	switch b := obs.Body.(type) {
+	case *model.Span:
+		obs.ID, obs.TraceID, obs.ParentObservationID, obs.Name = b.ID, b.TraceID, b.ParentObservationID, b.Name
+		obs.Input, obs.Output, obs.Metadata = b.Input, b.Output, b.Metadata
+		obs.Level, obs.StatusMessage, obs.StartTime, obs.EndTime = b.Level, b.StatusMessage, b.StartTime, b.EndTime
+	case *model.Generation:
+		obs.ID, obs.TraceID, obs.ParentObservationID, obs.Name = b.ID, b.TraceID, b.ParentObservationID, b.Name
+		obs.Input, obs.Output, obs.Metadata = b.Input, b.Output, b.Metadata
+		obs.Level, obs.StatusMessage, obs.StartTime, obs.EndTime = b.Level, b.StatusMessage, b.StartTime, b.EndTime
+	case *model.Event:
+		obs.ID, obs.TraceID, obs.ParentObservationID, obs.Name = b.ID, b.TraceID, b.ParentObservationID, b.Name
+		obs.Input, obs.Output, obs.Metadata = b.Input, b.Output, b.Metadata
+		obs.Level, obs.StatusMessage, obs.StartTime = b.Level, b.StatusMessage, b.StartTime
+	}
+}
+
+// mergeRecorded sets the fields of dst that update sets, as an upsert does.
+// dst and update are pointers to structs of the same type.
+func mergeRecorded(dst any, update any) {
+	target := reflect.ValueOf(dst).Elem()
+	source := reflect.ValueOf(update).Elem()
+	if target.Type() != source.Type() {
+		return
+	}
+	for i := 0; i < source.NumField(); i++ {
+		if field := source.Field(i); !field.IsZero() && target.Field(i).CanSet() {
+			target.Field(i).Set(field)
+		}
+	}
+}
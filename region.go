@@ -0,0 +1,51 @@
+package langfuse
+
+import "strings"
+
+// Region identifies a Langfuse Cloud data region
+type Region string
+
+const (
+	RegionEU Region = "eu"
+	RegionUS Region = "us"
+)
+
+// Host returns the default Langfuse Cloud host for the region
+func (r Region) Host() string {
+	switch r {
+	case RegionUS:
+		return "https://us.cloud.langfuse.com"
+	case RegionEU:
+		return "https://cloud.langfuse.com"
+	default:
+		return ""
+	}
+}
+
+// WithRegion sends events to the Langfuse Cloud host of the given region.
+// A host set with WithHost takes precedence; LANGFUSE_HOST is used when
+// neither is set.
+func (l *Langfuse) WithRegion(r Region) *Langfuse {
+	l.region = r
+	l.client.WithBaseURL(l.baseURL())
+	return l
+}
+
+// WithHost sets the Langfuse host explicitly, overriding the region and LANGFUSE_HOST
+func (l *Langfuse) WithHost(host string) *Langfuse {
+	l.host = strings.TrimRight(host, "/")
+	l.client.WithBaseURL(l.baseURL())
+	return l
+}
+
+// baseURL resolves the host: explicit host, then region, then the default host
+// (LANGFUSE_HOST for clients created with New)
+func (l *Langfuse) baseURL() string {
+	if l.host != "" {
+		return l.host
+	}
+	if host := l.region.Host(); host != "" {
+		return host
+	}
+	return l.defaultHost
+}
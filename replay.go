@@ -0,0 +1,137 @@
+package langfuse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
+)
+
+const (
+	defaultReplayLimit    = 50
+	replayPageSize        = 50
+	observationGeneration = "GENERATION"
+)
+
+// TraceFilter selects historical traces
+type TraceFilter struct {
+	Name      string
+	UserID    string
+	SessionID string
+	Tags      []string
+	From      *time.Time
+	To        *time.Time
+	// Limit caps the number of traces replayed (defaults to 50)
+	Limit int
+}
+
+// ReplayTraces fetches traces matching filter and runs the input of each of their
+// generations through runner. The original outputs become the expected outputs of a
+// dataset, and the new outputs are recorded as a dataset run scored by exact match.
+func (l *Langfuse) ReplayTraces(ctx context.Context, filter TraceFilter, runner func(interface{}) (interface{}, error)) (*EvaluationResult, error) {
+	if runner == nil {
+		return nil, fmt.Errorf("runner is required")
+	}
+
+	traces, err := l.listTraces(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	dataset, err := l.NewDatasetClient().CreateDataset(ctx,
+		fmt.Sprintf("replay-%s", l.Now().UTC().Format("20060102-150405")),
+		"Replay of production traces",
+		map[string]interface{}{
+			"source":      "replay",
+			"trace_count": len(traces),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, summary := range traces {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		trace, err := l.getTrace(ctx, summary.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get trace %s: %w", summary.ID, err)
+		}
+
+		for _, obs := range trace.Observations {
+			if obs.Type != observationGeneration || obs.Input == nil {
+				continue
+			}
+
+			item, err := dataset.CreateItem(obs.Input, obs.Output, map[string]interface{}{
+				"model":            obs.Model,
+				"observation_name": obs.Name,
+			})
+			if err != nil {
+				return nil, err
+			}
+			item.SourceTraceID = trace.ID
+			item.SourceSpanID = obs.ID
+		}
+	}
+
+	return NewDatasetEvaluator(dataset, exactMatch).Evaluate(ctx, runner)
+}
+
+// listTraces pages through the traces matching filter up to its limit
+func (l *Langfuse) listTraces(ctx context.Context, filter TraceFilter) ([]api.TraceSummary, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultReplayLimit
+	}
+
+	params := &api.ListTracesParams{
+		Limit:         replayPageSize,
+		Name:          filter.Name,
+		UserID:        filter.UserID,
+		SessionID:     filter.SessionID,
+		Tags:          filter.Tags,
+		FromTimestamp: filter.From,
+		ToTimestamp:   filter.To,
+	}
+
+	traces := make([]api.TraceSummary, 0, limit)
+	for page := 1; len(traces) < limit; page++ {
+		params.Page = page
+
+		res := &api.ListTracesResponse{}
+		if err := l.client.ListTraces(ctx, params, res); err != nil {
+			return nil, fmt.Errorf("failed to list traces: %w", err)
+		}
+
+		traces = append(traces, res.Data...)
+		if len(res.Data) == 0 || page >= res.Meta.TotalPages {
+			break
+		}
+	}
+
+	if len(traces) > limit {
+		traces = traces[:limit]
+	}
+	return traces, nil
+}
+
+// exactMatch scores 1 when the outputs have the same JSON encoding and 0 otherwise
+func exactMatch(input interface{}, expectedOutput interface{}, actualOutput interface{}) (float64, error) {
+	expected, err := json.Marshal(expectedOutput)
+	if err != nil {
+		return 0, err
+	}
+	actual, err := json.Marshal(actualOutput)
+	if err != nil {
+		return 0, err
+	}
+	if string(expected) == string(actual) {
+		return 1, nil
+	}
+	return 0, nil
+}
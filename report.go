@@ -0,0 +1,131 @@
+package langfuse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultPassThreshold is the average score an evaluation needs to pass unless
+// WithThreshold sets another one
+const DefaultPassThreshold = 0.5
+
+// WithThreshold sets the average score Passed and Report require
+func (r *EvaluationResult) WithThreshold(threshold float64) *EvaluationResult {
+	r.threshold = &threshold
+	return r
+}
+
+// Threshold returns the average score the evaluation needs to pass
+func (r *EvaluationResult) Threshold() float64 {
+	if r.threshold == nil {
+		return DefaultPassThreshold
+	}
+	return *r.threshold
+}
+
+// ErrorCount returns the number of items whose runner failed
+func (r *EvaluationResult) ErrorCount() int {
+	count := 0
+	for _, item := range r.Items {
+		if item.Error != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// Passed reports whether no item failed and the average score reaches the
+// threshold. Items skipped by a resumed evaluation are not counted.
+func (r *EvaluationResult) Passed() bool {
+	return r.ErrorCount() == 0 && r.Scores["average"] >= r.Threshold()
+}
+
+// exportedItem is an item result with its error as a message
+type exportedItem struct {
+	*ItemResult
+	Error string `json:"error,omitempty"`
+}
+
+// ExportJSON writes the result as indented JSON, adding the error count, the
+// threshold and whether the evaluation passed. Item errors are written as messages.
+func (r *EvaluationResult) ExportJSON(w io.Writer) error {
+	items := make([]exportedItem, len(r.Items))
+	for i, item := range r.Items {
+		items[i] = exportedItem{ItemResult: item}
+		if item.Error != nil {
+			items[i].Error = item.Error.Error()
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		*EvaluationResult
+		Items      []exportedItem `json:"items"`
+		ErrorCount int            `json:"errorCount"`
+		Threshold  float64        `json:"threshold"`
+		Passed     bool           `json:"passed"`
+	}{
+		EvaluationResult: r,
+		Items:            items,
+		ErrorCount:       r.ErrorCount(),
+		Threshold:        r.Threshold(),
+		Passed:           r.Passed(),
+	})
+}
+
+// Report returns a plain-text summary for logs and CI output: the verdict,
+// aggregate scores, and each item's score, status and trace ID.
+func (r *EvaluationResult) Report() string {
+	var b strings.Builder
+
+	verdict := "FAILED"
+	if r.Passed() {
+		verdict = "PASSED"
+	}
+	fmt.Fprintf(&b, "Evaluation of dataset %s: %s\n", r.DatasetName, verdict)
+
+	scored, skipped := 0, 0
+	low, high := 0.0, 0.0
+	for _, item := range r.Items {
+		if item.Skipped {
+			skipped++
+			continue
+		}
+		if scored == 0 || item.Score < low {
+			low = item.Score
+		}
+		if scored == 0 || item.Score > high {
+			high = item.Score
+		}
+		scored++
+	}
+	fmt.Fprintf(&b, "Items: %d run, %d errors, %d skipped\n", scored, r.ErrorCount(), skipped)
+	fmt.Fprintf(&b, "Scores: average %.3f, min %.3f, max %.3f (threshold %.3f)\n", r.Scores["average"], low, high, r.Threshold())
+	fmt.Fprintf(&b, "Duration: %s\n\n", r.EndedAt.Sub(r.StartedAt).Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ITEM\tSCORE\tSTATUS\tTRACE")
+	for _, item := range r.Items {
+		score := fmt.Sprintf("%.3f", item.Score)
+		var status string
+		switch {
+		case item.Skipped:
+			score, status = "-", "skipped"
+		case item.Error != nil:
+			status = "error: " + strings.ReplaceAll(item.Error.Error(), "\n", " ")
+		case item.Score >= r.Threshold():
+			status = "pass"
+		default:
+			status = "fail"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", item.ItemID, score, status, item.TraceID)
+	}
+	_ = tw.Flush()
+
+	return b.String()
+}
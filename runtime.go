@@ -0,0 +1,73 @@
+package langfuse
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+// metadataKeyRuntime holds the Go runtime context recorded with WithRuntimeMetadata
+const metadataKeyRuntime = "runtime"
+
+// WithRuntimeMetadata records the Go runtime context of the goroutine creating
+// each trace under the "runtime" metadata key: its goroutine ID, GOMAXPROCS
+// and the number of goroutines. It helps diagnose parallel workflows. Reading
+// the goroutine ID costs a few microseconds per trace, so it is off by default.
+func (l *Langfuse) WithRuntimeMetadata(enabled bool) *Langfuse {
+	l.runtimeMetadata = enabled
+	return l
+}
+
+// WithRuntimeMemStats adds heap and GC statistics to the runtime metadata of
+// WithRuntimeMetadata. Reading them briefly stops the world, so enable it only
+// while debugging.
+func (l *Langfuse) WithRuntimeMemStats(enabled bool) *Langfuse {
+	l.runtimeMemStats = enabled
+	return l
+}
+
+// withRuntimeMetadata returns metadata with the runtime context of the calling
+// goroutine stored under its "runtime" key
+func (l *Langfuse) withRuntimeMetadata(metadata any) any {
+	if !l.runtimeMetadata {
+		return metadata
+	}
+
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+
+	info := map[string]interface{}{
+		"goroutine_id":  goroutineID(),
+		"gomaxprocs":    runtime.GOMAXPROCS(0),
+		"num_goroutine": runtime.NumGoroutine(),
+		"go_version":    runtime.Version(),
+	}
+	if l.runtimeMemStats {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		info["heap_alloc_bytes"] = stats.HeapAlloc
+		info["sys_bytes"] = stats.Sys
+		info["num_gc"] = stats.NumGC
+	}
+	extended[metadataKeyRuntime] = info
+	return extended
+}
+
+// goroutineID parses the ID of the calling goroutine from its stack header,
+// "goroutine 42 [running]:". It returns 0 if the header cannot be parsed.
+func goroutineID() uint64 {
+	var buf [64]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if i := bytes.IndexByte(header, ' '); i >= 0 {
+		header = header[:i]
+	}
+	id, err := strconv.ParseUint(string(header), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
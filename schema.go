@@ -0,0 +1,79 @@
+package langfuse
+
+import (
+	"context"
+	"log"
+
+	"github.com/paulnegz/langfuse-go/internal/pkg/api"
+)
+
+// SchemaVersion is the version of the payload and metadata shapes this SDK
+// writes. It is sent in the metadata of every ingestion batch and stamped into
+// the metadata of each trace the client creates, so readers can tell older data
+// apart.
+const SchemaVersion = 1
+
+// metadataKeySchemaVersion holds the schema version of a trace. The leading
+// underscore marks it as internal; fetched traces have it removed.
+const metadataKeySchemaVersion = "_sdk_schema_version"
+
+// schemaMigrations upgrades fetched traces written with an older schema. The
+// function at index v converts a trace from version v to v+1. Version 0, data
+// written before versioning, needs no conversion.
+var schemaMigrations = []func(*api.TraceDetails){
+	0: func(*api.TraceDetails) {},
+}
+
+// withSchemaVersion returns metadata stamped with SchemaVersion when the client
+// first sends the trace. Later updates of the trace are not stamped, as the
+// stored metadata keeps the key.
+func (l *Langfuse) withSchemaVersion(traceID string, metadata any) any {
+	if _, stamped := l.schemaStamped.get(traceID); stamped {
+		return metadata
+	}
+	l.schemaStamped.store(traceID, true)
+
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+	extended[metadataKeySchemaVersion] = SchemaVersion
+	return extended
+}
+
+// getTrace fetches a trace and upgrades it to the current schema
+func (l *Langfuse) getTrace(ctx context.Context, traceID string) (*api.TraceDetails, error) {
+	trace := &api.TraceDetails{}
+	if err := l.client.GetTrace(ctx, traceID, trace); err != nil {
+		return nil, err
+	}
+	upgradeTrace(trace)
+	return trace, nil
+}
+
+// upgradeTrace removes the schema version from a fetched trace's metadata and
+// applies the migrations from that version to SchemaVersion. Traces written by a
+// newer SDK are left as they are.
+func upgradeTrace(trace *api.TraceDetails) {
+	version := 0
+	if metadata, isMap := trace.Metadata.(map[string]interface{}); isMap {
+		// JSON numbers decode as float64
+		if stamped, found := metadata[metadataKeySchemaVersion].(float64); found {
+			version = int(stamped)
+		}
+		delete(metadata, metadataKeySchemaVersion)
+		if len(metadata) == 0 {
+			trace.Metadata = nil
+		}
+	}
+	trace.SchemaVersion = version
+
+	if version > SchemaVersion {
+		log.Printf("Trace %s has schema version %d, newer than %d; reading it unchanged", trace.ID, version, SchemaVersion)
+		return
+	}
+	for v := version; v < SchemaVersion && v < len(schemaMigrations); v++ {
+		schemaMigrations[v](trace)
+	}
+}
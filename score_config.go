@@ -0,0 +1,125 @@
+package langfuse
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// ScoreConfig describes the scores recorded under a name: their data type
+// and, for numeric scores, their range
+type ScoreConfig struct {
+	Name     string
+	DataType model.ScoreDataType
+	// ID is the Langfuse score config linked to matching scores, if any
+	ID string
+	// Min and Max bound numeric values when set
+	Min *float64
+	Max *float64
+	// Clamp moves out-of-range values to the nearest bound instead of rejecting them
+	Clamp bool
+}
+
+// WithScoreConfigs checks scores against the config with their name. Scores of
+// another data type are coerced where the intent is clear, such as a boolean
+// or "true" for a numeric score, and rejected otherwise; numeric values
+// outside the configured range are clamped or rejected.
+func (l *Langfuse) WithScoreConfigs(configs ...ScoreConfig) *Langfuse {
+	l.scoreConfigs = make(map[string]ScoreConfig, len(configs))
+	for _, config := range configs {
+		l.scoreConfigs[config.Name] = config
+	}
+	return l
+}
+
+// coerceScore converts the value of s to the data type its config expects, or
+// the type it declares, and checks the value. A score with a string value and
+// no data type is categorical.
+func (l *Langfuse) coerceScore(s *model.Score) error {
+	submitted := s.DataType
+	if submitted == "" {
+		submitted = model.ScoreDataTypeNumeric
+		if s.StringValue != "" {
+			submitted = model.ScoreDataTypeCategorical
+		}
+	}
+
+	target := submitted
+	config, configured := l.scoreConfigs[s.Name]
+	if configured && config.DataType != "" {
+		target = config.DataType
+	}
+
+	switch target {
+	case model.ScoreDataTypeNumeric:
+		if submitted == model.ScoreDataTypeCategorical {
+			value, err := strconv.ParseBool(s.StringValue)
+			if err != nil {
+				return fmt.Errorf("score %q is numeric but has categorical value %q", s.Name, s.StringValue)
+			}
+			s.Value, s.StringValue = boolValue(value), ""
+		}
+		if configured {
+			if err := checkScoreRange(s, config); err != nil {
+				return err
+			}
+		}
+	case model.ScoreDataTypeBoolean:
+		if s.StringValue != "" {
+			value, err := strconv.ParseBool(s.StringValue)
+			if err != nil {
+				return fmt.Errorf("score %q is boolean but has value %q", s.Name, s.StringValue)
+			}
+			s.Value, s.StringValue = boolValue(value), ""
+		}
+		if s.Value != 0 && s.Value != 1 {
+			return fmt.Errorf("score %q is boolean but has value %v; use 0 or 1", s.Name, s.Value)
+		}
+	case model.ScoreDataTypeCategorical:
+		if submitted != model.ScoreDataTypeCategorical {
+			return fmt.Errorf("score %q is categorical but has %s value %v", s.Name, submitted, s.Value)
+		}
+		if s.StringValue == "" {
+			return fmt.Errorf("score %q is categorical but has no string value", s.Name)
+		}
+	default:
+		return fmt.Errorf("score %q has unknown data type %q", s.Name, target)
+	}
+
+	// Numeric scores that did not ask for a data type are sent as they were
+	if s.DataType != "" || configured || target != model.ScoreDataTypeNumeric {
+		s.DataType = target
+	}
+	if configured && s.ConfigID == "" {
+		s.ConfigID = config.ID
+	}
+	return nil
+}
+
+// checkScoreRange clamps or rejects a numeric value outside the configured range
+func checkScoreRange(s *model.Score, config ScoreConfig) error {
+	low, high := math.Inf(-1), math.Inf(1)
+	if config.Min != nil {
+		low = *config.Min
+	}
+	if config.Max != nil {
+		high = *config.Max
+	}
+	if s.Value >= low && s.Value <= high {
+		return nil
+	}
+	if !config.Clamp {
+		return fmt.Errorf("score %q has value %v outside its range [%v, %v]", s.Name, s.Value, low, high)
+	}
+	s.Value = math.Max(low, math.Min(high, s.Value))
+	return nil
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
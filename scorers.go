@@ -0,0 +1,164 @@
+package langfuse
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// EvaluationScoreName is the name of the score recorded for the evaluator
+// passed to NewDatasetEvaluator
+const EvaluationScoreName = "evaluation"
+
+// ScorerFunc scores the output of a dataset item run
+type ScorerFunc func(input interface{}, expectedOutput interface{}, actualOutput interface{}) (float64, error)
+
+type namedScorer struct {
+	name  string
+	score ScorerFunc
+}
+
+type weightedScore struct {
+	name    string
+	weights map[string]float64
+}
+
+// WithScorer adds a scorer whose result is recorded on each run as a score
+// named name, next to the evaluator's "evaluation" score, and kept in the
+// item's Scores. The result's Scores hold its average over the items scored.
+// Scorers do not run for items whose runner failed.
+func (de *DatasetEvaluator) WithScorer(name string, scorer ScorerFunc) *DatasetEvaluator {
+	de.scorers = append(de.scorers, namedScorer{name: name, score: scorer})
+	return de
+}
+
+// WithWeightedScore records resultName on each run as the weighted average of
+// the scores named in weights, added with WithScorer or "evaluation" for the
+// evaluator, e.g. the single headline metric a release is gated on. Weights
+// need not sum to one: scores missing for an item, because their scorer failed,
+// are left out and the remaining weights renormalized, and an item with none of
+// them gets no weighted score. Evaluate fails when a weight is negative or not
+// finite, names an unknown score, or all weights are zero.
+func (de *DatasetEvaluator) WithWeightedScore(weights map[string]float64, resultName string) *DatasetEvaluator {
+	copied := make(map[string]float64, len(weights))
+	for name, weight := range weights {
+		copied[name] = weight
+	}
+	de.weighted = append(de.weighted, weightedScore{name: resultName, weights: copied})
+	return de
+}
+
+// validateScorers checks the weighted scores before an evaluation starts
+func (de *DatasetEvaluator) validateScorers() error {
+	known := make(map[string]bool, len(de.scorers)+1)
+	if de.evaluator != nil {
+		known[EvaluationScoreName] = true
+	}
+	for _, scorer := range de.scorers {
+		known[scorer.name] = true
+	}
+
+	for _, ws := range de.weighted {
+		if ws.name == "" {
+			return fmt.Errorf("weighted score has no name")
+		}
+		total := 0.0
+		for _, name := range sortedWeightNames(ws.weights) {
+			weight := ws.weights[name]
+			if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+				return fmt.Errorf("weighted score %s: invalid weight %v for %s", ws.name, weight, name)
+			}
+			if !known[name] {
+				return fmt.Errorf("weighted score %s: unknown score %s", ws.name, name)
+			}
+			total += weight
+		}
+		if total == 0 {
+			return fmt.Errorf("weighted score %s: weights sum to zero", ws.name)
+		}
+	}
+	return nil
+}
+
+// scoreItem runs the named scorers and weighted scores for an item, records
+// them on the run, and returns every score the item got. evaluation is the
+// evaluator's score, nil when it did not produce one.
+func (de *DatasetEvaluator) scoreItem(rc *RunContext, item *DatasetItem, output interface{}, runErr error, evaluation *float64) map[string]float64 {
+	if len(de.scorers) == 0 && len(de.weighted) == 0 {
+		return nil
+	}
+
+	scores := make(map[string]float64, len(de.scorers)+len(de.weighted)+1)
+	if evaluation != nil {
+		scores[EvaluationScoreName] = *evaluation
+	}
+	if runErr == nil {
+		for _, scorer := range de.scorers {
+			value, err := scorer.score(item.Input, item.ExpectedOutput, output)
+			if err != nil {
+				log.Printf("Scorer %s error: %v", scorer.name, err)
+				continue
+			}
+			scores[scorer.name] = value
+			if scoreErr := rc.Score(scorer.name, value, ""); scoreErr != nil {
+				log.Printf("Failed to record score: %v", scoreErr)
+			}
+		}
+	}
+
+	for _, ws := range de.weighted {
+		weighted, totalWeight := 0.0, 0.0
+		for name, weight := range ws.weights {
+			if value, found := scores[name]; found {
+				weighted += weight * value
+				totalWeight += weight
+			}
+		}
+		if totalWeight == 0 {
+			continue
+		}
+		scores[ws.name] = weighted / totalWeight
+		if scoreErr := rc.Score(ws.name, scores[ws.name], ""); scoreErr != nil {
+			log.Printf("Failed to record score: %v", scoreErr)
+		}
+	}
+
+	return scores
+}
+
+// scoreAverages accumulates the named scores of the items run
+type scoreAverages struct {
+	sums   map[string]float64
+	counts map[string]int
+}
+
+func (a *scoreAverages) add(scores map[string]float64) {
+	if a.sums == nil {
+		a.sums = make(map[string]float64)
+		a.counts = make(map[string]int)
+	}
+	for name, value := range scores {
+		if name == EvaluationScoreName {
+			continue
+		}
+		a.sums[name] += value
+		a.counts[name]++
+	}
+}
+
+// apply stores the average of each named score in scores
+func (a *scoreAverages) apply(scores map[string]float64) {
+	for name, sum := range a.sums {
+		scores[name] = sum / float64(a.counts[name])
+	}
+}
+
+func sortedWeightNames(weights map[string]float64) []string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,75 @@
+package langfuse
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultSessionWindow is the idle time after which SessionFor starts a new session
+const defaultSessionWindow = 30 * time.Minute
+
+// sessionWindows tracks the current session of each user
+type sessionWindows struct {
+	mu       sync.Mutex
+	idle     time.Duration
+	sessions map[string]*userSession
+	sweepAt  time.Time
+}
+
+// userSession is a user's current session and the time of their last activity
+type userSession struct {
+	id           string
+	lastActivity time.Time
+}
+
+// sessionFor returns the user's session at now, starting a new one after idle time
+func (w *sessionWindows) sessionFor(userID string, now time.Time) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	idle := w.idle
+	if idle <= 0 {
+		idle = defaultSessionWindow
+	}
+	if w.sessions == nil {
+		w.sessions = make(map[string]*userSession)
+	}
+
+	// Forget expired sessions at most once per window so the map stays bounded
+	if now.After(w.sweepAt) {
+		for id, session := range w.sessions {
+			if now.Sub(session.lastActivity) > idle {
+				delete(w.sessions, id)
+			}
+		}
+		w.sweepAt = now.Add(idle)
+	}
+
+	session, exists := w.sessions[userID]
+	if !exists || now.Sub(session.lastActivity) > idle {
+		session = &userSession{id: uuid.New().String()}
+		w.sessions[userID] = session
+	}
+	session.lastActivity = now
+	return session.id
+}
+
+// WithSessionWindow sets the idle timeout after which SessionFor starts a new
+// session for a user (defaults to 30 minutes)
+func (l *Langfuse) WithSessionWindow(idle time.Duration) *Langfuse {
+	l.sessions.mu.Lock()
+	defer l.sessions.mu.Unlock()
+
+	l.sessions.idle = idle
+	return l
+}
+
+// SessionFor returns the session ID to use for activity of userID now. Activity
+// within the session window of the user's previous activity continues the same
+// session; after a longer idle period a new session is started. This groups
+// continuous activity of always-on agents into conversations.
+func (l *Langfuse) SessionFor(userID string) string {
+	return l.sessions.sessionFor(userID, l.clock.Now())
+}
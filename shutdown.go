@@ -0,0 +1,82 @@
+package langfuse
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// signalFlushTimeout bounds the flush run when a signal is received
+const signalFlushTimeout = 5 * time.Second
+
+// signalFlusher flushes the client when one of the watched signals arrives
+type signalFlusher struct {
+	mu   sync.Mutex
+	stop func()
+}
+
+// replace stops the current handler, if any, and installs stop as the new one
+func (s *signalFlusher) replace(stop func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		s.stop()
+	}
+	s.stop = stop
+}
+
+// WithFlushOnSignal flushes pending events when the process receives one of the
+// given signals (SIGINT and SIGTERM if none are given). After flushing, the
+// handler stops listening and re-raises the signal, so the process exits as it
+// would have without the handler; programs that handle the signal themselves
+// receive it a second time. Signal handling is opt-in and is removed by Shutdown.
+func (l *Langfuse) WithFlushOnSignal(signals ...os.Signal) *Langfuse {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	received := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(received, signals...)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			signal.Stop(received)
+			close(done)
+		})
+	}
+	l.signals.replace(stop)
+
+	go func() {
+		select {
+		case sig := <-received:
+			ctx, cancel := context.WithTimeout(context.Background(), signalFlushTimeout)
+			defer cancel()
+
+			if err := l.FlushSync(ctx); err != nil {
+				log.Printf("Failed to flush on %v: %v", sig, err)
+			}
+			stop()
+			if process, err := os.FindProcess(os.Getpid()); err == nil {
+				_ = process.Signal(sig)
+			}
+		case <-done:
+		}
+	}()
+
+	return l
+}
+
+// Shutdown removes the handler installed by WithFlushOnSignal and sends all
+// pending events. Call it, typically deferred in main, before the process exits;
+// the client does not rely on finalizers to flush.
+func (l *Langfuse) Shutdown(ctx context.Context) error {
+	l.signals.replace(nil)
+	return l.FlushSync(ctx)
+}
@@ -0,0 +1,182 @@
+package langfuse
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// maxOpenSpans bounds the spans remembered for SpanEndWith; the oldest are
+// forgotten first, as spans that are never ended would otherwise accumulate
+const maxOpenSpans = 10000
+
+// openSpans remembers the trace and start metadata of spans not yet ended
+type openSpans struct {
+	mu    sync.Mutex
+	spans map[string]openSpan
+	order []string
+}
+
+type openSpan struct {
+	traceID  string
+	name     string
+	metadata any
+}
+
+func (o *openSpans) add(id string, span openSpan) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.spans == nil {
+		o.spans = make(map[string]openSpan)
+	}
+	if _, exists := o.spans[id]; !exists {
+		o.order = append(o.order, id)
+	}
+	o.spans[id] = span
+
+	for len(o.spans) > maxOpenSpans {
+		oldest := o.order[0]
+		o.order = o.order[1:]
+		delete(o.spans, oldest)
+	}
+	// Drop IDs of ended spans once they dominate the order
+	if len(o.order) > 2*len(o.spans)+maxOpenSpans/10 {
+		order := make([]string, 0, len(o.spans))
+		for _, id := range o.order {
+			if _, open := o.spans[id]; open {
+				order = append(order, id)
+			}
+		}
+		o.order = order
+	}
+}
+
+func (o *openSpans) get(id string) (openSpan, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	span, exists := o.spans[id]
+	return span, exists
+}
+
+func (o *openSpans) remove(id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.spans, id)
+}
+
+// EndOption configures SpanEndWith
+type EndOption func(*endOptions)
+
+type endOptions struct {
+	metadata map[string]interface{}
+	level    model.ObservationLevel
+	err      error
+	traceID  string
+	comment  string
+}
+
+// WithEndMetadata adds metadata to the span, merged over the metadata it was started with
+func WithEndMetadata(metadata map[string]interface{}) EndOption {
+	return func(o *endOptions) {
+		if o.metadata == nil {
+			o.metadata = make(map[string]interface{}, len(metadata))
+		}
+		for k, v := range metadata {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// WithEndLevel sets the level of the span
+func WithEndLevel(level model.ObservationLevel) EndOption {
+	return func(o *endOptions) {
+		o.level = level
+	}
+}
+
+// WithEndError records err as the status message and under the error metadata
+// key. The level becomes ERROR unless WithEndLevel sets another one. A nil error
+// is ignored.
+func WithEndError(err error) EndOption {
+	return func(o *endOptions) {
+		o.err = err
+	}
+}
+
+// WithEndComment records a human-readable note on the span, e.g. "fell back
+// to secondary model", separate from any error status message
+func WithEndComment(comment string) EndOption {
+	return func(o *endOptions) {
+		o.comment = comment
+	}
+}
+
+// WithEndTraceID sets the trace of a span that was not started by this client
+func WithEndTraceID(traceID string) EndOption {
+	return func(o *endOptions) {
+		o.traceID = traceID
+	}
+}
+
+// SpanEndWith ends the span with the given ID at the current time. Unlike SpanEnd,
+// which replaces the span's fields, it keeps the trace and the metadata the span
+// was started with and merges the options into them.
+func (l *Langfuse) SpanEndWith(id string, output interface{}, opts ...EndOption) (*model.Span, error) {
+	var options endOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	started, isOpen := l.openSpans.get(id)
+	traceID := options.traceID
+	if traceID == "" {
+		traceID = started.traceID
+	}
+	if traceID == "" {
+		return nil, fmt.Errorf("span %s was not started by this client; set its trace with WithEndTraceID", id)
+	}
+
+	var metadata any = options.metadata
+	if isOpen && started.metadata != nil {
+		merged, ok := copyMetadata(started.metadata)
+		if !ok {
+			// Metadata of another shape cannot be merged into
+			return nil, fmt.Errorf("span %s: start metadata of type %T cannot be merged", id, started.metadata)
+		}
+		for k, v := range options.metadata {
+			merged[k] = v
+		}
+		metadata = merged
+	}
+
+	level := options.level
+	var statusMessage string
+	if options.err != nil {
+		extended, ok := copyMetadata(metadata)
+		if ok {
+			extended["error"] = options.err.Error()
+			metadata = extended
+		}
+		statusMessage = options.err.Error()
+		if level == "" {
+			level = model.ObservationLevelError
+		}
+	}
+
+	endTime := l.clock.Now()
+	return l.SpanEnd(&model.Span{
+		ID:            id,
+		TraceID:       traceID,
+		Name:          started.name,
+		EndTime:       &endTime,
+		Output:        output,
+		Metadata:      metadata,
+		Level:         level,
+		StatusMessage: statusMessage,
+		Comment:       options.comment,
+	})
+}
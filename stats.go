@@ -0,0 +1,69 @@
+package langfuse
+
+import "sync/atomic"
+
+// Stats is a snapshot of the client's ingestion counters. Counters are
+// cumulative since the client was created; QueueDepth, RequestsInFlight and
+// UploadsInFlight are current values.
+type Stats struct {
+	// EventsEnqueued counts events queued for sending, including buffered
+	// events once their trace is released
+	EventsEnqueued int64
+	// EventsSent counts events accepted by the server
+	EventsSent int64
+	// EventsFailed counts events rejected by the server or whose batch could not be sent
+	EventsFailed int64
+	// EventsSampledOut counts events discarded by WithSampleRate or the tail sampling predicate
+	EventsSampledOut int64
+	// EventsFiltered counts observations dropped by WithMinLevel
+	EventsFiltered int64
+	// EventsDropped counts events the server rejected as too large even when
+	// sent alone; they are also counted as failed
+	EventsDropped int64
+	// Batches counts ingestion requests made, successful or not
+	Batches int64
+	// QueueDepth is the number of events waiting for the next flush
+	QueueDepth int
+	// RequestsInFlight is the number of ingestion requests being sent
+	RequestsInFlight int
+	// UploadsInFlight is the number of media uploads queued or being sent
+	UploadsInFlight int64
+	// PromptCacheHits and PromptCacheMisses count prompt lookups of all the
+	// client's prompt clients answered from and missing their caches
+	PromptCacheHits   uint64
+	PromptCacheMisses uint64
+}
+
+// clientMetrics holds the counters behind Stats
+type clientMetrics struct {
+	eventsEnqueued   atomic.Int64
+	eventsSent       atomic.Int64
+	eventsFailed     atomic.Int64
+	eventsSampledOut atomic.Int64
+	eventsFiltered   atomic.Int64
+	eventsDropped    atomic.Int64
+	batches          atomic.Int64
+	uploadsInFlight  atomic.Int64
+	promptCache      cacheCounters
+}
+
+// Stats returns a snapshot of the client's ingestion counters, e.g. to export
+// them as metrics and alert on a rising failure rate. It is cheap enough to call
+// on every scrape and complements WithErrorHandler, which reports each failure.
+func (l *Langfuse) Stats() Stats {
+	return Stats{
+		EventsEnqueued:   l.metrics.eventsEnqueued.Load(),
+		EventsSent:       l.metrics.eventsSent.Load(),
+		EventsFailed:     l.metrics.eventsFailed.Load(),
+		EventsSampledOut: l.metrics.eventsSampledOut.Load(),
+		EventsFiltered:   l.metrics.eventsFiltered.Load(),
+		EventsDropped:    l.metrics.eventsDropped.Load(),
+		Batches:          l.metrics.batches.Load(),
+		QueueDepth:       l.observer.Len(),
+		RequestsInFlight: l.limiter.inFlight(),
+		UploadsInFlight:  l.metrics.uploadsInFlight.Load(),
+
+		PromptCacheHits:   l.metrics.promptCache.hits.Load(),
+		PromptCacheMisses: l.metrics.promptCache.misses.Load(),
+	}
+}
@@ -0,0 +1,46 @@
+package langfuse
+
+import (
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+const (
+	metadataKeyTimeToFirstToken = "time_to_first_token_ms"
+	metadataKeyTokensPerSecond  = "completion_tokens_per_second"
+)
+
+// applyStreamingMetrics derives the time to first token and completion throughput
+// of a generation from its timestamps and usage, and records them in the metadata
+func applyStreamingMetrics(g *model.Generation) {
+	if g.TimeToFirstToken == 0 && g.StartTime != nil && g.CompletionStartTime != nil {
+		g.TimeToFirstToken = g.CompletionStartTime.Sub(*g.StartTime)
+	}
+	if g.CompletionStartTime == nil && g.StartTime != nil && g.TimeToFirstToken > 0 {
+		completionStart := g.StartTime.Add(g.TimeToFirstToken)
+		g.CompletionStartTime = &completionStart
+	}
+
+	if g.CompletionTokensPerSecond == 0 && g.CompletionStartTime != nil && g.EndTime != nil {
+		outputTokens := g.Usage.OutputTokens()
+		if elapsed := g.EndTime.Sub(*g.CompletionStartTime).Seconds(); outputTokens > 0 && elapsed > 0 {
+			g.CompletionTokensPerSecond = float64(outputTokens) / elapsed
+		}
+	}
+
+	if g.TimeToFirstToken == 0 && g.CompletionTokensPerSecond == 0 {
+		return
+	}
+
+	metadata, ok := copyMetadata(g.Metadata)
+	if !ok {
+		return
+	}
+
+	if g.TimeToFirstToken > 0 {
+		metadata[metadataKeyTimeToFirstToken] = g.TimeToFirstToken.Milliseconds()
+	}
+	if g.CompletionTokensPerSecond > 0 {
+		metadata[metadataKeyTokensPerSecond] = g.CompletionTokensPerSecond
+	}
+	g.Metadata = metadata
+}
@@ -0,0 +1,60 @@
+package langfuse
+
+import "github.com/paulnegz/langfuse-go/model"
+
+// metadataKeyTags holds observation tags, which the ingestion API does not support natively
+const metadataKeyTags = "tags"
+
+// copyMetadata returns a copy of map-shaped metadata that can be extended.
+// It reports false for metadata of another shape.
+func copyMetadata(existing any) (map[string]interface{}, bool) {
+	metadata := make(map[string]interface{})
+	switch existing := existing.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range existing {
+			metadata[k] = v
+		}
+	case model.M:
+		for k, v := range existing {
+			metadata[k] = v
+		}
+	default:
+		return nil, false
+	}
+	return metadata, true
+}
+
+// withObservationTags returns metadata with tags merged into its "tags" key,
+// keeping any tags already stored there and skipping empty and duplicate tags
+// like AddTags
+func withObservationTags(metadata any, tags []string) any {
+	if len(tags) == 0 {
+		return metadata
+	}
+
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+
+	var merged []string
+	switch existing := extended[metadataKeyTags].(type) {
+	case []string:
+		merged = append(merged, existing...)
+	case []interface{}:
+		for _, tag := range existing {
+			if s, isString := tag.(string); isString {
+				merged = append(merged, s)
+			}
+		}
+	}
+	merged = model.AppendTags(merged, tags...)
+	if len(merged) == 0 {
+		return metadata
+	}
+
+	extended[metadataKeyTags] = merged
+	return extended
+}
@@ -0,0 +1,50 @@
+package langfuse
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// TestEnvironment is the environment, and the tag, of traces recorded in test mode
+const TestEnvironment = "test"
+
+// WithTestMode records traces, observations and scores in the "test"
+// environment and tags traces "test", so test and CI runs do not mix with
+// production data. It takes precedence over WithEnvironment; environments set
+// on a call or an event are kept. Without WithTestMode, test mode is detected:
+// it is on in binaries built by go test, as reported by testing.Testing(), for
+// clients without an environment. WithTestMode(false) turns detection off,
+// e.g. for integration tests that check production dashboards.
+func (l *Langfuse) WithTestMode(enabled bool) *Langfuse {
+	l.testMode = enabled
+	l.testModeSet = true
+	return l
+}
+
+// inTestMode reports whether events are recorded as test events: as set by
+// WithTestMode, or else when running under go test without an environment
+func (l *Langfuse) inTestMode() bool {
+	if l.testModeSet {
+		return l.testMode
+	}
+	return l.environment == "" && testing.Testing()
+}
+
+// baseEnvironment returns the environment of events that do not set one
+func (l *Langfuse) baseEnvironment() string {
+	if l.inTestMode() {
+		return TestEnvironment
+	}
+	return l.environment
+}
+
+// tagTestTrace adds the test tag to a trace recorded in test mode. The
+// caller's tags are not modified.
+func (l *Langfuse) tagTestTrace(t *model.Trace) {
+	if !l.inTestMode() || slices.Contains(t.Tags, TestEnvironment) {
+		return
+	}
+	t.Tags = append(slices.Clip(t.Tags), TestEnvironment)
+}
@@ -0,0 +1,25 @@
+package langfuse
+
+import (
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// metadataKeyTools holds the tools available to a generation, which the
+// ingestion API does not support natively
+const metadataKeyTools = "tools"
+
+// withGenerationTools returns metadata with tools stored under its "tools"
+// key, replacing any tools stored there
+func withGenerationTools(metadata any, tools []model.ToolDefinition) any {
+	if len(tools) == 0 {
+		return metadata
+	}
+
+	extended, ok := copyMetadata(metadata)
+	if !ok {
+		// Metadata of another shape cannot be extended
+		return metadata
+	}
+	extended[metadataKeyTools] = tools
+	return extended
+}
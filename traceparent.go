@@ -0,0 +1,95 @@
+package langfuse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// TraceparentHeader is the W3C Trace Context header propagated by
+// OpenTelemetry and other distributed tracing systems
+const TraceparentHeader = "traceparent"
+
+// MetadataKeyTraceparent holds the traceparent header a trace was joined from
+const MetadataKeyTraceparent = "traceparent"
+
+// traceparentVersion is the only version of the header format defined so far
+const traceparentVersion = "00"
+
+// ParseTraceparent parses a W3C traceparent header. The 16-byte trace ID maps
+// to the Langfuse trace ID with the same bytes in UUID form, so every system
+// sharing the header records into the same trace. The parent span belongs to
+// the other system, not to Langfuse, so no parent observation is set.
+func ParseTraceparent(header string) (TraceContext, error) {
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 {
+		return TraceContext{}, fmt.Errorf("traceparent %q is malformed", header)
+	}
+	version, traceID, spanID, flags := fields[0], fields[1], fields[2], fields[3]
+	if !isLowerHex(version, 2) || version == "ff" || (version == traceparentVersion && len(fields) != 4) {
+		return TraceContext{}, fmt.Errorf("traceparent %q has an unsupported version", header)
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return TraceContext{}, fmt.Errorf("traceparent %q has an invalid trace ID", header)
+	}
+	if !isLowerHex(spanID, 16) || spanID == strings.Repeat("0", 16) || !isLowerHex(flags, 2) {
+		return TraceContext{}, fmt.Errorf("traceparent %q has an invalid parent ID or flags", header)
+	}
+
+	raw, _ := hex.DecodeString(traceID)
+	id, _ := uuid.FromBytes(raw)
+	return TraceContext{TraceID: id.String()}, nil
+}
+
+// Traceparent serializes the context as a sampled W3C traceparent header for
+// calls into systems using distributed tracing. A UUID trace ID keeps its bytes,
+// so ParseTraceparent returns the same trace ID; other trace IDs, and the parent
+// observation ID, are hashed to the required sizes.
+func (tc TraceContext) Traceparent() string {
+	var traceID string
+	if id, err := uuid.Parse(tc.TraceID); err == nil {
+		traceID = hex.EncodeToString(id[:])
+	} else {
+		sum := sha256.Sum256([]byte(tc.TraceID))
+		traceID = hex.EncodeToString(sum[:16])
+	}
+
+	spanID := tc.ParentObservationID
+	if !isLowerHex(spanID, 16) {
+		// Without a parent observation the span ID is derived from the trace
+		seed := tc.ParentObservationID
+		if seed == "" {
+			seed = tc.TraceID
+		}
+		sum := sha256.Sum256([]byte(seed))
+		spanID = hex.EncodeToString(sum[:8])
+	}
+
+	return traceparentVersion + "-" + traceID + "-" + spanID + "-01"
+}
+
+// ContinueTraceparent returns a handle attaching observations to the trace
+// identified by a W3C traceparent header
+func (l *Langfuse) ContinueTraceparent(header string) (*TraceHandle, error) {
+	tc, err := ParseTraceparent(header)
+	if err != nil {
+		return nil, err
+	}
+	return l.ContinueTraceContext(tc), nil
+}
+
+// isLowerHex reports whether s is n lowercase hexadecimal digits
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
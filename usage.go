@@ -0,0 +1,35 @@
+package langfuse
+
+import "github.com/paulnegz/langfuse-go/model"
+
+// usageDetailsTotalKey is the usage and cost details key holding the total
+const usageDetailsTotalKey = "total"
+
+// applyUsageDetails adds a total to usage and cost details that lack one. The
+// usage total is the sum of the "input" and "output" entries: other usage keys,
+// such as "input_cached_tokens" or "output_reasoning_tokens", break those down
+// and are already counted in them. The cost total sums every cost entry. The
+// caller's maps are not modified.
+func applyUsageDetails(g *model.Generation) {
+	input, hasInput := g.UsageDetails[usageDetailsInputKey]
+	output, hasOutput := g.UsageDetails[usageDetailsOutputKey]
+	if _, hasTotal := g.UsageDetails[usageDetailsTotalKey]; (hasInput || hasOutput) && !hasTotal {
+		details := make(map[string]int, len(g.UsageDetails)+1)
+		for k, v := range g.UsageDetails {
+			details[k] = v
+		}
+		details[usageDetailsTotalKey] = input + output
+		g.UsageDetails = details
+	}
+
+	if _, hasTotal := g.CostDetails[usageDetailsTotalKey]; len(g.CostDetails) > 0 && !hasTotal {
+		details := make(map[string]float64, len(g.CostDetails)+1)
+		total := 0.0
+		for k, v := range g.CostDetails {
+			details[k] = v
+			total += v
+		}
+		details[usageDetailsTotalKey] = total
+		g.CostDetails = details
+	}
+}
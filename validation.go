@@ -0,0 +1,116 @@
+package langfuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/paulnegz/langfuse-go/model"
+)
+
+// Observation timestamps outside [minPlausibleTime, now+maxFutureSkew] are
+// reported as invalid, e.g. unset times or backfilled times in the wrong unit
+var minPlausibleTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const maxFutureSkew = 24 * time.Hour
+
+// WithStrictValidation makes Trace, Span, Generation, Event and Score return an
+// error for instrumentation mistakes such as a child observation without a trace
+// ID, an end time before the start time, a timestamp before 2000 or more than a
+// day in the future, or an unnamed new observation or score. Without
+// strict validation these are logged as warnings to the client's logger, set
+// with WithLogger, and corrected where safe.
+func (l *Langfuse) WithStrictValidation(strict bool) *Langfuse {
+	l.strictValidation = strict
+	return l
+}
+
+// validateTrace checks the timestamp of a trace
+func (l *Langfuse) validateTrace(t *model.Trace) error {
+	var issues []error
+	if t.Timestamp != nil && !plausibleTime(*t.Timestamp, l.clock.Now()) {
+		issues = append(issues, fmt.Errorf("trace %q has implausible timestamp %s", t.Name, t.Timestamp.Format(time.RFC3339Nano)))
+	}
+	return l.reportIssues(issues)
+}
+
+// validateObservation checks the common fields of a span, generation or event.
+// Outside strict mode an end time before the start time is clamped to the start.
+func (l *Langfuse) validateObservation(kind string, name string, traceID string, parentID string, start *time.Time, end **time.Time) error {
+	var issues []error
+
+	if parentID != "" && traceID == "" {
+		issues = append(issues, fmt.Errorf("%s %q has parent observation %s but no trace ID", kind, name, parentID))
+	}
+
+	// Observations with a start time are being created and should be named
+	if start != nil && name == "" {
+		issues = append(issues, fmt.Errorf("%s created without a name", kind))
+	}
+
+	now := l.clock.Now()
+	if start != nil && !plausibleTime(*start, now) {
+		issues = append(issues, fmt.Errorf("%s %q has implausible start time %s", kind, name, start.Format(time.RFC3339Nano)))
+	}
+	if end != nil && *end != nil && !plausibleTime(**end, now) {
+		issues = append(issues, fmt.Errorf("%s %q has implausible end time %s", kind, name, (*end).Format(time.RFC3339Nano)))
+	}
+
+	if start != nil && end != nil && *end != nil && (*end).Before(*start) {
+		issues = append(issues, fmt.Errorf("%s %q ends at %s, before its start at %s", kind, name, (*end).Format(time.RFC3339Nano), start.Format(time.RFC3339Nano)))
+		if !l.strictValidation {
+			corrected := *start
+			*end = &corrected
+		}
+	}
+
+	return l.reportIssues(issues)
+}
+
+// plausibleTime reports whether t is a credible observation timestamp
+func plausibleTime(t time.Time, now time.Time) bool {
+	return !t.Before(minPlausibleTime) && !t.After(now.Add(maxFutureSkew))
+}
+
+// validateScore checks a score. Non-finite values and values that do not match
+// the score's data type cannot be ingested and are rejected in every mode.
+func (l *Langfuse) validateScore(s *model.Score) error {
+	if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+		return fmt.Errorf("score %q has invalid value %v", s.Name, s.Value)
+	}
+	if err := l.coerceScore(s); err != nil {
+		return err
+	}
+
+	var issues []error
+	if s.Name == "" {
+		issues = append(issues, fmt.Errorf("score created without a name"))
+	}
+	return l.reportIssues(issues)
+}
+
+// reportIssues returns the issues as an error in strict mode and logs them to the
+// client's logger otherwise
+func (l *Langfuse) reportIssues(issues []error) error {
+	if len(issues) == 0 {
+		return nil
+	}
+	if l.strictValidation {
+		return errors.Join(issues...)
+	}
+	logger := l.Logger(context.Background())
+	for _, issue := range issues {
+		logger.Warn("Langfuse validation warning", "error", issue)
+	}
+	return nil
+}
+
+// parentObservationID returns the parent an observation will be sent with
+func parentObservationID(own string, parentID *string) string {
+	if parentID != nil {
+		return *parentID
+	}
+	return own
+}
@@ -0,0 +1,16 @@
+package langfuse
+
+// Version is the SDK version reported to Langfuse in the User-Agent header
+// and ingestion batch metadata, and by the langgraph hook in its trace and
+// root span metadata. Release builds set it at link time:
+//
+//	go build -ldflags "-X github.com/paulnegz/langfuse-go.Version=v1.4.0"
+var Version = "dev"
+
+// WithUserAgent replaces the default "langfuse-go/<Version>" User-Agent header
+// of requests to Langfuse, e.g. to add the name of your service. The SDK name
+// and version are still sent in their own headers.
+func (l *Langfuse) WithUserAgent(userAgent string) *Langfuse {
+	l.client.WithUserAgent(userAgent)
+	return l
+}